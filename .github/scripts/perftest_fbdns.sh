@@ -16,7 +16,7 @@ for s in {1..1000000} ; do echo C$s.test.com,test.com,4269 ;done >> testdata/dat
 for s in {555..888888} ; do echo -C$s.test.com,test.com,4269 ;done >> testdata/data/perftest.diff
 for s in {555..888888} ; do echo +C$s.test.com,deathowl.com,4269 ;done >> testdata/data/perftest.diff
 echo "DONE Generating test data"
-CGO_LDFLAGS_ALLOW=".*" CGO_CFLAGS_ALLOW=".*" go run cmd/dnsrocks-data/dnsrocks-data.go -i testdata/data/perftest.in -o testdata/perftest
+CGO_LDFLAGS_ALLOW=".*" CGO_CFLAGS_ALLOW=".*" go run ./cmd/dnsrocks-data -i testdata/data/perftest.in -o testdata/perftest
 CGO_LDFLAGS_ALLOW=".*" CGO_CFLAGS_ALLOW=".*" go build cmd/dnsrocks/dnsrocks.go
 ./dnsrocks  -ip ::1 -port 8053 -dbdriver rocksdb -dbpath testdata/perftest -refuse-any -dnstap-target stdout &
 cd ../goose || exit 1
@@ -14,7 +14,7 @@ echo """Ztest.com,a.ns.test.com,dns.test.com,123,7200,1800,604800,120,120,,
 =test.com,192.168.0.1,4269""" >> testdata/data/perftest.in
 for s in {1..1000000} ; do echo C$s.test.com,test.com,4269 ;done >> testdata/data/perftest.in
 echo "DONE Generating test data"
-CGO_LDFLAGS_ALLOW=".*" CGO_CFLAGS_ALLOW=".*" go run cmd/dnsrocks-data/dnsrocks-data.go -dbdriver cdb -i testdata/data/perftest.in -o testdata/cdbtest
+CGO_LDFLAGS_ALLOW=".*" CGO_CFLAGS_ALLOW=".*" go run ./cmd/dnsrocks-data -dbdriver cdb -i testdata/data/perftest.in -o testdata/cdbtest
 CGO_LDFLAGS_ALLOW=".*" CGO_CFLAGS_ALLOW=".*" go build cmd/dnsrocks/dnsrocks.go
 ./dnsrocks  -ip ::1 -port 8053 -dbdriver cdb -dbpath testdata/cdbtest -refuse-any -dnstap-target stdout &
 cd ../goose || exit 1
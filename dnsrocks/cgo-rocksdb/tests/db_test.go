@@ -1,3 +1,5 @@
+//go:build cgo
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -64,10 +64,12 @@ var order = []string{
 	"A",
 	"AAAA",
 	"CNAME",
+	"DNAME",
 	"MX",
 	"TXT",
 	"SRV",
 	"SPF",
+	"CAA",
 }
 
 func adjustTTL(ttl uint32) uint32 {
@@ -95,6 +97,10 @@ func processRecs(recs []dns.RR) map[string][]string {
 			// Cfqdn,x,ttl,timestamp,lo
 			line := fmt.Sprintf("C%s,%s,%d", normalizeDot(v.Hdr.Name), normalizeDot(v.Target), adjustTTL(v.Hdr.Ttl))
 			results["CNAME"] = append(results["CNAME"], line)
+		case *dns.DNAME:
+			// Dfqdn,x,ttl,timestamp,lo
+			line := fmt.Sprintf("D%s,%s,%d", normalizeDot(v.Hdr.Name), normalizeDot(v.Target), adjustTTL(v.Hdr.Ttl))
+			results["DNAME"] = append(results["DNAME"], line)
 		case *dns.TXT:
 			// fqdn,s,ttl,timestamp,lo
 			line := fmt.Sprintf("'%s,%s,%d", normalizeDot(v.Hdr.Name), replaceBadChars(v.Txt[0]), adjustTTL(v.Hdr.Ttl))
@@ -111,6 +117,10 @@ func processRecs(recs []dns.RR) map[string][]string {
 			// @fqdn,ip,x,dist,ttl,timestamp,lo
 			line := fmt.Sprintf("@%s,,%s,%d,%d", normalizeDot(v.Hdr.Name), normalizeDot(v.Mx), v.Preference, adjustTTL(v.Hdr.Ttl))
 			results["MX"] = append(results["MX"], line)
+		case *dns.CAA:
+			// Yfqdn,flags,tag,value,ttl,timestamp,lo
+			line := fmt.Sprintf("Y%s,%d,%s,%s,%d", normalizeDot(v.Hdr.Name), v.Flag, v.Tag, replaceBadChars(v.Value), adjustTTL(v.Hdr.Ttl))
+			results["CAA"] = append(results["CAA"], line)
 		// ignore
 		case *dns.SOA:
 		case *dns.NS:
@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/quote"
+	"github.com/facebook/dns/dnsrocks/geoimport"
+)
+
+func main() {
+	mmdbPath := flag.String("mmdb", "", "Path to the GeoIP database, in the MMDB format (MaxMind, DB-IP)")
+	mappingPath := flag.String("mapping", "", "Path to the mapping of the countries, regions and ASNs to location IDs, one 'country,<code>,<location>', 'region,<country>-<subdivision>,<location>' or 'asn,<number>,<location>' per line")
+	mapID := flag.String("map", "", "Map ID of the generated % records, escaped as in the data files")
+	outputPath := flag.String("o", "", "Output path of the generated % records, stdout if empty")
+	gapsPath := flag.String("gaps", "", "Output path of the networks not mapped to any location, if not empty")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Generate the %% records mapping the networks of a GeoIP database to locations.\nA report of the coverage is written to stderr.\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s -mmdb GeoLite2-City.mmdb -mapping mapping.csv -map m1 > data.geo\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *mmdbPath == "" || *mappingPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	lmap, err := quote.Bunquote([]byte(*mapID))
+	if err != nil {
+		log.Fatalf("invalid map ID %q: %v", *mapID, err)
+	}
+	db, err := geoimport.OpenMMDB(*mmdbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f, err := os.Open(*mappingPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mapping, err := geoimport.ParseMapping(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("%s: %v", *mappingPath, err)
+	}
+
+	result, err := geoimport.Import(db, mapping)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *outputPath != "" {
+		out, err := os.Create(*outputPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer out.Close()
+		w = out
+	}
+	if err := geoimport.WriteRecords(w, result.Networks, dnsdata.Lmap(lmap)); err != nil {
+		log.Fatal(err)
+	}
+
+	if *gapsPath != "" {
+		out, err := os.Create(*gapsPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, n := range result.Gaps {
+			fmt.Fprintln(out, n)
+		}
+		if err := out.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "%s (%s): %d records written\n", *mmdbPath, db.Metadata.DatabaseType, len(result.Networks))
+	if err := result.WriteReport(os.Stderr); err != nil {
+		log.Fatal(err)
+	}
+}
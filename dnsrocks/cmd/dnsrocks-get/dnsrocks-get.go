@@ -21,13 +21,85 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/miekg/dns"
 
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata"
 	"github.com/facebook/dns/dnsrocks/dnsserver"
 	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
 )
 
+// locationText formats a location ID as in the data file
+func locationText(id db.ID) string {
+	if id.IsZero() {
+		return "default"
+	}
+	var b strings.Builder
+	dnsdata.Putloctext(&b, dnsdata.Loc(id.Contents()))
+	return b.String()
+}
+
+// printLocation prints the chain of the location the query is answered for,
+// and which location of it answered.
+// When the DB has overlays, it also prints which layer answered.
+func printLocation(tdb *dnsserver.FBDNSDB, layered bool, qType, qName, resolver, subnet string) error {
+	reader, err := tdb.AcquireReader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	q := make([]byte, 255)
+	offset, err := dns.PackDomainName(dns.Fqdn(qName), q, 0, nil, false)
+	if err != nil {
+		return err
+	}
+	var ecs *dns.EDNS0_SUBNET
+	if subnet != "" {
+		o, err := dnsserver.MakeOPTWithECS(subnet)
+		if err != nil {
+			return err
+		}
+		ecs = db.FindECS(&dns.Msg{Extra: []dns.RR{o}})
+	}
+	loc, err := reader.FindLocation(q[:offset], ecs, resolver)
+	if err != nil {
+		return err
+	}
+	chain, err := reader.LocationChain(loc.LocID)
+	if err != nil {
+		return err
+	}
+	qtype := dns.StringToType[strings.ToUpper(qType)]
+	answering, err := db.AnsweringLocation(reader, q[:offset], qtype, loc.LocID)
+	if err != nil {
+		return err
+	}
+
+	levels := make([]string, 0, len(chain)+1)
+	for _, id := range append(chain, db.ZeroID) {
+		levels = append(levels, locationText(id))
+	}
+	fmt.Printf(";; location chain: %s\n", strings.Join(levels, " > "))
+	if answering != nil {
+		fmt.Printf(";; answered by location: %s\n", locationText(answering))
+	}
+	if layered && answering != nil {
+		layer, layerPath, err := tdb.AnsweringLayer(q[:offset], qtype, answering)
+		if err != nil {
+			return err
+		}
+		name := "base"
+		if layer > 0 {
+			name = fmt.Sprintf("overlay %d", layer)
+		}
+		fmt.Printf(";; answered by layer: %s (%s)\n", name, layerPath)
+	}
+	return nil
+}
+
 func main() {
 	var (
 		maxans        int
@@ -38,13 +110,17 @@ func main() {
 		handlerConfig dnsserver.HandlerConfig
 	)
 	flag.StringVar(&dbConfig.Path, "dbpath", "", "Path to CDB")
-	flag.StringVar(&dbConfig.Driver, "dbdriver", "rocksdb", "DB driver (cdb or rocksdb)")
+	flag.StringVar(&dbConfig.Driver, "dbdriver", "rocksdb", "DB driver (cdb, rocksdb or pebble)")
+	overlays := flag.String("dboverlays", "", "Comma separated list of the paths of the overlay DBs, consulted in order before -dbpath")
 	flag.IntVar(&maxans, "maxans", 1, "Max number of answer server should return.")
 	qType := flag.String("qtype", "A", "Type of the query")
 	qName := flag.String("qname", "", "Name to query")
 	resolver := flag.String("resolver", "127.0.0.1", "IP of the resolver to simulate the query from.")
 	subnet := flag.String("subnet", "", "client subnet")
 	flag.Parse()
+	if *overlays != "" {
+		dbConfig.Overlays = strings.Split(*overlays, ",")
+	}
 
 	if tdb, err = dnsserver.NewFBDNSDB(handlerConfig, dbConfig, cacheConfig, &dnsserver.TextLogger{IoWriter: os.Stdout}, &stats.DummyStats{}); err != nil {
 		log.Fatalf("Failed to instantiate DB: %s", err)
@@ -57,4 +133,7 @@ func main() {
 		log.Fatalf("%s", err)
 	}
 	fmt.Printf("%s\n%s\n", dns.RcodeToString[rec.Rcode], rec.Msg)
+	if err = printLocation(tdb, len(dbConfig.Overlays) > 0, *qType, *qName, *resolver, *subnet); err != nil {
+		log.Fatalf("Failed to find location: %s", err)
+	}
 }
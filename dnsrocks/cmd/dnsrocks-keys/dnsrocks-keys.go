@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/sign"
+
+	"github.com/miekg/dns"
+)
+
+func main() {
+	keys := flag.String("keys", "", "Comma-separated list of DNSSEC key files, as generated by dnssec-keygen, or directories of key files")
+	at := flag.String("at", "", "RFC 3339 time to show the key states at, defaults to now")
+	flag.Parse()
+
+	if *keys == "" {
+		log.Fatal("Need to specify the keys")
+	}
+	now := time.Now()
+	if *at != "" {
+		var err error
+		if now, err = time.Parse(time.RFC3339, *at); err != nil {
+			log.Fatalf("invalid time %q: %v", *at, err)
+		}
+	}
+	names, err := sign.ExpandKeyFiles(strings.Split(*keys, ","))
+	if err != nil {
+		log.Fatal(err)
+	}
+	k, err := sign.ParseKeyFiles(names)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sort.SliceStable(k, func(i, j int) bool {
+		a, b := dns.CanonicalName(k[i].DNSKEY.Hdr.Name), dns.CanonicalName(k[j].DNSKEY.Hdr.Name)
+		if a != b {
+			return a < b
+		}
+		return k[i].DNSKEY.KeyTag() < k[j].DNSKEY.KeyTag()
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ZONE\tKEYTAG\tALGORITHM\tROLE\tSTATE\tCDS\tNEXT EVENT")
+	for _, key := range k {
+		role := "ZSK"
+		if key.IsKSK() {
+			role = "KSK"
+		}
+		cds := "-"
+		if key.IsKSK() {
+			cds = fmt.Sprintf("%t", key.Timing.Sync(now))
+		}
+		next := "-"
+		if t := key.Timing.NextEvent(now); !t.IsZero() {
+			next = t.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			dns.CanonicalName(key.DNSKEY.Hdr.Name),
+			key.DNSKEY.KeyTag(),
+			dns.AlgorithmToString[key.DNSKEY.Algorithm],
+			role,
+			key.Timing.State(now),
+			cds,
+			next,
+		)
+	}
+	if err = w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
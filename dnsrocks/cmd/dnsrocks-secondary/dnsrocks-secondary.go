@@ -0,0 +1,57 @@
+//go:build cgo
+
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/facebook/dns/dnsrocks/secondary"
+)
+
+func main() {
+	var conf secondary.Config
+	zones := flag.String("zones", "", "Comma separated list of the zones to transfer from the primary")
+	flag.StringVar(&conf.Primary, "primary", "", "Address of the primary server, as host:port")
+	flag.StringVar(&conf.DBPath, "dbpath", "", "Path of the RDB (with V2 keys) the zones are written to")
+	flag.StringVar(&conf.ControlPath, "control-path", "", "Control directory of the dnsrocks instance serving the DB, to trigger partial reloads in")
+	flag.StringVar(&conf.NotifyAddr, "notify-addr", "", "Address to receive NOTIFY messages from the primary on, over UDP. NOTIFY is not supported if empty")
+	flag.DurationVar(&conf.Timeout, "timeout", 0, "Timeout of the queries and zone transfers to the primary")
+	flag.Parse()
+
+	if *zones != "" {
+		conf.Zones = strings.Split(*zones, ",")
+	}
+	s, err := secondary.New(&conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := s.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/sign"
+)
+
+func main() {
+	dbPath := flag.String("i", "", "Path to the compiled DNS DB to sign")
+	outputPath := flag.String("o", "", "(CDB-only) Output path to write the signed DNS DB, defaults to the input path")
+	dbDriver := flag.String("dbdriver", "rocksdb", "DB driver (cdb or rocksdb)")
+	keys := flag.String("keys", "", "Comma-separated list of DNSSEC key files, as generated by dnssec-keygen. The zones signed are the ones of the keys")
+	inception := flag.Duration("inception", 3*time.Hour, "How long before now the signatures are valid from, to allow for clock skew")
+	validity := flag.Duration("validity", 30*24*time.Hour, "How long from now the signatures are valid for. The DB must be signed again before they expire")
+	nsec3 := flag.Bool("nsec3", false, "Generate an NSEC3 chain for the signed zones, to prove negative and wildcard answers. Proofs are only served from RocksDB databases with sorted keys")
+	nsec3Salt := flag.String("nsec3-salt", "", "Hex encoded salt of the NSEC3 chain")
+	nsec3Iterations := flag.Uint("nsec3-iterations", 0, "Additional hash iterations of the NSEC3 chain")
+	nsec3OptOut := flag.Bool("nsec3-optout", false, "Leave the delegations without DS records out of the NSEC3 chain")
+	flag.Parse()
+
+	if *dbPath == "" || *keys == "" {
+		log.Fatal("Need to specify the DB to sign and the keys")
+	}
+	k, err := sign.ParseKeyFiles(strings.Split(*keys, ","))
+	if err != nil {
+		log.Fatal(err)
+	}
+	now := time.Now()
+	signer, err := sign.NewSigner(k, now.Add(-*inception), now.Add(*validity))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *nsec3 {
+		if *nsec3Iterations > math.MaxUint16 {
+			log.Fatalf("invalid NSEC3 iterations %d", *nsec3Iterations)
+		}
+		p := sign.NSEC3Params{Salt: *nsec3Salt, Iterations: uint16(*nsec3Iterations), OptOut: *nsec3OptOut}
+		if err = signer.SetNSEC3(p); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var signedRecs int
+	switch *dbDriver {
+	case "rocksdb":
+		if *outputPath != "" {
+			log.Fatal("RocksDB databases are signed in place")
+		}
+		signedRecs, err = signer.SignRDB(*dbPath)
+	case "cdb":
+		if *outputPath == "" {
+			*outputPath = *dbPath
+		}
+		signedRecs, err = signer.SignCDB(*dbPath, *outputPath)
+	default:
+		log.Fatalf("unsupported db driver '%s'", *dbDriver)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d records written", signedRecs)
+}
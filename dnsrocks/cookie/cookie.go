@@ -0,0 +1,248 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package cookie implements DNS Cookies (RFC 7873), using the interoperable
+// server cookies of RFC 9018 so that anycast nodes sharing a secret accept the
+// cookies of each other.
+package cookie
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/logger"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+const (
+	clientCookieLen    = 8
+	minServerCookieLen = 8
+	maxServerCookieLen = 32
+	// serverCookieLen is the length of the server cookies we generate.
+	serverCookieLen = 16
+	cookieVersion   = 1
+
+	// Server cookies are accepted for an hour, and refreshed after half an
+	// hour, see RFC 9018 section 4.3.
+	maxAge       = time.Hour
+	refreshAge   = 30 * time.Minute
+	maxClockSkew = 5 * time.Minute
+)
+
+// Config contains the config for DNS Cookies.
+type Config struct {
+	Enabled                  bool
+	SecretFile               string
+	SecretFileReloadInterval int // seconds, 0 to disable reload
+	// Require makes UDP queries carrying a client cookie, but no valid server
+	// cookie, get a BADCOOKIE response.
+	Require bool
+	// Exempt exempts queries with a valid server cookie from response rate
+	// limiting and throttling, as their source address can't be spoofed.
+	Exempt bool
+}
+
+type exemptKey struct{}
+
+// IsExempt returns whether the query carried a valid server cookie, and such
+// queries are exempt from rate limiting.
+func IsExempt(ctx context.Context) bool {
+	exempt, _ := ctx.Value(exemptKey{}).(bool)
+	return exempt
+}
+
+// parse extracts the client and server cookies from a COOKIE option, see
+// RFC 7873 section 4.
+func parse(o *dns.EDNS0_COOKIE) (client, server []byte, err error) {
+	c, err := hex.DecodeString(o.Cookie)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(c) != clientCookieLen && (len(c) < clientCookieLen+minServerCookieLen || len(c) > clientCookieLen+maxServerCookieLen) {
+		return nil, nil, fmt.Errorf("invalid cookie length: %d", len(c))
+	}
+	return c[:clientCookieLen], c[clientCookieLen:], nil
+}
+
+// findCookie returns the COOKIE option of a message, if any.
+func findCookie(m *dns.Msg) *dns.EDNS0_COOKIE {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if c, ok := o.(*dns.EDNS0_COOKIE); ok {
+			return c
+		}
+	}
+	return nil
+}
+
+// Handler is a [plugin.Handler] validating the cookies of queries, and adding
+// server cookies to their responses.
+type Handler struct {
+	secrets *Secrets
+	conf    *Config
+	stats   stats.Stats
+	now     func() time.Time
+	Next    plugin.Handler
+}
+
+// NewHandler initializes a new cookie Handler.
+func NewHandler(secrets *Secrets, conf *Config, stats stats.Stats) *Handler {
+	return &Handler{secrets: secrets, conf: conf, stats: stats, now: time.Now}
+}
+
+// ServeDNS implements the [plugin.Handler] interface.
+func (h *Handler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	o := findCookie(r)
+	if o == nil {
+		return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+	}
+	client, server, err := parse(o)
+	if err != nil {
+		h.stats.IncrementCounter("DNS_cookie.malformed")
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeFormatError)
+		m.SetEdns0(dns.MinMsgSize, false)
+		if err = w.WriteMsg(m); err != nil {
+			return dns.RcodeServerFailure, err
+		}
+		return dns.RcodeFormatError, nil
+	}
+
+	state := request.Request{W: w, Req: r}
+	ip := net.ParseIP(state.IP())
+	now := h.now()
+	valid, refresh := false, true
+	if len(server) > 0 {
+		valid, refresh = h.secrets.validate(client, server, ip, now)
+	}
+	switch {
+	case valid:
+		h.stats.IncrementCounter("DNS_cookie.valid")
+	case len(server) > 0:
+		h.stats.IncrementCounter("DNS_cookie.invalid")
+	default:
+		h.stats.IncrementCounter("DNS_cookie.client_only")
+	}
+	if refresh || !valid {
+		server = h.secrets.generate(client, ip, now)
+	}
+	cookie := hex.EncodeToString(append(append([]byte{}, client...), server...))
+
+	// Over TCP, the source address can't be spoofed, so there is no point
+	// in asking the client to retry, see RFC 7873 section 5.2.3.
+	if !valid && h.conf.Require && state.Proto() == "udp" {
+		h.stats.IncrementCounter("DNS_cookie.badcookie")
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeBadCookie)
+		m.SetEdns0(uint16(state.Size()), false)
+		m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+		if err = w.WriteMsg(m); err != nil {
+			return dns.RcodeServerFailure, err
+		}
+		return dns.RcodeBadCookie, nil
+	}
+
+	if valid && h.conf.Exempt {
+		ctx = context.WithValue(ctx, exemptKey{}, true)
+	}
+	rw := &responseWriter{ResponseWriter: w, cookie: cookie, size: state.Size()}
+	return plugin.NextOrFailure(h.Name(), h.Next, ctx, rw, r)
+}
+
+// Name returns the handler's name.
+func (h *Handler) Name() string { return "cookie" }
+
+// ValidCookie returns whether a query, in wire format, carries a valid server
+// cookie. It is meant to exempt such queries from throttling, before they are
+// parsed.
+func (h *Handler) ValidCookie(buf []byte, addr net.Addr) bool {
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil {
+		return false
+	}
+	o := findCookie(m)
+	if o == nil {
+		return false
+	}
+	client, server, err := parse(o)
+	if err != nil || len(server) == 0 {
+		return false
+	}
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		return false
+	}
+	valid, _ := h.secrets.validate(client, server, ip, h.now())
+	return valid
+}
+
+// responseWriter adds the server cookie to the response written.
+type responseWriter struct {
+	dns.ResponseWriter
+	cookie string
+	size   int
+}
+
+// WriteMsg implements the [dns.ResponseWriter] interface.
+func (w *responseWriter) WriteMsg(m *dns.Msg) error {
+	opt := m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(uint16(w.size), false)
+		opt = m.IsEdns0()
+	}
+	// The OPT record of the query may have been reused, with its COOKIE. Do
+	// not modify its options in place, they are still logged.
+	options := make([]dns.EDNS0, 0, len(opt.Option)+1)
+	for _, o := range opt.Option {
+		if o.Option() != dns.EDNS0COOKIE {
+			options = append(options, o)
+		}
+	}
+	opt.Option = append(options, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: w.cookie})
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// ConnectionState forwards the TLS state of w.ResponseWriter, if any.
+func (w *responseWriter) ConnectionState() *tls.ConnectionState {
+	if stater, ok := w.ResponseWriter.(dns.ConnectionStater); ok {
+		return stater.ConnectionState()
+	}
+	return nil
+}
+
+// Transport forwards the transport of w.ResponseWriter, if any.
+func (w *responseWriter) Transport() string {
+	if t, ok := w.ResponseWriter.(logger.Transporter); ok {
+		return t.Transport()
+	}
+	return ""
+}
@@ -0,0 +1,262 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookie
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+// TestServerCookie checks server cookies against the test vector of RFC 9018
+// appendix A.1.
+func TestServerCookie(t *testing.T) {
+	testCases := []struct {
+		client string
+		ip     string
+		secret string
+		ts     uint32
+		server string
+	}{
+		{
+			client: "2464c4abcf10c957",
+			ip:     "198.51.100.100",
+			secret: "e5e973e5a6b2a43f48e7dc849e37bfcf",
+			ts:     1559731985,
+			server: "010000005cf79f111f8130c3eee29480",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.ip, func(t *testing.T) {
+			var key secret
+			copy(key[:], mustDecodeHex(t, tc.secret))
+			server := key.serverCookie(mustDecodeHex(t, tc.client), net.ParseIP(tc.ip), tc.ts)
+			require.Equal(t, tc.server, hex.EncodeToString(server))
+		})
+	}
+}
+
+func TestLoadSecrets(t *testing.T) {
+	keys, err := loadSecrets(strings.NewReader(`{"Old": ["a"], "Current": ["b"], "New": ["c", "d"]}`))
+	require.NoError(t, err)
+	require.Equal(t, []secret{secretFromSeed("b"), secretFromSeed("a"), secretFromSeed("c"), secretFromSeed("d")}, keys)
+
+	_, err = loadSecrets(strings.NewReader(`{"Old": ["a"], "Current": ["b"]}`))
+	require.Error(t, err)
+	_, err = loadSecrets(strings.NewReader(`{`))
+	require.Error(t, err)
+}
+
+func TestSecretsValidate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ip := net.ParseIP("192.0.2.1")
+	client := mustDecodeHex(t, "0102030405060708")
+
+	seedfile := filepath.Join(t.TempDir(), "seeds.json")
+	require.NoError(t, os.WriteFile(seedfile, []byte(`{"Old": ["a"], "Current": ["b"], "New": ["c"]}`), 0o644))
+	s, err := NewSecrets(context.Background(), &Config{SecretFile: seedfile})
+	require.NoError(t, err)
+	server := s.generate(client, ip, now)
+
+	valid, refresh := s.validate(client, server, ip, now)
+	require.True(t, valid)
+	require.False(t, refresh)
+	valid, refresh = s.validate(client, server, ip, now.Add(45*time.Minute))
+	require.True(t, valid)
+	require.True(t, refresh)
+	valid, _ = s.validate(client, server, ip, now.Add(2*time.Hour))
+	require.False(t, valid, "expired cookie")
+	valid, _ = s.validate(client, server, ip, now.Add(-10*time.Minute))
+	require.False(t, valid, "cookie from the future")
+	valid, _ = s.validate(client, server, net.ParseIP("192.0.2.2"), now)
+	require.False(t, valid, "other client IP")
+	valid, _ = s.validate(mustDecodeHex(t, "0807060504030201"), server, ip, now)
+	require.False(t, valid, "other client cookie")
+	ip6 := net.ParseIP("2001:db8::1")
+	valid, _ = s.validate(client, s.generate(client, ip6, now), ip6, now)
+	require.True(t, valid, "IPv6 client")
+
+	// Cookies generated with the old secret are still valid after a rotation.
+	require.NoError(t, os.WriteFile(seedfile, []byte(`{"Old": ["b"], "Current": ["c"], "New": ["d"]}`), 0o644))
+	require.NoError(t, s.loadFromFile(seedfile))
+	valid, _ = s.validate(client, server, ip, now)
+	require.True(t, valid)
+	require.NoError(t, os.WriteFile(seedfile, []byte(`{"Old": ["c"], "Current": ["d"], "New": ["e"]}`), 0o644))
+	require.NoError(t, s.loadFromFile(seedfile))
+	valid, _ = s.validate(client, server, ip, now)
+	require.False(t, valid)
+}
+
+// cookieQuery returns a query with the given COOKIE option.
+func cookieQuery(cookie string) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	req.SetEdns0(4096, false)
+	req.IsEdns0().Option = append(req.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+	return req
+}
+
+func newTestHandler(t *testing.T, conf *Config) (*Handler, stats.Counters, *bool) {
+	secrets, err := NewSecrets(context.Background(), conf)
+	require.NoError(t, err)
+	s := stats.NewCounters()
+	h := NewHandler(secrets, conf, s)
+	exempt := new(bool)
+	h.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		*exempt = IsExempt(ctx)
+		m := new(dns.Msg)
+		m.SetReply(r)
+		state := request.Request{W: w, Req: r}
+		state.SizeAndDo(m)
+		return dns.RcodeSuccess, w.WriteMsg(m)
+	})
+	return h, s, exempt
+}
+
+// responseCookie returns the client and server cookies of a response.
+func responseCookie(t *testing.T, m *dns.Msg) (string, string) {
+	o := findCookie(m)
+	require.NotNil(t, o)
+	require.Len(t, o.Cookie, 2*(clientCookieLen+serverCookieLen))
+	return o.Cookie[:2*clientCookieLen], o.Cookie[2*clientCookieLen:]
+}
+
+func TestHandler(t *testing.T) {
+	const client = "0102030405060708"
+	h, s, exempt := newTestHandler(t, &Config{Exempt: true})
+
+	// The client learns a server cookie.
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err := h.ServeDNS(context.Background(), rec, cookieQuery(client))
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+	require.False(t, *exempt)
+	c, server := responseCookie(t, rec.Msg)
+	require.Equal(t, client, c)
+	require.Len(t, rec.Msg.IsEdns0().Option, 1, "the client cookie alone must not be echoed")
+
+	// And uses it in its next query.
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err = h.ServeDNS(context.Background(), rec, cookieQuery(client+server))
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+	require.True(t, *exempt)
+	_, s2 := responseCookie(t, rec.Msg)
+	require.Equal(t, server, s2)
+
+	// An invalid server cookie is replaced.
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err = h.ServeDNS(context.Background(), rec, cookieQuery(client+"01000000aaaaaaaabbbbbbbbbbbbbbbb"))
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+	require.False(t, *exempt)
+	_, s3 := responseCookie(t, rec.Msg)
+	require.Equal(t, server, s3)
+
+	require.Equal(t, int64(1), s["DNS_cookie.client_only"])
+	require.Equal(t, int64(1), s["DNS_cookie.valid"])
+	require.Equal(t, int64(1), s["DNS_cookie.invalid"])
+}
+
+func TestHandlerMalformed(t *testing.T) {
+	h, s, _ := newTestHandler(t, &Config{})
+	for _, cookie := range []string{"01020304", "0102030405060708" + "0102", "zz"} {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		rcode, err := h.ServeDNS(context.Background(), rec, cookieQuery(cookie))
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeFormatError, rcode)
+		require.Equal(t, dns.RcodeFormatError, rec.Msg.Rcode)
+	}
+	require.Equal(t, int64(3), s["DNS_cookie.malformed"])
+}
+
+func TestHandlerRequire(t *testing.T) {
+	const client = "0102030405060708"
+	h, s, _ := newTestHandler(t, &Config{Require: true})
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err := h.ServeDNS(context.Background(), rec, cookieQuery(client))
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeBadCookie, rec.Msg.Rcode)
+	_, server := responseCookie(t, rec.Msg)
+	// The BADCOOKIE response must be packable, with its extended RCODE.
+	_, err = rec.Msg.Pack()
+	require.NoError(t, err)
+
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err = h.ServeDNS(context.Background(), rec, cookieQuery(client+server))
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+
+	// Cookies are not required over TCP.
+	rec = dnstest.NewRecorder(&test.ResponseWriter{TCP: true})
+	_, err = h.ServeDNS(context.Background(), rec, cookieQuery(client))
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+
+	// Neither are they for queries without cookie.
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err = h.ServeDNS(context.Background(), rec, req)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+	require.Nil(t, rec.Msg.IsEdns0())
+
+	require.Equal(t, int64(1), s["DNS_cookie.badcookie"])
+}
+
+func TestValidCookie(t *testing.T) {
+	const client = "0102030405060708"
+	h, _, _ := newTestHandler(t, &Config{})
+	// test.ResponseWriter queries come from 10.240.0.1.
+	addr := &net.UDPAddr{IP: net.ParseIP("10.240.0.1"), Port: 53}
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err := h.ServeDNS(context.Background(), rec, cookieQuery(client))
+	require.NoError(t, err)
+	_, server := responseCookie(t, rec.Msg)
+
+	buf, err := cookieQuery(client + server).Pack()
+	require.NoError(t, err)
+	require.True(t, h.ValidCookie(buf, addr))
+	require.False(t, h.ValidCookie(buf, &net.UDPAddr{IP: net.ParseIP("10.240.0.2"), Port: 53}))
+	require.False(t, h.ValidCookie(buf[:10], addr))
+
+	buf, err = cookieQuery(client).Pack()
+	require.NoError(t, err)
+	require.False(t, h.ValidCookie(buf, addr))
+}
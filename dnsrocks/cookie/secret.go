@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookie
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/dchest/siphash"
+	"github.com/golang/glog"
+)
+
+// secret is a SipHash-2-4 key.
+type secret [16]byte
+
+func secretFromSeed(seed string) (s secret) {
+	h := sha256.New()
+	h.Write([]byte(seed))
+	copy(s[:], h.Sum(nil))
+	return
+}
+
+// serverCookie computes the server cookie of a client, as per RFC 9018
+// section 4.
+func (s *secret) serverCookie(client []byte, ip net.IP, ts uint32) []byte {
+	c := make([]byte, serverCookieLen)
+	c[0] = cookieVersion
+	binary.BigEndian.PutUint32(c[4:8], ts)
+	return s.sign(c, client, ip)
+}
+
+// sign sets the hash of a server cookie whose first 8 octets are set.
+func (s *secret) sign(c []byte, client []byte, ip net.IP) []byte {
+	msg := make([]byte, 0, clientCookieLen+8+net.IPv6len)
+	msg = append(msg, client...)
+	msg = append(msg, c[:8]...)
+	if ip4 := ip.To4(); ip4 != nil {
+		msg = append(msg, ip4...)
+	} else {
+		msg = append(msg, ip.To16()...)
+	}
+	h := siphash.Hash(binary.LittleEndian.Uint64(s[:8]), binary.LittleEndian.Uint64(s[8:]), msg)
+	binary.LittleEndian.PutUint64(c[8:], h)
+	return c
+}
+
+// Secrets holds the server secrets. The first current secret is used to
+// generate server cookies, and all the secrets are accepted when validating
+// them, so that nodes sharing a secret file can roll it without invalidating
+// the cookies of each other.
+type Secrets struct {
+	keys atomic.Pointer[[]secret]
+}
+
+// NewSecrets loads the secrets from the configured seed file. If configured,
+// the seed file is periodically reloaded for the lifetime of the context.
+// Without a seed file, a random secret is generated, which is fine as long as
+// clients keep talking to the same node.
+func NewSecrets(ctx context.Context, conf *Config) (*Secrets, error) {
+	s := new(Secrets)
+	if conf.SecretFile == "" {
+		glog.Infof("No cookie secret file provided, using a random secret.")
+		var key secret
+		if _, err := rand.Read(key[:]); err != nil {
+			return nil, fmt.Errorf("could not generate cookie secret: %w", err)
+		}
+		s.keys.Store(&[]secret{key})
+		return s, nil
+	}
+	if err := s.loadFromFile(conf.SecretFile); err != nil {
+		return nil, err
+	}
+	if conf.SecretFileReloadInterval > 0 {
+		glog.Infof(
+			"Setting ticker to reload cookie secret file %s every %d seconds.",
+			conf.SecretFile,
+			conf.SecretFileReloadInterval,
+		)
+		ticker := time.NewTicker(
+			time.Duration(conf.SecretFileReloadInterval) * time.Second,
+		)
+		go func() {
+			for {
+				select {
+				case <-ticker.C:
+					if err := s.loadFromFile(conf.SecretFile); err != nil {
+						glog.Errorf("Failed to load cookie secrets: %s", err)
+					}
+				case <-ctx.Done():
+					ticker.Stop()
+					return
+				}
+			}
+		}()
+	} else {
+		glog.Infof("Not reloading cookie secret file")
+	}
+	return s, nil
+}
+
+func (s *Secrets) loadFromFile(seedfile string) error {
+	seedReader, err := os.Open(seedfile)
+	if err != nil {
+		return fmt.Errorf("could not load seed file %q", seedfile)
+	}
+	defer seedReader.Close()
+	keys, err := loadSecrets(seedReader)
+	if err != nil {
+		return fmt.Errorf("could not load cookie seeds from %s: %w", seedfile, err)
+	}
+	s.keys.Store(&keys)
+	return nil
+}
+
+// loadSecrets reads seeds using the same format as the TLS session ticket
+// seeds.
+func loadSecrets(reader io.Reader) (keys []secret, err error) {
+	var (
+		t struct {
+			Old     []string
+			New     []string
+			Current []string
+		}
+		data []byte
+	)
+	if data, err = io.ReadAll(reader); err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &t); err != nil {
+		return
+	}
+	if len(t.Current) == 0 || len(t.Old) == 0 || len(t.New) == 0 {
+		err = fmt.Errorf("some of the seeds are missing")
+		return
+	}
+	appendKeys := func(seeds []string) {
+		for _, s := range seeds {
+			keys = append(keys, secretFromSeed(s))
+		}
+	}
+	appendKeys(t.Current)
+	appendKeys(t.Old)
+	appendKeys(t.New)
+	return
+}
+
+// generate returns a new server cookie for a client.
+func (s *Secrets) generate(client []byte, ip net.IP, now time.Time) []byte {
+	keys := *s.keys.Load()
+	return keys[0].serverCookie(client, ip, uint32(now.Unix()))
+}
+
+// validate returns whether a server cookie was generated for a client by any
+// of the secrets, and is still fresh. It also returns whether it should be
+// refreshed.
+func (s *Secrets) validate(client, server []byte, ip net.IP, now time.Time) (valid, refresh bool) {
+	if len(server) != serverCookieLen || server[0] != cookieVersion {
+		return false, false
+	}
+	// Serial number arithmetic, see RFC 9018 section 4.3.
+	age := int32(uint32(now.Unix()) - binary.BigEndian.Uint32(server[4:8]))
+	if age > int32(maxAge/time.Second) || age < -int32(maxClockSkew/time.Second) {
+		return false, false
+	}
+	expected := make([]byte, serverCookieLen)
+	copy(expected, server[:8])
+	for _, key := range *s.keys.Load() {
+		if subtle.ConstantTimeCompare(key.sign(expected, client, ip), server) == 1 {
+			return true, age > int32(refreshAge/time.Second)
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package db
+
+import (
+	"fmt"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+
+	"github.com/miekg/dns"
+)
+
+// TypeALIAS is the RR type of the ALIAS records stored in the DB.
+const TypeALIAS = uint16(dnsdata.TypeALIAS)
+
+func init() {
+	dns.PrivateHandle(dnsdata.TypeALIAS.String(), TypeALIAS, func() dns.PrivateRdata { return new(Alias) })
+}
+
+// Alias is the rdata of ALIAS records: the name whose A/AAAA records are
+// answered for the owner of the record.
+type Alias struct {
+	Target string
+}
+
+// String implements dns.PrivateRdata
+func (a *Alias) String() string {
+	return a.Target
+}
+
+// Parse implements dns.PrivateRdata
+func (a *Alias) Parse(txt []string) error {
+	if len(txt) != 1 {
+		return fmt.Errorf("ALIAS takes a single target, got %d fields", len(txt))
+	}
+	target := dns.Fqdn(txt[0])
+	if _, ok := dns.IsDomainName(target); !ok {
+		return fmt.Errorf("invalid ALIAS target %q", txt[0])
+	}
+	a.Target = target
+	return nil
+}
+
+// Pack implements dns.PrivateRdata
+func (a *Alias) Pack(buf []byte) (int, error) {
+	return dns.PackDomainName(a.Target, buf, 0, nil, false)
+}
+
+// Unpack implements dns.PrivateRdata
+func (a *Alias) Unpack(buf []byte) (int, error) {
+	var (
+		off int
+		err error
+	)
+	a.Target, off, err = dns.UnpackDomainName(buf, 0)
+	return off, err
+}
+
+// Copy implements dns.PrivateRdata
+func (a *Alias) Copy(dest dns.PrivateRdata) error {
+	d, ok := dest.(*Alias)
+	if !ok {
+		return dns.ErrRdata
+	}
+	d.Target = a.Target
+	return nil
+}
+
+// Len implements dns.PrivateRdata
+func (a *Alias) Len() int {
+	return len(a.Target) + 1
+}
+
+// AliasTarget returns the target of rr if it is an ALIAS record.
+func AliasTarget(rr dns.RR) (string, bool) {
+	p, ok := rr.(*dns.PrivateRR)
+	if !ok {
+		return "", false
+	}
+	a, ok := p.Data.(*Alias)
+	if !ok {
+		return "", false
+	}
+	return a.Target, true
+}
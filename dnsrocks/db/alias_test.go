@@ -0,0 +1,42 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package db
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAliasRR(t *testing.T) {
+	rr, err := dns.NewRR("example.org. 300 IN ALIAS www.example.org.")
+	require.NoError(t, err)
+	target, ok := AliasTarget(rr)
+	require.True(t, ok)
+	require.Equal(t, "www.example.org.", target)
+
+	buf := make([]byte, 512)
+	off, err := dns.PackRR(rr, buf, 0, nil, false)
+	require.NoError(t, err)
+	unpacked, _, err := dns.UnpackRR(buf[:off], 0)
+	require.NoError(t, err)
+	require.Equal(t, rr.String(), unpacked.String())
+
+	_, ok = AliasTarget(&dns.CNAME{Target: "www.example.org."})
+	require.False(t, ok)
+}
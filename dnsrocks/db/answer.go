@@ -23,6 +23,8 @@ import (
 	"io"
 	"math"
 
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+
 	"github.com/golang/glog"
 	"github.com/miekg/dns"
 )
@@ -33,6 +35,12 @@ type ResourceRecord struct {
 	Qtype  uint16
 	TTL    uint32
 	Offset int
+	// Weighted is set for the records of other types than A/AAAA which are
+	// picked by weighted random sample, see dnsdata.WeightedRecordFlag.
+	Weighted bool
+	// HealthTag is the health tag of the A/AAAA records, if any, see
+	// dnsdata.HealthTaggedRecordFlag and HealthChecker.
+	HealthTag []byte
 }
 
 var (
@@ -50,9 +58,35 @@ type recordProcessor struct {
 	seenError   bool
 	wrs         Wrs
 	recordFound bool
-	wildcard    bool
-	qname       string
-	qtype       uint16
+	// matched is the number of records of the type looked up found so far,
+	// which tells whether a location of the chain has them, see LocationChain.
+	matched  int
+	wildcard bool
+	qname    string
+	qtype    uint16
+	// dnssec is set when the stored signatures are requested (DO bit).
+	dnssec bool
+	sigs   []storedSignature
+	// labels is the number of labels of the name being looked up, which
+	// differs from qname's once we walk up to its ancestors.
+	labels int
+	// dname is the DNAME found at an ancestor of qname, see parseDNAME.
+	dname *dns.DNAME
+	// yxdomain is set when the name synthesized from dname is too long.
+	yxdomain bool
+	// healthy tells whether the health tagged records may be answered.
+	healthy func(tag []byte) bool
+	// unhealthy holds the sample of the A/AAAA records with an unhealthy tag,
+	// answered only when none of their type is healthy, see failOpen.
+	unhealthy Wrs
+}
+
+// storedSignature is a RRSIG record stored along with the RRset it covers.
+type storedSignature struct {
+	rr *dns.RRSIG
+	// located is set for the signatures of location records, which cover the
+	// location records along with the default location ones.
+	located bool
 }
 
 func (rp *recordProcessor) parseResult(result []byte) error {
@@ -66,6 +100,11 @@ func (rp *recordProcessor) parseResult(result []byte) error {
 
 	if rec, err = ExtractRRFromRow(result, rp.wildcard); err != nil {
 		if errors.Is(err, ErrWildcardMismatch) {
+			// A DNAME redirects the names below its owner, which we look up
+			// in wildcard mode.
+			if rp.wildcard && rec.Qtype == dns.TypeDNAME {
+				return rp.parseDNAME(result)
+			}
 			// ignore wildcard mismatch errors to stay consistent with existing system behavior
 			// nolint: nilerr
 			return nil
@@ -74,27 +113,43 @@ func (rp *recordProcessor) parseResult(result []byte) error {
 		return err
 	}
 	rp.recordFound = true
-	if rec.Qtype == dns.TypeCNAME || rec.Qtype == rp.qtype || rp.qtype == dns.TypeANY {
+	if rec.Qtype == dns.TypeRRSIG && rp.qtype != dns.TypeRRSIG {
+		// Stored signatures are only answered along with the RRsets they
+		// cover, see appendSignatures.
+		if !rp.dnssec {
+			return nil
+		}
+		if rr, err = rp.unpack(rec, result); err != nil {
+			return err
+		}
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			ch := result[2]
+			rp.sigs = append(rp.sigs, storedSignature{rr: sig, located: ch == '='+1 || ch == '*'+1})
+		}
+		return nil
+	}
+	// ALIAS records are resolved to the A/AAAA records of their target by
+	// the caller, and never answered as such.
+	if rec.Qtype == TypeALIAS && rp.qtype != dns.TypeA && rp.qtype != dns.TypeAAAA {
+		return nil
+	}
+	if rec.Qtype == dns.TypeCNAME || rec.Qtype == rp.qtype || rp.qtype == dns.TypeANY || rec.Qtype == TypeALIAS {
+		rp.matched++
 		// When dealing with A/AAAA we may have weighted round-robin records
 		// Compute the weight and update wrr4/wrr6 with the current winner.
 		// When we are done looping, we will add the record to the answer.
-		if rec.Qtype == dns.TypeA || rec.Qtype == dns.TypeAAAA {
-			if err = rp.wrs.Add(rec, result); err != nil {
+		if rec.Qtype == dns.TypeA || rec.Qtype == dns.TypeAAAA || rec.Weighted {
+			wrs := &rp.wrs
+			if len(rec.HealthTag) > 0 && rp.healthy != nil && !rp.healthy(rec.HealthTag) {
+				wrs = &rp.unhealthy
+			}
+			if err = wrs.Add(rec, result); err != nil {
 				rp.seenError = true
 				glog.Errorf("Failed in adding record to WRS: %v", err)
 			}
 			// For other records, we append them to the answer.
 		} else {
-			rdlength := len(result[rec.Offset:])
-			if rdlength > math.MaxUint16 {
-				err = errors.New("integer overflow for uint16 RR_Header.Rdlength")
-				glog.Errorf("Failed to create RR_Header: %v, max value is %d, tried assigning %d", err, math.MaxUint16, rdlength)
-				return err
-			}
-			hdr := dns.RR_Header{Name: rp.qname, Rrtype: rec.Qtype, Class: dns.ClassINET, Ttl: rec.TTL, Rdlength: uint16(rdlength)}
-			rr, _, err = dns.UnpackRRWithHeader(hdr, result, rec.Offset)
-			if err != nil {
-				glog.Errorf("Failed to create resource record %v %d, %d, qname: %s", err, hdr.Rdlength, len(result[rec.Offset:]), rp.qname)
+			if rr, err = rp.unpack(rec, result); err != nil {
 				return err
 			}
 			rp.msg.Answer = append(rp.msg.Answer, rr)
@@ -103,6 +158,131 @@ func (rp *recordProcessor) parseResult(result []byte) error {
 	return nil
 }
 
+// parseDNAME records the DNAME of a DB row owned by the ancestor of qname
+// currently looked up.
+func (rp *recordProcessor) parseDNAME(result []byte) error {
+	rec, err := ExtractRRFromRow(result, false)
+	if err != nil {
+		glog.Errorf("Failed to extract rr from row: %v", err)
+		return err
+	}
+	rr, err := rp.unpack(rec, result)
+	if err != nil {
+		return err
+	}
+	dname, ok := rr.(*dns.DNAME)
+	if !ok || rp.dname != nil {
+		return nil
+	}
+	idx := dns.Split(rp.qname)
+	if rp.labels == 0 {
+		dname.Hdr.Name = "."
+	} else {
+		dname.Hdr.Name = rp.qname[idx[len(idx)-rp.labels]:]
+	}
+	rp.dname = dname
+	rp.recordFound = true
+	return nil
+}
+
+// synthesizeCNAME replaces the answer found from start with the DNAME and the
+// CNAME synthesized from it, see https://datatracker.ietf.org/doc/html/rfc6672#section-3.
+// Records matched by wildcards at the DNAME owner are occluded by it.
+func (rp *recordProcessor) synthesizeCNAME(start int) {
+	rp.msg.Answer = append(rp.msg.Answer[:start], rp.dname)
+	rp.wrs = Wrs{MaxAnswers: rp.wrs.MaxAnswers, Seed: rp.wrs.Seed}
+	rp.unhealthy = Wrs{MaxAnswers: rp.wrs.MaxAnswers, Seed: rp.wrs.Seed}
+	rp.sigs = nil
+
+	owner := rp.dname.Hdr.Name
+	prefix := rp.qname
+	if owner != "." {
+		prefix = rp.qname[:len(rp.qname)-len(owner)]
+	}
+	target := prefix + rp.dname.Target
+	if rp.dname.Target == "." {
+		target = prefix
+	}
+	if _, ok := dns.IsDomainName(target); !ok {
+		rp.yxdomain = true
+		return
+	}
+	rp.msg.Answer = append(rp.msg.Answer, &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: rp.qname, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: rp.dname.Hdr.Ttl},
+		Target: target,
+	})
+}
+
+// failOpen answers the A/AAAA records with an unhealthy tag when there is no
+// healthy record of their type: answering a backend which may be down beats
+// answering none.
+func (rp *recordProcessor) failOpen() {
+	if rp.wrs.V4Count == 0 && rp.unhealthy.V4Count > 0 {
+		rp.wrs.V4, rp.wrs.V4Count = rp.unhealthy.V4, rp.unhealthy.V4Count
+		rp.unhealthy.V4, rp.unhealthy.V4Count = nil, 0
+	}
+	if rp.wrs.V6Count == 0 && rp.unhealthy.V6Count > 0 {
+		rp.wrs.V6, rp.wrs.V6Count = rp.unhealthy.V6, rp.unhealthy.V6Count
+		rp.unhealthy.V6, rp.unhealthy.V6Count = nil, 0
+	}
+}
+
+// unpack creates the resource record of a DB row, owned by the qname.
+func (rp *recordProcessor) unpack(rec ResourceRecord, result []byte) (dns.RR, error) {
+	rdlength := len(result[rec.Offset:])
+	if rdlength > math.MaxUint16 {
+		err := errors.New("integer overflow for uint16 RR_Header.Rdlength")
+		glog.Errorf("Failed to create RR_Header: %v, max value is %d, tried assigning %d", err, math.MaxUint16, rdlength)
+		return nil, err
+	}
+	hdr := dns.RR_Header{Name: rp.qname, Rrtype: rec.Qtype, Class: dns.ClassINET, Ttl: rec.TTL, Rdlength: uint16(rdlength)}
+	rr, _, err := dns.UnpackRRWithHeader(hdr, result, rec.Offset)
+	if err != nil {
+		glog.Errorf("Failed to create resource record %v %d, %d, qname: %s", err, hdr.Rdlength, len(result[rec.Offset:]), rp.qname)
+		return nil, err
+	}
+	return rr, nil
+}
+
+// appendSignatures appends the stored signatures covering the RRsets of the
+// answer. Signatures stored for the location take precedence over the default
+// location ones, as they cover the location records along with the default
+// location ones.
+// A and AAAA RRsets are only signed when all their records are answered: the
+// subset picked by the weighted random sample, or left by the health checks,
+// would not match the signature.
+func (rp *recordProcessor) appendSignatures(answer []dns.RR) {
+	if len(rp.sigs) == 0 {
+		return
+	}
+	covered := make(map[uint16]bool)
+	for _, rr := range answer {
+		covered[rr.Header().Rrtype] = true
+	}
+	if rp.wrs.V4Count > uint32(len(rp.wrs.V4)) || rp.unhealthy.V4Count > 0 {
+		delete(covered, dns.TypeA)
+	}
+	if rp.wrs.V6Count > uint32(len(rp.wrs.V6)) || rp.unhealthy.V6Count > 0 {
+		delete(covered, dns.TypeAAAA)
+	}
+	for qtype, sample := range rp.wrs.Others {
+		if sample.Count > uint32(len(sample.Items)) {
+			delete(covered, qtype)
+		}
+	}
+	located := make(map[uint16]bool)
+	for _, sig := range rp.sigs {
+		if sig.located {
+			located[sig.rr.TypeCovered] = true
+		}
+	}
+	for _, sig := range rp.sigs {
+		if covered[sig.rr.TypeCovered] && sig.located == located[sig.rr.TypeCovered] {
+			rp.msg.Answer = append(rp.msg.Answer, sig.rr)
+		}
+	}
+}
+
 func (rp *recordProcessor) responseCode() int {
 	// If any records are returned, we suppress most errors. This ensures that we don't
 	// drop a potentially useful response. However, if no records are returned and an error
@@ -112,6 +292,10 @@ func (rp *recordProcessor) responseCode() int {
 		return dns.RcodeServerFailure
 	}
 
+	if rp.yxdomain {
+		return dns.RcodeYXDomain
+	}
+
 	if !rp.recordFound {
 		return dns.RcodeNameError
 	}
@@ -142,7 +326,9 @@ func dnsLabelWildsafe(q []byte) bool {
 // A DB row contains data ResourceRecord information:
 // qtype (2) ch (1) recordloc (2+)? ttl (4) ttd (8) weight (4)? rdata (vlen)
 // recordloc is present only if ch == '+' + 1 or ch == '*' + 1
-// weight is only present if qtype is A or AAAA
+// weight is only present if qtype is A or AAAA, or if ch has the
+// dnsdata.WeightedRecordFlag set. It is followed by the health tag, as
+// taglen (1) tag (taglen), if ch has the dnsdata.HealthTaggedRecordFlag set
 // It returns a pointer to a ResourceRecord, nil when not a proper match.
 // nil is returned when the row is not matching the specific filters (e.g
 // Location or wildcard).
@@ -155,6 +341,9 @@ func ExtractRRFromRow(row []byte, wildcard bool) (rr ResourceRecord, err error)
 	if err != nil {
 		return
 	}
+	rr.Weighted = ch&dnsdata.WeightedRecordFlag != 0
+	healthTagged := ch&dnsdata.HealthTaggedRecordFlag != 0
+	ch &^= dnsdata.WeightedRecordFlag | dnsdata.HealthTaggedRecordFlag
 
 	// Only handle wildcard records when in wildcard mode.
 	if wildcard != (ch == '*' || ch == '*'+1) {
@@ -187,17 +376,41 @@ func ExtractRRFromRow(row []byte, wildcard bool) (rr ResourceRecord, err error)
 	if _, err = r.Seek(8, io.SeekCurrent); err != nil {
 		return
 	}
-	// Only A and AAAA records have a weight.
-	if rr.Qtype == dns.TypeAAAA || rr.Qtype == dns.TypeA {
+	// Only A and AAAA records, and weighted ones, have a weight.
+	if rr.Qtype == dns.TypeAAAA || rr.Qtype == dns.TypeA || rr.Weighted {
 		if err = binary.Read(r, binary.BigEndian, &rr.Weight); err != nil {
 			return
 		}
 	}
+	if healthTagged {
+		var n byte
+		if n, err = r.ReadByte(); err != nil {
+			return
+		}
+		off := int(r.Size()) - r.Len()
+		if r.Len() < int(n) {
+			return rr, io.ErrUnexpectedEOF
+		}
+		rr.HealthTag = row[off : off+int(n)]
+		if _, err = r.Seek(int64(n), io.SeekCurrent); err != nil {
+			return
+		}
+	}
 	rr.Offset = int(r.Size()) - r.Len()
 
 	return rr, nil
 }
 
+// labelCount returns the number of labels of a packed name, which may lack
+// its terminating root label.
+func labelCount(q []byte) int {
+	n := 0
+	for i := 0; i < len(q) && q[i] != 0; i += int(q[i]) + 1 {
+		n++
+	}
+	return n
+}
+
 // IsAuthoritative find whether or not we are authoritative and have NS
 // records for the given domain. Starting from the original qname, it
 // iterates through every possible parent domain by removing 1 label at a time
@@ -262,30 +475,48 @@ func (r *DataReader) IsAuthoritative(q []byte, locID ID) (ns bool, auth bool, zo
 	return
 }
 
-// FindAnswer will find answers for a given query q
-func (r *DataReader) FindAnswer(q []byte, packedControlName []byte, qname string, qtype uint16, locID ID, a *dns.Msg, maxAnswer int) (bool, int) {
+// FindAnswer will find answers for a given query q. When dnssec is set, the
+// stored signatures of the RRsets found are answered along with them. A non-zero
+// seed makes the weighted random sample deterministic, see Wrs.Seed.
+func (r *DataReader) FindAnswer(q []byte, packedControlName []byte, qname string, qtype uint16, locID ID, a *dns.Msg, maxAnswer int, dnssec bool, seed uint64) (bool, int) {
 	var (
-		rrs []dns.RR
-		err error
-		key = make([]byte, len(q)+len(locID))
-		rp  = &recordProcessor{
-			msg:   a,
-			wrs:   Wrs{MaxAnswers: maxAnswer},
-			qname: qname,
-			qtype: qtype,
+		rrs   []dns.RR
+		err   error
+		key   = make([]byte, len(q)+len(locID))
+		start = len(a.Answer)
+		rp    = &recordProcessor{
+			msg:       a,
+			wrs:       Wrs{MaxAnswers: maxAnswer, Seed: seed},
+			qname:     qname,
+			qtype:     qtype,
+			dnssec:    dnssec,
+			healthy:   r.healthCheck(),
+			unhealthy: Wrs{MaxAnswers: maxAnswer, Seed: seed},
 		}
 	)
 
+	chain, err := r.LocationChain(locID)
+	if err != nil {
+		rp.seenError = true
+		glog.Errorf("%v", err)
+	}
+
 	for {
-		// Add location prefix to qname
-		if !locID.IsZero() {
-			key = append(key[:0], locID...)
-			key = append(key[:len(locID)], q...)
+		rp.labels = labelCount(q)
+		// Add location prefix to qname, walking up the chain of the location
+		// until it has records of the type looked up
+		matched := rp.matched
+		for _, id := range chain {
+			key = append(key[:0], id...)
+			key = append(key, q...)
 			err = r.ForEach(key, rp.parseResult)
 			if err != nil {
 				rp.seenError = true
 				glog.Errorf("%v", err)
 			}
+			if rp.matched > matched {
+				break
+			}
 		}
 
 		key = append(key[:0], ZeroID...)
@@ -296,6 +527,12 @@ func (r *DataReader) FindAnswer(q []byte, packedControlName []byte, qname string
 			glog.Errorf("%v", err)
 		}
 
+		if rp.dname != nil {
+			rp.synthesizeCNAME(start)
+			break
+		}
+
+		rp.failOpen()
 		// append A/AAAA records with the selected RR record
 		if rrs, err = rp.wrs.ARecord(qname, dns.ClassINET); err != nil {
 			rp.seenError = true
@@ -309,9 +546,16 @@ func (r *DataReader) FindAnswer(q []byte, packedControlName []byte, qname string
 		} else {
 			a.Answer = append(a.Answer, rrs...)
 		}
+		if rrs, err = rp.wrs.OtherRecords(qname, dns.ClassINET); err != nil {
+			rp.seenError = true
+			glog.Errorf("%v", err)
+		} else {
+			a.Answer = append(a.Answer, rrs...)
+		}
 
 		// recordFound is modified by recordProcessor's parseResult
 		if rp.recordFound {
+			rp.appendSignatures(a.Answer[start:])
 			break
 		}
 		if bytes.Equal(q, packedControlName) {
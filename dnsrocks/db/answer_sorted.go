@@ -25,19 +25,26 @@ import (
 	"github.com/miekg/dns"
 )
 
-func (r *sortedDataReader) FindAnswer(q []byte, packedControlName []byte, qname string, qtype uint16, locID ID, a *dns.Msg, maxAnswer int) (bool, int) {
+func (r *sortedDataReader) FindAnswer(q []byte, packedControlName []byte, qname string, qtype uint16, locID ID, a *dns.Msg, maxAnswer int, dnssec bool, seed uint64) (bool, int) {
 	var (
-		rrs []dns.RR
-		err error
-		rp  = &recordProcessor{
-			msg:   a,
-			wrs:   Wrs{MaxAnswers: maxAnswer},
-			qname: qname,
-			qtype: qtype,
+		rrs   []dns.RR
+		err   error
+		start = len(a.Answer)
+		rp    = &recordProcessor{
+			msg:       a,
+			wrs:       Wrs{MaxAnswers: maxAnswer, Seed: seed},
+			qname:     qname,
+			qtype:     qtype,
+			dnssec:    dnssec,
+			healthy:   r.healthCheck(),
+			unhealthy: Wrs{MaxAnswers: maxAnswer, Seed: seed},
 		}
 	)
 
-	var lastLength = len(q)
+	var (
+		lastLength = len(q)
+		matched    int
+	)
 
 	preIterationCheck := func(q []byte, length int) bool {
 		// we iterate on labels of initial qName, so length check should be sufficient to determine
@@ -66,11 +73,19 @@ func (r *sortedDataReader) FindAnswer(q []byte, packedControlName []byte, qname
 		}
 
 		lastLength = length
+		rp.labels = labelCount(q[:length-1])
+		matched = rp.matched
 
 		return true
 	}
 
 	postIterationCheck := func() bool {
+		if rp.dname != nil {
+			rp.synthesizeCNAME(start)
+			return false
+		}
+
+		rp.failOpen()
 		// append A/AAAA records with the selected RR record
 		if rrs, err = rp.wrs.ARecord(qname, dns.ClassINET); err != nil {
 			rp.seenError = true
@@ -84,8 +99,15 @@ func (r *sortedDataReader) FindAnswer(q []byte, packedControlName []byte, qname
 		} else {
 			a.Answer = append(a.Answer, rrs...)
 		}
+		if rrs, err = rp.wrs.OtherRecords(qname, dns.ClassINET); err != nil {
+			rp.seenError = true
+			glog.Errorf("%v", err)
+		} else {
+			a.Answer = append(a.Answer, rrs...)
+		}
 
 		if rp.recordFound {
+			rp.appendSignatures(a.Answer[start:])
 			return false
 		}
 
@@ -94,7 +116,21 @@ func (r *sortedDataReader) FindAnswer(q []byte, packedControlName []byte, qname
 		return true
 	}
 
-	err = r.find(q, locID, rp.parseResult, preIterationCheck, postIterationCheck)
+	chain, err := r.LocationChain(locID)
+	if err != nil {
+		rp.seenError = true
+		glog.Errorf("%v", err)
+	}
+
+	// walk up the chain of the location until it has records of the type
+	// looked up
+	levelFound := func() bool {
+		found := rp.matched > matched
+		matched = rp.matched
+		return found
+	}
+
+	err = r.find(q, chain, rp.parseResult, levelFound, preIterationCheck, postIterationCheck)
 	if err != nil {
 		rp.seenError = true
 	}
@@ -131,17 +167,25 @@ func (r *sortedDataReader) IsAuthoritative(q []byte, locID ID) (ns bool, auth bo
 		return !ns
 	}
 
-	_ = r.find(q, locID, parseResult, preIterationCheck, postIterationCheck)
+	var chain []ID
+	if !locID.IsZero() {
+		chain = []ID{locID}
+	}
+	_ = r.find(q, chain, parseResult, nil, preIterationCheck, postIterationCheck)
 
 	zoneCut = q[len(q)-zoneCutLength:]
 
 	return
 }
 
+// find looks up q and its ancestors in the locations of chain, then in the
+// default location. The locations of the chain are looked up in order until
+// levelFound, when set, tells the last one looked up is the one answering.
 func (r *sortedDataReader) find(
 	q []byte,
-	locID ID,
+	chain []ID,
 	parseResult func(value []byte) error,
+	levelFound func() bool,
 	preIterationCheck func(qName []byte, currentLength int) bool,
 	postIterationCheck func() bool,
 ) error {
@@ -151,7 +195,7 @@ func (r *sortedDataReader) find(
 
 	qLength := len(reversedQName)
 
-	key := make([]byte, len(q)+max(len(locID), len(ZeroID))+len(dnsdata.ResourceRecordsKeyMarker))
+	key := make([]byte, len(q)+len(ZeroID)+len(dnsdata.ResourceRecordsKeyMarker))
 	copy(key, []byte(dnsdata.ResourceRecordsKeyMarker))
 
 	domainNameStart := len(dnsdata.ResourceRecordsKeyMarker)
@@ -165,20 +209,37 @@ func (r *sortedDataReader) find(
 		// mark domain name end. we can avoid re-copying as we are cutting labels from the end
 		key[locationStart-1] = 0x00
 
-		key = append(key[:locationStart], locID...)
-
 		// new key that is equal to what we asked for, or less than it.
 		// This new key can be very different from what we requested, i.e.
 		// for com.example.foo (if exact match is not found) previous key will be returned,
 		// which doesn't guaranteed to even start with com.example, it can be com.examnle.foo for what we know
 		var k []byte
-		k, err = r.TryForEach(key, parseResult)
+		if len(chain) == 0 {
+			key = append(key[:locationStart], ZeroID...)
+			k, err = r.TryForEach(key, parseResult)
+		}
+		for i, id := range chain {
+			key = append(key[:locationStart], id...)
+			var found []byte
+			found, err = r.TryForEach(key, parseResult)
+			if err != nil {
+				break
+			}
+			// the ancestors of the location may sort after the default
+			// location, only the first key tells where to go next
+			if i == 0 {
+				k = found
+			}
+			if levelFound != nil && levelFound() {
+				break
+			}
+		}
 		if err != nil {
 			break
 		}
 
 		// zone cut for different location exists -> check default location
-		if !locID.IsZero() &&
+		if len(chain) > 0 &&
 			// empty location override might exists as only location part is different in found key
 			bytes.HasPrefix(k, key[:locationStart]) {
 			key = append(key[:locationStart], ZeroID...)
@@ -19,6 +19,7 @@ package db
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/miekg/dns"
@@ -302,7 +303,7 @@ func TestDBFindAnswer(t *testing.T) {
 				a.Compress = true
 				a.Authoritative = true
 
-				weighted, rcode := r.FindAnswer(q[:offset], controlName[:controlOffset], tc.qname, tc.qtype, tc.locID, a, 10)
+				weighted, rcode := r.FindAnswer(q[:offset], controlName[:controlOffset], tc.qname, tc.qtype, tc.locID, a, 10, false, 0)
 				require.False(t, weighted)
 				require.Equal(t, tc.expectedRcode, rcode)
 
@@ -317,6 +318,282 @@ func TestDBFindAnswer(t *testing.T) {
 	}
 }
 
+func TestDBFindAnswerDNAME(t *testing.T) {
+	var q = make([]byte, 255)
+	var controlName = make([]byte, 255)
+	long := strings.Repeat("a", 63) + ".long.example.org."
+
+	testCases := []struct {
+		qname         string
+		qtype         uint16
+		expectedRcode int
+		expected      []string
+	}{
+		{
+			qname:         "www.old.example.org.",
+			qtype:         dns.TypeA,
+			expectedRcode: dns.RcodeSuccess,
+			expected: []string{
+				"old.example.org.\t3600\tIN\tDNAME\tnew.example.org.",
+				"www.old.example.org.\t3600\tIN\tCNAME\twww.new.example.org.",
+			},
+		},
+		{
+			qname:         "a.b.old.example.org.",
+			qtype:         dns.TypeTXT,
+			expectedRcode: dns.RcodeSuccess,
+			expected: []string{
+				"old.example.org.\t3600\tIN\tDNAME\tnew.example.org.",
+				"a.b.old.example.org.\t3600\tIN\tCNAME\ta.b.new.example.org.",
+			},
+		},
+		// the DNAME owner itself is not redirected
+		{
+			qname:         "old.example.org.",
+			qtype:         dns.TypeDNAME,
+			expectedRcode: dns.RcodeSuccess,
+			expected: []string{
+				"old.example.org.\t3600\tIN\tDNAME\tnew.example.org.",
+			},
+		},
+		{
+			qname:         "old.example.org.",
+			qtype:         dns.TypeA,
+			expectedRcode: dns.RcodeSuccess,
+		},
+		{
+			qname:         long,
+			qtype:         dns.TypeA,
+			expectedRcode: dns.RcodeYXDomain,
+			expected: []string{
+				"long.example.org.\t3600\tIN\tDNAME\t" + strings.Repeat(strings.Repeat("a", 63)+".", 3) + "example.org.",
+			},
+		},
+	}
+
+	for _, config := range testaid.TestDBs {
+		db, err := Open(config.Path, config.Driver)
+		require.Nil(t, err, "could not open fixture database")
+		r, err := NewReader(db)
+		require.Nil(t, err, "could not open db file")
+
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s/%s/%s", config.Driver, tc.qname, dns.TypeToString[tc.qtype]), func(t *testing.T) {
+				offset, err := dns.PackDomainName(tc.qname, q, 0, nil, false)
+				require.NoError(t, err)
+				controlOffset, err := dns.PackDomainName("example.org.", controlName, 0, nil, false)
+				require.NoError(t, err)
+				a := new(dns.Msg)
+
+				weighted, rcode := r.FindAnswer(q[:offset], controlName[:controlOffset], tc.qname, tc.qtype, ZeroID, a, 10, false, 0)
+				require.False(t, weighted)
+				require.Equal(t, tc.expectedRcode, rcode)
+
+				answer := []string{}
+				for _, rr := range a.Answer {
+					answer = append(answer, rr.String())
+				}
+				require.ElementsMatch(t, tc.expected, answer)
+			})
+		}
+	}
+}
+
+func TestDBFindAnswerWeighted(t *testing.T) {
+	var q = make([]byte, 255)
+	var controlName = make([]byte, 255)
+	qname := "weighted.example.org."
+
+	for _, config := range testaid.TestDBs {
+		db, err := Open(config.Path, config.Driver)
+		require.Nil(t, err, "could not open fixture database")
+		r, err := NewReader(db)
+		require.Nil(t, err, "could not open db file")
+
+		t.Run(config.Driver, func(t *testing.T) {
+			offset, err := dns.PackDomainName(qname, q, 0, nil, false)
+			require.NoError(t, err)
+			controlOffset, err := dns.PackDomainName("example.org.", controlName, 0, nil, false)
+			require.NoError(t, err)
+			a := new(dns.Msg)
+
+			weighted, rcode := r.FindAnswer(q[:offset], controlName[:controlOffset], qname, dns.TypeA, ZeroID, a, 10, false, 0)
+			require.True(t, weighted)
+			require.Equal(t, dns.RcodeSuccess, rcode)
+			require.Len(t, a.Answer, 1)
+			cname, ok := a.Answer[0].(*dns.CNAME)
+			require.True(t, ok)
+			require.Equal(t, qname, cname.Hdr.Name)
+			require.Contains(t, []string{"foo.example.org.", "bar.example.org."}, cname.Target)
+		})
+	}
+}
+
+// testHealth maps the unhealthy tags to true.
+type testHealth map[string]bool
+
+func (h testHealth) Healthy(tag string) bool {
+	return !h[tag]
+}
+
+func TestDBFindAnswerHealth(t *testing.T) {
+	var q = make([]byte, 255)
+	var controlName = make([]byte, 255)
+	qname := "health.example.org."
+
+	testCases := []struct {
+		name      string
+		unhealthy testHealth
+		qtype     uint16
+		expected  []string
+		tags      []string
+	}{
+		{
+			name:     "no health state",
+			qtype:    dns.TypeA,
+			expected: []string{"1.1.1.10", "1.1.1.11"},
+			tags:     []string{"pool-a", "pool-b"},
+		},
+		{
+			name:      "unhealthy tag skipped",
+			unhealthy: testHealth{"pool-a": true},
+			qtype:     dns.TypeA,
+			expected:  []string{"1.1.1.11"},
+			tags:      []string{"pool-a", "pool-b"},
+		},
+		{
+			name:      "fail open when all are unhealthy",
+			unhealthy: testHealth{"pool-a": true, "pool-b": true},
+			qtype:     dns.TypeA,
+			expected:  []string{"1.1.1.10", "1.1.1.11"},
+			tags:      []string{"pool-a", "pool-b"},
+		},
+		{
+			name:      "fail open per type",
+			unhealthy: testHealth{"pool-a": true},
+			qtype:     dns.TypeAAAA,
+			expected:  []string{"fd00::10"},
+			tags:      []string{"pool-a"},
+		},
+	}
+
+	for _, config := range testaid.TestDBs {
+		db, err := Open(config.Path, config.Driver)
+		require.Nil(t, err, "could not open fixture database")
+
+		for _, tc := range testCases {
+			t.Run(config.Driver+"/"+tc.name, func(t *testing.T) {
+				db.SetHealthChecker(tc.unhealthy)
+				r, err := NewReader(db)
+				require.Nil(t, err, "could not open db file")
+				defer r.Close()
+
+				offset, err := dns.PackDomainName(qname, q, 0, nil, false)
+				require.NoError(t, err)
+				controlOffset, err := dns.PackDomainName("example.org.", controlName, 0, nil, false)
+				require.NoError(t, err)
+				a := new(dns.Msg)
+
+				_, rcode := r.FindAnswer(q[:offset], controlName[:controlOffset], qname, tc.qtype, ZeroID, a, 10, false, 0)
+				require.Equal(t, dns.RcodeSuccess, rcode)
+				var addrs []string
+				for _, rr := range a.Answer {
+					switch rr := rr.(type) {
+					case *dns.A:
+						addrs = append(addrs, rr.A.String())
+					case *dns.AAAA:
+						addrs = append(addrs, rr.AAAA.String())
+					}
+				}
+				require.ElementsMatch(t, tc.expected, addrs)
+				require.ElementsMatch(t, tc.tags, r.HealthTags())
+			})
+		}
+	}
+}
+
+func TestDBFindAnswerLocationChain(t *testing.T) {
+	var q = make([]byte, 255)
+	var controlName = make([]byte, 255)
+	qname := "chain.example.org."
+
+	testCases := []struct {
+		name     string
+		locID    ID
+		qtype    uint16
+		expected []string
+	}{
+		{
+			name:     "answered by the location",
+			locID:    ID{0, 041},
+			qtype:    dns.TypeA,
+			expected: []string{"10.0.41.1"},
+		},
+		{
+			name:     "answered by the parent",
+			locID:    ID{0, 041},
+			qtype:    dns.TypeAAAA,
+			expected: []string{"fd00::42"},
+		},
+		{
+			name:     "answered by the grandparent",
+			locID:    ID{0, 042},
+			qtype:    dns.TypeA,
+			expected: []string{"10.0.43.1"},
+		},
+		{
+			name:     "answered by the default location",
+			locID:    ID{0, 041},
+			qtype:    dns.TypeTXT,
+			expected: []string{"default"},
+		},
+		{
+			name:  "not answered by the children",
+			locID: ID{0, 043},
+			qtype: dns.TypeAAAA,
+		},
+		{
+			name:  "location without chain",
+			locID: ID{0, 044},
+			qtype: dns.TypeA,
+		},
+	}
+
+	for _, config := range testaid.TestDBs {
+		db, err := Open(config.Path, config.Driver)
+		require.Nil(t, err, "could not open fixture database")
+
+		for _, tc := range testCases {
+			t.Run(config.Driver+"/"+tc.name, func(t *testing.T) {
+				r, err := NewReader(db)
+				require.Nil(t, err, "could not open db file")
+				defer r.Close()
+
+				offset, err := dns.PackDomainName(qname, q, 0, nil, false)
+				require.NoError(t, err)
+				controlOffset, err := dns.PackDomainName("example.org.", controlName, 0, nil, false)
+				require.NoError(t, err)
+				a := new(dns.Msg)
+
+				_, rcode := r.FindAnswer(q[:offset], controlName[:controlOffset], qname, tc.qtype, tc.locID, a, 10, false, 0)
+				require.Equal(t, dns.RcodeSuccess, rcode)
+				var answers []string
+				for _, rr := range a.Answer {
+					switch rr := rr.(type) {
+					case *dns.A:
+						answers = append(answers, rr.A.String())
+					case *dns.AAAA:
+						answers = append(answers, rr.AAAA.String())
+					case *dns.TXT:
+						answers = append(answers, rr.Txt...)
+					}
+				}
+				require.ElementsMatch(t, tc.expected, answers)
+			})
+		}
+	}
+}
+
 func BenchmarkFindAnswer(b *testing.B) {
 	var (
 		packedQName = make([]byte, 255)
@@ -347,7 +624,7 @@ func BenchmarkFindAnswer(b *testing.B) {
 				a.Compress = true
 				a.Authoritative = true
 				for i := 0; i < b.N; i++ {
-					_, rcode := r.FindAnswer(packedQName[:offset], controlName[:controlOffset], bm.qname, bm.qtype, bm.locID, a, 10)
+					_, rcode := r.FindAnswer(packedQName[:offset], controlName[:controlOffset], bm.qname, bm.qtype, bm.locID, a, 10, false, 0)
 					if bm.expectedRcode == dns.RcodeNameError && rcode != dns.RcodeNameError {
 						b.Fatal("unexpectedly found missing record")
 					}
@@ -67,6 +67,7 @@ type DB struct {
 	destroyable bool
 	refCount    uint64
 	l           sync.RWMutex
+	health      HealthChecker
 }
 
 // Reader will be able to perform DNS queries.
@@ -74,7 +75,7 @@ type DB struct {
 type Reader interface {
 	FindLocation(qname []byte, ecs *dns.EDNS0_SUBNET, ip string) (loc *Location, err error)
 	IsAuthoritative(q []byte, locID ID) (ns bool, auth bool, zoneCut []byte, err error)
-	FindAnswer(q []byte, packedControlName []byte, qname string, qtype uint16, locID ID, a *dns.Msg, maxAnswer int) (bool, int)
+	FindAnswer(q []byte, packedControlName []byte, qname string, qtype uint16, locID ID, a *dns.Msg, maxAnswer int, dnssec bool, seed uint64) (bool, int)
 
 	EcsLocation(q []byte, ecs *dns.EDNS0_SUBNET) (*Location, error)
 	ResolverLocation(q []byte, ip string) (*Location, error)
@@ -82,6 +83,11 @@ type Reader interface {
 
 	ForEach(key []byte, f func(value []byte) error) (err error)
 	ForEachResourceRecord(domainName []byte, locID ID, parseRecord func(result []byte) error) error
+	ForEachLocationRecord(domainName []byte, locID ID, parseRecord func(result []byte) error) error
+	LocationChain(locID ID) ([]ID, error)
+	ForEachZoneRecord(zone []byte, locID ID, f func(owner []byte, rrs []dns.RR) error) error
+	FindNSEC3(zone []byte, hash string, locID ID) (rrs []dns.RR, match bool, err error)
+	HealthTags() []string
 
 	Close()
 }
@@ -91,6 +97,8 @@ type Reader interface {
 type DataReader struct {
 	db      *DB
 	context Context
+	// healthTags are the health tags checked by the reader, see HealthTags.
+	healthTags map[string]struct{}
 }
 
 type sortedDataReader struct {
@@ -107,6 +115,51 @@ var ErrReloadTimeout = errors.New("DB reload timeout")
 // Open opens the named file read-only and returns a new db object.  The file
 // should exist and be a compatible (CDB or RDB) database file.
 func Open(name string, driver string) (*DB, error) {
+	dbi, err := openDBI(name, driver)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{dbi: dbi}, nil
+}
+
+// OpenLayered opens the named base DB and the overlay DBs layered over it,
+// see Reader. The overlays are consulted in order before the base, the
+// records of an owner in a location shadowing those of the same type in the
+// next layers. The base is layer 0, and overlays[i] layer i+1, see
+// DB.ReloadLayer.
+func OpenLayered(name string, overlays []string, driver string) (*DB, error) {
+	if len(overlays) == 0 {
+		return Open(name, driver)
+	}
+	base, err := openDBI(name, driver)
+	if err != nil {
+		return nil, err
+	}
+	dbis := make([]DBI, 0, len(overlays))
+	closeAll := func() {
+		base.Close()
+		for _, dbi := range dbis {
+			dbi.Close()
+		}
+	}
+	for _, overlay := range overlays {
+		dbi, err := openDBI(overlay, driver)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		dbis = append(dbis, dbi)
+	}
+	layered, err := newLayeredDBI(base, dbis)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	return &DB{dbi: layered}, nil
+}
+
+// openDBI opens the named file with the given driver.
+func openDBI(name string, driver string) (DBI, error) {
 	var openfunc func(string) (DBI, error)
 
 	switch driver {
@@ -114,14 +167,12 @@ func Open(name string, driver string) (*DB, error) {
 		openfunc = openCDB
 	case "rocksdb":
 		openfunc = openRDB
+	case "pebble":
+		openfunc = openPDB
 	default:
-		return nil, fmt.Errorf("%s: invalid argument; valid values are: cdb, rocksdb", driver)
-	}
-	dbi, err := openfunc(name)
-	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: invalid argument; valid values are: cdb, rocksdb, pebble", driver)
 	}
-	return &DB{dbi: dbi}, nil
+	return openfunc(name)
 }
 
 // NewReader returns a new DB reader to be used to perform DNS record search in DB.
@@ -163,7 +214,24 @@ func (f *DB) Destroy() {
 // If path is different it will return new DB and close the old one. The validationKey is used
 // to verify that the format of the DB file is valid, by checking for the existence of a key
 // that is known to exist. If the DB file is invalid, the old DB will continue to be used.
+// Of a layered DB, only the base is reloaded.
 func (f *DB) Reload(path string, validationKey []byte, reloadTimeout time.Duration) (*DB, error) {
+	return f.ReloadLayer(0, path, validationKey, reloadTimeout)
+}
+
+// ReloadLayer reloads the given layer of a DB opened by OpenLayered as Reload
+// does, sharing the other layers with the new DB it returns if any. Layer 0
+// is the base, and the only layer of a DB opened by Open.
+func (f *DB) ReloadLayer(n int, path string, validationKey []byte, reloadTimeout time.Duration) (*DB, error) {
+	reload := func() (DBI, error) {
+		if l, ok := f.dbi.(*layeredDBI); ok {
+			return l.reloadLayer(n, path)
+		}
+		if n != 0 {
+			return nil, fmt.Errorf("no DB layer %d", n)
+		}
+		return f.dbi.Reload(path)
+	}
 	c := make(chan int)
 	ctx, cancel := context.WithTimeout(context.Background(), reloadTimeout)
 	defer cancel()
@@ -175,7 +243,7 @@ func (f *DB) Reload(path string, validationKey []byte, reloadTimeout time.Durati
 	// reload goroutine
 	go func() {
 		var localDBI DBI
-		localDBI, err = f.dbi.Reload(path)
+		localDBI, err = reload()
 		m.Lock()
 		defer m.Unlock()
 		if localDBI != nil && destroyNewDbi && localDBI != f.dbi {
@@ -209,7 +277,7 @@ func (f *DB) Reload(path string, validationKey []byte, reloadTimeout time.Durati
 		}
 
 		// Validate newDBI
-		newDB := &DB{dbi: newDBI}
+		newDB := &DB{dbi: newDBI, health: f.health}
 		err = newDB.validateDbKeyOrDestroy(validationKey)
 		if err != nil {
 			glog.Errorf("Key validation for New DBI failed, using old DB instead")
@@ -271,6 +339,38 @@ func (f *DB) ValidateDbKey(dbKey []byte) error {
 	return nil
 }
 
+// AnsweringLayer returns the layer of the DB whose records of type qtype
+// answer the name q in location locID: the first overlay with such records,
+// else the base, 0. It does not account for the wildcards.
+func (f *DB) AnsweringLayer(q []byte, qtype uint16, locID ID) (int, error) {
+	l, ok := f.dbi.(*layeredDBI)
+	if !ok {
+		return 0, nil
+	}
+	for n := 1; n < len(l.layers); n++ {
+		r, err := NewReader(&DB{dbi: l.layers[n].dbi})
+		if err != nil {
+			return 0, err
+		}
+		found := false
+		err = r.ForEachLocationRecord(q, locID, func(result []byte) error {
+			rec, err := ExtractRRFromRow(result, false)
+			if err == nil && answersType(rec.Qtype, qtype) {
+				found = true
+			}
+			return nil
+		})
+		r.Close()
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			return n, nil
+		}
+	}
+	return 0, nil
+}
+
 // GetStats reports DB backend stats
 func (f *DB) GetStats() map[string]int64 {
 	return f.dbi.GetStats()
@@ -307,56 +407,62 @@ func (r *DataReader) Close() {
 	}
 }
 
-// ForEachResourceRecord calls parseRecord for each RR record in DB in provided AND default location
+// ForEachResourceRecord calls parseRecord for each RR record in DB in the
+// first location of the chain of locID with records for domainName, see
+// LocationChain, AND default location
 func (r *DataReader) ForEachResourceRecord(domainName []byte, locID ID, parseRecord func(result []byte) error) error {
-	var err error
-
-	key := make([]byte, len(locID)+len(domainName))
-
-	if !locID.IsZero() {
-		key = append(key[:0], locID...)
-		key = append(key, domainName...)
-		err = r.ForEach(key, parseRecord)
-		if err != nil {
-			glog.Errorf("Error %v", err)
-			return err
-		}
-	}
+	return forEachResourceRecord(r, domainName, locID, parseRecord)
+}
 
-	key = append(key[:0], ZeroID...)
+// ForEachLocationRecord calls parseRecord for each RR record in DB in provided location only
+func (r *DataReader) ForEachLocationRecord(domainName []byte, locID ID, parseRecord func(result []byte) error) error {
+	key := make([]byte, 0, len(locID)+len(domainName))
+	key = append(key, locID...)
 	key = append(key, domainName...)
-	err = r.ForEach(key, parseRecord)
-	if err != nil {
-		glog.Errorf("Error %v", err)
-		return err
-	}
-
-	return nil
+	return r.ForEach(key, parseRecord)
 }
 
-// ForEachResourceRecord calls parseRecord for each RR record in DB in provided AND default location
+// ForEachResourceRecord calls parseRecord for each RR record in DB in the
+// first location of the chain of locID with records for domainName, see
+// LocationChain, AND default location
 func (r *sortedDataReader) ForEachResourceRecord(domainName []byte, locID ID, parseRecord func(result []byte) error) error {
-	var err error
+	return forEachResourceRecord(r, domainName, locID, parseRecord)
+}
 
-	key := make([]byte, len(domainName)+max(len(locID), len(ZeroID))+len(dnsdata.ResourceRecordsKeyMarker))
+// ForEachLocationRecord calls parseRecord for each RR record in DB in provided location only
+func (r *sortedDataReader) ForEachLocationRecord(domainName []byte, locID ID, parseRecord func(result []byte) error) error {
+	key := make([]byte, len(dnsdata.ResourceRecordsKeyMarker)+len(domainName), len(dnsdata.ResourceRecordsKeyMarker)+len(domainName)+len(locID))
 	copy(key, []byte(dnsdata.ResourceRecordsKeyMarker))
-
 	reverseZoneNameToBuffer(domainName, key[len(dnsdata.ResourceRecordsKeyMarker):])
+	key = append(key, locID...)
+	return r.ForEach(key, parseRecord)
+}
 
-	locationIndex := len(dnsdata.ResourceRecordsKeyMarker) + len(domainName)
+// forEachResourceRecord walks the chain of locID until a location has
+// records for domainName, then the default location.
+func forEachResourceRecord(r Reader, domainName []byte, locID ID, parseRecord func(result []byte) error) error {
+	chain, err := r.LocationChain(locID)
+	if err != nil {
+		glog.Errorf("Error %v", err)
+		return err
+	}
 
-	if !locID.IsZero() {
-		key = append(key[:locationIndex], locID...)
-		err = r.ForEach(key, parseRecord)
-		if err != nil {
+	found := false
+	parseFound := func(result []byte) error {
+		found = true
+		return parseRecord(result)
+	}
+	for _, id := range chain {
+		if err = r.ForEachLocationRecord(domainName, id, parseFound); err != nil {
 			glog.Errorf("Error %v", err)
 			return err
 		}
+		if found {
+			break
+		}
 	}
 
-	key = append(key[:locationIndex], ZeroID...)
-	err = r.ForEach(key, parseRecord)
-	if err != nil {
+	if err = r.ForEachLocationRecord(domainName, ZeroID, parseRecord); err != nil {
 		glog.Errorf("Error %v", err)
 		return err
 	}
@@ -411,6 +411,36 @@ func (mr *MockReaderMockRecorder) ForEachResourceRecord(domainName, loc, parseRe
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForEachResourceRecord", reflect.TypeOf((*MockReader)(nil).ForEachResourceRecord), domainName, loc, parseRecord)
 }
 
+// ForEachZoneRecord mocks base method
+func (m *MockReader) ForEachZoneRecord(zone []byte, locID ID, f func([]byte, []dns.RR) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ForEachZoneRecord", zone, locID, f)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForEachZoneRecord indicates an expected call of ForEachZoneRecord
+func (mr *MockReaderMockRecorder) ForEachZoneRecord(zone, locID, f interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForEachZoneRecord", reflect.TypeOf((*MockReader)(nil).ForEachZoneRecord), zone, locID, f)
+}
+
+// FindNSEC3 mocks base method
+func (m *MockReader) FindNSEC3(zone []byte, hash string, locID ID) ([]dns.RR, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindNSEC3", zone, hash, locID)
+	ret0, _ := ret[0].([]dns.RR)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// FindNSEC3 indicates an expected call of FindNSEC3
+func (mr *MockReaderMockRecorder) FindNSEC3(zone, hash, locID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindNSEC3", reflect.TypeOf((*MockReader)(nil).FindNSEC3), zone, hash, locID)
+}
+
 // Close mocks base method
 func (m *MockReader) Close() {
 	m.ctrl.T.Helper()
@@ -55,9 +55,10 @@ func TestDbKeyValidationV1Keys(t *testing.T) {
 		},
 	}
 
-	testDBs := []testaid.TestDB{testaid.TestCDB, testaid.TestRDB}
-
-	for _, testDb := range testDBs {
+	for _, testDb := range testaid.TestDBs {
+		if testDb.Flavour == testaid.TestRDBV2.Flavour {
+			continue
+		}
 		db, err := Open(testDb.Path, testDb.Driver)
 		if err != nil {
 			t.Fatalf("Failed to initialize DB: %v", err)
@@ -65,7 +66,7 @@ func TestDbKeyValidationV1Keys(t *testing.T) {
 		defer db.Destroy()
 
 		for i, test := range testCases {
-			t.Run(fmt.Sprintf("Testing %v", test.key), func(t *testing.T) {
+			t.Run(fmt.Sprintf("%s/%s/Testing %v", testDb.Driver, testDb.Flavour, test.key), func(t *testing.T) {
 				err := db.ValidateDbKey(test.key)
 				require.Equalf(t, test.err, err, "test %d expected error", i)
 			})
@@ -89,9 +90,10 @@ func TestDbKeyValidationV2Keys(t *testing.T) {
 		},
 	}
 
-	testDBs := []testaid.TestDB{testaid.TestRDBV2}
-
-	for _, testDb := range testDBs {
+	for _, testDb := range testaid.TestDBs {
+		if testDb.Flavour != testaid.TestRDBV2.Flavour {
+			continue
+		}
 		db, err := Open(testDb.Path, testDb.Driver)
 		if err != nil {
 			t.Fatalf("Failed to initialize DB: %v", err)
@@ -99,7 +101,7 @@ func TestDbKeyValidationV2Keys(t *testing.T) {
 		defer db.Destroy()
 
 		for i, test := range testCases {
-			t.Run(fmt.Sprintf("Testing %v", test.key), func(t *testing.T) {
+			t.Run(fmt.Sprintf("%s/%s/Testing %v", testDb.Driver, testDb.Flavour, test.key), func(t *testing.T) {
 				err := db.ValidateDbKey(test.key)
 				require.Equalf(t, test.err, err, "test %d expected error", i)
 			})
@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package db
+
+// HealthChecker tells whether the A/AAAA records with a health tag may be
+// answered. Records with an unhealthy tag are left out of the answers, unless
+// no record of their type is healthy.
+type HealthChecker interface {
+	// Healthy returns whether the records tagged with tag are healthy.
+	Healthy(tag string) bool
+}
+
+// SetHealthChecker sets the health checker of the DB, which the DBs it is
+// reloaded into keep. It must be set before the DB is read.
+func (f *DB) SetHealthChecker(h HealthChecker) {
+	f.health = h
+}
+
+// healthy tells whether the records tagged with tag are healthy, and records
+// the tag, see HealthTags.
+func (r *DataReader) healthy(tag []byte) bool {
+	if r.healthTags == nil {
+		r.healthTags = make(map[string]struct{})
+	}
+	t := string(tag)
+	r.healthTags[t] = struct{}{}
+	return r.db.health.Healthy(t)
+}
+
+// HealthTags returns the health tags of the records the reader looked at so
+// far: the answers it found depend on their health.
+func (r *DataReader) HealthTags() []string {
+	if len(r.healthTags) == 0 {
+		return nil
+	}
+	tags := make([]string, 0, len(r.healthTags))
+	for tag := range r.healthTags {
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// healthCheck returns the health check of the records the reader answers, nil
+// when the DB has no health checker.
+func (r *DataReader) healthCheck() func(tag []byte) bool {
+	if r.db.health == nil {
+		return nil
+	}
+	return r.healthy
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// helpers shared by the drivers over sorted key-value stores (RocksDB and Pebble),
+// which use the same keys and multi-value layout, see ../dnsdata/rdb/rdb_util.go
+
+// mapKeys returns the keys to look up, in order, to find mapID for domain
+// in a store with v1 keys: the exact match first, then the wildcards
+// obtained by removing 1 label at a time.
+func mapKeys(domain, mtype []byte) [][]byte {
+	var (
+		k    = make([]byte, 0, 50)   // prime the byte array capacity
+		keys = make([][]byte, 0, 10) // 10 is a sane number of subdomains we can expect in FQDN
+	)
+
+	k = append(k, mtype...)
+	firstLoop := true
+
+	for {
+		dlen := 2
+		k = append(k[:dlen], domain[:]...)
+		dlen += len(domain)
+
+		if !firstLoop {
+			k = append(k[:dlen], wildcardKeyElement...)
+		} else {
+			k = append(k[:dlen], exactMatchKeyElement...)
+		}
+		key := make([]byte, len(k))
+		copy(key, k)
+		keys = append(keys, key)
+
+		// If there is no more label, break out of the loop
+		if domain[0] == 0 {
+			break
+		}
+		// otherwise, pop 1 label
+		domain = domain[1+domain[0]:]
+		firstLoop = false
+	}
+	return keys
+}
+
+// findMapInSortedData returns mapID for domain e.g DB key "{mtype}{packed_domain}"
+// from a store with v2 (sorted) keys, using findClosest to walk the keys.
+// Starting from a domain = q, first we try to get an exact match
+// then, we remove 1 label at a time and try to find a wildcard match.
+func findMapInSortedData(domain, mtype []byte, findClosest func(key []byte) ([]byte, []byte, error)) (mapID []byte, err error) {
+	reversedZone := reverseZoneName(domain)
+	suffix := exactMatchKeyElement
+	k := make([]byte, len(reversedZone)+len(mtype)+len(suffix))
+
+	copy(k, mtype)
+	copy(k[len(mtype):], reversedZone)
+
+	prefixLen := len(mtype)
+
+	for {
+		copy(k[len(k)-len(suffix):], suffix)
+
+		var foundKey []byte
+		var foundValue []byte
+		foundKey, foundValue, err = findClosest(k)
+		if err != nil {
+			break
+		}
+
+		if bytes.Equal(foundKey, k) {
+			mapID = foundValue[4:]
+			break
+		}
+
+		if len(foundKey) < prefixLen ||
+			!bytes.Equal(foundKey[:prefixLen], k[:prefixLen]) {
+			// reached end of maps data segment
+			break
+		}
+
+		foundLabel := foundKey[prefixLen : len(foundKey)-1]
+		length := findCommonLongestPrefix(reversedZone, foundLabel)
+		if length == 0 {
+			break
+		}
+
+		// k already has necessary data - we just need to cut it at proper point
+		k[prefixLen+length] = 0
+		k = k[:prefixLen+length+1+len(suffix)]
+		suffix = wildcardKeyElement
+	}
+	// keep the [ff, n] prefix of the long IDs -
+	// would have to reinject on lookups otherwise
+	return mapID, err
+}
+
+var (
+	firstIPv4 = net.ParseIP("0000:0000:0000:0000:0000:ffff:0000:0000") // the very first IPv4 address according to RFC-2765
+)
+
+func isIPv4(addr net.IP) bool {
+	return addr != nil && (len(addr) == net.IPv4len || net.IP.Equal(addr[:12], firstIPv4[:12]))
+}
+
+func unpackLocation(foundKey, foundVal []byte) (loc []byte, mlen uint8, err error) {
+	if len(foundVal) < 4 {
+		if len(foundVal) == 0 {
+			return nil, 0, nil // consistent with the return at the end of cdbdriver.go:/GetLocationByMap
+		}
+		return nil, 0, fmt.Errorf("short value: length %d, value %v", len(foundVal), foundVal)
+	}
+	if len(foundKey) == 0 {
+		return nil, 0, fmt.Errorf("empty key with non-empty value %v", foundVal)
+	}
+	// take the first value from the potential multi-value - see ../dnsdata/rdb/rdb_util.go
+	valLen := binary.LittleEndian.Uint32(foundVal[:4])
+	if len(foundVal) < int(valLen)+4 {
+		return nil, 0, fmt.Errorf("short value: length %d, length from header %d, value %v", len(foundVal), valLen, foundVal)
+	}
+	foundVal = foundVal[4 : 4+valLen] // skip over the multi-value header
+	mlen = foundKey[len(foundKey)-1]
+	switch valLen {
+	case 0:
+		// Rearranger will always add /0 mask, so if anything - the empty location will match
+		return nil, mlen, nil
+	case 2:
+		loc = foundVal
+		return loc, mlen, nil
+	default:
+		if valLen < 3 {
+			err = fmt.Errorf("invalid location length %d, value %v", len(foundVal), foundVal)
+			return nil, 0, err
+		}
+		if foundVal[0] != 0xff {
+			loc = foundVal
+			return loc, mlen, nil
+		}
+		locLen := int(foundVal[1])
+		if 2+locLen > int(valLen) {
+			err = fmt.Errorf("invalid location length byte %d > %d", locLen, valLen)
+			return nil, 0, err
+		}
+
+		// keep the [ff, n] prefix of the long IDs -
+		// would have to reinject on lookups otherwise
+		loc = foundVal[:2+locLen]
+		return loc, mlen, nil
+	}
+}
+
+// ipMapRangePointKey returns the IP MAP key to look up for the subnet: map ID, IP address -> LocID, mask
+func ipMapRangePointKey(ipnet *net.IPNet, mapID []byte) []byte {
+	// Build key prefix: "\000\000\000!{MapID}"
+	// We prime the key byte array (fullKey) with the key prefix,
+	// followed by the original IP address in V6 format.
+	nmap := len(mapID)
+	fullKey := make([]byte, 4+nmap+net.IPv6len+1) // 4 bytes for prefix, n bytes for mapID, and the rest is IP and masklen
+	copy(fullKey, ipMapRangePointKeyElement)      // prefix, 4 bytes
+	copy(fullKey[4:], mapID)                      // mapID, n bytes
+	copy(fullKey[4+nmap:], ipnet.IP.To16())
+	reqMaskLen, _ := ipnet.Mask.Size()
+	if isIPv4(ipnet.IP) {
+		reqMaskLen += 128 - 32
+	}
+	copy(fullKey[4+nmap+16:], []byte{uint8(reqMaskLen)}) //nolint:gosec
+	return fullKey
+}
@@ -0,0 +1,311 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// layer is a DB of a layered DB. It is shared by the layered DBs which
+// reloaded some other layers, and closed along with the last of them.
+type layer struct {
+	dbi  DBI
+	refs int32
+}
+
+// release drops a reference to the layer, closing it with the last one.
+func (l *layer) release() error {
+	if atomic.AddInt32(&l.refs, -1) == 0 {
+		return l.dbi.Close()
+	}
+	return nil
+}
+
+// layeredDBI implements DBI over a base DB, layer 0, and overlay DBs, layers
+// 1 and up, which are consulted in order before the base. The records of an
+// owner in a location shadow those of the same type in the next layers, the
+// other keys, location maps included, are looked up in the first layer which
+// has them.
+type layeredDBI struct {
+	layers []*layer
+	sorted bool
+}
+
+// layeredContext carries the context of each layer.
+type layeredContext struct {
+	contexts []Context
+}
+
+// Reset resets the context of each layer.
+func (c *layeredContext) Reset() {
+	for _, ctx := range c.contexts {
+		ctx.Reset()
+	}
+}
+
+// newLayeredDBI layers overlays over base, which must all use the same key
+// format.
+func newLayeredDBI(base DBI, overlays []DBI) (*layeredDBI, error) {
+	l := &layeredDBI{sorted: base.ClosestKeyFinder() != nil}
+	for _, dbi := range append([]DBI{base}, overlays...) {
+		if (dbi.ClosestKeyFinder() != nil) != l.sorted {
+			return nil, fmt.Errorf("layered DBs must all use the same keys format")
+		}
+		l.layers = append(l.layers, &layer{dbi: dbi, refs: 1})
+	}
+	return l, nil
+}
+
+// lookupOrder returns the index of the i-th layer to consult: the overlays
+// in order, then the base.
+func (l *layeredDBI) lookupOrder(i int) int {
+	return (i + 1) % len(l.layers)
+}
+
+func (l *layeredDBI) NewContext() Context {
+	c := &layeredContext{contexts: make([]Context, len(l.layers))}
+	for i, layer := range l.layers {
+		c.contexts[i] = layer.dbi.NewContext()
+	}
+	return c
+}
+
+func (l *layeredDBI) FreeContext(context Context) {
+	c := context.(*layeredContext)
+	for i, layer := range l.layers {
+		layer.dbi.FreeContext(c.contexts[i])
+	}
+}
+
+// Find returns the first data value for the given key in the first layer
+// which has it.
+func (l *layeredDBI) Find(key []byte, context Context) ([]byte, error) {
+	c := context.(*layeredContext)
+	for i := range l.layers {
+		n := l.lookupOrder(i)
+		v, err := l.layers[n].dbi.Find(key, c.contexts[n])
+		if !errors.Is(err, io.EOF) {
+			return v, err
+		}
+	}
+	return nil, io.EOF
+}
+
+// ForEach calls a function for each value of the given key. The values of a
+// resource records key are taken from each layer, except for the records whose
+// type a previous layer has values of. The values of the other keys, whose
+// first bytes are no record type, are all taken from the first layer which has
+// the key, as Find does.
+func (l *layeredDBI) ForEach(key []byte, f func(value []byte) error, context Context) error {
+	c := context.(*layeredContext)
+	records := l.isRecordsKey(key)
+	var shadowed, found []uint16
+	for i := range l.layers {
+		n := l.lookupOrder(i)
+		if l.sorted {
+			// the context of a sorted layer caches the closest key found
+			// by FindClosestKey for key, only look up the exact one
+			k, err := l.layers[n].dbi.ClosestKeyFinder().FindClosestKey(key, c.contexts[n])
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(k, key) {
+				continue
+			}
+		}
+		if !records {
+			var seen bool
+			err := l.layers[n].dbi.ForEach(key, func(value []byte) error {
+				seen = true
+				return f(value)
+			}, c.contexts[n])
+			if err != nil || seen {
+				return err
+			}
+			continue
+		}
+		err := l.layers[n].dbi.ForEach(key, func(value []byte) error {
+			if len(value) < 2 {
+				return f(value)
+			}
+			qtype := binary.BigEndian.Uint16(value)
+			for _, t := range shadowed {
+				if t == qtype {
+					return nil
+				}
+			}
+			found = append(found, qtype)
+			return f(value)
+		}, c.contexts[n])
+		if err != nil {
+			return err
+		}
+		shadowed = append(shadowed, found...)
+		found = found[:0]
+	}
+	return nil
+}
+
+// v1OtherKeyMarkers are the prefixes of the v1 keys other than those of the
+// resource records, which start with the location of the records instead
+var v1OtherKeyMarkers = [][]byte{
+	ipMapKeyElement,
+	maskLensKeyElement,
+	maskLensKeyElementv4,
+	maskLensKeyElementv6,
+	ipMapRangePointKeyElement,
+	{0, 'M'},
+	{0, '8'},
+	[]byte(dnsdata.LocationChainKeyMarker),
+	[]byte(dnsdata.HistoryKeyMarker),
+}
+
+// isRecordsKey returns whether key is the key of the resource records of an
+// owner in a location.
+func (l *layeredDBI) isRecordsKey(key []byte) bool {
+	if string(key) == dnsdata.FeaturesKey {
+		return false
+	}
+	if l.sorted {
+		return bytes.HasPrefix(key, []byte(dnsdata.ResourceRecordsKeyMarker))
+	}
+	for _, marker := range v1OtherKeyMarkers {
+		if bytes.HasPrefix(key, marker) {
+			return false
+		}
+	}
+	return true
+}
+
+// FindMap returns the map ID for domain from the first layer which has one.
+func (l *layeredDBI) FindMap(domain, mtype []byte, context Context) ([]byte, error) {
+	c := context.(*layeredContext)
+	for i := range l.layers {
+		n := l.lookupOrder(i)
+		mapID, err := l.layers[n].dbi.FindMap(domain, mtype, c.contexts[n])
+		if err != nil || mapID != nil {
+			return mapID, err
+		}
+	}
+	return nil, nil
+}
+
+// GetLocationByMap returns the location and mask from the first layer which
+// locates ipnet, those of the base if none does.
+func (l *layeredDBI) GetLocationByMap(ipnet *net.IPNet, mapID []byte, context Context) (loc []byte, mlen uint8, err error) {
+	c := context.(*layeredContext)
+	for i := range l.layers {
+		n := l.lookupOrder(i)
+		loc, mlen, err = l.layers[n].dbi.GetLocationByMap(ipnet, mapID, c.contexts[n])
+		if err != nil || loc != nil {
+			return loc, mlen, err
+		}
+	}
+	return loc, mlen, err
+}
+
+func (l *layeredDBI) Close() error {
+	var err error
+	for _, layer := range l.layers {
+		if e := layer.release(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Reload reloads the base DB, see reloadLayer.
+func (l *layeredDBI) Reload(path string) (DBI, error) {
+	return l.reloadLayer(0, path)
+}
+
+// reloadLayer reloads the given layer from path. It returns l if the layer
+// was reloaded in place, else a new layered DBI sharing the other layers.
+func (l *layeredDBI) reloadLayer(n int, path string) (DBI, error) {
+	if n < 0 || n >= len(l.layers) {
+		return nil, fmt.Errorf("no DB layer %d", n)
+	}
+	old := l.layers[n].dbi
+	dbi, err := old.Reload(path)
+	if err != nil {
+		return nil, err
+	}
+	if dbi == old {
+		return l, nil
+	}
+	if (dbi.ClosestKeyFinder() != nil) != l.sorted {
+		dbi.Close()
+		return nil, fmt.Errorf("layered DBs must all use the same keys format")
+	}
+	reloaded := &layeredDBI{layers: make([]*layer, len(l.layers)), sorted: l.sorted}
+	for i, layer := range l.layers {
+		if i == n {
+			continue
+		}
+		atomic.AddInt32(&layer.refs, 1)
+		reloaded.layers[i] = layer
+	}
+	reloaded.layers[n] = &layer{dbi: dbi, refs: 1}
+	return reloaded, nil
+}
+
+// GetStats reports the stats of the base DB, and those of the overlays
+// prefixed by "overlay<n>.".
+func (l *layeredDBI) GetStats() map[string]int64 {
+	stats := make(map[string]int64)
+	for i, layer := range l.layers {
+		for k, v := range layer.dbi.GetStats() {
+			if i > 0 {
+				k = fmt.Sprintf("overlay%d.%s", i, k)
+			}
+			stats[k] = v
+		}
+	}
+	return stats
+}
+
+func (l *layeredDBI) ClosestKeyFinder() ClosestKeyFinder {
+	if l.sorted {
+		return l
+	}
+	return nil
+}
+
+// FindClosestKey returns the closest key smaller or equal to key among those
+// of all the layers.
+func (l *layeredDBI) FindClosestKey(key []byte, context Context) ([]byte, error) {
+	c := context.(*layeredContext)
+	var closest []byte
+	for i, layer := range l.layers {
+		k, err := layer.dbi.ClosestKeyFinder().FindClosestKey(key, c.contexts[i])
+		if err != nil {
+			return nil, err
+		}
+		if k != nil && (closest == nil || bytes.Compare(k, closest) > 0) {
+			closest = k
+		}
+	}
+	return closest, nil
+}
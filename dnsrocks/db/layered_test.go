@@ -0,0 +1,212 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package db
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+// overlayData shadows the A records of chain.example.org in \000\041 and its
+// TXT records in the default location.
+const overlayData = `+chain.example.org,192.0.2.41,180,,\000\041
+'chain.example.org,overlay,180
+`
+
+// findAnswers returns the A, AAAA and TXT answers for chain.example.org.
+func findAnswers(t *testing.T, db *DB, qtype uint16, locID ID) []string {
+	var q = make([]byte, 255)
+	var controlName = make([]byte, 255)
+	qname := "chain.example.org."
+
+	r, err := NewReader(db)
+	require.NoError(t, err)
+	defer r.Close()
+	offset, err := dns.PackDomainName(qname, q, 0, nil, false)
+	require.NoError(t, err)
+	controlOffset, err := dns.PackDomainName("example.org.", controlName, 0, nil, false)
+	require.NoError(t, err)
+	a := new(dns.Msg)
+	_, rcode := r.FindAnswer(q[:offset], controlName[:controlOffset], qname, qtype, locID, a, 10, false, 0)
+	require.Equal(t, dns.RcodeSuccess, rcode)
+	var answers []string
+	for _, rr := range a.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			answers = append(answers, rr.A.String())
+		case *dns.AAAA:
+			answers = append(answers, rr.AAAA.String())
+		case *dns.TXT:
+			answers = append(answers, rr.Txt...)
+		}
+	}
+	return answers
+}
+
+func TestLayeredFindAnswer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		locID    ID
+		qtype    uint16
+		expected []string
+	}{
+		{
+			name:     "shadowed by the overlay",
+			locID:    ID{0, 041},
+			qtype:    dns.TypeA,
+			expected: []string{"192.0.2.41"},
+		},
+		{
+			name:     "other type from the base",
+			locID:    ID{0, 041},
+			qtype:    dns.TypeAAAA,
+			expected: []string{"fd00::42"},
+		},
+		{
+			name:     "other location from the base",
+			locID:    ID{0, 042},
+			qtype:    dns.TypeA,
+			expected: []string{"10.0.43.1"},
+		},
+		{
+			name:     "default location shadowed by the overlay",
+			locID:    ID{0, 041},
+			qtype:    dns.TypeTXT,
+			expected: []string{"overlay"},
+		},
+	}
+
+	for _, config := range testaid.TestDBs {
+		db, err := OpenLayered(config.Path, []string{testaid.CompileDB(t, config, overlayData)}, config.Driver)
+		require.NoError(t, err)
+
+		for _, tc := range testCases {
+			t.Run(config.Driver+"/"+config.Flavour+"/"+tc.name, func(t *testing.T) {
+				require.ElementsMatch(t, tc.expected, findAnswers(t, db, tc.qtype, tc.locID))
+			})
+		}
+		db.Destroy()
+	}
+}
+
+func TestLayeredAnsweringLayer(t *testing.T) {
+	var q = make([]byte, 255)
+	offset, err := dns.PackDomainName("chain.example.org.", q, 0, nil, false)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		locID ID
+		qtype uint16
+		layer int
+	}{
+		{locID: ID{0, 041}, qtype: dns.TypeA, layer: 1},
+		{locID: ID{0, 042}, qtype: dns.TypeAAAA, layer: 0},
+		{locID: ZeroID, qtype: dns.TypeTXT, layer: 1},
+	}
+
+	for _, config := range testaid.TestDBs {
+		db, err := OpenLayered(config.Path, []string{testaid.CompileDB(t, config, overlayData)}, config.Driver)
+		require.NoError(t, err)
+
+		for _, tc := range testCases {
+			layer, err := db.AnsweringLayer(q[:offset], tc.qtype, tc.locID)
+			require.NoError(t, err)
+			require.Equal(t, tc.layer, layer, "%s %s %v", config.Driver, dns.TypeToString[tc.qtype], tc.locID)
+		}
+		db.Destroy()
+
+		db, err = Open(config.Path, config.Driver)
+		require.NoError(t, err)
+		layer, err := db.AnsweringLayer(q[:offset], dns.TypeA, ID{0, 041})
+		require.NoError(t, err)
+		require.Equal(t, 0, layer)
+		db.Destroy()
+	}
+}
+
+func TestLayeredReloadLayer(t *testing.T) {
+	for _, config := range testaid.TestDBs {
+		t.Run(config.Driver+"/"+config.Flavour, func(t *testing.T) {
+			db, err := OpenLayered(config.Path, []string{testaid.CompileDB(t, config, overlayData)}, config.Driver)
+			require.NoError(t, err)
+
+			_, err = db.ReloadLayer(2, config.Path, nil, time.Second)
+			require.Error(t, err)
+
+			overlay := testaid.CompileDB(t, config, "+chain.example.org,192.0.2.99,180,,\\000\\041\n")
+			reloaded, err := db.ReloadLayer(1, overlay, nil, time.Second)
+			require.NoError(t, err)
+			require.NotSame(t, db, reloaded)
+			defer reloaded.Destroy()
+
+			// the old DB is destroyed, while the base it shares is not
+			require.ElementsMatch(t, []string{"192.0.2.99"}, findAnswers(t, reloaded, dns.TypeA, ID{0, 041}))
+			require.ElementsMatch(t, []string{"fd00::42"}, findAnswers(t, reloaded, dns.TypeAAAA, ID{0, 041}))
+			require.ElementsMatch(t, []string{"default"}, findAnswers(t, reloaded, dns.TypeTXT, ID{0, 041}))
+		})
+	}
+}
+
+func TestLayeredLocationMap(t *testing.T) {
+	// the overlay locates 1.1.1.1 of map c\000 in \000\077, the base in \000\002
+	const overlayMap = "%\\000\\077,1.1.1.1/32,c\\000\n"
+	_, ipnet, err := net.ParseCIDR("1.1.1.1/32")
+	require.NoError(t, err)
+	mapID := []byte("c\000")
+
+	for _, config := range testaid.TestDBs {
+		t.Run(config.Driver+"/"+config.Flavour, func(t *testing.T) {
+			db, err := OpenLayered(config.Path, []string{testaid.CompileDB(t, config, overlayMap)}, config.Driver)
+			require.NoError(t, err)
+			defer db.Destroy()
+			ctx := db.dbi.NewContext()
+			defer db.dbi.FreeContext(ctx)
+
+			loc, _, err := db.dbi.GetLocationByMap(ipnet, mapID, ctx)
+			require.NoError(t, err)
+			require.Equal(t, []byte{0, 077}, loc)
+
+			// the map entry of the overlay overrides the one of the base,
+			// both of which are keyed by the subnet
+			key := ipMapRangePointKey(ipnet, mapID)
+			if config.Driver == "cdb" {
+				key = append(append([]byte{}, ipMapKeyElement...), key[len(ipMapRangePointKeyElement):]...)
+			}
+			var values []string
+			err = db.dbi.ForEach(key, func(value []byte) error {
+				values = append(values, string(value))
+				return nil
+			}, ctx)
+			require.NoError(t, err)
+			require.Equal(t, []string{"\000\077"}, values)
+
+			// the records are still looked up in both layers
+			require.ElementsMatch(t, []string{"fd00::42"}, findAnswers(t, db, dns.TypeAAAA, ID{0, 041}))
+		})
+	}
+}
+
+func TestOpenLayeredKeysFormat(t *testing.T) {
+	_, err := OpenLayered(testaid.TestPDB.Path, []string{testaid.CompileDB(t, testaid.TestPDBV2, overlayData)}, testaid.TestPDB.Driver)
+	require.Error(t, err)
+}
@@ -18,9 +18,13 @@ package db
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"net"
 	"os"
 
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+
 	"github.com/golang/glog"
 	"github.com/miekg/dns"
 )
@@ -225,3 +229,79 @@ func (r *DataReader) EcsLocation(q []byte, ecs *dns.EDNS0_SUBNET) (*Location, er
 
 	return loc, nil
 }
+
+// LocationChain returns the locations whose records are answered to the
+// clients of locID, nearest first: locID itself followed by the parents
+// declared for it, see dnsdata.Rparent. The default location, which ends
+// every chain, is not part of it.
+func (r *DataReader) LocationChain(locID ID) ([]ID, error) {
+	if locID.IsZero() {
+		return nil, nil
+	}
+	key := make([]byte, 0, len(dnsdata.LocationChainKeyMarker)+len(locID))
+	key = append(key, dnsdata.LocationChainKeyMarker...)
+	key = append(key, locID...)
+	v, err := r.Find(key)
+	if errors.Is(err, io.EOF) {
+		return []ID{locID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the value is reused by the DB, keep a copy for the IDs
+	v = append([]byte(nil), v...)
+	chain := []ID{locID}
+	for len(v) > 0 {
+		n := 2
+		if v[0] == 0xff && len(v) >= 2 {
+			n += int(v[1])
+		}
+		if len(v) < n {
+			return nil, io.ErrUnexpectedEOF
+		}
+		chain = append(chain, ID(v[:n]))
+		v = v[n:]
+	}
+	return chain, nil
+}
+
+// AnsweringLocation returns the location whose records of type qtype answer
+// the name q for the clients of locID: the first location of its chain with
+// such records, see LocationChain, else the default location. It is nil when
+// none has, and does not account for the wildcards.
+func AnsweringLocation(r Reader, q []byte, qtype uint16, locID ID) (ID, error) {
+	chain, err := r.LocationChain(locID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range append(chain, ZeroID) {
+		found := false
+		err = r.ForEachLocationRecord(q, id, func(result []byte) error {
+			rec, err := ExtractRRFromRow(result, false)
+			if err != nil {
+				// nolint: nilerr
+				return nil
+			}
+			if answersType(rec.Qtype, qtype) {
+				found = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return id, nil
+		}
+	}
+	return nil, nil
+}
+
+// answersType returns whether a record of type rtype answers a query of type
+// qtype.
+func answersType(rtype, qtype uint16) bool {
+	if rtype == TypeALIAS {
+		return qtype == dns.TypeA || qtype == dns.TypeAAAA
+	}
+	return rtype == qtype || rtype == dns.TypeCNAME || qtype == dns.TypeANY
+}
@@ -941,3 +941,70 @@ func TestDBECSResolverFindMap(t *testing.T) {
 		}
 	}
 }
+
+func TestDBLocationChain(t *testing.T) {
+	var q = make([]byte, 255)
+
+	testCases := []struct {
+		locID ID
+		chain []ID
+		qtype []uint16
+		// the location answering A records
+		answering ID
+	}{
+		{
+			locID:     ID{0, 041},
+			chain:     []ID{{0, 041}, {0, 042}, {0, 043}},
+			qtype:     []uint16{dns.TypeA, dns.TypeTXT},
+			answering: ID{0, 041},
+		},
+		{
+			locID:     ID{0, 042},
+			chain:     []ID{{0, 042}, {0, 043}},
+			qtype:     []uint16{dns.TypeAAAA, dns.TypeTXT},
+			answering: ID{0, 043},
+		},
+		{
+			locID: ID{0, 044},
+			chain: []ID{{0, 044}},
+			qtype: []uint16{dns.TypeTXT},
+		},
+		{
+			locID: ZeroID,
+			qtype: []uint16{dns.TypeTXT},
+		},
+	}
+
+	for _, config := range testaid.TestDBs {
+		db, err := Open(config.Path, config.Driver)
+		require.Nil(t, err, "could not open fixture database")
+
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s/%v", config.Driver, tc.locID), func(t *testing.T) {
+				r, err := NewReader(db)
+				require.Nil(t, err, "could not open db file")
+				defer r.Close()
+
+				chain, err := r.LocationChain(tc.locID)
+				require.NoError(t, err)
+				require.Equal(t, tc.chain, chain)
+
+				offset, err := dns.PackDomainName("chain.example.org.", q, 0, nil, false)
+				require.NoError(t, err)
+				var qtype []uint16
+				err = r.ForEachResourceRecord(q[:offset], tc.locID, func(result []byte) error {
+					rec, err := ExtractRRFromRow(result, false)
+					require.NoError(t, err)
+					qtype = append(qtype, rec.Qtype)
+					return nil
+				})
+				require.NoError(t, err)
+				require.ElementsMatch(t, tc.qtype, qtype)
+
+				answering, err := AnsweringLocation(r, q[:offset], dns.TypeA, tc.locID)
+				require.NoError(t, err)
+				require.Equal(t, tc.answering, answering)
+			})
+		}
+	}
+}
@@ -0,0 +1,348 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+// ErrNSEC3Unsupported is returned when looking for the NSEC3 record covering
+// a hash in a DB whose keys are not sorted, e.g a CDB.
+var ErrNSEC3Unsupported = errors.New("finding NSEC3 records requires a DB with sorted keys")
+
+// hashLabelLen is the length of the base32 encoded SHA-1 hashes of the owner
+// names of NSEC3 records.
+const hashLabelLen = 32
+
+// FindNSEC3 is not supported by unsorted DBs, as there is no way to find the
+// NSEC3 record covering a hash without scanning the whole DB.
+func (r *DataReader) FindNSEC3(_ []byte, _ string, _ ID) ([]dns.RR, bool, error) {
+	return nil, false, ErrNSEC3Unsupported
+}
+
+// FindNSEC3 returns the NSEC3 record of the packed zone matching or covering
+// the lower case hash, along with its signatures, in provided location if
+// any, or default location otherwise. match tells whether the hash is the one
+// of the record. No records are returned if the zone has no NSEC3 chain.
+func (r *sortedDataReader) FindNSEC3(zone []byte, hash string, locID ID) (rrs []dns.RR, match bool, err error) {
+	markerLen := len(dnsdata.ResourceRecordsKeyMarker)
+
+	// Keys of the hashed owner names start with the reversed name of the
+	// zone, minus the terminating empty label, followed by the length of the
+	// hash label.
+	prefix := make([]byte, markerLen+len(zone))
+	copy(prefix, []byte(dnsdata.ResourceRecordsKeyMarker))
+	reverseZoneNameToBuffer(zone, prefix[markerLen:])
+	prefix[len(prefix)-1] = hashLabelLen
+
+	// This sorts after any key of the hashed owner name, but before the keys
+	// of the names below it.
+	key := append(append(bytes.Clone(prefix), hash...), 1)
+	wrapped := false
+	for {
+		k, err := r.closestKeyFinder.FindClosestKey(key, r.context)
+		if err != nil {
+			return nil, false, err
+		}
+		if !bytes.HasPrefix(k, prefix) || len(k) <= len(prefix)+hashLabelLen {
+			// The hash is before the first one of the chain, so it is
+			// covered by the last one.
+			if wrapped {
+				return nil, false, nil
+			}
+			wrapped = true
+			key = append(bytes.Clone(prefix), 0xff)
+			continue
+		}
+		label := k[len(prefix) : len(prefix)+hashLabelLen]
+		// The label alone sorts right before all the keys of the names
+		// ending with it.
+		key = append(bytes.Clone(prefix), label...)
+		if k[len(prefix)+hashLabelLen] != 0 {
+			// a name below a 32 characters long label
+			continue
+		}
+
+		owner := make([]byte, 0, 1+hashLabelLen+len(zone))
+		owner = append(append(append(owner, hashLabelLen), label...), zone...)
+		if rrs, err = r.nsec3Records(owner, locID); err != nil {
+			return nil, false, err
+		}
+		if len(rrs) > 0 {
+			return rrs, string(label) == hash, nil
+		}
+	}
+}
+
+// nsec3Records returns the NSEC3 records of a packed owner name and their
+// signatures. The records stored in provided location replace the default
+// location ones.
+func (r *sortedDataReader) nsec3Records(owner []byte, locID ID) ([]dns.RR, error) {
+	name, _, err := dns.UnpackDomainName(owner, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var located, rrs []dns.RR
+	parseRecord := func(result []byte) error {
+		rec, err := ExtractRRFromRow(result, false)
+		if err != nil || (rec.Qtype != dns.TypeNSEC3 && rec.Qtype != dns.TypeRRSIG) {
+			// nolint: nilerr
+			return nil
+		}
+		rr, err := unpackRow(name, result)
+		if err != nil {
+			glog.Errorf("Skipping resource record of %s: %v", name, err)
+			return nil
+		}
+		if sig, ok := rr.(*dns.RRSIG); ok && sig.TypeCovered != dns.TypeNSEC3 {
+			return nil
+		}
+		if result[2] == '='+1 {
+			located = append(located, rr)
+		} else {
+			rrs = append(rrs, rr)
+		}
+		return nil
+	}
+
+	if err = r.ForEachResourceRecord(owner, locID, parseRecord); err != nil {
+		return nil, err
+	}
+	if len(located) > 0 {
+		return located, nil
+	}
+	return rrs, nil
+}
+
+// nsec3Prover finds the NSEC3 records proving answers in a zone.
+type nsec3Prover struct {
+	r        Reader
+	zone     []byte
+	zoneName string
+	param    *dns.NSEC3PARAM
+	locID    ID
+}
+
+// find returns the NSEC3 record matching or covering a name.
+func (p *nsec3Prover) find(name string) ([]dns.RR, bool, error) {
+	hash := dns.HashName(name, p.param.Hash, p.param.Iterations, p.param.Salt)
+	if hash == "" {
+		return nil, false, fmt.Errorf("cannot hash %s with NSEC3 algorithm %d", name, p.param.Hash)
+	}
+	return p.r.FindNSEC3(p.zone, strings.ToLower(hash), p.locID)
+}
+
+// cover returns the NSEC3 record covering a name, nil if it is matched by one.
+func (p *nsec3Prover) cover(name string) ([]dns.RR, error) {
+	rrs, match, err := p.find(name)
+	if match {
+		return nil, err
+	}
+	return rrs, err
+}
+
+// closestEncloser returns the closest encloser of a name, i.e its longest
+// ancestor, itself included, with a matching NSEC3 record, along with that
+// record. ce is empty if the chain is broken.
+func (p *nsec3Prover) closestEncloser(name string) (ce string, rrs []dns.RR, err error) {
+	for {
+		rrs, match, err := p.find(name)
+		if err != nil || len(rrs) == 0 {
+			return "", nil, err
+		}
+		if match {
+			return name, rrs, nil
+		}
+		if name == p.zoneName {
+			return "", nil, nil
+		}
+		off, _ := dns.NextLabel(name, 0)
+		name = name[off:]
+	}
+}
+
+// nextCloser returns the name one label longer than the closest encloser ce
+// of qname.
+func nextCloser(qname, ce string) string {
+	labels := dns.SplitDomainName(qname)
+	return dns.Fqdn(strings.Join(labels[len(labels)-dns.CountLabel(ce)-1:], "."))
+}
+
+// NSEC3Proof adds to the authority section of an answer to a DO query in the
+// packed zone the NSEC3 records proving it, if the zone has an NSEC3 chain.
+// These are the closest encloser, next closer name and wildcard proofs of
+// NXDOMAIN answers, the matching NSEC3 record of NODATA answers, along with
+// the wildcard proof for wildcard NODATA answers, and the next closer name
+// proof of wildcard answers (RFC 5155 section 7.2). Negative answers also get
+// the signatures of their SOA record. It returns whether the answer was
+// proven.
+func NSEC3Proof(r Reader, zone []byte, qname string, qtype uint16, locID ID, a *dns.Msg) (bool, error) {
+	zoneName, _, err := dns.UnpackDomainName(zone, 0)
+	if err != nil {
+		return false, err
+	}
+	qname = strings.ToLower(dns.Fqdn(qname))
+
+	var (
+		param *dns.NSEC3PARAM
+		// located signatures take precedence over the default ones
+		soaSigs, locatedSOASigs []dns.RR
+	)
+	parseResult := func(result []byte) error {
+		rec, err := ExtractRRFromRow(result, false)
+		if err != nil || (rec.Qtype != dns.TypeNSEC3PARAM && rec.Qtype != dns.TypeRRSIG) {
+			// nolint: nilerr
+			return nil
+		}
+		rr, err := unpackRow(zoneName, result)
+		if err != nil {
+			glog.Errorf("Skipping resource record of %s: %v", zoneName, err)
+			return nil
+		}
+		switch rr := rr.(type) {
+		case *dns.NSEC3PARAM:
+			if param == nil {
+				param = rr
+			}
+		case *dns.RRSIG:
+			if rr.TypeCovered != dns.TypeSOA {
+				break
+			}
+			if result[2] == '='+1 {
+				locatedSOASigs = append(locatedSOASigs, rr)
+			} else {
+				soaSigs = append(soaSigs, rr)
+			}
+		}
+		return nil
+	}
+	if err = r.ForEachResourceRecord(zone, locID, parseResult); err != nil {
+		return false, err
+	}
+	if param == nil {
+		return false, nil
+	}
+	if len(locatedSOASigs) > 0 {
+		soaSigs = locatedSOASigs
+	}
+
+	p := &nsec3Prover{r: r, zone: zone, zoneName: zoneName, param: param, locID: locID}
+	var proof [][]dns.RR
+	switch {
+	case a.Rcode == dns.RcodeNameError:
+		ce, ceRRs, err := p.closestEncloser(qname)
+		if err != nil || ce == "" || ce == qname {
+			// the name exists, e.g an empty non-terminal
+			return false, err
+		}
+		nc, err := p.cover(nextCloser(qname, ce))
+		if err != nil || nc == nil {
+			return false, err
+		}
+		wildcard, err := p.cover("*." + ce)
+		if err != nil || wildcard == nil {
+			return false, err
+		}
+		proof = [][]dns.RR{ceRRs, nc, wildcard, soaSigs}
+	case a.Rcode == dns.RcodeSuccess && len(a.Answer) == 0:
+		ce, ceRRs, err := p.closestEncloser(qname)
+		if err != nil || ce == "" {
+			return false, err
+		}
+		if ce == qname {
+			proof = [][]dns.RR{ceRRs, soaSigs}
+			break
+		}
+		nc, err := p.cover(nextCloser(qname, ce))
+		if err != nil || nc == nil {
+			return false, err
+		}
+		wildcard, match, err := p.find("*." + ce)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case match:
+			proof = [][]dns.RR{ceRRs, nc, wildcard, soaSigs}
+		case qtype == dns.TypeDS && optOut(nc):
+			// the name is an unsigned delegation left out of the chain
+			proof = [][]dns.RR{ceRRs, nc, soaSigs}
+		default:
+			return false, nil
+		}
+	case a.Rcode == dns.RcodeSuccess:
+		labels := wildcardLabels(qname, a.Answer)
+		if labels < 0 {
+			return false, nil
+		}
+		ce := "."
+		if labels > 0 {
+			ce = dns.Fqdn(strings.Join(dns.SplitDomainName(qname)[dns.CountLabel(qname)-labels:], "."))
+		}
+		nc, err := p.cover(nextCloser(qname, ce))
+		if err != nil || nc == nil {
+			return false, err
+		}
+		proof = [][]dns.RR{nc}
+	default:
+		return false, nil
+	}
+
+	// The same NSEC3 record may prove several things.
+	added := make(map[string]bool)
+	for _, rrs := range proof {
+		for _, rr := range rrs {
+			key := rr.String()
+			if !added[key] {
+				added[key] = true
+				a.Ns = append(a.Ns, rr)
+			}
+		}
+	}
+	return true, nil
+}
+
+// wildcardLabels returns the number of labels of the wildcard an answer to
+// qname was synthesized from, as told by its signature, -1 if it was not.
+func wildcardLabels(qname string, answer []dns.RR) int {
+	n := dns.CountLabel(qname)
+	for _, rr := range answer {
+		if sig, ok := rr.(*dns.RRSIG); ok && strings.EqualFold(sig.Hdr.Name, qname) && int(sig.Labels) < n {
+			return int(sig.Labels)
+		}
+	}
+	return -1
+}
+
+// optOut returns whether the NSEC3 record of rrs has the opt-out flag set.
+func optOut(rrs []dns.RR) bool {
+	for _, rr := range rrs {
+		if nsec3, ok := rr.(*dns.NSEC3); ok {
+			return nsec3.Flags&1 == 1
+		}
+	}
+	return false
+}
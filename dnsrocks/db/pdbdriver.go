@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package db
+
+import (
+	"net"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata/pdb"
+
+	"github.com/golang/glog"
+)
+
+// implement db.DBI interface over Pebble
+type pdbdriver struct {
+	db           *pdb.PDB
+	path         string
+	isDataSorted bool
+}
+
+func openPDB(path string) (DBI, error) {
+	db, err := pdb.NewReader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	isDataSorted := db.IsV2KeySyntaxUsed()
+
+	driver := &pdbdriver{db: db, path: path, isDataSorted: isDataSorted}
+	return driver, nil
+}
+
+func (p *pdbdriver) NewContext() Context {
+	return pdb.NewContext()
+}
+
+func (p *pdbdriver) FreeContext(context Context) {
+	context.Reset()
+}
+
+// Find returns the first data value for the given key as a byte slice.
+func (p *pdbdriver) Find(key []byte, context Context) ([]byte, error) {
+	ctx := context.(*pdb.Context)
+
+	return p.db.Find(key, ctx)
+}
+
+// FindMap returns mapID for domain e.g DB key "{mtype}{packed_domain}"
+// Starting from a domain = q, first we try to get an exact match
+// then, we remove 1 label at a time and try to find a wildcard match.
+func (p *pdbdriver) FindMap(domain, mtype []byte, context Context) ([]byte, error) {
+	if p.isDataSorted {
+		return findMapInSortedData(domain, mtype, func(key []byte) ([]byte, []byte, error) {
+			return p.findClosest(key, context)
+		})
+	}
+
+	mapID, _, err := p.db.FindFirst(mapKeys(domain, mtype))
+	if err != nil {
+		return nil, err
+	}
+	// keep the [ff, n] prefix of the long IDs -
+	// would have to reinject on lookups otherwise
+	return mapID, nil
+}
+
+// GetLocationByMap finds and returns location and mask. If the location is not found, returns nil and 0.
+func (p *pdbdriver) GetLocationByMap(ipnet *net.IPNet, mapID []byte, context Context) (loc []byte, mlen uint8, err error) {
+	// NOTE: Rearranger has merging on adjacent locations with same mask and locID,
+	// so findClosest() might return the key that will match some other IP. It is fine for our purposes.
+	foundKey, foundVal, err := p.findClosest(ipMapRangePointKey(ipnet, mapID), context)
+	if err != nil {
+		return nil, 0, err
+	}
+	return unpackLocation(foundKey, foundVal)
+}
+
+func (p *pdbdriver) Close() error {
+	return p.db.Close()
+}
+
+// Reload opens the database at path. Pebble has no equivalent of the RocksDB
+// secondary mode, so a reload of the same path opens a new reader as well,
+// from a new checkpoint picking up the data written since the current one was
+// taken. It fails while a writer has the database open, the current reader
+// keeps serving until a later reload succeeds.
+func (p *pdbdriver) Reload(path string) (DBI, error) {
+	start := time.Now()
+	if path == p.path {
+		glog.Infof("Taking a new PDB checkpoint to pick up changes")
+	} else {
+		glog.Infof("Doing full PDB reload, new path=%s", path)
+	}
+	newDB, err := openPDB(path)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Finished PDB reload in %v", time.Since(start))
+	return newDB, nil
+}
+
+// GetStats reports DB backend stats
+func (p *pdbdriver) GetStats() map[string]int64 {
+	return p.db.GetStats()
+}
+
+func (p *pdbdriver) findClosest(key []byte, context Context) ([]byte, []byte, error) {
+	ctx := context.(*pdb.Context)
+
+	return p.db.FindClosest(key, ctx)
+}
+
+// ForEach calls a function for each key match.
+// The function takes a byte slice as a value and return an error.
+// if error is not nil, the loop will stop.
+func (p *pdbdriver) ForEach(key []byte, f func(value []byte) error, context Context) error {
+	ctx := context.(*pdb.Context)
+
+	return p.db.ForEach(key, f, ctx)
+}
+
+// FindClosestKey searches for closest key which is smaller or equal to provided key
+func (p *pdbdriver) FindClosestKey(key []byte, context Context) ([]byte, error) {
+	k, _, err := p.findClosest(key, context)
+
+	return k, err
+}
+
+func (p *pdbdriver) ClosestKeyFinder() ClosestKeyFinder {
+	if p.isDataSorted {
+		return p
+	}
+
+	return nil
+}
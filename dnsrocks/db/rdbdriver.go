@@ -1,3 +1,5 @@
+//go:build cgo
+
 /*
  * Copyright (c) Meta Platforms, Inc. and affiliates.
  *
@@ -17,9 +19,6 @@
 package db
 
 import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
 	"net"
 	"time"
 
@@ -68,41 +67,12 @@ func (r *rdbdriver) Find(key []byte, context Context) ([]byte, error) {
 // then, we remove 1 label at a time and try to find a wildcard match.
 func (r *rdbdriver) FindMap(domain, mtype []byte, context Context) ([]byte, error) {
 	if r.isDataSorted {
-		return r.findMapInSortedData(domain, mtype, context)
-	}
-
-	var (
-		k    = make([]byte, 0, 50)   // prime the byte array capacity
-		keys = make([][]byte, 0, 10) // 10 is a sane number of subdomains we can expect in FQDN
-	)
-
-	k = append(k, mtype...)
-	firstLoop := true
-
-	for {
-		dlen := 2
-		k = append(k[:dlen], domain[:]...)
-		dlen += len(domain)
-
-		if !firstLoop {
-			k = append(k[:dlen], wildcardKeyElement...)
-		} else {
-			k = append(k[:dlen], exactMatchKeyElement...)
-		}
-		key := make([]byte, len(k))
-		copy(key, k)
-		keys = append(keys, key)
-
-		// If there is no more label, break out of the loop
-		if domain[0] == 0 {
-			break
-		}
-		// otherwise, pop 1 label
-		domain = domain[1+domain[0]:]
-		firstLoop = false
+		return findMapInSortedData(domain, mtype, func(key []byte) ([]byte, []byte, error) {
+			return r.findClosest(key, context)
+		})
 	}
 
-	mapID, _, err := r.db.FindFirst(keys)
+	mapID, _, err := r.db.FindFirst(mapKeys(domain, mtype))
 	if err != nil {
 		return nil, err
 	}
@@ -111,134 +81,13 @@ func (r *rdbdriver) FindMap(domain, mtype []byte, context Context) ([]byte, erro
 	return mapID, nil
 }
 
-// FindMap returns mapID for domain e.g DB key "{mtype}{packed_domain}"
-// Starting from a domain = q, first we try to get an exact match
-// then, we remove 1 label at a time and try to find a wildcard match.
-func (r *rdbdriver) findMapInSortedData(domain, mtype []byte, context Context) (mapID []byte, err error) {
-	ctx := context.(*rdb.Context)
-
-	reversedZone := reverseZoneName(domain)
-	suffix := exactMatchKeyElement
-	k := make([]byte, len(reversedZone)+len(mtype)+len(suffix))
-
-	copy(k, mtype)
-	copy(k[len(mtype):], reversedZone)
-
-	prefixLen := len(mtype)
-
-	for {
-		copy(k[len(k)-len(suffix):], suffix)
-
-		var foundKey []byte
-		var foundValue []byte
-		foundKey, foundValue, err = r.findClosest(k, ctx)
-		if err != nil {
-			break
-		}
-
-		if bytes.Equal(foundKey, k) {
-			mapID = foundValue[4:]
-			break
-		}
-
-		if len(foundKey) < prefixLen ||
-			!bytes.Equal(foundKey[:prefixLen], k[:prefixLen]) {
-			// reached end of maps data segment
-			break
-		}
-
-		foundLabel := foundKey[prefixLen : len(foundKey)-1]
-		length := findCommonLongestPrefix(reversedZone, foundLabel)
-		if length == 0 {
-			break
-		}
-
-		// k already has necessary data - we just need to cut it at proper point
-		k[prefixLen+length] = 0
-		k = k[:prefixLen+length+1+len(suffix)]
-		suffix = wildcardKeyElement
-	}
-	// keep the [ff, n] prefix of the long IDs -
-	// would have to reinject on lookups otherwise
-	return mapID, err
-}
-
-var (
-	firstIPv4 = net.ParseIP("0000:0000:0000:0000:0000:ffff:0000:0000") // the very first IPv4 address according to RFC-2765
-)
-
-func isIPv4(addr net.IP) bool {
-	return addr != nil && (len(addr) == net.IPv4len || net.IP.Equal(addr[:12], firstIPv4[:12]))
-}
-
-func unpackLocation(foundKey, foundVal []byte) (loc []byte, mlen uint8, err error) {
-	if len(foundVal) < 4 {
-		if len(foundVal) == 0 {
-			return nil, 0, nil // consistent with the return at the end of cdbdriver.go:/GetLocationByMap
-		}
-		return nil, 0, fmt.Errorf("short value: length %d, value %v", len(foundVal), foundVal)
-	}
-	if len(foundKey) == 0 {
-		return nil, 0, fmt.Errorf("empty key with non-empty value %v", foundVal)
-	}
-	// take the first value from the potential multi-value - see ../dnsdata/rdb/rdb_util.go
-	valLen := binary.LittleEndian.Uint32(foundVal[:4])
-	if len(foundVal) < int(valLen)+4 {
-		return nil, 0, fmt.Errorf("short value: length %d, length from header %d, value %v", len(foundVal), valLen, foundVal)
-	}
-	foundVal = foundVal[4 : 4+valLen] // skip over the multi-value header
-	mlen = foundKey[len(foundKey)-1]
-	switch valLen {
-	case 0:
-		// Rearranger will always add /0 mask, so if anything - the empty location will match
-		return nil, mlen, nil
-	case 2:
-		loc = foundVal
-		return loc, mlen, nil
-	default:
-		if valLen < 3 {
-			err = fmt.Errorf("invalid location length %d, value %v", len(foundVal), foundVal)
-			return nil, 0, err
-		}
-		if foundVal[0] != 0xff {
-			loc = foundVal
-			return loc, mlen, nil
-		}
-		locLen := int(foundVal[1])
-		if 2+locLen > int(valLen) {
-			err = fmt.Errorf("invalid location length byte %d > %d", locLen, valLen)
-			return nil, 0, err
-		}
-
-		// keep the [ff, n] prefix of the long IDs -
-		// would have to reinject on lookups otherwise
-		loc = foundVal[:2+locLen]
-		return loc, mlen, nil
-	}
-}
-
 // GetLocationByMap finds and returns location and mask. If the location is not found, returns nil and 0.
 func (r *rdbdriver) GetLocationByMap(ipnet *net.IPNet, mapID []byte, context Context) (loc []byte, mlen uint8, err error) {
-	// Lookup the subnet in IP MAP: map ID, IP address -> LocID, mask
-	// Build key prefix: "\000\000\000!{MapID}"
-	// We prime the key byte array (fullKey) with the key prefix,
-	// followed by the original IP address in V6 format.
-	nmap := len(mapID)
-	fullKey := make([]byte, 4+nmap+net.IPv6len+1) // 4 bytes for prefix, n bytes for mapID, and the rest is IP and masklen
-	copy(fullKey, ipMapRangePointKeyElement)      // prefix, 4 bytes
-	copy(fullKey[4:], mapID)                      // mapID, n bytes
-	copy(fullKey[4+nmap:], ipnet.IP.To16())
-	reqMaskLen, _ := ipnet.Mask.Size()
-	if isIPv4(ipnet.IP) {
-		reqMaskLen += 128 - 32
-	}
-	copy(fullKey[4+nmap+16:], []byte{uint8(reqMaskLen)}) //nolint:gosec
-
 	ctx := context.(*rdb.Context)
 
 	// NOTE: Rearranger has merging on adjacent locations with same mask and locID,
 	// so findClosest() might return the key that will match some other IP. It is fine for our purposes.
-	foundKey, foundVal, err := r.db.FindClosest(fullKey, ctx)
+	foundKey, foundVal, err := r.db.FindClosest(ipMapRangePointKey(ipnet, mapID), ctx)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -17,20 +17,31 @@
 package db
 
 import (
+	"cmp"
+	"encoding/binary"
 	"fmt"
+	"hash/fnv"
 	"math"
 	"net"
+	"slices"
 
 	"github.com/miekg/dns"
 )
 
 // WrsItem Weighted Random Sample Item
 type WrsItem struct {
-	Key  float64
-	TTL  uint32
+	Key float64
+	TTL uint32
+	// Addr holds the rdata of the record, which is the address for A/AAAA.
 	Addr net.IP
 }
 
+// WrsSample is the Weighted Random Sample of the records of a type
+type WrsSample struct {
+	Items []WrsItem
+	Count uint32
+}
+
 // Wrs Weighted Random Sample
 type Wrs struct {
 	MaxAnswers int
@@ -38,6 +49,14 @@ type Wrs struct {
 	V4Count    uint32
 	V6         []WrsItem
 	V6Count    uint32
+	// Others holds the samples of the weighted records of other types, see
+	// ResourceRecord.Weighted.
+	Others map[uint16]*WrsSample
+	// Seed, when non-zero, makes the sample deterministic: the random value
+	// of each record is derived from a hash of the seed and its rdata rather
+	// than drawn from localRand, so the same seed always selects the same
+	// subset in the same order.
+	Seed uint64
 }
 
 /*
@@ -51,16 +70,16 @@ var localRand = NewRand()
 // Add adds a ResourceRecord to Wrs if its randomly computed weight is greater
 // then the existing record.
 func (w *Wrs) Add(rec ResourceRecord, data []byte) error {
-	if rec.Qtype != dns.TypeA && rec.Qtype != dns.TypeAAAA {
+	if rec.Qtype != dns.TypeA && rec.Qtype != dns.TypeAAAA && !rec.Weighted {
 		return fmt.Errorf("unsupported type %d", rec.Qtype)
 	}
 
-	key := math.Pow(float64(localRand.Uint32())*float64(1.0/math.MaxUint32), 1.0/float64(rec.Weight))
+	key := math.Pow(float64(w.uniform(data[rec.Offset:]))*float64(1.0/math.MaxUint32), 1.0/float64(rec.Weight))
 	wrsItem := WrsItem{Key: key,
 		TTL:  rec.TTL,
 		Addr: data[rec.Offset:]}
-	addRecord := func(items []WrsItem) []WrsItem {
-		if len(items) < w.MaxAnswers {
+	addRecord := func(items []WrsItem, maxAnswers int) []WrsItem {
+		if len(items) < maxAnswers {
 			items = append(items, wrsItem)
 		} else {
 			minKey := key
@@ -91,7 +110,7 @@ func (w *Wrs) Add(rec ResourceRecord, data []byte) error {
 		if w.MaxAnswers == 1 {
 			w.V4 = checkAndReplaceRecord(w.V4)
 		} else {
-			w.V4 = addRecord(w.V4)
+			w.V4 = addRecord(w.V4, w.MaxAnswers)
 		}
 	}
 	if rec.Qtype == dns.TypeAAAA {
@@ -99,12 +118,47 @@ func (w *Wrs) Add(rec ResourceRecord, data []byte) error {
 		if w.MaxAnswers == 1 {
 			w.V6 = checkAndReplaceRecord(w.V6)
 		} else {
-			w.V6 = addRecord(w.V6)
+			w.V6 = addRecord(w.V6, w.MaxAnswers)
+		}
+	}
+	if rec.Qtype != dns.TypeA && rec.Qtype != dns.TypeAAAA {
+		if w.Others == nil {
+			w.Others = make(map[uint16]*WrsSample)
+		}
+		sample, ok := w.Others[rec.Qtype]
+		if !ok {
+			sample = new(WrsSample)
+			w.Others[rec.Qtype] = sample
+		}
+		sample.Count++
+		// a name can only have a single CNAME
+		if w.MaxAnswers == 1 || rec.Qtype == dns.TypeCNAME {
+			sample.Items = checkAndReplaceRecord(sample.Items)
+		} else {
+			sample.Items = addRecord(sample.Items, w.MaxAnswers)
 		}
 	}
 	return nil
 }
 
+// uniform returns the random value used to compute the key of a record.
+func (w *Wrs) uniform(rdata []byte) uint32 {
+	if w.Seed == 0 {
+		return localRand.Uint32()
+	}
+	h := fnv.New64a()
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], w.Seed)
+	h.Write(b[:])
+	h.Write(rdata)
+	// FNV barely spreads close inputs, finish it with the splitmix64 mixer
+	sum := h.Sum64()
+	sum = (sum ^ (sum >> 30)) * 0xbf58476d1ce4e5b9
+	sum = (sum ^ (sum >> 27)) * 0x94d049bb133111eb
+	sum ^= sum >> 31
+	return uint32(sum >> 32)
+}
+
 func (w *Wrs) record(name string, class uint16, qtype uint16) (rrs []dns.RR, err error) {
 	var items []WrsItem
 	switch qtype {
@@ -113,11 +167,21 @@ func (w *Wrs) record(name string, class uint16, qtype uint16) (rrs []dns.RR, err
 	case dns.TypeAAAA:
 		items = w.V6
 	default:
-		return nil, fmt.Errorf("unsupported type %d", qtype)
+		sample, ok := w.Others[qtype]
+		if !ok {
+			return nil, fmt.Errorf("unsupported type %d", qtype)
+		}
+		items = sample.Items
+	}
+	if w.Seed != 0 {
+		slices.SortFunc(items, func(a, b WrsItem) int {
+			return cmp.Compare(b.Key, a.Key)
+		})
+	} else {
+		localRand.Shuffle(len(items), func(i, j int) {
+			items[i], items[j] = items[j], items[i]
+		})
 	}
-	localRand.Shuffle(len(items), func(i, j int) {
-		items[i], items[j] = items[j], items[i]
-	})
 
 	rrs = make([]dns.RR, len(items))
 	for i, item := range items {
@@ -141,7 +205,30 @@ func (w *Wrs) AAAARecord(name string, class uint16) (rrs []dns.RR, err error) {
 	return w.record(name, class, dns.TypeAAAA)
 }
 
+// OtherRecords returns the weighted random samples for the other types than
+// A/AAAA if there are any, ordered by type.
+func (w *Wrs) OtherRecords(name string, class uint16) (rrs []dns.RR, err error) {
+	qtypes := make([]uint16, 0, len(w.Others))
+	for qtype := range w.Others {
+		qtypes = append(qtypes, qtype)
+	}
+	slices.Sort(qtypes)
+	for _, qtype := range qtypes {
+		sampled, err := w.record(name, class, qtype)
+		if err != nil {
+			return nil, err
+		}
+		rrs = append(rrs, sampled...)
+	}
+	return rrs, nil
+}
+
 // WeightedAnswer returns true if the answer selected a subset of possible results.
 func (w *Wrs) WeightedAnswer() bool {
+	for _, sample := range w.Others {
+		if int(sample.Count) > len(sample.Items) {
+			return true
+		}
+	}
 	return int(w.V4Count) > len(w.V4) || int(w.V6Count) > len(w.V6)
 }
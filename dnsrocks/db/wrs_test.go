@@ -55,6 +55,85 @@ func TestWeightedAnswer2(t *testing.T) {
 	require.True(t, wrs.WeightedAnswer())
 }
 
+func TestWeightedOtherTypes(t *testing.T) {
+	cname := func(target string) []byte {
+		buf := make([]byte, 255)
+		off, err := dns.PackDomainName(target, buf, 0, nil, false)
+		require.NoError(t, err)
+		return buf[:off]
+	}
+	wrs := Wrs{MaxAnswers: 2}
+	// unweighted records of other types are not sampled
+	err := wrs.Add(ResourceRecord{Weight: 1, Qtype: dns.TypeCNAME}, nil)
+	require.Error(t, err)
+
+	for _, target := range []string{"a.example.com.", "b.example.com."} {
+		err = wrs.Add(ResourceRecord{Weight: 1, Qtype: dns.TypeCNAME, TTL: 60, Weighted: true}, cname(target))
+		require.NoError(t, err)
+	}
+	// a name has a single CNAME, whatever the number of answers asked for
+	require.True(t, wrs.WeightedAnswer())
+	rrs, err := wrs.OtherRecords("www.example.com.", dns.ClassINET)
+	require.NoError(t, err)
+	require.Len(t, rrs, 1)
+	c, ok := rrs[0].(*dns.CNAME)
+	require.True(t, ok)
+	require.Equal(t, "www.example.com.", c.Hdr.Name)
+	require.Equal(t, uint32(60), c.Hdr.Ttl)
+	require.Contains(t, []string{"a.example.com.", "b.example.com."}, c.Target)
+}
+
+func TestWeightedOtherTypesDistribution(t *testing.T) {
+	counts := map[string]int{}
+	for range 1000 {
+		wrs := Wrs{MaxAnswers: 1}
+		for txt, weight := range map[string]uint32{"a": 90, "b": 10} {
+			err := wrs.Add(ResourceRecord{Weight: weight, Qtype: dns.TypeTXT, Weighted: true}, []byte{1, txt[0]})
+			require.NoError(t, err)
+		}
+		rrs, err := wrs.OtherRecords("example.com.", dns.ClassINET)
+		require.NoError(t, err)
+		require.Len(t, rrs, 1)
+		counts[rrs[0].(*dns.TXT).Txt[0]]++
+	}
+	require.Greater(t, counts["a"], counts["b"])
+}
+
+func TestWrsSeed(t *testing.T) {
+	sample := func(seed uint64) string {
+		wrs := Wrs{MaxAnswers: 1, Seed: seed}
+		for _, txt := range []string{"a", "b", "c"} {
+			err := wrs.Add(ResourceRecord{Weight: 10, Qtype: dns.TypeTXT, Weighted: true}, []byte{1, txt[0]})
+			require.NoError(t, err)
+		}
+		rrs, err := wrs.OtherRecords("example.com.", dns.ClassINET)
+		require.NoError(t, err)
+		require.Len(t, rrs, 1)
+		return rrs[0].(*dns.TXT).Txt[0]
+	}
+
+	for seed := range uint64(100) {
+		require.Equal(t, sample(seed+1), sample(seed+1))
+	}
+}
+
+func TestWrsSeedDistribution(t *testing.T) {
+	counts := map[string]int{}
+	for seed := range uint64(10000) {
+		wrs := Wrs{MaxAnswers: 1, Seed: seed + 1}
+		for txt, weight := range map[string]uint32{"a": 90, "b": 10} {
+			err := wrs.Add(ResourceRecord{Weight: weight, Qtype: dns.TypeTXT, Weighted: true}, []byte{1, txt[0]})
+			require.NoError(t, err)
+		}
+		rrs, err := wrs.OtherRecords("example.com.", dns.ClassINET)
+		require.NoError(t, err)
+		counts[rrs[0].(*dns.TXT).Txt[0]]++
+	}
+	// the split across clients follows the weights
+	require.InDelta(t, 9000, counts["a"], 300)
+	require.InDelta(t, 1000, counts["b"], 300)
+}
+
 func BenchmarkAdd(b *testing.B) {
 	testCases := []struct {
 		numAnswers int
@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+// ErrZoneWalkUnsupported is returned when walking a zone of a DB whose keys
+// are not sorted, e.g a CDB.
+var ErrZoneWalkUnsupported = errors.New("walking a zone requires a DB with sorted keys")
+
+// ForEachZoneRecord is not supported by unsorted DBs, as there is no way to
+// find the owner names below a zone cut without scanning the whole DB.
+func (r *DataReader) ForEachZoneRecord(_ []byte, _ ID, _ func(owner []byte, rrs []dns.RR) error) error {
+	return ErrZoneWalkUnsupported
+}
+
+// ForEachZoneRecord calls f with the records of every owner name at or below
+// the packed zone name, in provided AND default location, the same way they
+// would be answered to queries from locID.
+// Owner names are visited in reverse canonical order, from the last one to the
+// zone apex. f is not called for owner names without records in these
+// locations. If f returns an error, the walk stops.
+func (r *sortedDataReader) ForEachZoneRecord(zone []byte, locID ID, f func(owner []byte, rrs []dns.RR) error) error {
+	markerLen := len(dnsdata.ResourceRecordsKeyMarker)
+
+	// Keys of the names at or below the zone all start with its reversed
+	// name, minus the terminating empty label.
+	prefix := make([]byte, markerLen+len(zone))
+	copy(prefix, []byte(dnsdata.ResourceRecordsKeyMarker))
+	reverseZoneNameToBuffer(zone, prefix[markerLen:])
+	prefix = prefix[:len(prefix)-1]
+
+	// Label lengths are at most 63, so this sorts after any key of the zone.
+	key := append(bytes.Clone(prefix), 0xff)
+
+	for {
+		k, err := r.closestKeyFinder.FindClosestKey(key, r.context)
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(k, prefix) {
+			// walked past the zone apex
+			return nil
+		}
+
+		nameEnd := markerLen
+		for nameEnd < len(k) && k[nameEnd] != 0 {
+			nameEnd += int(k[nameEnd]) + 1
+		}
+		if nameEnd >= len(k) {
+			return fmt.Errorf("malformed resource record key %v", k)
+		}
+		// Keys are the reversed name followed by the location, so the name
+		// alone sorts right before all the keys of this name.
+		key = bytes.Clone(k[:nameEnd+1])
+		owner := reverseZoneName(key[markerLen:])
+
+		rrs, err := r.resourceRecords(owner, locID)
+		if err != nil {
+			return err
+		}
+		if len(rrs) == 0 {
+			continue
+		}
+		if err = f(owner, rrs); err != nil {
+			return err
+		}
+	}
+}
+
+// resourceRecords returns all the records of a packed owner name, in provided
+// AND default location. Wildcard records are returned with their "*" label.
+// Records that can't be unpacked are logged and skipped, so that a single bad
+// record does not prevent walking the rest of the zone.
+func (r *sortedDataReader) resourceRecords(owner []byte, locID ID) ([]dns.RR, error) {
+	name, _, err := dns.UnpackDomainName(owner, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var rrs []dns.RR
+	parseRecord := func(result []byte) error {
+		rr, err := unpackRow(name, result)
+		if err != nil {
+			glog.Errorf("Skipping resource record of %s: %v", name, err)
+			return nil
+		}
+		rrs = append(rrs, rr)
+		return nil
+	}
+
+	if err = r.ForEachResourceRecord(owner, locID, parseRecord); err != nil {
+		return nil, err
+	}
+	return rrs, nil
+}
+
+// unpackRow unpacks a resource record row of an owner name. Wildcard records
+// are returned with their "*" label.
+func unpackRow(name string, row []byte) (dns.RR, error) {
+	rec, err := ExtractRRFromRow(row, false)
+	if errors.Is(err, ErrWildcardMismatch) {
+		name = "*." + name
+		rec, err = ExtractRRFromRow(row, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rdlength := len(row[rec.Offset:])
+	if rdlength > math.MaxUint16 {
+		return nil, fmt.Errorf("integer overflow for uint16 RR_Header.Rdlength: %d", rdlength)
+	}
+	hdr := dns.RR_Header{Name: name, Rrtype: rec.Qtype, Class: dns.ClassINET, Ttl: rec.TTL, Rdlength: uint16(rdlength)}
+	rr, _, err := dns.UnpackRRWithHeader(hdr, row, rec.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("%s %d: %w", name, rec.Qtype, err)
+	}
+	return rr, nil
+}
+
+// UnpackResourceRecord unpacks a resource record from its key and row in a
+// DB with sorted keys, e.g. as recorded in the zone history, and returns it
+// along with the ID of its location.
+func UnpackResourceRecord(key, row []byte) (dns.RR, ID, error) {
+	owner, loc, err := SplitResourceRecordKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	rr, err := UnpackOwnerRecord(owner, row)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rr, loc, nil
+}
+
+// SplitResourceRecordKey splits a resource record key of a DB with sorted keys
+// into the packed owner name and the ID of the location.
+func SplitResourceRecordKey(key []byte) ([]byte, ID, error) {
+	markerLen := len(dnsdata.ResourceRecordsKeyMarker)
+	if !bytes.HasPrefix(key, []byte(dnsdata.ResourceRecordsKeyMarker)) {
+		return nil, nil, fmt.Errorf("not a resource record key %v", key)
+	}
+	nameEnd := markerLen
+	for nameEnd < len(key) && key[nameEnd] != 0 {
+		nameEnd += int(key[nameEnd]) + 1
+	}
+	if nameEnd >= len(key) {
+		return nil, nil, fmt.Errorf("malformed resource record key %v", key)
+	}
+	return reverseZoneName(key[markerLen : nameEnd+1]), ID(key[nameEnd+1:]), nil
+}
+
+// UnpackOwnerRecord unpacks a resource record row of a packed owner name.
+// Wildcard records are returned with their "*" label.
+func UnpackOwnerRecord(owner, row []byte) (dns.RR, error) {
+	name, _, err := dns.UnpackDomainName(owner, 0)
+	if err != nil {
+		return nil, err
+	}
+	return unpackRow(name, row)
+}
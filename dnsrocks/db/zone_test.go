@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package db
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestForEachZoneRecord(t *testing.T) {
+	defaultCounts := map[string]int{
+		"example.net.":      5, // SOA, NS and MX
+		"*.example.net.":    1,
+		"a.ns.example.net.": 2,
+		"b.ns.example.net.": 2,
+		"www.example.net.":  1,
+		"www2.example.net.": 1,
+		"www3.example.net.": 1,
+		"bar.example.net.":  2,
+	}
+	locationCounts := map[string]int{
+		"foo.example.net.":      2,
+		"cnamemap.example.net.": 1,
+	}
+	for name, count := range defaultCounts {
+		locationCounts[name] = count
+	}
+
+	testCases := []struct {
+		name   string
+		locID  ID
+		counts map[string]int
+	}{
+		{
+			name:   "default location",
+			locID:  ZeroID,
+			counts: defaultCounts,
+		},
+		{
+			name:   "location 1",
+			locID:  ID{0, 1},
+			counts: locationCounts,
+		},
+	}
+
+	zone := make([]byte, 255)
+	offset, err := dns.PackDomainName("example.net.", zone, 0, nil, false)
+	require.NoError(t, err)
+	zone = zone[:offset]
+
+	for _, config := range testaid.TestDBs {
+		db, err := Open(config.Path, config.Driver)
+		require.NoError(t, err, "could not open fixture database")
+		r, err := NewReader(db)
+		require.NoError(t, err, "could not open db file")
+
+		for _, tc := range testCases {
+			t.Run(config.Driver+"/"+config.Flavour+"/"+tc.name, func(t *testing.T) {
+				counts := make(map[string]int)
+				var last []byte
+				err := r.ForEachZoneRecord(zone, tc.locID, func(owner []byte, rrs []dns.RR) error {
+					// owner names are visited in reverse order of their keys
+					if last != nil {
+						require.Negative(t, bytes.Compare(reverseZoneName(owner), reverseZoneName(last)))
+					}
+					last = owner
+					for _, rr := range rrs {
+						counts[rr.Header().Name]++
+					}
+					return nil
+				})
+				if config.Flavour != testaid.TestRDBV2.Flavour {
+					require.ErrorIs(t, err, ErrZoneWalkUnsupported)
+					return
+				}
+				require.NoError(t, err)
+				require.Equal(t, tc.counts, counts)
+				require.Equal(t, zone, last, "the zone apex is visited last")
+			})
+		}
+
+		t.Run(config.Driver+"/"+config.Flavour+"/stop", func(t *testing.T) {
+			if config.Flavour != testaid.TestRDBV2.Flavour {
+				t.Skip("unsupported")
+			}
+			stop := errors.New("stop")
+			calls := 0
+			err := r.ForEachZoneRecord(zone, ZeroID, func(_ []byte, _ []dns.RR) error {
+				calls++
+				return stop
+			})
+			require.ErrorIs(t, err, stop)
+			require.Equal(t, 1, calls)
+		})
+		r.Close()
+	}
+}
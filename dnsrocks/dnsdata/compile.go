@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dnsdata
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// NewKVCodec returns the codec generating the keys and values of the
+// key-value databases, RocksDB and Pebble. Both have to produce the same keys
+// and values, so that the drivers and tools can share them.
+func NewKVCodec(serial uint32, useV2Keys bool) *Codec {
+	codec := new(Codec)
+	codec.Serial = serial
+	codec.Acc.Ranger.Enable()
+	codec.Acc.NoPrefixSets = true
+	codec.NoRnetOutput = true
+	codec.Features.UseV2Keys = useV2Keys
+	return codec
+}
+
+// CompileStream parses data from io.Reader with codec, in parallel when
+// workers != 1, and passes the resulting records to store. Once store returns
+// an error, the rest of the records is dropped and that error is returned.
+func CompileStream(r io.Reader, codec *Codec, workers int, store func([]MapRecord) error) error {
+	var g errgroup.Group
+	// will be closed by ParseStream
+	results := make(chan []MapRecord, max(workers, 0))
+
+	g.Go(func() error {
+		return ParseStream(r, codec, results, workers)
+	})
+	var err error
+	// the results have to be drained for the parser to finish
+	for v := range results {
+		if err == nil {
+			err = store(v)
+		}
+	}
+	if perr := g.Wait(); err == nil {
+		err = perr
+	}
+	return err
+}
+
+// CompileFile opens inputFileName, derives the serial from it and passes both
+// to compile.
+func CompileFile(inputFileName string, compile func(in io.Reader, serial uint32) (int, error)) (int, error) {
+	ifile, err := os.Open(inputFileName)
+	if err != nil {
+		return 0, fmt.Errorf("error opening input file %s: %w", inputFileName, err)
+	}
+	defer ifile.Close()
+	serial, err := DeriveSerial(ifile)
+	if err != nil {
+		return 0, fmt.Errorf("error accessing input file %s: %w", inputFileName, err)
+	}
+	return compile(ifile, serial)
+}
@@ -26,6 +26,7 @@ import (
 	"math"
 	"math/big"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -83,6 +84,7 @@ type Codec struct {
 	Acc          Accum     // a meta-record which represents an accumulated state over the whole data set
 	NoRnetOutput bool      // if set, disables Rnet ("%"-records) output in the output - use with Acc.Ranger.Enable()
 	Features     Rfeatures // a meta-record with features supported by generated DB
+	Chains       Rchains   // a meta-record with the parent chains of the locations
 }
 
 // rshared is a struct with fields are available to the most of record types
@@ -94,6 +96,13 @@ type rshared struct {
 	iswildcard bool   // imply "*." in front of dom
 }
 
+// wrs is the optional weight of the records picked by weighted random sample
+// among the records of their type and owner, like A/AAAA records are.
+type wrs struct {
+	weighted  bool   // the weight field is set
+	wrsWeight uint32 // weight
+}
+
 // Rsoa is "Z" - SOA record.
 type Rsoa struct {
 	rshared
@@ -134,6 +143,7 @@ type Raddr struct {
 	c      *Codec
 	ip     net.IP // the address
 	weight uint32 // weight
+	health []byte // the optional health tag, see HealthTaggedRecordFlag
 }
 
 // Rpaddr is [composite]  = → (A/AAAA, PTR)
@@ -149,6 +159,7 @@ type Rmx struct {
 // Rmx1 is just MX
 type Rmx1 struct {
 	rshared
+	wrs
 	mx   []byte // the MX prefix/server
 	dist uint16 // the "distance" (default: 0)
 	c    *Codec
@@ -164,6 +175,7 @@ type Rsrv struct {
 // Rsrv1 is just SRV
 type Rsrv1 struct {
 	rshared
+	wrs
 	srv    []byte // the service
 	pri    uint16 // priority, 0-65535
 	weight uint16 // weight, 0-65535
@@ -181,17 +193,42 @@ type Rptr struct {
 // Rcname is C → CNAME
 type Rcname struct {
 	rshared
+	wrs
 	cname []byte // the canonical name
 	c     *Codec
 }
 
+// Rdname is D → DNAME
+type Rdname struct {
+	rshared
+	target []byte // the subtree the names below dom are redirected to
+	c      *Codec
+}
+
+// Ralias is A → ALIAS, answered with the A/AAAA records of its target
+type Ralias struct {
+	rshared
+	target []byte // the name whose addresses are answered for dom
+	c      *Codec
+}
+
 // Rtxt is ' → TXT
 type Rtxt struct {
 	rshared
+	wrs
 	txt []byte // the text
 	c   *Codec
 }
 
+// Rcaa is Y → CAA
+type Rcaa struct {
+	rshared
+	flags uint8  // issuer critical flag is the only one defined
+	tag   []byte // property tag, see caaTags
+	value []byte // property value
+	c     *Codec
+}
+
 // Raux is : → (anything else)
 type Raux struct {
 	rshared
@@ -235,6 +272,22 @@ type Rfeatures struct {
 	UseV2Keys bool
 }
 
+// Rparent is "L" - location parent record.
+// The clients of the location are answered the records of its parent for the
+// names and types the location has no records of, up to the default location.
+type Rparent struct {
+	lo     Loc // the location
+	parent Loc // the parent of the location
+	c      *Codec
+}
+
+// Rchains is a meta record storing the chains of parents of the locations,
+// accumulated from the "L" records
+type Rchains struct {
+	parents map[string]Loc
+	mux     sync.Mutex
+}
+
 // Rsvcb is SVCB (service binding) record
 // other SVCB-like records share the same layout as Rsvcb's
 type Rsvcb struct {
@@ -281,11 +334,17 @@ const (
 	TypeAAAA WireType = 28
 	// TypeSRV represents SRV record type
 	TypeSRV WireType = 33
+	// TypeDNAME represents DNAME record type
+	TypeDNAME WireType = 39
 	// TypeSVCB represents SVCB record type
 	// for SVCB/HTTPS, see https://datatracker.ietf.org/doc/html/draft-ietf-dnsop-svcb-https-08
 	TypeSVCB WireType = 64
 	// TypeHTTPS represents HTTPS record type
 	TypeHTTPS WireType = 65
+	// TypeALIAS represents ALIAS record type, from the private use range
+	TypeALIAS WireType = 65401
+	// TypeCAA represents CAA record type
+	TypeCAA WireType = 257
 )
 
 func (m Lmap) String() string {
@@ -314,10 +373,16 @@ func (w WireType) String() string {
 		return "AAAA"
 	case TypeSRV:
 		return "SRV"
+	case TypeDNAME:
+		return "DNAME"
 	case TypeSVCB:
 		return "SVCB"
 	case TypeHTTPS:
 		return "HTTPS"
+	case TypeCAA:
+		return "CAA"
+	case TypeALIAS:
+		return "ALIAS"
 	}
 
 	return fmt.Sprintf("%d", w)
@@ -343,6 +408,10 @@ const (
 	prefixRangePoint Rtype = "!"
 	prefixSVCB       Rtype = "B"
 	prefixHTTPS      Rtype = "H"
+	prefixCAA        Rtype = "Y"
+	prefixDName      Rtype = "D"
+	prefixAlias      Rtype = "A"
+	prefixParent     Rtype = "L"
 )
 
 func decodeRtype(text []byte) Rtype {
@@ -388,6 +457,14 @@ func (c *Codec) newRecord(t Rtype) (Record, error) {
 		return &Rsvcb{c: c, wtype: TypeSVCB}, nil
 	case prefixHTTPS:
 		return &Rhttps{c: c, wtype: TypeHTTPS}, nil
+	case prefixCAA:
+		return &Rcaa{c: c}, nil
+	case prefixDName:
+		return &Rdname{c: c}, nil
+	case prefixAlias:
+		return &Ralias{c: c}, nil
+	case prefixParent:
+		return &Rparent{c: c}, nil
 	}
 	return nil, ErrBadRType
 }
@@ -414,6 +491,11 @@ func (c *Codec) DecodeLn(text []byte) (Record, error) {
 	if err != nil {
 		return nil, err
 	}
+	if p, ok := r.(*Rparent); ok {
+		if err = c.Chains.update(p); err != nil {
+			return nil, err
+		}
+	}
 	return r, nil
 }
 
@@ -551,6 +633,19 @@ const (
 	FeaturesKey = "\x00o_features"
 	// ResourceRecordsKeyMarker is the prefix for the resource record keys
 	ResourceRecordsKeyMarker = "\000o"
+	// HistoryKeyMarker is the prefix for the keys of the zone history entries,
+	// recorded as diffs are applied
+	HistoryKeyMarker = "\000\000\000x"
+	// WeightedRecordFlag is set on the second byte of the record head of the
+	// weighted records other than A/AAAA, whose weight follows the head
+	WeightedRecordFlag = 0x80
+	// HealthTaggedRecordFlag is set on the second byte of the record head of
+	// the A/AAAA records with a health tag, which follows their weight as a
+	// length-prefixed string
+	HealthTaggedRecordFlag = 0x40
+	// LocationChainKeyMarker is the prefix for the keys of the location
+	// chains, followed by the location ID
+	LocationChainKeyMarker = "\000\000\000<"
 )
 
 // Feature is a bitmap representing different characteristics of DB data
@@ -987,8 +1082,11 @@ func (r *Raddr) UnmarshalText(text []byte) error {
 	// f[3] ignored
 	var err error
 	r.lo, err = getloc(f[4])
+	if err != nil {
+		return err
+	}
 	getuint32(f[5], &r.weight)
-	return err
+	return r.gethealth(f[6])
 }
 
 func (r *Raddr) loadDefaults() {
@@ -1015,6 +1113,7 @@ func (r *Raddr) MarshalMap() ([]MapRecord, error) {
 		if err = binary.Write(v, binary.BigEndian, r.weight); err != nil {
 			return nil, err
 		}
+		r.puthealth(v)
 		v.Write(ip4)
 	} else {
 		if err := putrrhead(v, TypeAAAA, r.ttl, r.lo, r.iswildcard); err != nil {
@@ -1023,6 +1122,7 @@ func (r *Raddr) MarshalMap() ([]MapRecord, error) {
 		if err = binary.Write(v, binary.BigEndian, r.weight); err != nil {
 			return nil, err
 		}
+		r.puthealth(v)
 		v.Write(r.ip)
 	}
 	x := MapRecord{Key: k, Value: v.Bytes()}
@@ -1039,7 +1139,11 @@ func (r *Rpaddr) UnmarshalText(text []byte) error {
 	// f[3] ignored
 	var err error
 	r.lo, err = getloc(f[4])
-	return err
+	if err != nil {
+		return err
+	}
+	// f[5] ignored
+	return (*Raddr)(r).gethealth(f[6])
 }
 
 func (r *Rpaddr) loadDefaults() {
@@ -1061,6 +1165,7 @@ func (r *Rpaddr) DerivedRecords() []Record {
 	a.ip = r.ip
 	a.ttl = r.ttl
 	a.lo = r.lo
+	a.health = r.health
 	a.c = r.c
 
 	ptr := new(Rptr)
@@ -1121,8 +1226,10 @@ func (r *Rmx1) unmarshalFields(f [][]byte) error {
 	getuint32(f[4], &r.ttl)
 	// f[5] ignored
 	var err error
-	r.lo, err = getloc(f[6])
-	return err
+	if r.lo, err = getloc(f[6]); err != nil {
+		return err
+	}
+	return r.getwrs(f[7])
 }
 
 func (r *Rmx1) loadDefaults() {
@@ -1140,6 +1247,9 @@ func (r *Rmx1) MarshalMap() ([]MapRecord, error) {
 	if err = putrrhead(v, TypeMX, r.ttl, r.lo, false); err != nil {
 		return nil, err
 	}
+	if err = r.putwrs(v); err != nil {
+		return nil, err
+	}
 	if err = binary.Write(v, binary.BigEndian, r.dist); err != nil {
 		return nil, err
 	}
@@ -1217,8 +1327,10 @@ func (r *Rsrv1) unmarshalFields(f [][]byte) error {
 	getuint32(f[6], &r.ttl)
 	// f[7] ignored
 	var err error
-	r.lo, err = getloc(f[8])
-	return err
+	if r.lo, err = getloc(f[8]); err != nil {
+		return err
+	}
+	return r.getwrs(f[9])
 }
 
 // MarshalMap implements MapMarshaler
@@ -1232,6 +1344,9 @@ func (r *Rsrv1) MarshalMap() ([]MapRecord, error) {
 	if err = putrrhead(v, TypeSRV, r.ttl, r.lo, false); err != nil {
 		return nil, err
 	}
+	if err = r.putwrs(v); err != nil {
+		return nil, err
+	}
 	if err = binary.Write(v, binary.BigEndian, r.pri); err != nil {
 		return nil, err
 	}
@@ -1273,8 +1388,10 @@ func (r *Rcname) UnmarshalText(text []byte) error {
 	getuint32(f[2], &r.ttl)
 	// f[3] ignored
 	var err error
-	r.lo, err = getloc(f[4])
-	return err
+	if r.lo, err = getloc(f[4]); err != nil {
+		return err
+	}
+	return r.getwrs(f[5])
 }
 
 func (r *Rcname) loadDefaults() {
@@ -1292,11 +1409,87 @@ func (r *Rcname) MarshalMap() ([]MapRecord, error) {
 	if err = putrrhead(v, TypeCNAME, r.ttl, r.lo, r.iswildcard); err != nil {
 		return nil, err
 	}
+	if err = r.putwrs(v); err != nil {
+		return nil, err
+	}
 	putdom(v, r.cname)
 	m := []MapRecord{{Key: k, Value: v.Bytes()}}
 	return m, nil
 }
 
+// UnmarshalText implements encoding.TextUnmarshaler
+func (r *Rdname) UnmarshalText(text []byte) error {
+	r.loadDefaults()
+	f := fields(text)
+	r.dom, r.iswildcard = getdom(f[0])
+	if r.iswildcard {
+		return fmt.Errorf("DNAME owner cannot be a wildcard: %q", f[0])
+	}
+	var err error
+	if r.target, err = quote.Bunquote(f[1]); err != nil {
+		return err
+	}
+	getuint32(f[2], &r.ttl)
+	// f[3] ignored
+	r.lo, err = getloc(f[4])
+	return err
+}
+
+func (r *Rdname) loadDefaults() {
+	r.ttl = LongTTL
+}
+
+// MarshalMap implements MapMarshaler
+func (r *Rdname) MarshalMap() ([]MapRecord, error) {
+	var err error
+	var k []byte
+	if k, err = makedomainkey(r.dom, r.lo, r.c); err != nil {
+		return nil, err
+	}
+	v := new(bytes.Buffer)
+	if err = putrrhead(v, TypeDNAME, r.ttl, r.lo, false); err != nil {
+		return nil, err
+	}
+	putdom(v, r.target)
+	m := []MapRecord{{Key: k, Value: v.Bytes()}}
+	return m, nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (r *Ralias) UnmarshalText(text []byte) error {
+	r.loadDefaults()
+	f := fields(text)
+	r.dom, r.iswildcard = getdom(f[0])
+	var err error
+	if r.target, err = quote.Bunquote(f[1]); err != nil {
+		return err
+	}
+	getuint32(f[2], &r.ttl)
+	// f[3] ignored
+	r.lo, err = getloc(f[4])
+	return err
+}
+
+func (r *Ralias) loadDefaults() {
+	r.ttl = LongTTL
+}
+
+// MarshalMap implements MapMarshaler
+func (r *Ralias) MarshalMap() ([]MapRecord, error) {
+	var err error
+	var k []byte
+	if k, err = makedomainkey(r.dom, r.lo, r.c); err != nil {
+		return nil, err
+	}
+	v := new(bytes.Buffer)
+	if err = putrrhead(v, TypeALIAS, r.ttl, r.lo, r.iswildcard); err != nil {
+		return nil, err
+	}
+	putdom(v, r.target)
+	m := []MapRecord{{Key: k, Value: v.Bytes()}}
+	return m, nil
+}
+
 // UnmarshalText implements encoding.TextUnmarshaler
 func (r *Rptr) UnmarshalText(text []byte) error {
 	r.loadDefaults()
@@ -1339,8 +1532,10 @@ func (r *Rtxt) UnmarshalText(text []byte) error {
 	getuint32(f[2], &r.ttl)
 	// f[3] ignored
 	var err error
-	r.lo, err = getloc(f[4])
-	return err
+	if r.lo, err = getloc(f[4]); err != nil {
+		return err
+	}
+	return r.getwrs(f[5])
 }
 
 func (r *Rtxt) loadDefaults() {
@@ -1358,6 +1553,9 @@ func (r *Rtxt) MarshalMap() ([]MapRecord, error) {
 	if err = putrrhead(v, TypeTXT, r.ttl, r.lo, r.iswildcard); err != nil {
 		return nil, err
 	}
+	if err = r.putwrs(v); err != nil {
+		return nil, err
+	}
 	for sofar := 0; sofar < len(r.txt); {
 		n := len(r.txt) - sofar
 		if n > 127 {
@@ -1371,6 +1569,63 @@ func (r *Rtxt) MarshalMap() ([]MapRecord, error) {
 	return m, nil
 }
 
+// caaTags are the CAA property tags registered with IANA, see
+// https://www.iana.org/assignments/pkix-parameters/pkix-parameters.xhtml#caa-properties
+var caaTags = map[string]bool{
+	"issue":        true,
+	"issuewild":    true,
+	"iodef":        true,
+	"contactemail": true,
+	"contactphone": true,
+	"issuemail":    true,
+	"issuevmc":     true,
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (r *Rcaa) UnmarshalText(text []byte) error {
+	r.loadDefaults()
+	f := fields(text)
+	r.dom, r.iswildcard = getdom(f[0])
+	flags, err := strconv.ParseUint(string(f[1]), 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid CAA flags %q: %w", f[1], err)
+	}
+	r.flags = uint8(flags)
+	r.tag = bytes.ToLower(f[2])
+	if !caaTags[string(r.tag)] {
+		return fmt.Errorf("invalid CAA tag %q", f[2])
+	}
+	if r.value, err = quote.Bunquote(f[3]); err != nil {
+		return err
+	}
+	getuint32(f[4], &r.ttl)
+	// f[5] ignored
+	r.lo, err = getloc(f[6])
+	return err
+}
+
+func (r *Rcaa) loadDefaults() {
+	r.ttl = LongTTL
+}
+
+// MarshalMap implements MapMarshaler
+func (r *Rcaa) MarshalMap() ([]MapRecord, error) {
+	var err error
+	var k []byte
+	if k, err = makedomainkey(r.dom, r.lo, r.c); err != nil {
+		return nil, err
+	}
+	v := new(bytes.Buffer)
+	if err = putrrhead(v, TypeCAA, r.ttl, r.lo, r.iswildcard); err != nil {
+		return nil, err
+	}
+	v.Write([]byte{r.flags, byte(len(r.tag))})
+	v.Write(r.tag)
+	v.Write(r.value)
+	m := []MapRecord{{Key: k, Value: v.Bytes()}}
+	return m, nil
+}
+
 // UnmarshalText implements encoding.TextUnmarshaler
 func (r *Raux) UnmarshalText(text []byte) error {
 	r.loadDefaults()
@@ -1454,6 +1709,80 @@ func (r *Rfeatures) MarshalMap() ([]MapRecord, error) {
 	return []MapRecord{{Key: []byte(FeaturesKey), Value: encodeFeatures(features)}}, nil
 }
 
+// ErrBadLocationChain is returned when the parents of the locations do not
+// form chains ending at the default location.
+var ErrBadLocationChain = errors.New("bad location chain")
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (r *Rparent) UnmarshalText(text []byte) error {
+	f := fields(text)
+	var err error
+	if r.lo, err = getloc(f[0]); err != nil {
+		return err
+	}
+	if r.parent, err = getloc(f[1]); err != nil {
+		return err
+	}
+	if r.lo == nil || r.parent == nil || bytes.Equal(r.lo, r.parent) {
+		return fmt.Errorf("%w: %q has parent %q", ErrBadLocationChain, r.lo, r.parent)
+	}
+	return nil
+}
+
+// MarshalMap implements MapMarshaler. The parents are stored along with the
+// whole data set, see Rchains.
+func (r *Rparent) MarshalMap() ([]MapRecord, error) {
+	return nil, nil
+}
+
+// accounts the parent of a location
+func (r *Rchains) update(p *Rparent) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.parents == nil {
+		r.parents = make(map[string]Loc)
+	}
+	if parent, ok := r.parents[string(p.lo)]; ok && !bytes.Equal(parent, p.parent) {
+		return fmt.Errorf("%w: %q has parents %q and %q", ErrBadLocationChain, p.lo, parent, p.parent)
+	}
+	r.parents[string(p.lo)] = p.parent
+	return nil
+}
+
+// MarshalMap implements MapMarshaler. The chain of a location is stored as
+// the list of its ancestors, nearest first, keyed by the location.
+func (r *Rchains) MarshalMap() ([]MapRecord, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	locs := make([]string, 0, len(r.parents))
+	for lo := range r.parents {
+		locs = append(locs, lo)
+	}
+	sort.Strings(locs)
+
+	m := make([]MapRecord, 0, len(locs))
+	for _, lo := range locs {
+		k := new(bytes.Buffer)
+		k.WriteString(LocationChainKeyMarker)
+		if err := putloc(k, Loc(lo)); err != nil {
+			return nil, err
+		}
+		v := new(bytes.Buffer)
+		seen := map[string]bool{lo: true}
+		for parent, ok := r.parents[lo]; ok; parent, ok = r.parents[string(parent)] {
+			if seen[string(parent)] {
+				return nil, fmt.Errorf("%w: %q is its own ancestor", ErrBadLocationChain, parent)
+			}
+			seen[string(parent)] = true
+			if err := putloc(v, parent); err != nil {
+				return nil, err
+			}
+		}
+		m = append(m, MapRecord{Key: k.Bytes(), Value: v.Bytes()})
+	}
+	return m, nil
+}
+
 // UnmarshalText implements encoding.TextUnmarshaler
 func (r *Rsvcb) UnmarshalText(text []byte) error {
 	f := fields(text)
@@ -1730,6 +2059,68 @@ func putrrhead(w io.Writer, t WireType, ttl uint32, loc Loc, iswildcard bool) er
 	return err
 }
 
+// getwrs parses the optional weight field of the record.
+func (r *wrs) getwrs(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	weight, err := strconv.ParseUint(string(b), 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid weight %q: %w", b, err)
+	}
+	r.weighted = true
+	r.wrsWeight = uint32(weight)
+	return nil
+}
+
+// putwrs marks the record head written by putrrhead at the start of v as
+// weighted, and appends the weight, which precedes the rdata as for A/AAAA.
+func (r *wrs) putwrs(v *bytes.Buffer) error {
+	if !r.weighted {
+		return nil
+	}
+	v.Bytes()[2] |= WeightedRecordFlag
+	return binary.Write(v, binary.BigEndian, r.wrsWeight)
+}
+
+// gethealth parses the optional health tag field of the record.
+func (r *Raddr) gethealth(b []byte) error {
+	if len(b) > 255 {
+		return fmt.Errorf("health tag too long: %s", b)
+	}
+	if len(b) > 0 {
+		r.health = bytes.Clone(b)
+	}
+	return nil
+}
+
+// puthealth marks the record head written by putrrhead at the start of v as
+// health tagged, and appends the tag, which follows the weight.
+func (r *Raddr) puthealth(v *bytes.Buffer) {
+	if len(r.health) == 0 {
+		return
+	}
+	v.Bytes()[2] |= HealthTaggedRecordFlag
+	v.WriteByte(byte(len(r.health)))
+	v.Write(r.health)
+}
+
+// puthealthtext writes the optional health tag field of the record.
+func (r *Raddr) puthealthtext(w io.Writer) {
+	if len(r.health) > 0 {
+		w.Write(NSEP)
+		w.Write(r.health)
+	}
+}
+
+// putwrstext writes the optional weight field of the record.
+func (r *wrs) putwrstext(w io.Writer) {
+	if r.weighted {
+		w.Write(NSEP)
+		fmt.Fprintf(w, "%d", r.wrsWeight)
+	}
+}
+
 // Convert unsigned integer to decimal string.
 // (lifted from https://golang.org/src/net/parse.go?m=text)
 func uitoa(val uint) string {
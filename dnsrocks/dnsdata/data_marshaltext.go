@@ -126,6 +126,7 @@ func (r *Raddr) MarshalText() (text []byte, err error) {
 	Putloctext(w, r.lo)
 	w.Write(NSEP)
 	fmt.Fprintf(w, "%d", r.weight)
+	r.puthealthtext(w)
 	return w.Bytes(), nil
 }
 
@@ -149,6 +150,11 @@ func (r *Rpaddr) MarshalText() (text []byte, err error) {
 	// skip one unused field
 	w.Write(NSEP)
 	Putloctext(w, r.lo)
+	if len(r.health) > 0 {
+		// skip one unused field
+		w.Write(NSEP)
+		(*Raddr)(r).puthealthtext(w)
+	}
 	return w.Bytes(), nil
 }
 
@@ -173,6 +179,7 @@ func (r *Rmx) MarshalText() (text []byte, err error) {
 	// skip one unused field
 	w.Write(NSEP)
 	Putloctext(w, r.Rmx1.lo)
+	r.Rmx1.putwrstext(w)
 	return w.Bytes(), nil
 }
 
@@ -193,6 +200,7 @@ func (r *Rmx1) MarshalText() (text []byte, err error) {
 	// skip one unused field
 	w.Write(NSEP)
 	Putloctext(w, r.lo)
+	r.putwrstext(w)
 	return w.Bytes(), nil
 }
 
@@ -221,6 +229,7 @@ func (r *Rsrv) MarshalText() (text []byte, err error) {
 	// skip one unused field
 	w.Write(NSEP)
 	Putloctext(w, r.Rsrv1.lo)
+	r.Rsrv1.putwrstext(w)
 	return w.Bytes(), nil
 }
 
@@ -245,6 +254,7 @@ func (r *Rsrv1) MarshalText() (text []byte, err error) {
 	// skip one unused field
 	w.Write(NSEP)
 	Putloctext(w, r.lo)
+	r.putwrstext(w)
 	return w.Bytes(), nil
 }
 
@@ -264,6 +274,7 @@ func (r *Rcname) MarshalText() (text []byte, err error) {
 	// skip one unused field
 	w.Write(NSEP)
 	Putloctext(w, r.lo)
+	r.putwrstext(w)
 	return w.Bytes(), nil
 }
 
@@ -299,6 +310,65 @@ func (r *Rtxt) MarshalText() (text []byte, err error) {
 	// skip one unused field
 	w.Write(NSEP)
 	Putloctext(w, r.lo)
+	r.putwrstext(w)
+	return w.Bytes(), nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (r *Ralias) MarshalText() (text []byte, err error) {
+	w := new(bytes.Buffer)
+	w.WriteString(string(prefixAlias))
+	if r.iswildcard {
+		w.WriteString("*.")
+	}
+	putdomtext(w, r.dom)
+	w.Write(NSEP)
+	putdomtext(w, r.target)
+	w.Write(NSEP)
+	fmt.Fprintf(w, "%d", r.ttl)
+	w.Write(NSEP)
+	// skip one unused field
+	w.Write(NSEP)
+	Putloctext(w, r.lo)
+	return w.Bytes(), nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (r *Rdname) MarshalText() (text []byte, err error) {
+	w := new(bytes.Buffer)
+	w.WriteString(string(prefixDName))
+	putdomtext(w, r.dom)
+	w.Write(NSEP)
+	putdomtext(w, r.target)
+	w.Write(NSEP)
+	fmt.Fprintf(w, "%d", r.ttl)
+	w.Write(NSEP)
+	// skip one unused field
+	w.Write(NSEP)
+	Putloctext(w, r.lo)
+	return w.Bytes(), nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (r *Rcaa) MarshalText() (text []byte, err error) {
+	w := new(bytes.Buffer)
+	w.WriteString(string(prefixCAA))
+	if r.iswildcard {
+		w.WriteString("*.")
+	}
+	putdomtext(w, r.dom)
+	w.Write(NSEP)
+	fmt.Fprintf(w, "%d", r.flags)
+	w.Write(NSEP)
+	w.Write(r.tag)
+	w.Write(NSEP)
+	putquotedtext(w, r.value)
+	w.Write(NSEP)
+	fmt.Fprintf(w, "%d", r.ttl)
+	w.Write(NSEP)
+	// skip one unused field
+	w.Write(NSEP)
+	Putloctext(w, r.lo)
 	return w.Bytes(), nil
 }
 
@@ -362,6 +432,16 @@ func (r *Rcsmap) MarshalText() (text []byte, err error) {
 	return w.Bytes(), nil
 }
 
+// MarshalText implements encoding.TextMarshaler
+func (r *Rparent) MarshalText() (text []byte, err error) {
+	w := new(bytes.Buffer)
+	w.WriteString(string(prefixParent))
+	Putloctext(w, r.lo)
+	w.Write(NSEP)
+	Putloctext(w, r.parent)
+	return w.Bytes(), nil
+}
+
 // MarshalText implements encoding.TextMarshaler
 func (r *Rrangepoint) MarshalText() (text []byte, err error) {
 	return r.pt.MarshalTextForLmap(r.lmap)
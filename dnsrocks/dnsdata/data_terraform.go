@@ -51,6 +51,13 @@ func (r *Rcname) TerraformValue() (string, error) {
 	return w.String(), nil
 }
 
+// TerraformValue implements TerraformRecord interface
+func (r *Rdname) TerraformValue() (string, error) {
+	w := new(bytes.Buffer)
+	putdomtext(w, r.target)
+	return w.String(), nil
+}
+
 // TerraformValue implements TerraformRecord interface
 func (r *Rptr) TerraformValue() (string, error) {
 	w := new(bytes.Buffer)
@@ -77,6 +84,11 @@ func (r *Rtxt) TerraformValue() (string, error) {
 	return result, nil
 }
 
+// TerraformValue implements TerraformRecord interface
+func (r *Rcaa) TerraformValue() (string, error) {
+	return fmt.Sprintf("%d %s %q", r.flags, r.tag, r.value), nil
+}
+
 // TerraformValue implements TerraformRecord interface
 func (r *Rsrv1) TerraformValue() (string, error) {
 	w := new(bytes.Buffer)
@@ -107,10 +119,14 @@ func WireTypeToTerraformString(t WireType) (string, error) {
 		return "AAAA", nil
 	case TypeSRV:
 		return "SRV", nil
+	case TypeDNAME:
+		return "DNAME", nil
 	case TypeSVCB:
 		return "SVCB", nil
 	case TypeHTTPS:
 		return "HTTPS", nil
+	case TypeCAA:
+		return "CAA", nil
 	}
 
 	return "", fmt.Errorf("unknown wire type: %v", t)
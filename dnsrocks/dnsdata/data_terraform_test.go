@@ -70,6 +70,11 @@ func TestTerraformFormatting(t *testing.T) {
 			expectedType:  "SRV",
 			expectedValue: "0 443 10 db.test.com",
 		},
+		{
+			input:         "Ytest.com,0,issue,letsencrypt.org,3600",
+			expectedType:  "CAA",
+			expectedValue: "0 issue \"letsencrypt.org\"",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -507,6 +507,72 @@ var codectests = []codecTest{
 			},
 		},
 	},
+	{
+		in:      []byte("+*.grid.example.com,10.10.10.10,3600,,,1,pool1"),
+		outText: []byte("+*.grid.example.com,10.10.10.10,3600,,,1,pool1"),
+		out: []MapRecord{{
+			Key: []byte{0, 0, 4, 103, 114, 105, 100, 7, 101, 120, 97, 109, 112, 108, 101, 3, 99, 111, 109, 0},
+			Value: []byte{
+				0, 1, '*' | HealthTaggedRecordFlag, 0, 0, 14, 16, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+				5, 'p', 'o', 'o', 'l', '1', // health tag
+				10, 10, 10, 10,
+			},
+		}},
+		outV2: []MapRecord{
+			{
+				Key: []byte{
+					0, 111, // prefix
+					3, 99, 111, 109, 7, 101, 120, 97, 109, 112, 108, 101, 4, 103, 114, 105, 100, 0, // inverted name
+					0, 0, // location
+				},
+				Value: []byte{
+					0, 1, '*' | HealthTaggedRecordFlag, 0, 0, 14, 16, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+					5, 'p', 'o', 'o', 'l', '1', // health tag
+					10, 10, 10, 10,
+				},
+			},
+		},
+	},
+	{
+		in:      []byte("=button.panic.mil,1.8.7.108,3600,,,,pool1"),
+		outText: []byte("=button.panic.mil,1.8.7.108,3600,,,,pool1"),
+		out: []MapRecord{
+			{
+				Key: []byte{0, 0, 6, 98, 117, 116, 116, 111, 110, 5, 112, 97, 110, 105, 99, 3, 109, 105, 108, 0},
+				Value: []byte{
+					0, 1, '=' | HealthTaggedRecordFlag, 0, 0, 14, 16, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+					5, 'p', 'o', 'o', 'l', '1', // health tag
+					1, 8, 7, 108,
+				},
+			},
+			{
+				Key:   []byte{0, 0, 3, 49, 48, 56, 1, 55, 1, 56, 1, 49, 7, 105, 110, 45, 97, 100, 100, 114, 4, 97, 114, 112, 97, 0},
+				Value: []byte{0, 12, 61, 0, 0, 14, 16, 0, 0, 0, 0, 0, 0, 0, 0, 6, 98, 117, 116, 116, 111, 110, 5, 112, 97, 110, 105, 99, 3, 109, 105, 108, 0},
+			},
+		},
+		outV2: []MapRecord{
+			{
+				Key: []byte{
+					0, 111, // prefix
+					3, 109, 105, 108, 5, 112, 97, 110, 105, 99, 6, 98, 117, 116, 116, 111, 110, 0, // inverted name
+					0, 0, // location
+				},
+				Value: []byte{
+					0, 1, '=' | HealthTaggedRecordFlag, 0, 0, 14, 16, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+					5, 'p', 'o', 'o', 'l', '1', // health tag
+					1, 8, 7, 108,
+				},
+			},
+			{
+				Key: []byte{
+					0, 111, // prefix
+					4, 97, 114, 112, 97, 7, 105, 110, 45, 97, 100, 100, 114, 1, 49, 1, 56, 1, 55, 3, 49, 48, 56, 0, // inverted name
+					0, 0, // location
+				},
+				Value: []byte{0, 12, 61, 0, 0, 14, 16, 0, 0, 0, 0, 0, 0, 0, 0, 6, 98, 117, 116, 116, 111, 110, 5, 112, 97, 110, 105, 99, 3, 109, 105, 108, 0},
+			},
+		},
+	},
 	{
 		in:      []byte("=button.p\\141nic.mil:1.8.7.108"),
 		outText: []byte("=button.panic.mil,1.8.7.108,86400,,"),
@@ -1486,6 +1552,218 @@ var codectests = []codecTest{
 			},
 		},
 	},
+	{
+		in:      []byte("Yexample.com,0,issue,letsencrypt.org,3600"),
+		outText: []byte("Yexample.com,0,issue,letsencrypt.org,3600,,"),
+		out: []MapRecord{
+			{
+				Key: []byte{
+					0, 0, // loc
+					7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+				},
+				Value: []byte{
+					1, 1, // CAA type
+					'=',          // loc
+					0, 0, 14, 16, // ttl
+					0, 0, 0, 0, 0, 0, 0, 0, // 8 zeros from putrrhead
+					0,                          // flags
+					5, 'i', 's', 's', 'u', 'e', // tag
+					'l', 'e', 't', 's', 'e', 'n', 'c', 'r', 'y', 'p', 't', '.', 'o', 'r', 'g', // value
+				},
+			},
+		},
+		outV2: []MapRecord{
+			{
+				Key: []byte{
+					0, 111, // prefix
+					3, 'c', 'o', 'm', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0, // inverted name
+					0, 0, // location
+				},
+				Value: []byte{
+					1, 1, // CAA type
+					'=',          // loc
+					0, 0, 14, 16, // ttl
+					0, 0, 0, 0, 0, 0, 0, 0, // 8 zeros from putrrhead
+					0,                          // flags
+					5, 'i', 's', 's', 'u', 'e', // tag
+					'l', 'e', 't', 's', 'e', 'n', 'c', 'r', 'y', 'p', 't', '.', 'o', 'r', 'g', // value
+				},
+			},
+		},
+	},
+	{
+		in:      []byte("Y*.example.com,128,IODEF,mailto:sec@example.com,300,,xx"),
+		outText: []byte("Y*.example.com,128,iodef,mailto\\072sec@example.com,300,,\\170\\170"),
+		out: []MapRecord{
+			{
+				Key: []byte{
+					'x', 'x', // loc
+					7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+				},
+				Value: []byte{
+					1, 1, // CAA type
+					'+', 'x', 'x', // loc
+					0, 0, 1, 44, // ttl
+					0, 0, 0, 0, 0, 0, 0, 0, // 8 zeros from putrrhead
+					128,                        // flags
+					5, 'i', 'o', 'd', 'e', 'f', // tag
+					'm', 'a', 'i', 'l', 't', 'o', ':', 's', 'e', 'c', '@', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm', // value
+				},
+			},
+		},
+		outV2: []MapRecord{
+			{
+				Key: []byte{
+					0, 111, // prefix
+					3, 'c', 'o', 'm', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0, // inverted name
+					'x', 'x', // location
+				},
+				Value: []byte{
+					1, 1, // CAA type
+					'+', 'x', 'x', // loc
+					0, 0, 1, 44, // ttl
+					0, 0, 0, 0, 0, 0, 0, 0, // 8 zeros from putrrhead
+					128,                        // flags
+					5, 'i', 'o', 'd', 'e', 'f', // tag
+					'm', 'a', 'i', 'l', 't', 'o', ':', 's', 'e', 'c', '@', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm', // value
+				},
+			},
+		},
+	},
+	{
+		in:      []byte("Cw.example.com,a.example.com,300,,,90"),
+		outText: []byte("Cw.example.com,a.example.com,300,,,90"),
+		out: []MapRecord{
+			{
+				Key: []byte{
+					0, 0, // loc
+					1, 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+				},
+				Value: []byte{
+					0, 5, // CNAME type
+					'=' | WeightedRecordFlag, // loc, weighted
+					0, 0, 1, 44,              // ttl
+					0, 0, 0, 0, 0, 0, 0, 0, // 8 zeros from putrrhead
+					0, 0, 0, 90, // weight
+					1, 'a', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+				},
+			},
+		},
+		outV2: []MapRecord{
+			{
+				Key: []byte{
+					0, 111, // prefix
+					3, 'c', 'o', 'm', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 1, 'w', 0, // inverted name
+					0, 0, // location
+				},
+				Value: []byte{
+					0, 5, // CNAME type
+					'=' | WeightedRecordFlag, // loc, weighted
+					0, 0, 1, 44,              // ttl
+					0, 0, 0, 0, 0, 0, 0, 0, // 8 zeros from putrrhead
+					0, 0, 0, 90, // weight
+					1, 'a', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+				},
+			},
+		},
+	},
+	{
+		in:      []byte("'w.example.com,canary,300,,,0"),
+		outText: []byte("'w.example.com,canary,300,,,0"),
+		out: []MapRecord{
+			{
+				Key: []byte{
+					0, 0, // loc
+					1, 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+				},
+				Value: []byte{
+					0, 16, // TXT type
+					'=' | WeightedRecordFlag, // loc, weighted
+					0, 0, 1, 44,              // ttl
+					0, 0, 0, 0, 0, 0, 0, 0, // 8 zeros from putrrhead
+					0, 0, 0, 0, // weight
+					6, 'c', 'a', 'n', 'a', 'r', 'y',
+				},
+			},
+		},
+		outV2: []MapRecord{
+			{
+				Key: []byte{
+					0, 111, // prefix
+					3, 'c', 'o', 'm', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 1, 'w', 0, // inverted name
+					0, 0, // location
+				},
+				Value: []byte{
+					0, 16, // TXT type
+					'=' | WeightedRecordFlag, // loc, weighted
+					0, 0, 1, 44,              // ttl
+					0, 0, 0, 0, 0, 0, 0, 0, // 8 zeros from putrrhead
+					0, 0, 0, 0, // weight
+					6, 'c', 'a', 'n', 'a', 'r', 'y',
+				},
+			},
+		},
+	},
+	{
+		in:      []byte("L\\000\\001,\\000\\002"),
+		outText: []byte("L\\000\\001,\\000\\002"),
+		out:     nil, // stored along with the whole data set, see Rchains
+	},
+}
+
+func TestCAAInvalidTag(t *testing.T) {
+	for _, in := range []string{
+		"Yexample.com,0,isue,letsencrypt.org,3600",
+		"Yexample.com,0,,letsencrypt.org,3600",
+		"Yexample.com,0,issue-wild,letsencrypt.org,3600",
+	} {
+		t.Run(in, func(t *testing.T) {
+			codec := new(Codec)
+			_, err := codec.ConvertLn([]byte(in))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestCAAInvalidFlags(t *testing.T) {
+	for _, in := range []string{
+		"Yexample.com,Z,issue,letsencrypt.org,3600",
+		"Yexample.com,300,issue,letsencrypt.org,3600",
+		"Yexample.com,,issue,letsencrypt.org,3600",
+	} {
+		t.Run(in, func(t *testing.T) {
+			codec := new(Codec)
+			_, err := codec.ConvertLn([]byte(in))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestDNAMEInvalidTarget(t *testing.T) {
+	codec := new(Codec)
+	_, err := codec.ConvertLn([]byte("Dexample.com,example\\9.net,3600"))
+	require.Error(t, err)
+}
+
+func TestALIASInvalidTarget(t *testing.T) {
+	codec := new(Codec)
+	_, err := codec.ConvertLn([]byte("Aexample.com,example\\9.net,3600"))
+	require.Error(t, err)
+}
+
+func TestInvalidWeight(t *testing.T) {
+	for _, in := range []string{
+		"Cw.example.com,a.example.com,300,,,9o",
+		"@w.example.com,,mx.example.com,10,300,,,-1",
+		"Sw.example.com,,srv.example.com,443,10,10,300,,,4294967296",
+		"'w.example.com,canary,300,,,x",
+	} {
+		t.Run(in, func(t *testing.T) {
+			codec := new(Codec)
+			_, err := codec.ConvertLn([]byte(in))
+			require.Error(t, err)
+		})
+	}
 }
 
 func TestConvertLnStateful(t *testing.T) {
@@ -1813,6 +2091,58 @@ func TestNoEncodeSubnets(t *testing.T) {
 	}
 }
 
+func TestEncodeLocationChains(t *testing.T) {
+	tc := [][]byte{
+		[]byte(`L\000\001,\000\002`),
+		[]byte(`L\000\002,\000\003`),
+		[]byte(`Lcity01,\000\001`),
+		[]byte(`L\000\001,\000\002`),
+	}
+	targ := []MapRecord{
+		{Key: []byte{0, 0, 0, '<', 0, 1}, Value: []byte{0, 2, 0, 3}},
+		{Key: []byte{0, 0, 0, '<', 0, 2}, Value: []byte{0, 3}},
+		{Key: []byte{0, 0, 0, '<', 0xff, 6, 'c', 'i', 't', 'y', '0', '1'}, Value: []byte{0, 1, 0, 2, 0, 3}},
+	}
+
+	codec := new(Codec)
+	for _, in := range tc {
+		v, err := codec.ConvertLn(in)
+		require.NoError(t, err, string(in))
+		require.Empty(t, v)
+	}
+	out, err := codec.Chains.MarshalMap()
+	require.NoError(t, err)
+	require.Equal(t, targ, out)
+}
+
+func TestBadLocationChains(t *testing.T) {
+	for _, in := range []string{
+		`L\000\001,\000\001`,
+		`L\000\001,`,
+		`L,\000\001`,
+	} {
+		t.Run(in, func(t *testing.T) {
+			codec := new(Codec)
+			_, err := codec.ConvertLn([]byte(in))
+			require.ErrorIs(t, err, ErrBadLocationChain)
+		})
+	}
+
+	codec := new(Codec)
+	_, err := codec.ConvertLn([]byte(`L\000\001,\000\002`))
+	require.NoError(t, err)
+	_, err = codec.ConvertLn([]byte(`L\000\001,\000\003`))
+	require.ErrorIs(t, err, ErrBadLocationChain, "conflicting parents")
+
+	codec = new(Codec)
+	for _, in := range []string{`L\000\001,\000\002`, `L\000\002,\000\003`, `L\000\003,\000\001`} {
+		_, err := codec.ConvertLn([]byte(in))
+		require.NoError(t, err)
+	}
+	_, err = codec.Chains.MarshalMap()
+	require.ErrorIs(t, err, ErrBadLocationChain, "loop")
+}
+
 func TestLmapString(t *testing.T) {
 	l := Lmap{97, 1}
 	require.Equal(t, `\141\001`, l.String())
@@ -85,6 +85,21 @@ func (r *Rtxt) WireType() WireType {
 	return TypeTXT
 }
 
+// WireType implements WireRecord interface
+func (r *Ralias) WireType() WireType {
+	return TypeALIAS
+}
+
+// WireType implements WireRecord interface
+func (r *Rdname) WireType() WireType {
+	return TypeDNAME
+}
+
+// WireType implements WireRecord interface
+func (r *Rcaa) WireType() WireType {
+	return TypeCAA
+}
+
 // WireType implements WireRecord interface
 func (r *Rsrv1) WireType() WireType {
 	return TypeSRV
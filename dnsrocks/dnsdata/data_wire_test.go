@@ -106,6 +106,30 @@ func TestWireRecordIsImplemented(t *testing.T) {
 			location:   []byte("\011\012"),
 			ttl:        1805,
 		},
+		{
+			in:         "Atest.com,www.test.com,1809,,\005\006",
+			record:     &Ralias{},
+			wireType:   TypeALIAS,
+			domainName: "test.com",
+			location:   []byte("\005\006"),
+			ttl:        1809,
+		},
+		{
+			in:         "Dold.test.com,new.test.com,1808,,\004\005",
+			record:     &Rdname{},
+			wireType:   TypeDNAME,
+			domainName: "old.test.com",
+			location:   []byte("\004\005"),
+			ttl:        1808,
+		},
+		{
+			in:         "Ytest.com,0,issue,ca.test.com,1807,,\003\004",
+			record:     &Rcaa{},
+			wireType:   TypeCAA,
+			domainName: "test.com",
+			location:   []byte("\003\004"),
+			ttl:        1807,
+		},
 		{
 			in:         "Htest.com,www.test.com,1806,\002\003,0",
 			record:     &Rhttps{},
@@ -72,6 +72,13 @@ func ParseStream(r io.Reader, codec *Codec, results chan<- []MapRecord, workers
 	}
 	results <- v
 
+	// Pack the location chains
+	v, err = codec.Chains.MarshalMap()
+	if err != nil {
+		return fmt.Errorf("location chains marshalling failed: %w", err)
+	}
+	results <- v
+
 	return nil
 }
 
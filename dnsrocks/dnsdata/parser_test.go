@@ -69,6 +69,12 @@ func genData(size int) ([]byte, []MapRecord) {
 	}
 	mapRecords = append(mapRecords, extra...)
 
+	extra, err = c.Chains.MarshalMap()
+	if err != nil {
+		panic(err)
+	}
+	mapRecords = append(mapRecords, extra...)
+
 	return bytes.Join(records, []byte("\n")), mapRecords
 }
 
@@ -129,6 +135,12 @@ func getExpected(useV2data bool) []MapRecord {
 		expected = append(expected, versionRecord)
 	}
 
+	// generated from the location parents
+	expected = append(expected, MapRecord{
+		Key:   []byte("\000\000\000<\000\001"),
+		Value: []byte{0x00, 0x02},
+	})
+
 	return expected
 }
 
@@ -0,0 +1,392 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/bloom"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// defaults for Pebble options
+const (
+	defaultBloomFilterBits = 10
+	defaultBlockCacheMB    = 8
+	numLevels              = 7
+)
+
+// Mb is megabyte
+const Mb = 1 << 20
+
+// PDB is Pebble-backed DNS database. It uses the same keys and the same
+// multi-value layout as RDB: each value is a list of chunks prefixed with
+// their 32-bit little-endian length, see ../rdb/rdb_util.go
+type PDB struct {
+	db            *pebble.DB
+	readOnly      bool
+	checkpointDir string
+}
+
+// Context is a structure holding the state between calls to DB.
+// Pebble lookups are cheap enough not to need any caching.
+type Context struct{}
+
+// NewContext creates a new structure holding state across calls
+func NewContext() *Context {
+	return new(Context)
+}
+
+// Reset prepares the context for a new look-up cycle.
+func (ctx *Context) Reset() {
+}
+
+func defaultOptions() *pebble.Options {
+	opts := &pebble.Options{
+		Cache: pebble.NewCache(defaultBlockCacheMB * Mb),
+		// values are appended with Merge, the default merger concatenates them
+		// which is exactly the multi-value layout
+		Merger: pebble.DefaultMerger,
+		Levels: make([]pebble.LevelOptions, numLevels),
+	}
+	for i := range opts.Levels {
+		opts.Levels[i].FilterPolicy = bloom.FilterPolicy(defaultBloomFilterBits)
+		opts.Levels[i].FilterType = pebble.TableFilter
+	}
+	return opts
+}
+
+func open(path string, opts *pebble.Options) (*pebble.DB, error) {
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%s directory does not exist: %w", path, err)
+	}
+	// the DB holds its own reference to the cache
+	defer opts.Cache.Unref()
+	return pebble.Open(path, opts)
+}
+
+// NewPDB creates an instance of PDB; path should be an existing path
+// to the directory, the database will be opened or initialized
+func NewPDB(path string) (*PDB, error) {
+	opts := defaultOptions()
+	// values are always rebuilt from the data file, so there is nothing to recover from WAL
+	opts.DisableWAL = true
+	db, err := open(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &PDB{db: db}, nil
+}
+
+// NewReader creates a read-only instance of PDB; path should be an existing path
+// to the directory containing a Pebble database. Pebble doesn't support reading
+// a database while another process writes it, the writer deletes the files its
+// compactions replace, so the reader takes a checkpoint of the database next to
+// path and reads from it. Opening a reader fails while a writer has the database
+// open. The reader sees the state of the database at the time it was opened, a
+// new reader has to be opened to see later changes.
+func NewReader(path string) (*PDB, error) {
+	checkpointDir, err := checkpoint(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	opts := defaultOptions()
+	opts.ReadOnly = true
+	opts.ErrorIfNotExists = true
+	db, err := open(filepath.Join(checkpointDir, checkpointName), opts)
+	if err != nil {
+		os.RemoveAll(checkpointDir)
+		return nil, err
+	}
+	return &PDB{db: db, readOnly: true, checkpointDir: checkpointDir}, nil
+}
+
+// checkpointName is the name of the checkpoint in the directory created for it
+const checkpointName = "db"
+
+// checkpointMu serializes taking checkpoints, as only one instance of the
+// database at a given path can be open in a process
+var checkpointMu sync.Mutex
+
+// checkpoint takes a checkpoint of the database at path into a new directory
+// next to it, so that the checkpoint files are hard links to the database ones.
+// It returns the directory, which has to be removed once the checkpoint is not
+// used anymore.
+func checkpoint(path string) (string, error) {
+	checkpointMu.Lock()
+	defer checkpointMu.Unlock()
+
+	// Pebble can't take checkpoints of a database open read-only. Nothing is
+	// written to the database, and compactions are disabled not to rewrite it
+	// either while the checkpoint is taken.
+	opts := defaultOptions()
+	opts.ErrorIfNotExists = true
+	opts.DisableWAL = true
+	opts.DisableAutomaticCompactions = true
+	db, err := open(path, opts)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	dir, err := os.MkdirTemp(filepath.Dir(path), filepath.Base(path)+".reader-")
+	if err != nil {
+		return "", err
+	}
+	if err = db.Checkpoint(filepath.Join(dir, checkpointName)); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("error taking checkpoint of %s: %w", path, err)
+	}
+	return dir, nil
+}
+
+// Add appends a value to the multi-value stored for the key
+func (p *PDB) Add(key, value []byte) error {
+	return p.db.Merge(key, appendValues(nil, value), pebble.NoSync)
+}
+
+// Flush writes out in-memory data, so that it becomes visible to readers
+func (p *PDB) Flush() error {
+	return p.db.Flush()
+}
+
+// Compact compacts the whole key space, merging all values of each key together
+func (p *PDB) Compact() error {
+	iter, err := p.db.NewIter(nil)
+	if err != nil {
+		return err
+	}
+	var first, last []byte
+	if iter.First() {
+		first = copyBytes(iter.Key())
+		if iter.Last() {
+			last = copyBytes(iter.Key())
+		}
+	}
+	if err = iter.Close(); err != nil {
+		return err
+	}
+	if first == nil || last == nil {
+		return nil // empty database
+	}
+	return p.db.Compact(first, append(last, 0), true)
+}
+
+// Close closes the database and frees up resources
+func (p *PDB) Close() error {
+	if !p.readOnly {
+		if err := p.db.Flush(); err != nil {
+			p.db.Close()
+			return err
+		}
+	}
+	err := p.db.Close()
+	if p.checkpointDir != "" {
+		if rerr := os.RemoveAll(p.checkpointDir); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// get returns all the values of the key, or nil if the key does not exist
+func (p *PDB) get(key []byte) ([]byte, error) {
+	value, closer, err := p.db.Get(key)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return copyBytes(value), nil
+}
+
+// Find returns the first data value for the given key as a byte slice.
+// It returns io.EOF if the key does not exist.
+func (p *PDB) Find(key []byte, _ *Context) ([]byte, error) {
+	data, err := p.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	v, _, err := readNextChunk(data)
+
+	return v, err
+}
+
+// FindFirst returns the value of the first existing key, as well as key offset.
+// If the key is not found or error happened, the key offset is -1.
+// The order of keys on the input DOES matter. Will return nil/no error
+// if nothing was found. If key has more than one value - will return the first one anyway.
+func (p *PDB) FindFirst(keys [][]byte) ([]byte, int, error) {
+	for i, key := range keys {
+		data, err := p.get(key)
+		if err != nil {
+			return nil, -1, err
+		}
+		if len(data) == 0 {
+			continue // skip nonexistent keys
+		}
+		v, _, err := readNextChunk(data)
+		if err != nil {
+			return nil, -1, err
+		}
+		return v, i, nil
+	}
+	return nil, -1, nil
+}
+
+// FindClosest given the key, returns KV for either the exact key match,
+// or for the largest key preceding the requested key. For instance, if key {1, 2, 3, 4} is requested, but
+// such a key does not exist - it will return existing key {1, 2, 3, 3}.
+// It returns nil key and value if there is no such key.
+func (p *PDB) FindClosest(key []byte, _ *Context) ([]byte, []byte, error) {
+	iter, err := p.db.NewIter(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iter.Close()
+
+	// the smallest key greater than the requested one is the key followed by a zero byte
+	next := make([]byte, len(key)+1)
+	copy(next, key)
+	if !iter.SeekLT(next) {
+		return nil, nil, iter.Error()
+	}
+	v, err := iter.ValueAndErr()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return copyBytes(iter.Key()), copyBytes(v), nil
+}
+
+// ForEach calls a function for each value of the key.
+// if error is not nil, the loop will stop.
+func (p *PDB) ForEach(key []byte, f func(value []byte) error, _ *Context) error {
+	data, err := p.get(key)
+	if err != nil {
+		return err
+	}
+	return forEachChunk(data, f)
+}
+
+// ForEachRow calls a function for each value of each key of the database,
+// in key order. If the function returns an error, the loop stops.
+func (p *PDB) ForEachRow(f func(key, value []byte) error) error {
+	iter, err := p.db.NewIter(nil)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		data, err := iter.ValueAndErr()
+		if err != nil {
+			return err
+		}
+		err = forEachChunk(data, func(v []byte) error { return f(key, v) })
+		if err != nil {
+			return fmt.Errorf("key %v: %w", key, err)
+		}
+	}
+	return iter.Error()
+}
+
+// IsV2KeySyntaxUsed returns value indicating whether v2 syntax is used for DB keys
+func (p *PDB) IsV2KeySyntaxUsed() bool {
+	value, err := p.Find([]byte(dnsdata.FeaturesKey), NewContext())
+	if err != nil {
+		return false
+	}
+
+	feature := dnsdata.DecodeFeatures(value)
+
+	return feature&dnsdata.V2KeysFeature > 0
+}
+
+// GetStats reports main memory and LSM stats from Pebble.
+func (p *PDB) GetStats() map[string]int64 {
+	m := p.db.Metrics()
+	return map[string]int64{
+		"pebble.mem.block-cache.usage.bytes": m.BlockCache.Size,
+		"pebble.block-cache.hits":            m.BlockCache.Hits,
+		"pebble.block-cache.misses":          m.BlockCache.Misses,
+		"pebble.mem.memtables.bytes":         int64(m.MemTable.Size), //nolint:gosec
+		"pebble.num-files-at-level0":         m.Levels[0].NumFiles,
+		"pebble.read-amp":                    int64(m.ReadAmp()),
+		"pebble.filter.hits":                 m.Filter.Hits,
+		"pebble.filter.misses":               m.Filter.Misses,
+	}
+}
+
+// appendValues appends a value to a multi-value 'data', prefixing it with its 32-bit length
+func appendValues(data []byte, value []byte) []byte {
+	var b [4]byte
+
+	binary.LittleEndian.PutUint32(b[:], uint32(len(value))) //nolint:gosec
+	data = append(data, b[:]...)
+	return append(data, value...)
+}
+
+// readNextChunk splits next chunk from data assuming the following format
+// <4 byte length><chunk>[<<4 byte length><chunk>>...]
+func readNextChunk(data []byte) (chunk []byte, leftover []byte, err error) {
+	if len(data) == 0 {
+		return nil, data, io.EOF
+	}
+	if len(data) < 4 {
+		return nil, data, io.ErrUnexpectedEOF
+	}
+	chunkLen := int(binary.LittleEndian.Uint32(data)) + 4
+	if len(data) < chunkLen {
+		return nil, data, io.ErrUnexpectedEOF
+	}
+	return data[4:chunkLen], data[chunkLen:], nil
+}
+
+func forEachChunk(data []byte, f func(value []byte) error) error {
+	for {
+		v, leftover, err := readNextChunk(data)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = f(v); err != nil {
+			return err
+		}
+		data = leftover
+	}
+}
+
+func copyBytes(b []byte) []byte {
+	cc := make([]byte, len(b))
+	copy(cc, b)
+	return cc
+}
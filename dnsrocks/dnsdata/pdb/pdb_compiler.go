@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/cockroachdb/pebble"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// DefaultBatchSize is the default number of records written in one batch
+const DefaultBatchSize = 100000
+
+// ErrNotEmpty is returned when compiling into a directory that is not empty
+var ErrNotEmpty = errors.New("destination directory is not empty")
+
+// CompilationOptions allows us to provide options to PDB compiler
+type CompilationOptions struct {
+	NumCPU         int  // Parser parallelism
+	UseV2KeySyntax bool // specifies whether v2 keys syntax should be used
+	BatchSize      int  // number of records written in one batch
+}
+
+// Compile data from io.Reader into PDB database at destPath.
+// destPath has to be an empty directory: the values of each key are
+// concatenated as they are compiled, which would append them to the values
+// of a database already there.
+func Compile(in io.Reader, serial uint32, destPath string, opts CompilationOptions) (nw int, err error) {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	entries, err := os.ReadDir(destPath)
+	if err != nil {
+		return 0, fmt.Errorf("error opening database at %s: %w", destPath, err)
+	}
+	if len(entries) > 0 {
+		return 0, fmt.Errorf("error opening database at %s: %w", destPath, ErrNotEmpty)
+	}
+
+	db, err := NewPDB(destPath)
+	if err != nil {
+		return 0, fmt.Errorf("error opening database at %s: %w", destPath, err)
+	}
+	defer func() {
+		if err == nil {
+			// compact if no errors happened, so that readers don't have to merge values
+			err = db.Compact()
+		}
+		if ierr := db.Close(); ierr != nil {
+			log.Printf("error closing database: %v", ierr)
+			if err == nil {
+				// report closing error if no other errors happened
+				err = ierr
+			}
+		}
+	}()
+
+	log.Println("Reading ...")
+	batch := db.db.NewBatch()
+	defer batch.Close()
+	store := func(data []dnsdata.MapRecord) error {
+		for _, m := range data {
+			if err := batch.Merge(m.Key, appendValues(nil, m.Value), nil); err != nil {
+				return err
+			}
+			nw++
+			if nw%batchSize == 0 {
+				log.Println(nw)
+				if err := batch.Commit(pebble.NoSync); err != nil {
+					return err
+				}
+				batch.Reset()
+			}
+		}
+		return nil
+	}
+
+	codec := dnsdata.NewKVCodec(serial, opts.UseV2KeySyntax)
+	if err = dnsdata.CompileStream(in, codec, opts.NumCPU, store); err == nil {
+		// final flush
+		err = batch.Commit(pebble.NoSync)
+	}
+	if err != nil {
+		return nw, fmt.Errorf("error writing database: %w", err)
+	}
+
+	return nw, nil
+}
+
+// CompileToPDB compiles inputFileName into PDB database at destPath.
+func CompileToPDB(inputFileName, destPath string, o CompilationOptions) (int, error) {
+	return dnsdata.CompileFile(inputFileName, func(in io.Reader, serial uint32) (int, error) {
+		return Compile(in, serial, destPath, o)
+	})
+}
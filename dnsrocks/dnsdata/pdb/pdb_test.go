@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pdb
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPDB(t *testing.T) (*PDB, string) {
+	dir := t.TempDir()
+	db, err := NewPDB(dir)
+	require.NoError(t, err)
+	return db, dir
+}
+
+func TestPDBMissingDir(t *testing.T) {
+	_, err := NewPDB("/nonexistent/pdb")
+	require.Error(t, err)
+	_, err = NewReader(t.TempDir())
+	require.Error(t, err, "reader must not create a database")
+}
+
+func TestPDBAddFind(t *testing.T) {
+	db, dir := newTestPDB(t)
+	require.NoError(t, db.Add([]byte("b"), []byte("v1")))
+	require.NoError(t, db.Add([]byte("b"), []byte("v2")))
+	require.NoError(t, db.Add([]byte("d"), []byte("v3")))
+	require.NoError(t, db.Close())
+
+	r, err := NewReader(dir)
+	require.NoError(t, err)
+	defer r.Close()
+	ctx := NewContext()
+
+	v, err := r.Find([]byte("b"), ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte("v1"), v)
+
+	_, err = r.Find([]byte("c"), ctx)
+	require.ErrorIs(t, err, io.EOF)
+
+	var values []string
+	err = r.ForEach([]byte("b"), func(value []byte) error {
+		values = append(values, string(value))
+		return nil
+	}, ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1", "v2"}, values)
+
+	stop := errors.New("stop")
+	calls := 0
+	err = r.ForEach([]byte("b"), func([]byte) error {
+		calls++
+		return stop
+	}, ctx)
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, 1, calls)
+
+	v, i, err := r.FindFirst([][]byte{[]byte("a"), []byte("d"), []byte("b")})
+	require.NoError(t, err)
+	require.Equal(t, 1, i)
+	require.Equal(t, []byte("v3"), v)
+
+	v, i, err = r.FindFirst([][]byte{[]byte("a"), []byte("c")})
+	require.NoError(t, err)
+	require.Equal(t, -1, i)
+	require.Nil(t, v)
+
+	var rows []string
+	err = r.ForEachRow(func(key, value []byte) error {
+		rows = append(rows, string(key)+"="+string(value))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"b=v1", "b=v2", "d=v3"}, rows)
+}
+
+func TestPDBReaderCheckpoint(t *testing.T) {
+	db, dir := newTestPDB(t)
+	require.NoError(t, db.Add([]byte("b"), []byte("v1")))
+
+	// the writer holds the directory lock
+	_, err := NewReader(dir)
+	require.Error(t, err)
+	require.NoError(t, db.Close())
+
+	r, err := NewReader(dir)
+	require.NoError(t, err)
+
+	// the reader doesn't keep the database open, so it can be written again
+	db, err = NewPDB(dir)
+	require.NoError(t, err)
+	require.NoError(t, db.Add([]byte("b"), []byte("v2")))
+	require.NoError(t, db.Compact())
+	require.NoError(t, db.Close())
+
+	var values []string
+	err = r.ForEach([]byte("b"), func(value []byte) error {
+		values = append(values, string(value))
+		return nil
+	}, NewContext())
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1"}, values, "the reader keeps the state it was opened with")
+
+	r2, err := NewReader(dir)
+	require.NoError(t, err)
+	values = nil
+	err = r2.ForEach([]byte("b"), func(value []byte) error {
+		values = append(values, string(value))
+		return nil
+	}, NewContext())
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1", "v2"}, values)
+
+	require.NoError(t, r.Close())
+	require.NoError(t, r2.Close())
+	checkpoints, err := filepath.Glob(dir + ".reader-*")
+	require.NoError(t, err)
+	require.Empty(t, checkpoints, "checkpoints are removed with their readers")
+}
+
+func TestPDBFindClosest(t *testing.T) {
+	db, dir := newTestPDB(t)
+	for _, k := range []string{"\x01\x02\x03\x03", "\x01\x02\x05"} {
+		require.NoError(t, db.Add([]byte(k), []byte(k)))
+	}
+	require.NoError(t, db.Close())
+
+	r, err := NewReader(dir)
+	require.NoError(t, err)
+	defer r.Close()
+
+	testCases := []struct {
+		key  string
+		want string
+	}{
+		{key: "\x01\x02\x03\x04", want: "\x01\x02\x03\x03"},
+		{key: "\x01\x02\x03\x03", want: "\x01\x02\x03\x03"},
+		{key: "\x01\x02\x05\x00", want: "\x01\x02\x05"},
+		{key: "\xff", want: "\x01\x02\x05"},
+		{key: "\x01\x02\x03", want: ""},
+		{key: "\x00", want: ""},
+	}
+	for _, tc := range testCases {
+		k, v, err := r.FindClosest([]byte(tc.key), NewContext())
+		require.NoError(t, err)
+		if tc.want == "" {
+			require.Nil(t, k, "key %v", []byte(tc.key))
+			require.Nil(t, v, "key %v", []byte(tc.key))
+			continue
+		}
+		require.Equal(t, []byte(tc.want), k, "key %v", []byte(tc.key))
+		require.Equal(t, appendValues(nil, []byte(tc.want)), v, "key %v", []byte(tc.key))
+	}
+}
+
+func TestPDBCompile(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	data := `Zexample.org,a.ns.example.org,dns.example.org,123,7200,1800,604800,120,120,,
++www.example.org,192.0.2.1,3600,,
++www.example.org,192.0.2.2,3600,,
+`
+	for _, v2 := range []bool{false, true} {
+		dir := t.TempDir()
+		n, err := Compile(strings.NewReader(data), 123, dir, CompilationOptions{NumCPU: 1, UseV2KeySyntax: v2})
+		require.NoError(t, err)
+		require.Positive(t, n)
+
+		r, err := NewReader(dir)
+		require.NoError(t, err)
+		require.Equal(t, v2, r.IsV2KeySyntaxUsed())
+
+		rows := 0
+		require.NoError(t, r.ForEachRow(func(_, _ []byte) error {
+			rows++
+			return nil
+		}))
+		require.Equal(t, n, rows, "each compiled record is stored as a value")
+		require.NoError(t, r.Close())
+
+		// compiling again would duplicate the values
+		_, err = Compile(strings.NewReader(data), 123, dir, CompilationOptions{NumCPU: 1, UseV2KeySyntax: v2})
+		require.ErrorIs(t, err, ErrNotEmpty)
+	}
+}
@@ -1,3 +1,5 @@
+//go:build cgo
+
 /*
  * Copyright (c) Meta Platforms, Inc. and affiliates.
  *
@@ -19,12 +21,17 @@ package rdb
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
 
 	"github.com/facebook/dns/dnsrocks/dnsdata"
 	"github.com/facebook/dns/dnsrocks/dnsdata/rdb/dbdiff"
+
+	"github.com/miekg/dns"
 )
 
 func (batch *Batch) ApplyDiff(d *dbdiff.Entry) {
@@ -39,10 +46,9 @@ func (batch *Batch) ApplyDiff(d *dbdiff.Entry) {
 }
 
 func (rdb *RDB) ApplyDiff(r io.Reader, serial uint32) error {
-	codec := initCodec(serial)
-	codec.Features.UseV2Keys = rdb.IsV2KeySyntaxUsed()
-	batch := rdb.CreateBatch()
+	codec := dnsdata.NewKVCodec(serial, rdb.IsV2KeySyntaxUsed())
 	scanner := bufio.NewScanner(r)
+	var entries []*dbdiff.Entry
 	for scanner.Scan() {
 		line := scanner.Bytes()
 		if len(line) < 1 || bytes.HasPrefix(line, []byte("#")) {
@@ -55,11 +61,27 @@ func (rdb *RDB) ApplyDiff(r io.Reader, serial uint32) error {
 		if err := e.Convert(codec); err != nil {
 			return fmt.Errorf("conversion error for line '%s' (op '%v'): %w", e.Bytes, e.Op, err)
 		}
-		batch.ApplyDiff(e)
+		entries = append(entries, e)
 	}
 	if err := scanner.Err(); err != nil {
 		return err
 	}
+	return rdb.ApplyEntries(entries)
+}
+
+// ApplyEntries applies converted diff entries in a single batch, recording
+// the history of the zones they change.
+func (rdb *RDB) ApplyEntries(entries []*dbdiff.Entry) error {
+	batch := rdb.CreateBatch()
+	for _, e := range entries {
+		batch.ApplyDiff(e)
+	}
+	// Zones can only be walked, and so transferred, in DBs with sorted keys
+	if rdb.IsV2KeySyntaxUsed() {
+		if err := rdb.recordHistory(batch, entries); err != nil {
+			return err
+		}
+	}
 	if err := rdb.ExecuteBatch(batch); err != nil {
 		return fmt.Errorf("database update failed: %w", err)
 	}
@@ -84,3 +106,210 @@ func ApplyDiff(diffpath, dbpath string) error {
 	}
 	return rdb.ApplyDiff(file, serial)
 }
+
+// zoneSerial returns the serial of the default location SOA record of a
+// reversed name in the DB, if any.
+func (rdb *RDB) zoneSerial(rname []byte, context *Context) (uint32, bool, error) {
+	k := make([]byte, 0, len(dnsdata.ResourceRecordsKeyMarker)+len(rname)+2)
+	k = append(k, dnsdata.ResourceRecordsKeyMarker...)
+	k = append(k, rname...)
+	k = append(k, 0, 0)
+	var (
+		serial uint32
+		found  bool
+	)
+	err := rdb.ForEach(k, func(row []byte) error {
+		if s, ok := soaSerial(row); ok && !found {
+			serial, found = s, true
+		}
+		return nil
+	}, context)
+	return serial, found, err
+}
+
+// recordHistory adds to the batch the history entries of the zones changed by
+// a diff, so that incremental zone transfers can be served from them. Zones
+// are found by their default location SOA record, whose serial must be
+// bumped by the diff for its changes to be recorded.
+func (rdb *RDB) recordHistory(batch *Batch, entries []*dbdiff.Entry) error {
+	context := NewContext()
+	// reversed zone name -> serial, before and after the diff
+	oldSerials := make(map[string]uint32)
+	newSerials := make(map[string]uint32)
+	noZone := make(map[string]bool)
+	for _, e := range entries {
+		if e.Op != dbdiff.AddOp {
+			continue
+		}
+		for _, r := range e.Records {
+			rname, ok := rrKeyName(r.Key)
+			if !ok || !bytes.Equal(r.Key[len(dnsdata.ResourceRecordsKeyMarker)+len(rname):], []byte{0, 0}) {
+				continue
+			}
+			if s, ok := soaSerial(r.Value); ok {
+				newSerials[string(rname)] = s
+			}
+		}
+	}
+	isZone := func(rname []byte) (bool, error) {
+		if _, ok := oldSerials[string(rname)]; ok {
+			return true, nil
+		}
+		// a zone added by the diff is not in the DB yet
+		_, added := newSerials[string(rname)]
+		if noZone[string(rname)] {
+			return added, nil
+		}
+		s, found, err := rdb.zoneSerial(rname, context)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			oldSerials[string(rname)] = s
+		} else {
+			noZone[string(rname)] = true
+		}
+		return found || added, nil
+	}
+	// findZone returns the closest enclosing zone of a reversed name
+	findZone := func(rname []byte) ([]byte, error) {
+		var cuts []int
+		for i := 0; i < len(rname)-1; i += int(rname[i]) + 1 {
+			cuts = append(cuts, i)
+		}
+		cuts = append(cuts, len(rname)-1)
+		for i := len(cuts) - 1; i >= 0; i-- {
+			z := append(bytes.Clone(rname[:cuts[i]]), 0)
+			ok, err := isZone(z)
+			if err != nil || ok {
+				return z, err
+			}
+		}
+		return nil, nil
+	}
+
+	history := make(map[string]*HistoryEntry)
+	var zones []string
+	for _, e := range entries {
+		for _, r := range e.Records {
+			rname, ok := rrKeyName(r.Key)
+			if !ok {
+				continue
+			}
+			zone, err := findZone(rname)
+			if err != nil {
+				return err
+			}
+			if zone == nil {
+				continue
+			}
+			h, ok := history[string(zone)]
+			if !ok {
+				h = new(HistoryEntry)
+				history[string(zone)] = h
+				zones = append(zones, string(zone))
+			}
+			switch e.Op {
+			case dbdiff.AddOp:
+				h.Added = append(h.Added, r)
+			case dbdiff.DelOp:
+				h.Deleted = append(h.Deleted, r)
+			}
+		}
+	}
+
+	for _, zone := range zones {
+		name := reverseName([]byte(zone))
+		zoneName, _, _ := dns.UnpackDomainName(name, 0)
+		oldSerial, ok := oldSerials[zone]
+		if !ok {
+			// a new zone, there is nothing to transfer incrementally
+			continue
+		}
+		newSerial, ok := newSerials[zone]
+		if !ok || newSerial == oldSerial {
+			log.Printf("zone %s changed without a SOA serial bump, not recording its history", zoneName)
+			continue
+		}
+		h := history[zone]
+		h.NewSerial = newSerial
+		v, err := h.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		k := HistoryKey(name, oldSerial)
+		old, err := rdb.Find(k, context)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read history of zone %s: %w", zoneName, err)
+		}
+		if old != nil {
+			batch.Del(k, old)
+		}
+		batch.Add(k, v)
+		if err := rdb.pruneHistory(batch, name, oldSerial, newSerial); err != nil {
+			return fmt.Errorf("failed to prune history of zone %s: %w", zoneName, err)
+		}
+	}
+	return nil
+}
+
+// pruneHistory adds to the batch the removal of the history entries of the
+// zone that are neither among the MaxZoneHistory latest ones, going back from
+// the entry from serial to newSerial being recorded, nor on their chain.
+func (rdb *RDB) pruneHistory(batch *Batch, zone []byte, serial, newSerial uint32) error {
+	prefix := HistoryKey(zone, 0)
+	prefix = prefix[:len(prefix)-4]
+
+	// serial -> new serial and value of the stored entries
+	type stored struct {
+		newSerial uint32
+		value     []byte
+	}
+	entries := make(map[uint32]stored)
+	iter := rdb.db.CreateIterator(rdb.readOptions)
+	defer iter.FreeIterator()
+	for iter.Seek(prefix); iter.IsValid(); iter.Next() {
+		k := iter.Key()
+		if !bytes.HasPrefix(k, prefix) {
+			break
+		}
+		if len(k) != len(prefix)+4 {
+			continue
+		}
+		v, _, err := ReadNextChunk(iter.Value())
+		if err != nil {
+			return err
+		}
+		if len(v) < 4 {
+			return errBadHistoryEntry
+		}
+		entries[binary.BigEndian.Uint32(k[len(prefix):])] = stored{
+			newSerial: binary.BigEndian.Uint32(v),
+			value:     copyBytes(v),
+		}
+	}
+	if err := iter.GetError(); err != nil {
+		return err
+	}
+	entries[serial] = stored{newSerial: newSerial}
+
+	previous := make(map[uint32]uint32, len(entries))
+	for s, e := range entries {
+		previous[e.newSerial] = s
+	}
+	keep := make(map[uint32]bool, MaxZoneHistory)
+	for s := serial; len(keep) < MaxZoneHistory && !keep[s]; {
+		keep[s] = true
+		p, ok := previous[s]
+		if !ok {
+			break
+		}
+		s = p
+	}
+	for s, e := range entries {
+		if s != serial && !keep[s] {
+			batch.Del(HistoryKey(zone, s), e.value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+
+	"github.com/miekg/dns"
+)
+
+// errBadHistoryEntry is returned when decoding a truncated history entry
+var errBadHistoryEntry = errors.New("malformed history entry")
+
+// MaxZoneHistory is the number of history entries kept for each zone, older
+// entries are removed when a diff records a new one.
+var MaxZoneHistory = 100
+
+// HistoryKey returns the key of the history entry changing the zone from
+// the given SOA serial. The zone is a packed, lower case domain name.
+func HistoryKey(zone []byte, serial uint32) []byte {
+	k := make([]byte, 0, len(dnsdata.HistoryKeyMarker)+len(zone)+4)
+	k = append(k, dnsdata.HistoryKeyMarker...)
+	k = append(k, zone...)
+	return binary.BigEndian.AppendUint32(k, serial)
+}
+
+// HistoryEntry is a diff applied to a zone, bumping its SOA serial to
+// NewSerial. The records are in their DB key/value form, SOA records
+// included.
+type HistoryEntry struct {
+	NewSerial uint32
+	Deleted   []dnsdata.MapRecord
+	Added     []dnsdata.MapRecord
+}
+
+// MarshalBinary encodes the entry as
+// <serial><ndeleted><nadded>[<key length><key><value length><value>...]
+func (e *HistoryEntry) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 12)
+	b = binary.BigEndian.AppendUint32(b, e.NewSerial)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(e.Deleted)))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(e.Added)))
+	for _, records := range [][]dnsdata.MapRecord{e.Deleted, e.Added} {
+		for _, r := range records {
+			b = binary.BigEndian.AppendUint32(b, uint32(len(r.Key)))
+			b = append(b, r.Key...)
+			b = binary.BigEndian.AppendUint32(b, uint32(len(r.Value)))
+			b = append(b, r.Value...)
+		}
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes an entry encoded by MarshalBinary
+func (e *HistoryEntry) UnmarshalBinary(data []byte) error {
+	if len(data) < 12 {
+		return errBadHistoryEntry
+	}
+	e.NewSerial = binary.BigEndian.Uint32(data)
+	ndel := binary.BigEndian.Uint32(data[4:])
+	nadd := binary.BigEndian.Uint32(data[8:])
+	data = data[12:]
+	next := func() ([]byte, error) {
+		if len(data) < 4 {
+			return nil, errBadHistoryEntry
+		}
+		l := binary.BigEndian.Uint32(data)
+		if uint32(len(data)-4) < l {
+			return nil, errBadHistoryEntry
+		}
+		v := data[4 : 4+l]
+		data = data[4+l:]
+		return v, nil
+	}
+	read := func(n uint32) ([]dnsdata.MapRecord, error) {
+		var records []dnsdata.MapRecord
+		for range n {
+			k, err := next()
+			if err != nil {
+				return nil, err
+			}
+			v, err := next()
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, dnsdata.MapRecord{Key: k, Value: v})
+		}
+		return records, nil
+	}
+	var err error
+	if e.Deleted, err = read(ndel); err != nil {
+		return err
+	}
+	if e.Added, err = read(nadd); err != nil {
+		return err
+	}
+	if len(data) != 0 {
+		return errBadHistoryEntry
+	}
+	return nil
+}
+
+// rrKeyName returns the reversed name of a V2 resource record key, and
+// whether it is one.
+func rrKeyName(key []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(key, []byte(dnsdata.ResourceRecordsKeyMarker)) {
+		return nil, false
+	}
+	i := len(dnsdata.ResourceRecordsKeyMarker)
+	for i < len(key) {
+		if key[i] == 0 {
+			return key[len(dnsdata.ResourceRecordsKeyMarker) : i+1], true
+		}
+		i += int(key[i]) + 1
+	}
+	return nil, false
+}
+
+// reverseName reverses the order of the labels of a packed name, turning a
+// domain name into its reversed DB form and back.
+func reverseName(name []byte) []byte {
+	out := make([]byte, len(name))
+	j := len(name) - 1
+	for i := 0; i < len(name)-1; i += int(name[i]) + 1 {
+		l := int(name[i]) + 1
+		j -= l
+		copy(out[j:], name[i:i+l])
+	}
+	return out
+}
+
+// soaSerial returns the serial of a SOA row, and whether it is one. Rows are
+// <qtype><ch>[<loc>]<ttl><ttd><rdata>, see dnsdata.
+func soaSerial(row []byte) (uint32, bool) {
+	if len(row) < 3 || binary.BigEndian.Uint16(row) != dns.TypeSOA {
+		return 0, false
+	}
+	off := 3
+	switch row[2] {
+	case '=':
+	case '=' + 1:
+		if len(row) < off+2 {
+			return 0, false
+		}
+		if row[off] == 0xff {
+			off += int(row[off+1])
+		}
+		off += 2
+	default:
+		return 0, false
+	}
+	off += 12
+	if off > len(row) {
+		return 0, false
+	}
+	var err error
+	for range 2 {
+		if _, off, err = dns.UnpackDomainName(row, off); err != nil {
+			return 0, false
+		}
+	}
+	if len(row) < off+4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(row[off:]), true
+}
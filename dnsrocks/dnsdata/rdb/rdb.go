@@ -1,3 +1,5 @@
+//go:build cgo
+
 /*
  * Copyright (c) Meta Platforms, Inc. and affiliates.
  *
@@ -32,12 +34,6 @@ import (
 	"github.com/facebook/dns/dnsrocks/dnsdata"
 )
 
-// DefaultBatchSize is the default allocation for Batch
-const DefaultBatchSize = 100000
-
-// DefaultBatchNum is the default number of Batches to allocate
-const DefaultBatchNum = 10
-
 // defaults for RocksDB options
 const (
 	defaultBloomFilterBits = 10
@@ -262,12 +258,16 @@ func NewUpdater(dbpath string) (*RDB, error) {
 	// We disable WAL because there is a known bug with CatchWithPrimary when it's enabled - T59258592
 	wopt := rocksdb.NewWriteOptions(false, true, true, false, false)
 	ropt := rocksdb.NewDefaultReadOptions()
+	// The pool is left disabled, so that iterators are created for each use
+	// and see the latest updates.
+	iteratorPool := newIteratorPool(func() *rocksdb.Iterator { return db.CreateIterator(ropt) })
 	rdb := &RDB{
 		db:           db,
 		writeMutex:   &sync.Mutex{},
 		readOptions:  ropt,
 		writeOptions: wopt,
 		logDir:       dbpath,
+		iteratorPool: iteratorPool,
 	}
 	return rdb, nil
 }
@@ -292,6 +292,12 @@ func (rdb *RDB) CatchWithPrimary() error {
 	return nil
 }
 
+// Flush writes the changes to disk. Updates are written without WAL, so the
+// secondaries only catch up with them once flushed.
+func (rdb *RDB) Flush() error {
+	return rdb.db.Flush()
+}
+
 // Add inserts a multi-value pair of key and value
 func (rdb *RDB) Add(key, value []byte) error {
 	rdb.writeMutex.Lock()
@@ -712,6 +718,31 @@ func (rdb *RDB) ForEach(key []byte, f func(value []byte) error, ctx *Context) (e
 	return err
 }
 
+// ForEachRow calls a function for each value of each key of the database,
+// in key order. If the function returns an error, the loop stops.
+func (rdb *RDB) ForEachRow(f func(key, value []byte) error) error {
+	iter := rdb.db.CreateIterator(rdb.readOptions)
+	defer iter.FreeIterator()
+
+	for iter.SeekToFirst(); iter.IsValid(); iter.Next() {
+		key, data := iter.Key(), iter.Value()
+		for {
+			v, leftover, err := ReadNextChunk(data)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("malformed value of key %v: %w", key, err)
+			}
+			if err = f(key, v); err != nil {
+				return err
+			}
+			data = leftover
+		}
+	}
+	return iter.GetError()
+}
+
 // IsV2KeySyntaxUsed returns value indicating whether v2 syntax is used for DB keys
 func (rdb *RDB) IsV2KeySyntaxUsed() bool {
 	value, err := rdb.Find([]byte(dnsdata.FeaturesKey), NewContext())
@@ -1,3 +1,5 @@
+//go:build cgo
+
 /*
  * Copyright (c) Meta Platforms, Inc. and affiliates.
  *
@@ -20,50 +22,15 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os"
-	"path"
 
 	"golang.org/x/sync/errgroup"
 
 	"github.com/facebook/dns/dnsrocks/dnsdata"
 )
 
-// CleanRDBDir removes all files from the directory - for instance, to clean up output directory before compilation
-func CleanRDBDir(rdbDir string) error {
-	dir, err := os.Open(rdbDir)
-	if err != nil {
-		return fmt.Errorf("error opening directory %s: %w", rdbDir, err)
-	}
-	var files []string
-	files, err = dir.Readdirnames(0)
-	if err != nil {
-		return fmt.Errorf("error reading directory %s: %w", rdbDir, err)
-	}
-	for _, fileName := range files {
-		fullName := path.Join(rdbDir, fileName)
-		if err = os.Remove(fullName); err != nil {
-			return fmt.Errorf("error removing file %s: %w", fullName, err)
-		}
-	}
-	return nil
-}
-
-// CompilationOptions allows us to provide options to RDB compiler
-type CompilationOptions struct {
-	NumCPU         int  // Parser and builder parallelism
-	UseV2KeySyntax bool // specifies whether v2 keys syntax should be used
-	// builder-related settings
-	UseBuilder          bool // if we use RDB builder (mem hungry, fastest) or not
-	BuilderUseHardlinks bool // if RDB builder can use hardlinks instead of copying sst files
-	// batch-related settings
-	BatchNumParallel int // When not using builder, how many batches can we backlog while parsing, affects mem consumption
-	BatchSize        int // When not using builder, ize of RDB batches
-}
-
 func compileBuilder(in io.Reader, codec *dnsdata.Codec, destPath string, opts CompilationOptions) (int, error) {
 	var builder *Builder
 	var err error
-	var g errgroup.Group
 	// Open or create database
 	if builder, err = NewBuilder(destPath, opts.BuilderUseHardlinks); err != nil {
 		return 0, fmt.Errorf("error opening database at %s: %w", destPath, err)
@@ -75,7 +42,7 @@ func compileBuilder(in io.Reader, codec *dnsdata.Codec, destPath string, opts Co
 	counter := 0
 	nw := 0
 
-	store := func(data []dnsdata.MapRecord) {
+	store := func(data []dnsdata.MapRecord) error {
 		for _, m := range data {
 			builder.ScheduleAdd(m)
 			nw++
@@ -85,16 +52,11 @@ func compileBuilder(in io.Reader, codec *dnsdata.Codec, destPath string, opts Co
 				log.Println(nw)
 			}
 		}
+		return nil
 	}
 
-	// will be closed by ParseParallelStream
-	resultsChan := make(chan []dnsdata.MapRecord, opts.NumCPU)
-
-	g.Go(func() error {
-		return dnsdata.ParseStream(in, codec, resultsChan, opts.NumCPU)
-	})
-	for v := range resultsChan {
-		store(v)
+	if err = dnsdata.CompileStream(in, codec, opts.NumCPU, store); err != nil {
+		return nw, err
 	}
 
 	// final flush
@@ -102,7 +64,7 @@ func compileBuilder(in io.Reader, codec *dnsdata.Codec, destPath string, opts Co
 		return nw, fmt.Errorf("building database failed: %w", err)
 	}
 
-	return nw, g.Wait()
+	return nw, nil
 }
 
 func compileBatches(in io.Reader, codec *dnsdata.Codec, destPath string, opts CompilationOptions) (int, error) {
@@ -141,7 +103,7 @@ func compileBatches(in io.Reader, codec *dnsdata.Codec, destPath string, opts Co
 	counter := 0
 	nw := 0
 
-	store := func(data []dnsdata.MapRecord) {
+	store := func(data []dnsdata.MapRecord) error {
 		for _, m := range data {
 			rdbBatch.Add(m.Key, m.Value)
 
@@ -163,17 +125,12 @@ func compileBatches(in io.Reader, codec *dnsdata.Codec, destPath string, opts Co
 				rdbBatch = db.CreateBatch()
 			}
 		}
+		return nil
 	}
 
-	// will be closed by ParseParallelStream
-	resultsChan := make(chan []dnsdata.MapRecord, opts.NumCPU)
-
 	g.Go(func() error {
-		return dnsdata.ParseStream(in, codec, resultsChan, opts.NumCPU)
+		return dnsdata.CompileStream(in, codec, opts.NumCPU, store)
 	})
-	for v := range resultsChan {
-		store(v)
-	}
 
 	// final flush
 	if !rdbBatch.IsEmpty() {
@@ -189,8 +146,7 @@ func compileBatches(in io.Reader, codec *dnsdata.Codec, destPath string, opts Co
 // Compile data from io.Reader into RDB database at destPath.
 // useHardlinks allows to use hardlinks in Builder mode. Not supported by fbcode filesystem.
 func Compile(in io.Reader, serial uint32, destPath string, opts CompilationOptions) (int, error) {
-	codec := initCodec(serial)
-	codec.Features.UseV2Keys = opts.UseV2KeySyntax
+	codec := dnsdata.NewKVCodec(serial, opts.UseV2KeySyntax)
 
 	if opts.UseBuilder {
 		return compileBuilder(in, codec, destPath, opts)
@@ -208,24 +164,7 @@ func CompileToRDB(inputFileName, destPath string, o CompilationOptions) (int, er
 // useHardlinks allows to use hardlinks in Builder mode. Not supported by fbcode filesystem.
 // useV2KeySyntax specifies whether v2 keys syntax should be used
 func CompileToSpecificRDBVersion(inputFileName, destPath string, o CompilationOptions) (int, error) {
-	// Open infile for read
-	ifile, err := os.Open(inputFileName)
-	if err != nil {
-		return 0, fmt.Errorf("error opening input file %s: %w", inputFileName, err)
-	}
-	defer ifile.Close()
-	serial, err := dnsdata.DeriveSerial(ifile)
-	if err != nil {
-		return 0, fmt.Errorf("error accessing input file %s: %w", inputFileName, err)
-	}
-	return Compile(ifile, serial, destPath, o)
-}
-
-func initCodec(serial uint32) *dnsdata.Codec {
-	codec := new(dnsdata.Codec)
-	codec.Serial = serial
-	codec.Acc.Ranger.Enable()
-	codec.Acc.NoPrefixSets = true
-	codec.NoRnetOutput = true
-	return codec
+	return dnsdata.CompileFile(inputFileName, func(in io.Reader, serial uint32) (int, error) {
+		return Compile(in, serial, destPath, o)
+	})
 }
@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rdb
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// DefaultBatchSize is the default allocation for Batch
+const DefaultBatchSize = 100000
+
+// DefaultBatchNum is the default number of Batches to allocate
+const DefaultBatchNum = 10
+
+// CleanRDBDir removes all files from the directory - for instance, to clean up output directory before compilation
+func CleanRDBDir(rdbDir string) error {
+	dir, err := os.Open(rdbDir)
+	if err != nil {
+		return fmt.Errorf("error opening directory %s: %w", rdbDir, err)
+	}
+	var files []string
+	files, err = dir.Readdirnames(0)
+	if err != nil {
+		return fmt.Errorf("error reading directory %s: %w", rdbDir, err)
+	}
+	for _, fileName := range files {
+		fullName := path.Join(rdbDir, fileName)
+		if err = os.Remove(fullName); err != nil {
+			return fmt.Errorf("error removing file %s: %w", fullName, err)
+		}
+	}
+	return nil
+}
+
+// CompilationOptions allows us to provide options to RDB compiler
+type CompilationOptions struct {
+	NumCPU         int  // Parser and builder parallelism
+	UseV2KeySyntax bool // specifies whether v2 keys syntax should be used
+	// builder-related settings
+	UseBuilder          bool // if we use RDB builder (mem hungry, fastest) or not
+	BuilderUseHardlinks bool // if RDB builder can use hardlinks instead of copying sst files
+	// batch-related settings
+	BatchNumParallel int // When not using builder, how many batches can we backlog while parsing, affects mem consumption
+	BatchSize        int // When not using builder, ize of RDB batches
+}
@@ -0,0 +1,127 @@
+//go:build cgo
+
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// ZoneSerial returns the serial of the default location SOA record of a
+// zone, given as a packed, lower case domain name.
+func (rdb *RDB) ZoneSerial(zone []byte) (serial uint32, found bool, err error) {
+	return rdb.zoneSerial(reverseName(zone), NewContext())
+}
+
+// ZoneRecords returns the default location records of the names of a zone,
+// given as a packed, lower case domain name, as stored in a DB with V2 keys.
+// Names at or below a zone cut, i.e. a name below the apex with its own SOA
+// record, belong to a child zone and are left out.
+func (rdb *RDB) ZoneRecords(zone []byte) ([]dnsdata.MapRecord, error) {
+	markerLen := len(dnsdata.ResourceRecordsKeyMarker)
+	// Keys of the names at or below the zone all start with its reversed
+	// name, minus the terminating empty label.
+	apex := reverseName(zone)
+	prefix := append([]byte(dnsdata.ResourceRecordsKeyMarker), apex...)
+	prefix = prefix[:len(prefix)-1]
+	// Label lengths are at most 63, so this sorts after any key of the zone.
+	key := append(bytes.Clone(prefix), 0xff)
+
+	context := NewContext()
+	var (
+		records []dnsdata.MapRecord
+		// reversed names of the child zones, minus the terminating empty label
+		cuts [][]byte
+	)
+	for {
+		k, data, err := rdb.FindClosest(key, context)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasPrefix(k, prefix) {
+			return withoutChildZones(records, cuts), nil
+		}
+		rname, ok := rrKeyName(k)
+		if !ok {
+			return nil, fmt.Errorf("malformed resource record key %v", k)
+		}
+		nameEnd := markerLen + len(rname)
+		// Keys are the reversed name followed by the location, so the name
+		// alone sorts right before all the keys of this name.
+		key = bytes.Clone(k[:nameEnd])
+		if !bytes.Equal(k[nameEnd:], []byte{0, 0}) {
+			continue
+		}
+		for {
+			var v []byte
+			v, data, err = ReadNextChunk(data)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := soaSerial(v); ok && !bytes.Equal(rname, apex) {
+				cuts = append(cuts, bytes.Clone(rname[:len(rname)-1]))
+			}
+			records = append(records, dnsdata.MapRecord{Key: bytes.Clone(k), Value: bytes.Clone(v)})
+		}
+	}
+}
+
+// withoutChildZones filters out the records of the names at or below the
+// zone cuts. Reversed names are prefixed by the ones of their parents.
+func withoutChildZones(records []dnsdata.MapRecord, cuts [][]byte) []dnsdata.MapRecord {
+	if len(cuts) == 0 {
+		return records
+	}
+	filtered := records[:0]
+	for _, r := range records {
+		name := r.Key[len(dnsdata.ResourceRecordsKeyMarker):]
+		child := false
+		for _, cut := range cuts {
+			if bytes.HasPrefix(name, cut) {
+				child = true
+				break
+			}
+		}
+		if !child {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered
+}
+
+// NameRecords returns the default location records stored under a name,
+// given as a packed, lower case domain name, in a DB with V2 keys. The records
+// of the wildcard below the name are stored along with them.
+func (rdb *RDB) NameRecords(name []byte) ([]dnsdata.MapRecord, error) {
+	key := append([]byte(dnsdata.ResourceRecordsKeyMarker), reverseName(name)...)
+	key = append(key, 0, 0)
+	var records []dnsdata.MapRecord
+	err := rdb.ForEach(key, func(v []byte) error {
+		records = append(records, dnsdata.MapRecord{Key: key, Value: bytes.Clone(v)})
+		return nil
+	}, NewContext())
+	return records, err
+}
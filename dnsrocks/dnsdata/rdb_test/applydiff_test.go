@@ -1,3 +1,5 @@
+//go:build cgo
+
 /*
  * Copyright (c) Meta Platforms, Inc. and affiliates.
  *
@@ -17,11 +19,18 @@
 package rdb
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/facebook/dns/dnsrocks/dnsdata"
 	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
 	"github.com/facebook/dns/dnsrocks/testaid"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestMain(m *testing.M) {
@@ -141,3 +150,144 @@ func testApplyDiff(t *testing.T, baseDB testaid.TestDB, key1, key2, key3 []byte)
 		}
 	})
 }
+
+func TestApplyDiffHistory(t *testing.T) {
+	db, err := rdb.NewUpdater(testaid.TestRDBV2.Path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	diffs := []string{`
+-Zexample.org,a.ns.example.org,dns.example.org,123,7200,1800,604800,120,120,,
++Zexample.org,a.ns.example.org,dns.example.org,124,7200,1800,604800,120,120,,
+++history.example.org,192.0.2.1,3600,,
+++history.new,192.0.2.1,3600,,
+`, `
+-Zexample.org,a.ns.example.org,dns.example.org,124,7200,1800,604800,120,120,,
++Zexample.org,a.ns.example.org,dns.example.org,125,7200,1800,604800,120,120,,
+-+history.example.org,192.0.2.1,3600,,
+`, `
+++nobump.example.org,192.0.2.1,3600,,
+`}
+	for _, diff := range diffs {
+		require.NoError(t, db.ApplyDiff(strings.NewReader(diff), 1))
+	}
+
+	zone := []byte("\007example\003org\000")
+	history := func(serial uint32) *rdb.HistoryEntry {
+		v, err := db.Find(rdb.HistoryKey(zone, serial), rdb.NewContext())
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		require.NoError(t, err)
+		e := new(rdb.HistoryEntry)
+		require.NoError(t, e.UnmarshalBinary(v))
+		return e
+	}
+
+	e := history(123)
+	require.NotNil(t, e)
+	require.Equal(t, uint32(124), e.NewSerial)
+	require.Len(t, e.Deleted, 1)
+	// history.new is out of the zone
+	require.Len(t, e.Added, 2)
+
+	e = history(124)
+	require.NotNil(t, e)
+	require.Equal(t, uint32(125), e.NewSerial)
+	require.Len(t, e.Deleted, 2)
+	require.Len(t, e.Added, 1)
+
+	// the last diff did not bump the serial
+	require.Nil(t, history(125))
+}
+
+func TestHistoryEntryMarshalBinary(t *testing.T) {
+	e := &rdb.HistoryEntry{
+		NewSerial: 42,
+		Deleted:   []dnsdata.MapRecord{{Key: []byte("k1"), Value: []byte("v1")}},
+		Added:     []dnsdata.MapRecord{{Key: []byte("k2"), Value: []byte{}}, {Key: []byte("k3"), Value: []byte("v3")}},
+	}
+	b, err := e.MarshalBinary()
+	require.NoError(t, err)
+	d := new(rdb.HistoryEntry)
+	require.NoError(t, d.UnmarshalBinary(b))
+	require.Equal(t, e, d)
+
+	for i := range len(b) {
+		require.Error(t, d.UnmarshalBinary(b[:i]))
+	}
+}
+
+// newHistoryDB compiles a DB with v2 keys holding the example.org zone
+func newHistoryDB(t *testing.T) *rdb.RDB {
+	dir := t.TempDir()
+	input := dir + "/data"
+	data := "Zexample.org,a.ns.example.org,dns.example.org,1,7200,1800,604800,120,120,,\n"
+	require.NoError(t, os.WriteFile(input, []byte(data), 0o644))
+	path := dir + "/rdb"
+	require.NoError(t, os.Mkdir(path, 0o755))
+	_, err := rdb.CompileToSpecificRDBVersion(input, path, rdb.CompilationOptions{UseV2KeySyntax: true})
+	require.NoError(t, err)
+	db, err := rdb.NewUpdater(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func zoneHistoryEntry(t *testing.T, db *rdb.RDB, zone []byte, serial uint32) *rdb.HistoryEntry {
+	v, err := db.Find(rdb.HistoryKey(zone, serial), rdb.NewContext())
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	require.NoError(t, err)
+	e := new(rdb.HistoryEntry)
+	require.NoError(t, e.UnmarshalBinary(v))
+	return e
+}
+
+func TestApplyDiffHistoryNewZone(t *testing.T) {
+	db := newHistoryDB(t)
+	diff := `
+-Zexample.org,a.ns.example.org,dns.example.org,1,7200,1800,604800,120,120,,
++Zexample.org,a.ns.example.org,dns.example.org,2,7200,1800,604800,120,120,,
+++www.example.org,192.0.2.1,3600,,
++Zsub.example.org,a.ns.example.org,dns.example.org,1,7200,1800,604800,120,120,,
+++a.sub.example.org,192.0.2.2,3600,,
+++b.sub.example.org,192.0.2.3,3600,,
+++c.sub.example.org,192.0.2.4,3600,,
+`
+	require.NoError(t, db.ApplyDiff(strings.NewReader(diff), 1))
+
+	e := zoneHistoryEntry(t, db, []byte("\007example\003org\000"), 1)
+	require.NotNil(t, e)
+	require.Equal(t, uint32(2), e.NewSerial)
+	// the SOA and www.example.org, none of the records of the new zone
+	require.Len(t, e.Added, 2)
+	require.Len(t, e.Deleted, 1)
+	require.Nil(t, zoneHistoryEntry(t, db, []byte("\003sub\007example\003org\000"), 1))
+}
+
+func TestApplyDiffHistoryLimit(t *testing.T) {
+	defer func(n int) { rdb.MaxZoneHistory = n }(rdb.MaxZoneHistory)
+	rdb.MaxZoneHistory = 3
+
+	db := newHistoryDB(t)
+	for serial := 1; serial <= 5; serial++ {
+		diff := fmt.Sprintf(`
+-Zexample.org,a.ns.example.org,dns.example.org,%d,7200,1800,604800,120,120,,
++Zexample.org,a.ns.example.org,dns.example.org,%d,7200,1800,604800,120,120,,
+`, serial, serial+1)
+		require.NoError(t, db.ApplyDiff(strings.NewReader(diff), 1))
+	}
+
+	zone := []byte("\007example\003org\000")
+	for serial := uint32(1); serial <= 5; serial++ {
+		e := zoneHistoryEntry(t, db, zone, serial)
+		if serial <= 2 {
+			require.Nil(t, e, "entry from serial %d should have been pruned", serial)
+			continue
+		}
+		require.NotNil(t, e, "entry from serial %d should have been kept", serial)
+		require.Equal(t, serial+1, e.NewSerial)
+	}
+}
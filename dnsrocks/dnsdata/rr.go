@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dnsdata
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata/quote"
+
+	"github.com/miekg/dns"
+)
+
+// rrName returns a domain name as written in data files, without its
+// trailing dot. Labels containing dots can't be written in data files.
+func rrName(name string) (string, error) {
+	labels := dns.SplitDomainName(name)
+	quoted := make([]string, 0, len(labels))
+	for _, l := range labels {
+		b := make([]byte, 256)
+		n, err := dns.PackDomainName(l+".", b, 0, nil, false)
+		if err != nil {
+			return "", err
+		}
+		if n < 2 || n != int(b[0])+2 || bytes.IndexByte(b[1:n-1], '.') >= 0 {
+			return "", fmt.Errorf("label of %q can't be written in data files", name)
+		}
+		quoted = append(quoted, string(quote.Bquote(b[1:n-1])))
+	}
+	return strings.Join(quoted, "."), nil
+}
+
+// canUseTarget returns whether a target name can be written in the native
+// records of data files, which append the owner name to single label targets.
+func canUseTarget(name string) bool {
+	return dns.CountLabel(name) > 1
+}
+
+// RRLine returns the data file line of a resource record. Records without
+// a native data file type are written as generic ':' records.
+func RRLine(rr dns.RR) (string, error) {
+	h := rr.Header()
+	if h.Class != dns.ClassINET {
+		return "", fmt.Errorf("unsupported class %d for %s", h.Class, h.Name)
+	}
+	name, err := rrName(h.Name)
+	if err != nil {
+		return "", err
+	}
+	target := func(t string) string {
+		n, e := rrName(t)
+		if e != nil {
+			err = e
+		}
+		return n
+	}
+
+	var line string
+	switch v := rr.(type) {
+	case *dns.SOA:
+		line = fmt.Sprintf("Z%s,%s,%s,%d,%d,%d,%d,%d,%d", name, target(v.Ns), target(v.Mbox), v.Serial, v.Refresh, v.Retry, v.Expire, v.Minttl, h.Ttl)
+	case *dns.A:
+		line = fmt.Sprintf("+%s,%s,%d", name, v.A, h.Ttl)
+	case *dns.AAAA:
+		if v.AAAA.To4() != nil {
+			// would be written as an A record
+			return auxLine(name, rr)
+		}
+		line = fmt.Sprintf("+%s,%s,%d", name, v.AAAA, h.Ttl)
+	case *dns.NS:
+		if !canUseTarget(v.Ns) {
+			return auxLine(name, rr)
+		}
+		line = fmt.Sprintf("&%s,,%s,%d", name, target(v.Ns), h.Ttl)
+	case *dns.CNAME:
+		line = fmt.Sprintf("C%s,%s,%d", name, target(v.Target), h.Ttl)
+	case *dns.MX:
+		if !canUseTarget(v.Mx) {
+			return auxLine(name, rr)
+		}
+		line = fmt.Sprintf("@%s,,%s,%d,%d", name, target(v.Mx), v.Preference, h.Ttl)
+	case *dns.SRV:
+		if !canUseTarget(v.Target) {
+			return auxLine(name, rr)
+		}
+		line = fmt.Sprintf("S%s,,%s,%d,%d,%d,%d", name, target(v.Target), v.Port, v.Priority, v.Weight, h.Ttl)
+	case *dns.PTR:
+		line = fmt.Sprintf("^%s,%s,%d", name, target(v.Ptr), h.Ttl)
+	default:
+		return auxLine(name, rr)
+	}
+	return line, err
+}
+
+// auxLine returns the generic data file line of a resource record, carrying
+// its rdata as is.
+func auxLine(name string, rr dns.RR) (string, error) {
+	h := rr.Header()
+	b := make([]byte, dns.Len(rr))
+	off, err := dns.PackRR(rr, b, 0, nil, false)
+	if err != nil {
+		return "", err
+	}
+	rdata := b[off-int(h.Rdlength) : off]
+	return fmt.Sprintf(":%s,%d,%s,%d", name, h.Rrtype, quote.Bquote(rdata), h.Ttl), nil
+}
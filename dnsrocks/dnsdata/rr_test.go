@@ -0,0 +1,49 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dnsdata
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRRLine(t *testing.T) {
+	testCases := map[string]string{
+		"example.org. 60 IN A 192.0.2.1":                                      "+example.org,192.0.2.1,60",
+		"*.example.org. 60 IN AAAA 2001:db8::1":                               "+*.example.org,2001:db8::1,60",
+		"example.org. 60 IN NS ns.example.net.":                               "&example.org,,ns.example.net,60",
+		"example.org. 60 IN MX 10 mx.example.net.":                            "@example.org,,mx.example.net,10,60",
+		"example.org. 60 IN SOA ns.example.net. a\\.b.example.org. 1 2 3 4 5": "",
+		"a\\,b.example.org. 60 IN CNAME example.net.":                         "Ca\\054b.example.org,example.net,60",
+		"example.org. 60 IN MX 0 .":                                           ":example.org,15,\\x00\\x00\\x00,60",
+	}
+	for r, want := range testCases {
+		t.Run(r, func(t *testing.T) {
+			rr, err := dns.NewRR(r)
+			require.NoError(t, err)
+			line, err := RRLine(rr)
+			if want == "" {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, want, line)
+		})
+	}
+}
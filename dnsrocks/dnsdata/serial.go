@@ -29,3 +29,9 @@ func DeriveSerial(f *os.File) (uint32, error) {
 	serial := uint32(inmtime)
 	return serial, nil
 }
+
+// SerialNewerOrEqual compares SOA serials using serial number arithmetic, see
+// RFC 1982.
+func SerialNewerOrEqual(a, b uint32) bool {
+	return int32(a-b) >= 0
+}
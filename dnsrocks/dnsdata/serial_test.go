@@ -35,3 +35,21 @@ func TestDeriveSerial(t *testing.T) {
 		t.Error("expected non-zero serial, got 0")
 	}
 }
+
+func TestSerialNewerOrEqual(t *testing.T) {
+	testCases := []struct {
+		a, b uint32
+		want bool
+	}{
+		{a: 1, b: 1, want: true},
+		{a: 2, b: 1, want: true},
+		{a: 1, b: 2, want: false},
+		{a: 1, b: 0xffffffff, want: true},
+		{a: 0xffffffff, b: 1, want: false},
+	}
+	for _, tc := range testCases {
+		if got := SerialNewerOrEqual(tc.a, tc.b); got != tc.want {
+			t.Errorf("SerialNewerOrEqual(%d, %d) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
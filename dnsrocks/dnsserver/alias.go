@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dnsserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/facebook/dns/dnsrocks/db"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+var (
+	// errAliasCycle is returned when ALIAS targets lead back to a name already
+	// visited.
+	errAliasCycle = errors.New("ALIAS cycle")
+	// errAliasTarget is returned when an ALIAS target is not served by the DB.
+	errAliasTarget = errors.New("ALIAS target not in DB")
+)
+
+// flattenAlias replaces the ALIAS record answered for the query, among the
+// answer records from start on, with the records of its target of the query
+// type, looked up in the same DB and location. ALIAS records found at the
+// target are followed. The records are answered as owned by the query name,
+// with the minimum TTL of the chain. Records of the query type found along the
+// ALIAS take precedence over it. It returns whether the records were picked by
+// weighted random sample, which is seeded with seed.
+func (h *FBDNSDB) flattenAlias(reader db.Reader, state request.Request, locID db.ID, maxAns int, a *dns.Msg, start int, seed uint64) (bool, error) {
+	target, ttl, answer := splitAlias(a.Answer[start:])
+	if target == "" {
+		return false, nil
+	}
+	a.Answer = a.Answer[:start+len(answer)]
+	if hasType(answer, state.QType()) {
+		return false, nil
+	}
+	h.stats.IncrementCounter("DNS_alias.queries")
+
+	var (
+		packedTarget = make([]byte, 255)
+		seen         = map[string]bool{state.Name(): true}
+		hops         = 0
+	)
+	for {
+		name := strings.ToLower(target)
+		if seen[name] {
+			h.stats.IncrementCounter("DNS_alias.cycle")
+			return false, fmt.Errorf("%w: %s", errAliasCycle, target)
+		}
+		seen[name] = true
+		hops++
+
+		offset, err := dns.PackDomainName(name, packedTarget, 0, nil, false)
+		if err != nil {
+			h.stats.IncrementCounter("DNS_alias.pack_domain_fail")
+			return false, err
+		}
+		_, auth, zoneCut, err := reader.IsAuthoritative(packedTarget[:offset], locID)
+		if err != nil {
+			h.stats.IncrementCounter("DNS_alias.is_authoritative.error")
+			return false, err
+		}
+		if !auth {
+			h.stats.IncrementCounter("DNS_alias.out_of_db")
+			return false, fmt.Errorf("%w: %s", errAliasTarget, target)
+		}
+
+		m := new(dns.Msg)
+		weighted, rcode := reader.FindAnswer(packedTarget[:offset], zoneCut, target, state.QType(), locID, m, maxAns, false, seed)
+		switch rcode {
+		case dns.RcodeSuccess:
+		case dns.RcodeNameError:
+			h.stats.IncrementCounter("DNS_alias.out_of_db")
+			return false, fmt.Errorf("%w: %s", errAliasTarget, target)
+		default:
+			return false, fmt.Errorf("looking up ALIAS target %s: %s", target, dns.RcodeToString[rcode])
+		}
+
+		next, nextTTL, records := splitAlias(m.Answer)
+		if next != "" && !hasType(records, state.QType()) {
+			target = next
+			ttl = min(ttl, nextTTL)
+			continue
+		}
+		for _, rr := range records {
+			if rr.Header().Rrtype == state.QType() {
+				ttl = min(ttl, rr.Header().Ttl)
+			}
+		}
+		for _, rr := range records {
+			if rr.Header().Rrtype == state.QType() {
+				rr.Header().Name = state.QName()
+				rr.Header().Ttl = ttl
+				a.Answer = append(a.Answer, rr)
+			}
+		}
+		h.stats.AddSample("DNS_alias.hop_count", int64(hops))
+		return weighted, nil
+	}
+}
+
+// splitAlias splits the ALIAS record from the other records of rrs.
+func splitAlias(rrs []dns.RR) (target string, ttl uint32, others []dns.RR) {
+	others = rrs[:0]
+	for _, rr := range rrs {
+		if t, ok := db.AliasTarget(rr); ok {
+			if target == "" {
+				target, ttl = t, rr.Header().Ttl
+			}
+			continue
+		}
+		others = append(others, rr)
+	}
+	return target, ttl, others
+}
+
+func hasType(rrs []dns.RR, qtype uint16) bool {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == qtype {
+			return true
+		}
+	}
+	return false
+}
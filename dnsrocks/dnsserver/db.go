@@ -22,6 +22,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -44,7 +45,11 @@ type CacheConfig struct {
 
 // DBConfig contains our DNS Database configuration.
 type DBConfig struct {
-	Path           string
+	Path string
+	// Overlays are the paths of the DBs layered over the one at Path, which
+	// are consulted in order before it and reloaded on their own. See
+	// db.OpenLayered.
+	Overlays       []string
 	ControlPath    string
 	Driver         string
 	ReloadInterval int
@@ -67,6 +72,9 @@ const (
 type ReloadSignal struct {
 	Kind    ReloadType
 	Payload string
+	// Layer is the DB layer to reload, 0 for the base DB at DBConfig.Path
+	// and n for DBConfig.Overlays[n-1].
+	Layer int
 }
 
 // NewFullReloadSignal is a helper to create new ReloadSignal of kind FullReload
@@ -85,10 +93,23 @@ func NewPartialReloadSignal() *ReloadSignal {
 	}
 }
 
-// group of control files we watch for to reload DB
+// NewLayerReloadSignal is a helper to create new ReloadSignal of the given
+// kind for a DB layer, see ReloadSignal.Layer
+func NewLayerReloadSignal(kind ReloadType, layer int, newDBPath string) *ReloadSignal {
+	return &ReloadSignal{
+		Kind:    kind,
+		Payload: newDBPath,
+		Layer:   layer,
+	}
+}
+
+// group of control files we watch for to reload DB. Those of the overlays
+// are suffixed by ".<layer>", e.g. "reload.1" for the first one.
 const (
 	ControlFileFullReload    = "switchdb"
 	ControlFilePartialReload = "reload"
+	// ControlFileKeysReload triggers a reload of the DNSSEC keys
+	ControlFileKeysReload = "reloadkeys"
 )
 
 // HandlerConfig contains config used when handling a DNS request.
@@ -99,6 +120,33 @@ type HandlerConfig struct {
 	CNAMEChasing bool
 	// Controls the number of max hops we do for CNAME chasing
 	MaxCNAMEHops int
+	// Controls whether the weighted random sample is derived from the client
+	// subnet, the qname and the zone serial rather than drawn for each query,
+	// so that a client consistently gets the same weighted answers. Such
+	// answers are cached per location, for the TTL of their records.
+	StickyWRS bool
+	// ECSTrusted is a comma separated list of the subnets of the resolvers
+	// whose EDNS Client Subnet options are honored, those of all resolvers
+	// are if empty. The clients of the other resolvers are located by the
+	// resolver IP, and no ECS option is answered to them.
+	ECSTrusted string
+	// ECSOptOutZones is a comma separated list of the zones whose names are
+	// answered ignoring the ECS options.
+	ECSOptOutZones string
+	// ECSMaxIPv4PrefixLen and ECSMaxIPv6PrefixLen bound the length of the ECS
+	// source prefixes the clients are located by, 0 to not bound it.
+	ECSMaxIPv4PrefixLen int
+	ECSMaxIPv6PrefixLen int
+}
+
+// ReloadObserver is notified of the reloads of the DB, e.g. to find out which
+// zones changed.
+type ReloadObserver interface {
+	// BeforeReload is called with a reader of the DB about to be reloaded.
+	BeforeReload(r db.Reader)
+	// AfterReload is called with a reader of the DB once successfully
+	// reloaded.
+	AfterReload(r db.Reader)
 }
 
 // FBDNSDB is the DNS DB handler.
@@ -109,11 +157,18 @@ type FBDNSDB struct {
 	handlerConfig HandlerConfig
 	cacheConfig   CacheConfig
 	reloadMu      sync.RWMutex
+	observers     []ReloadObserver
 	done          chan struct{}
 	lru           *lru.Cache
+	health        *HealthState
+	ecs           *ecsPolicy
 	logger        Logger
 	stats         stats.Stats
 	Next          plugin.Handler
+
+	// generation is bumped by each reload, so that the answers cached from
+	// a reader of the previous DB are not served.
+	generation uint64
 }
 
 // NewFBDNSDBBasic initialize a new FBDNSDB. Reloading strategy is left to be set.
@@ -124,12 +179,21 @@ func NewFBDNSDBBasic(handlerConfig HandlerConfig, dbConfig DBConfig, cacheConfig
 			return
 		}
 	}
+	ecs, err := newECSPolicy(handlerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// the overlay paths are updated on reload
+	dbConfig.Overlays = append([]string(nil), dbConfig.Overlays...)
 
 	tdb := &FBDNSDB{
 		handlerConfig: handlerConfig,
 		dbConfig:      dbConfig,
 		cacheConfig:   cacheConfig,
 		lru:           lrucache,
+		health:        NewHealthState(),
+		ecs:           ecs,
 		logger:        l,
 		stats:         s,
 		done:          make(chan struct{}),
@@ -214,7 +278,9 @@ func (h *FBDNSDB) PeriodicDBReload(reloadInt int) {
 		case <-h.done:
 			return
 		case <-ticker.C:
-			h.ReloadChan <- *NewPartialReloadSignal()
+			for layer := 0; layer <= len(h.dbConfig.Overlays); layer++ {
+				h.ReloadChan <- *NewLayerReloadSignal(PartialReload, layer, "")
+			}
 		}
 	}
 }
@@ -231,8 +297,11 @@ func (h *FBDNSDB) watchDBAndReload(watcher *fsnotify.Watcher) (err error) {
 		case <-h.done:
 			return nil
 		case ev := <-watcher.Events:
-			if filterEvent(ev.Op) && path.Clean(ev.Name) == h.dbConfig.Path {
-				h.ReloadChan <- *NewPartialReloadSignal()
+			if !filterEvent(ev.Op) {
+				continue
+			}
+			if layer, ok := h.layerOfPath(path.Clean(ev.Name)); ok {
+				h.ReloadChan <- *NewLayerReloadSignal(PartialReload, layer, "")
 			}
 		}
 	}
@@ -249,10 +318,58 @@ func (h *FBDNSDB) WatchDBAndReload() error {
 	if err != nil {
 		return err
 	}
+	for _, overlay := range h.dbConfig.Overlays {
+		if err = watcher.Add(path.Dir(overlay)); err != nil {
+			return fmt.Errorf("adding %q to fsnotify watcher: %w", path.Dir(overlay), err)
+		}
+	}
 
 	return h.watchDBAndReload(watcher)
 }
 
+// layerOfPath returns the DB layer at p.
+func (h *FBDNSDB) layerOfPath(p string) (int, bool) {
+	if p == h.dbConfig.Path {
+		return 0, true
+	}
+	for i, overlay := range h.dbConfig.Overlays {
+		if p == overlay {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// layerPath returns the path of a DB layer.
+func (h *FBDNSDB) layerPath(layer int) string {
+	if layer == 0 {
+		return h.dbConfig.Path
+	}
+	return h.dbConfig.Overlays[layer-1]
+}
+
+// controlFileName returns the name of the given control file of a DB layer.
+func controlFileName(name string, layer int) string {
+	if layer == 0 {
+		return name
+	}
+	return name + "." + strconv.Itoa(layer)
+}
+
+// parseControlFileName returns the control file name and the DB layer of the
+// file name of the control directory, see controlFileName.
+func (h *FBDNSDB) parseControlFileName(name string) (string, int) {
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 {
+		return name, 0
+	}
+	layer, err := strconv.Atoi(name[i+1:])
+	if err != nil || layer < 1 || layer > len(h.dbConfig.Overlays) {
+		return name, 0
+	}
+	return name[:i], layer
+}
+
 // cleanupSignalFile removes processed signal files
 func (h *FBDNSDB) cleanupSignalFile(s ReloadSignal) error {
 	if h.dbConfig.ControlPath == "" {
@@ -261,9 +378,9 @@ func (h *FBDNSDB) cleanupSignalFile(s ReloadSignal) error {
 	p := ""
 	switch s.Kind {
 	case FullReload:
-		p = path.Join(h.dbConfig.ControlPath, ControlFileFullReload)
+		p = path.Join(h.dbConfig.ControlPath, controlFileName(ControlFileFullReload, s.Layer))
 	case PartialReload:
-		p = path.Join(h.dbConfig.ControlPath, ControlFilePartialReload)
+		p = path.Join(h.dbConfig.ControlPath, controlFileName(ControlFilePartialReload, s.Layer))
 	default:
 		return fmt.Errorf("unknown reload signal %v", s)
 	}
@@ -287,18 +404,26 @@ func (h *FBDNSDB) watchControlDirAndReload(watcher *fsnotify.Watcher) (err error
 			}
 			cp := path.Clean(ev.Name)
 			_, name := path.Split(cp)
+			name, layer := h.parseControlFileName(name)
 
 			switch name {
 			case ControlFilePartialReload:
-				glog.Infof("Found partial reload trigger file")
-				h.ReloadChan <- *NewPartialReloadSignal()
+				glog.Infof("Found partial reload trigger file for layer %d", layer)
+				h.ReloadChan <- *NewLayerReloadSignal(PartialReload, layer, "")
 			case ControlFileFullReload:
-				glog.Infof("Found full reload trigger file")
+				glog.Infof("Found full reload trigger file for layer %d", layer)
 				newPath, err := getNewDBPath(cp)
 				if err != nil {
 					return fmt.Errorf("getting new DB path: %w", err)
 				}
-				h.ReloadChan <- *NewFullReloadSignal(newPath)
+				h.ReloadChan <- *NewLayerReloadSignal(FullReload, layer, newPath)
+			case ControlFileHealth:
+				glog.Infof("Found health state file")
+				if err := h.loadHealthFile(); err != nil {
+					glog.Errorf("Failed to update health state: %v", err)
+				}
+			case ControlFileKeysReload:
+				// handled by the DNSSEC handler, which may share the control directory
 			default:
 				glog.Infof("Ignoring unknown file in control directory: %s", name)
 			}
@@ -325,21 +450,57 @@ func (h *FBDNSDB) WatchControlDirAndReload() error {
 func (h *FBDNSDB) Load() (err error) {
 	var dnsdb *db.DB
 	glog.Infof("Loading %s using %s driver", h.dbConfig.Path, h.dbConfig.Driver)
-	if dnsdb, err = db.Open(h.dbConfig.Path, h.dbConfig.Driver); err != nil {
+	if len(h.dbConfig.Overlays) > 0 {
+		glog.Infof("Layering overlays %s over it", strings.Join(h.dbConfig.Overlays, ", "))
+	}
+	if dnsdb, err = db.OpenLayered(h.dbConfig.Path, h.dbConfig.Overlays, h.dbConfig.Driver); err != nil {
 		return err
 	}
+	dnsdb.SetHealthChecker(h.health)
 	h.dnsdb = dnsdb
+	if err = h.loadHealthFile(); err != nil {
+		glog.Errorf("Failed to load health state: %v", err)
+	}
 	h.stats.IncrementCounter("DNS_db.reload")
 	h.stats.ResetCounter("DNS_db.ErrReloadTimeout")
 	return nil
 }
 
+// AddReloadObserver registers an observer of the reloads of the DB.
+func (h *FBDNSDB) AddReloadObserver(o ReloadObserver) {
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+	h.observers = append(h.observers, o)
+}
+
+// notifyObservers calls f on each observer with a reader of the current DB.
+// The caller must hold reloadMu.
+func (h *FBDNSDB) notifyObservers(f func(o ReloadObserver, r db.Reader)) {
+	if len(h.observers) == 0 {
+		return
+	}
+	r, err := db.NewReader(h.dnsdb)
+	if err != nil {
+		glog.Errorf("Failed to acquire reader for reload observers: %v", err)
+		return
+	}
+	defer r.Close()
+	for _, o := range h.observers {
+		f(o, r)
+	}
+}
+
 // Reload reload the db
 func (h *FBDNSDB) Reload(s ReloadSignal) (err error) {
 	newPath := ""
 
+	if s.Layer < 0 || s.Layer > len(h.dbConfig.Overlays) {
+		return fmt.Errorf("asked to reload DB layer %d but there are %d overlays", s.Layer, len(h.dbConfig.Overlays))
+	}
+
 	h.reloadMu.Lock()
 	defer h.reloadMu.Unlock()
+	h.notifyObservers(ReloadObserver.BeforeReload)
 
 	switch s.Kind {
 	case FullReload:
@@ -348,11 +509,11 @@ func (h *FBDNSDB) Reload(s ReloadSignal) (err error) {
 		}
 		newPath = s.Payload
 	case PartialReload:
-		newPath = h.dbConfig.Path
+		newPath = h.layerPath(s.Layer)
 	}
 
 	var newDB *db.DB
-	newDB, err = h.dnsdb.Reload(newPath, h.dbConfig.ValidationKey, h.dbConfig.ReloadTimeout)
+	newDB, err = h.dnsdb.ReloadLayer(s.Layer, newPath, h.dbConfig.ValidationKey, h.dbConfig.ReloadTimeout)
 	if err != nil {
 		if errors.Is(err, db.ErrValidationKeyNotFound) {
 			h.stats.IncrementCounter("DNS_db.ErrValidationKeyNotFound")
@@ -365,11 +526,17 @@ func (h *FBDNSDB) Reload(s ReloadSignal) (err error) {
 
 	// if we didn't timeout and reloading finished without errors
 	h.dnsdb = newDB
-	h.dbConfig.Path = newPath
+	if s.Layer == 0 {
+		h.dbConfig.Path = newPath
+	} else {
+		h.dbConfig.Overlays[s.Layer-1] = newPath
+	}
 
+	h.generation++
 	if h.cacheConfig.Enabled && h.lru != nil {
 		h.lru.Purge()
 	}
+	h.notifyObservers(ReloadObserver.AfterReload)
 
 	if err := h.cleanupSignalFile(s); err != nil {
 		return err
@@ -383,9 +550,16 @@ func (h *FBDNSDB) Reload(s ReloadSignal) (err error) {
 // providing a consistent view on the DB during a query.
 // The Reader must be `Close`d when not needed anymore.
 func (h *FBDNSDB) AcquireReader() (db.Reader, error) {
+	r, _, err := h.acquireReader()
+	return r, err
+}
+
+// acquireReader is AcquireReader, also returning the generation of the DB.
+func (h *FBDNSDB) acquireReader() (db.Reader, uint64, error) {
 	h.reloadMu.RLock()
 	defer h.reloadMu.RUnlock()
-	return db.NewReader(h.dnsdb)
+	r, err := db.NewReader(h.dnsdb)
+	return r, h.generation, err
 }
 
 // Close closes the database. It also takes care of closing the channel used
@@ -409,6 +583,30 @@ func (h *FBDNSDB) ReportBackendStats() {
 	}
 }
 
+// DBPath returns the path of the DB currently loaded.
+func (h *FBDNSDB) DBPath() string {
+	h.reloadMu.RLock()
+	defer h.reloadMu.RUnlock()
+	return h.dbConfig.Path
+}
+
+// DBDriver returns the driver the DB is loaded with.
+func (h *FBDNSDB) DBDriver() string {
+	return h.dbConfig.Driver
+}
+
+// AnsweringLayer returns the DB layer whose records of type qtype answer the
+// name q in location locID, see db.DB.AnsweringLayer, and its path.
+func (h *FBDNSDB) AnsweringLayer(q []byte, qtype uint16, locID db.ID) (int, string, error) {
+	h.reloadMu.RLock()
+	defer h.reloadMu.RUnlock()
+	layer, err := h.dnsdb.AnsweringLayer(q, qtype, locID)
+	if err != nil {
+		return 0, "", err
+	}
+	return layer, h.layerPath(layer), nil
+}
+
 // ValidateDbKey checks whether record of certain key is in db
 func (h *FBDNSDB) ValidateDbKey(dbKey []byte) error {
 	return h.dnsdb.ValidateDbKey(dbKey)
@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dnsserver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ecsPolicy tells which EDNS Client Subnet options are honored to locate the
+// clients, see HandlerConfig. A nil policy honors all of them.
+type ecsPolicy struct {
+	trusted []*net.IPNet
+	optOut  []string
+	maxV4   int
+	maxV6   int
+}
+
+// newECSPolicy parses the ECS policy of the handler config.
+func newECSPolicy(c HandlerConfig) (*ecsPolicy, error) {
+	p := &ecsPolicy{maxV4: c.ECSMaxIPv4PrefixLen, maxV6: c.ECSMaxIPv6PrefixLen}
+	if p.maxV4 < 0 || p.maxV4 > 8*net.IPv4len {
+		return nil, fmt.Errorf("invalid ECS IPv4 prefix length: %d", p.maxV4)
+	}
+	if p.maxV6 < 0 || p.maxV6 > 8*net.IPv6len {
+		return nil, fmt.Errorf("invalid ECS IPv6 prefix length: %d", p.maxV6)
+	}
+	for _, v := range strings.Split(c.ECSTrusted, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ECS trusted subnet %q: %w", v, err)
+		}
+		p.trusted = append(p.trusted, n)
+	}
+	for _, v := range strings.Split(c.ECSOptOutZones, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if _, ok := dns.IsDomainName(v); !ok {
+			return nil, fmt.Errorf("invalid ECS opt-out zone %q", v)
+		}
+		p.optOut = append(p.optOut, dns.CanonicalName(v))
+	}
+	return p, nil
+}
+
+// trusts returns whether the ECS option of a query for qname from the
+// resolver ip is honored.
+func (p *ecsPolicy) trusts(qname string, ip string) bool {
+	if p == nil {
+		return true
+	}
+	for _, zone := range p.optOut {
+		if dns.IsSubDomain(zone, strings.ToLower(qname)) {
+			return false
+		}
+	}
+	if len(p.trusted) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	for _, n := range p.trusted {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clamp returns the ECS option to locate the client with: ecs itself, or a
+// copy of it whose source prefix is shortened to the maximum length allowed.
+func (p *ecsPolicy) clamp(ecs *dns.EDNS0_SUBNET) *dns.EDNS0_SUBNET {
+	if p == nil {
+		return ecs
+	}
+	maxLen, bits := p.maxV4, 8*net.IPv4len
+	if ecs.Family == 2 {
+		maxLen, bits = p.maxV6, 8*net.IPv6len
+	}
+	if maxLen == 0 || int(ecs.SourceNetmask) <= maxLen {
+		return ecs
+	}
+	clamped := *ecs
+	clamped.SourceNetmask = uint8(maxLen)
+	clamped.Address = ecs.Address.Mask(net.CIDRMask(maxLen, bits))
+	return &clamped
+}
+
+// lookupECS returns the ECS option of the query to locate its client with,
+// see ecsPolicy, nil if there is none or it is ignored.
+func (h *FBDNSDB) lookupECS(ecs *dns.EDNS0_SUBNET, qname string, ip string) *dns.EDNS0_SUBNET {
+	if ecs == nil {
+		return nil
+	}
+	if !h.ecs.trusts(qname, ip) {
+		h.stats.IncrementCounter("DNS_ecs.ignored")
+		return nil
+	}
+	h.stats.IncrementCounter("DNS_ecs.honored")
+	lookup := h.ecs.clamp(ecs)
+	if lookup != ecs {
+		h.stats.IncrementCounter("DNS_ecs.clamped")
+	}
+	return lookup
+}
+
+// echoECS returns the ECS option answered to the query: the one of the query,
+// with the scope found for the option its client was located with, nil if
+// that was not honored.
+func echoECS(query, lookup *dns.EDNS0_SUBNET) *dns.EDNS0_SUBNET {
+	if lookup == nil || lookup == query {
+		return lookup
+	}
+	query.SourceScope = lookup.SourceScope
+	return query
+}
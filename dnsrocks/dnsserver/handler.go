@@ -18,6 +18,7 @@ package dnsserver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
@@ -51,6 +52,9 @@ var typeToStats = make(map[uint16]string)
 type cacheEntry struct {
 	expiration int64
 	response   *dns.Msg
+	// tags are the health tags the response depends on, whose health
+	// changes evict it, see UpdateHealth.
+	tags []string
 }
 
 type maxAnswerKey string
@@ -143,7 +147,7 @@ func (h *FBDNSDB) writeAndLog(state request.Request, resp *dns.Msg, ecs *dns.EDN
 	return rcode, nil
 }
 
-func (h *FBDNSDB) chaseCNAME(reader db.Reader, localState request.Request, maxAns int, a *dns.Msg, ecs *dns.EDNS0_SUBNET) ([]dns.RR, bool, error) {
+func (h *FBDNSDB) chaseCNAME(reader db.Reader, localState request.Request, maxAns int, a *dns.Msg, ecs *dns.EDNS0_SUBNET, seed uint64) ([]dns.RR, bool, error) {
 	var (
 		packedQName = make([]byte, 255)
 		// the location matching this requestor and target
@@ -200,7 +204,19 @@ func (h *FBDNSDB) chaseCNAME(reader db.Reader, localState request.Request, maxAn
 	}
 
 	answerSizeBefore := len(a.Answer)
-	weighted, _ := reader.FindAnswer(packedQName, zoneCut, localState.QName(), localState.QType(), loc.LocID, a, maxAns)
+	weighted, rcode := reader.FindAnswer(packedQName, zoneCut, localState.QName(), localState.QType(), loc.LocID, a, maxAns, localState.Do(), seed)
+	if rcode == dns.RcodeYXDomain {
+		// the name synthesized from a DNAME on the way is too long
+		a.Rcode = rcode
+	}
+
+	if localState.QType() == dns.TypeA || localState.QType() == dns.TypeAAAA {
+		aliasWeighted, err := h.flattenAlias(reader, localState, loc.LocID, maxAns, a, answerSizeBefore, seed)
+		if err != nil {
+			return nil, weighted, err
+		}
+		weighted = weighted || aliasWeighted
+	}
 
 	newRecords := a.Answer[answerSizeBefore:]
 	if len(newRecords) == 0 {
@@ -209,6 +225,22 @@ func (h *FBDNSDB) chaseCNAME(reader db.Reader, localState request.Request, maxAn
 	return newRecords, weighted, nil
 }
 
+// chasedTarget returns the target to chase from the records found for a
+// name: its CNAME, or the CNAME synthesized from a DNAME.
+func chasedTarget(rrs []dns.RR) (string, bool) {
+	switch {
+	case len(rrs) == 1:
+	case len(rrs) == 2 && rrs[0].Header().Rrtype == dns.TypeDNAME:
+		rrs = rrs[1:]
+	default:
+		return "", false
+	}
+	if cname, ok := rrs[0].(*dns.CNAME); ok {
+		return cname.Target, true
+	}
+	return "", false
+}
+
 // ServeDNSWithRCODE handles a dns query and with return the RCODE and eventual
 // error that happen during processing.
 func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
@@ -227,10 +259,15 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 		weighted = false
 		// When caching is enabled, this will hold the cache key
 		cacheKey string
+		// With sticky WRS, the seed of the weighted random sample derived from
+		// the subnet identifying the client
+		seed uint64
+		// the generation of the health state the answer is looked up under
+		healthGen = h.health.Generation()
 	)
 	h.stats.IncrementCounter("DNS_queries")
 
-	reader, err := h.AcquireReader()
+	reader, generation, err := h.acquireReader()
 	if err != nil {
 		h.stats.IncrementCounter("DNS_db.read_error")
 		// We cannot acquire a reader, most likely because the DB couldn't be loaded.
@@ -266,7 +303,9 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 
 	packedQName = packedQName[:offset]
 
-	ecs = db.FindECS(state.Req)
+	// the ECS option of the query, answered with the scope found for ecs
+	queryECS := db.FindECS(state.Req)
+	ecs = h.lookupECS(queryECS, state.Name(), state.IP())
 	if loc, err = reader.FindLocation(packedQName, ecs, state.IP()); err != nil {
 		glog.Errorf("%s: failed to find location: %v", state.Name(), err)
 		h.logger.LogFailed(state, ecs, loc, time.Since(startTime).Microseconds())
@@ -302,7 +341,9 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 	}
 
 	if h.cacheConfig.Enabled {
-		cacheKey = fmt.Sprintf("%.3d%.3d%.3d%s", loc.LocID, state.QType(), state.QClass(), state.Name())
+		// Answers to DO queries carry the stored signatures, and sticky weighted
+		// answers depend on the zone serials of the DB.
+		cacheKey = fmt.Sprintf("%.3d%.3d%.3d%t%d%s", loc.LocID, state.QType(), state.QClass(), state.Do(), generation, state.Name())
 		if v, ok := h.lru.Get(cacheKey); ok {
 			t := v.(cacheEntry).expiration
 			if t < time.Now().Unix() {
@@ -322,7 +363,7 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 					o.Hdr.Rrtype = dns.TypeOPT
 
 					if ecs != nil {
-						o.Option = append(o.Option, ecs)
+						o.Option = append(o.Option, echoECS(queryECS, ecs))
 					}
 
 					resp.Extra = append([]dns.RR{o}, resp.Extra...)
@@ -395,7 +436,19 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 			maxAns = DefaultMaxAnswer
 			// log something
 		}
-		weighted, a.Rcode = reader.FindAnswer(packedQName, zoneCut, state.QName(), state.QType(), loc.LocID, a, maxAns)
+		if h.handlerConfig.StickyWRS {
+			seed = stickySeed(clientPrefix(ecs, state.IP()), state.Name(), zoneSerial(reader, zoneCut, loc.LocID))
+		}
+		weighted, a.Rcode = reader.FindAnswer(packedQName, zoneCut, state.QName(), state.QType(), loc.LocID, a, maxAns, state.Do(), seed)
+
+		if state.QType() == dns.TypeA || state.QType() == dns.TypeAAAA {
+			aliasWeighted, err := h.flattenAlias(reader, state, loc.LocID, maxAns, a, 0, seed)
+			if err != nil {
+				glog.Errorf("Failed to resolve ALIAS for qname: %s, error: %v", state.Name(), err)
+				return dns.RcodeServerFailure, nil
+			}
+			weighted = weighted || aliasWeighted
+		}
 
 		// Don't do CNAME chasing for ECS queries (https://fburl.com/8lamzlu1) or queries
 		// of type CNAME or ANY: https://datatracker.ietf.org/doc/html/rfc1034#section-3.6.2.
@@ -407,7 +460,7 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 				maxCNAMEHops = h.handlerConfig.MaxCNAMEHops
 			)
 
-			for len(newRecords) == 1 && newRecords[0].Header().Rrtype == dns.TypeCNAME {
+			for target, ok := chasedTarget(newRecords); ok; target, ok = chasedTarget(newRecords) {
 				if iterCount == maxCNAMEHops {
 					h.stats.IncrementCounter("DNS_cname_chasing.max_hops")
 					glog.Errorf("Max hops (%d) reached for CNAME chasing for qname: %s", maxCNAMEHops, state.Name())
@@ -415,7 +468,6 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 				}
 
 				iterCount++
-				target := newRecords[0].(*dns.CNAME).Target
 
 				// CNAME cycle detection.
 				// a.Answer contains all records we've seen until now. So by comparing target
@@ -429,7 +481,10 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 				}
 
 				updatedState := state.NewWithQuestion(target, state.QType())
-				newRecords, weighted, err = h.chaseCNAME(reader, updatedState, maxAns, a, ecs)
+				// the answer stays weighted if any hop of the chain is
+				var chasedWeighted bool
+				newRecords, chasedWeighted, err = h.chaseCNAME(reader, updatedState, maxAns, a, ecs, seed)
+				weighted = weighted || chasedWeighted
 				if err != nil {
 					glog.Errorf("Failed to chase CNAME for domain: %s, target: %s, error: %v", state.Name(), target, err)
 					break
@@ -468,6 +523,17 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 		}
 	}
 
+	// Validating resolvers need proofs of the negative and wildcard answers.
+	if auth && state.Do() {
+		proven, err := db.NSEC3Proof(reader, zoneCut, state.Name(), state.QType(), loc.LocID, a)
+		if err != nil && !errors.Is(err, db.ErrNSEC3Unsupported) {
+			h.stats.IncrementCounter("DNS_dnssec.nsec3_proof.error")
+			glog.Errorf("%s: failed to find NSEC3 proof: %v", state.Name(), err)
+		} else if proven {
+			h.stats.IncrementCounter("DNS_dnssec.nsec3_proof")
+		}
+	}
+
 	// Additional section
 	weighted = db.AdditionalSectionForRecords(reader, a, loc.LocID, state.QClass(), a.Answer) || weighted
 	weighted = db.AdditionalSectionForRecords(reader, a, loc.LocID, state.QClass(), a.Ns) || weighted
@@ -478,10 +544,13 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 		if !weighted {
 			// FIXME: we can leave this in cache until it get flushed (via DB reload)
 			timeout = time.Now().Unix() + 1000
-			h.lru.Add(cacheKey, cacheEntry{expiration: timeout, response: a.Copy()})
+		} else if ttl := minTTL(a); h.handlerConfig.StickyWRS && ttl > 0 {
+			timeout = time.Now().Unix() + int64(ttl)
 		} else if h.cacheConfig.WRSTimeout > 0 {
 			timeout = time.Now().Unix() + h.cacheConfig.WRSTimeout
-			h.lru.Add(cacheKey, cacheEntry{expiration: timeout, response: a.Copy()})
+		}
+		if timeout > 0 {
+			h.cacheAnswer(cacheKey, cacheEntry{expiration: timeout, response: a.Copy(), tags: reader.HealthTags()}, healthGen)
 		}
 	}
 
@@ -491,7 +560,7 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 		o.Hdr.Rrtype = dns.TypeOPT
 
 		if ecs != nil {
-			o.Option = append(o.Option, ecs)
+			o.Option = append(o.Option, echoECS(queryECS, ecs))
 		}
 
 		a.Extra = append([]dns.RR{o}, a.Extra...)
@@ -22,6 +22,7 @@ import (
 	"net"
 	"os"
 	"path"
+	"strings"
 	"testing"
 	"time"
 
@@ -31,6 +32,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata/cdb"
 	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
 	"github.com/facebook/dns/dnsrocks/dnsserver/test"
 	"github.com/facebook/dns/dnsrocks/testaid"
@@ -1088,6 +1090,60 @@ func TestCNAMEchasing(t *testing.T) {
 			},
 			resolver: "2.2.2.2",
 		},
+		// CNAME synthesized from a DNAME is followed
+		{
+			qname:        "www.old.example.org.",
+			qtype:        dns.TypeA,
+			expectedCode: dns.RcodeSuccess,
+			expectedAnswer: []dns.RR{
+				&dns.DNAME{
+					Hdr: dns.RR_Header{
+						Name:   "old.example.org.",
+						Rrtype: dns.TypeDNAME,
+						Class:  dns.ClassINET,
+						Ttl:    3600,
+					},
+					Target: "new.example.org.",
+				},
+				&dns.CNAME{
+					Hdr: dns.RR_Header{
+						Name:   "www.old.example.org.",
+						Rrtype: dns.TypeCNAME,
+						Class:  dns.ClassINET,
+						Ttl:    3600,
+					},
+					Target: "www.new.example.org.",
+				},
+				&dns.A{
+					Hdr: dns.RR_Header{
+						Name:   "www.new.example.org.",
+						Rrtype: dns.TypeA,
+						Class:  dns.ClassINET,
+						Ttl:    180,
+					},
+					A: net.ParseIP("1.1.1.6"),
+				},
+			},
+			resolver: "1.1.1.1",
+		},
+		// name synthesized from a DNAME is too long
+		{
+			qname:        strings.Repeat("a", 63) + ".long.example.org.",
+			qtype:        dns.TypeA,
+			expectedCode: dns.RcodeYXDomain,
+			expectedAnswer: []dns.RR{
+				&dns.DNAME{
+					Hdr: dns.RR_Header{
+						Name:   "long.example.org.",
+						Rrtype: dns.TypeDNAME,
+						Class:  dns.ClassINET,
+						Ttl:    3600,
+					},
+					Target: strings.Repeat(strings.Repeat("a", 63)+".", 3) + "example.org.",
+				},
+			},
+			resolver: "1.1.1.1",
+		},
 		// Max hop count is respected
 		{
 			qname:        "one.example.com.",
@@ -2069,6 +2125,192 @@ func TestHandlerCache(t *testing.T) {
 	}
 }
 
+// TestHandlerCacheWeighted tests that weighted CNAME answers are not cached
+// without WRS timeout.
+func TestHandlerCacheWeighted(t *testing.T) {
+	ctr := stats.NewCounters()
+	th := createFBDNSDBWithCache(t, ctr)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+	for range 2 {
+		req := new(dns.Msg)
+		req.SetQuestion("weighted.example.org.", dns.TypeA)
+		rcode, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeSuccess, rcode)
+		require.Len(t, rec.Msg.Answer, 1)
+		require.Equal(t, dns.TypeCNAME, rec.Msg.Answer[0].Header().Rrtype)
+	}
+	require.Zero(t, ctr["DNS_cache.hit"])
+	require.Equal(t, int64(2), ctr["DNS_cache.missed"])
+}
+
+func TestHandlerStickyWRS(t *testing.T) {
+	ctr := stats.NewCounters()
+	th := createFBDNSDBWithCache(t, ctr)
+	th.handlerConfig.StickyWRS = true
+	th.cacheConfig.Enabled = false
+
+	targets := map[string]bool{}
+	for _, subnet := range []string{"192.0.2.0/24", "198.51.100.0/24", "203.0.113.0/24", "2001:db8::/56"} {
+		var target string
+		for i := range 10 {
+			o, err := MakeOPTWithECS(subnet)
+			require.NoError(t, err)
+			req := new(dns.Msg)
+			req.SetQuestion("weighted.example.org.", dns.TypeA)
+			req.Extra = []dns.RR{o}
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			rcode, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+			require.NoError(t, err)
+			require.Equal(t, dns.RcodeSuccess, rcode)
+			require.Len(t, rec.Msg.Answer, 1)
+			cname := rec.Msg.Answer[0].(*dns.CNAME).Target
+			if i == 0 {
+				target = cname
+			}
+			require.Equal(t, target, cname, "subnet %s", subnet)
+		}
+		targets[target] = true
+	}
+	require.NotEmpty(t, targets)
+}
+
+func TestHandlerCacheStickyWRS(t *testing.T) {
+	ctr := stats.NewCounters()
+	th := createFBDNSDBWithCache(t, ctr)
+	th.handlerConfig.StickyWRS = true
+
+	// Resolvers of distinct subnets in the same location share the cached
+	// answer.
+	for _, ip := range []string{"192.0.2.1", "198.51.100.1"} {
+		rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: ip})
+		req := new(dns.Msg)
+		req.SetQuestion("weighted.example.org.", dns.TypeA)
+		rcode, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeSuccess, rcode)
+		require.Len(t, rec.Msg.Answer, 1)
+	}
+	require.Equal(t, int64(1), ctr["DNS_cache.hit"])
+	require.Equal(t, int64(1), ctr["DNS_cache.missed"])
+
+	// The answer is cached for the TTL of its records.
+	keys := th.lru.Keys()
+	require.Len(t, keys, 1)
+	v, ok := th.lru.Get(keys[0])
+	require.True(t, ok)
+	require.InDelta(t, time.Now().Unix()+300, v.(cacheEntry).expiration, 1)
+
+	// Answers are not served from the cache of the previous DB.
+	th.generation++
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	req := new(dns.Msg)
+	req.SetQuestion("weighted.example.org.", dns.TypeA)
+	_, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), ctr["DNS_cache.missed"])
+}
+
+func TestHandlerECSPolicy(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  HandlerConfig
+		ecs     string
+		answer  string
+		echoed  string // the ECS option answered, as source/scope
+		counter string
+	}{
+		{
+			name:    "honored by default",
+			ecs:     "3.3.3.1/32",
+			answer:  "1.1.1.3",
+			echoed:  "3.3.3.1/32/32",
+			counter: "DNS_ecs.honored",
+		},
+		{
+			name:    "trusted resolver",
+			config:  HandlerConfig{ECSTrusted: "198.51.100.0/24, 192.0.2.0/24"},
+			ecs:     "3.3.3.1/32",
+			answer:  "1.1.1.3",
+			echoed:  "3.3.3.1/32/32",
+			counter: "DNS_ecs.honored",
+		},
+		{
+			name:    "untrusted resolver",
+			config:  HandlerConfig{ECSTrusted: "198.51.100.0/24"},
+			ecs:     "3.3.3.1/32",
+			answer:  "1.1.1.1",
+			counter: "DNS_ecs.ignored",
+		},
+		{
+			name:    "opted out zone",
+			config:  HandlerConfig{ECSOptOutZones: "example.net,Example.com"},
+			ecs:     "3.3.3.1/32",
+			answer:  "1.1.1.1",
+			counter: "DNS_ecs.ignored",
+		},
+		{
+			name:    "clamped source prefix",
+			config:  HandlerConfig{ECSMaxIPv4PrefixLen: 24},
+			ecs:     "3.3.3.1/32",
+			answer:  "1.1.1.4",
+			echoed:  "3.3.3.1/32/24",
+			counter: "DNS_ecs.clamped",
+		},
+		{
+			name:    "short source prefix",
+			config:  HandlerConfig{ECSMaxIPv4PrefixLen: 24, ECSMaxIPv6PrefixLen: 56},
+			ecs:     "3.3.3.0/24",
+			answer:  "1.1.1.4",
+			echoed:  "3.3.3.0/24/24",
+			counter: "DNS_ecs.honored",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctr := stats.NewCounters()
+			th := createFBDNSDBWithCache(t, ctr)
+			th.cacheConfig.Enabled = false
+			var err error
+			th.ecs, err = newECSPolicy(tc.config)
+			require.NoError(t, err)
+
+			o, err := MakeOPTWithECS(tc.ecs)
+			require.NoError(t, err)
+			req := new(dns.Msg)
+			req.SetQuestion("foo.example.com.", dns.TypeA)
+			req.Extra = []dns.RR{o}
+			rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: "192.0.2.1"})
+			rcode, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+			require.NoError(t, err)
+			require.Equal(t, dns.RcodeSuccess, rcode)
+			require.Len(t, rec.Msg.Answer, 1)
+			require.Equal(t, tc.answer, rec.Msg.Answer[0].(*dns.A).A.String())
+			require.NotZero(t, ctr[tc.counter])
+
+			var echoed string
+			if ecs := db.FindECS(rec.Msg); ecs != nil {
+				echoed = fmt.Sprintf("%s/%d/%d", ecs.Address, ecs.SourceNetmask, ecs.SourceScope)
+			}
+			require.Equal(t, tc.echoed, echoed)
+		})
+	}
+}
+
+func TestNewECSPolicyInvalid(t *testing.T) {
+	for _, c := range []HandlerConfig{
+		{ECSTrusted: "192.0.2.0"},
+		{ECSOptOutZones: "example..com"},
+		{ECSMaxIPv4PrefixLen: 33},
+		{ECSMaxIPv6PrefixLen: -1},
+	} {
+		_, err := newECSPolicy(c)
+		require.Error(t, err, "%+v", c)
+	}
+}
+
 // TestHandlerNoCache tests that we DO NOT exercise the caching path when
 // caching is disabled.
 func TestHandlerNoCache(t *testing.T) {
@@ -2105,53 +2347,66 @@ func TestHandlerNoCache(t *testing.T) {
 }
 
 func TestReloadPartial(t *testing.T) {
-	th := OpenDbForTesting(t, &testaid.TestRDB)
-	ctr := stats.NewCounters()
-	th.stats = ctr
-	th.dbConfig.ReloadTimeout = 10 * time.Second
-	signal := NewPartialReloadSignal()
-	err := th.Reload(*signal)
-	require.Nil(t, err)
-	require.NotZero(t, ctr["DNS_db.reload"])
-	require.Zero(t, ctr["DNS_db.ErrReloadTimeout"])
+	for _, config := range testaid.TestDBs {
+		t.Run(config.Driver+"/"+config.Flavour, func(t *testing.T) {
+			th := OpenDbForTesting(t, &config)
+			ctr := stats.NewCounters()
+			th.stats = ctr
+			th.dbConfig.ReloadTimeout = 10 * time.Second
+			signal := NewPartialReloadSignal()
+			err := th.Reload(*signal)
+			require.Nil(t, err)
+			require.NotZero(t, ctr["DNS_db.reload"])
+			require.Zero(t, ctr["DNS_db.ErrReloadTimeout"])
+		})
+	}
 }
 
 func TestReloadFull(t *testing.T) {
-	th := OpenDbForTesting(t, &testaid.TestRDB)
-	ctr := stats.NewCounters()
-	th.stats = ctr
-	th.dbConfig.ReloadTimeout = 10 * time.Second
-	rdbDir, err := os.MkdirTemp("", "reload-test")
-	require.Nil(t, err)
-	defer os.RemoveAll(rdbDir)
-	// copy existing rdb to new path, so we can switch to it
-	err = newCopy.Copy(
-		testaid.TestRDB.Path,
-		rdbDir,
-	)
-	require.Nil(t, err)
-	signal := NewFullReloadSignal(rdbDir)
-	err = th.Reload(*signal)
-	require.Nil(t, err)
-	require.NotZero(t, ctr["DNS_db.reload"])
-	require.Zero(t, ctr["DNS_db.ErrReloadTimeout"])
+	for _, config := range testaid.TestDBs {
+		t.Run(config.Driver+"/"+config.Flavour, func(t *testing.T) {
+			th := OpenDbForTesting(t, &config)
+			ctr := stats.NewCounters()
+			th.stats = ctr
+			th.dbConfig.ReloadTimeout = 10 * time.Second
+			dbPath := t.TempDir()
+			if config.Driver == "cdb" {
+				dbPath = path.Join(dbPath, path.Base(config.Path))
+			}
+			// copy existing db to new path, so we can switch to it
+			err := newCopy.Copy(
+				config.Path,
+				dbPath,
+			)
+			require.Nil(t, err)
+			signal := NewFullReloadSignal(dbPath)
+			err = th.Reload(*signal)
+			require.Nil(t, err)
+			require.NotZero(t, ctr["DNS_db.reload"])
+			require.Zero(t, ctr["DNS_db.ErrReloadTimeout"])
+		})
+	}
 }
 
 func TestReloadFullTimeoutRDB(t *testing.T) {
-	th := OpenDbForTesting(t, &testaid.TestRDB)
-	ctr := stats.NewCounters()
-	th.stats = ctr
-	// setting reload timeout to 0 to simulate timeout
-	th.dbConfig.ReloadTimeout = 0 * time.Millisecond
-	rdbDir, err := os.MkdirTemp("", "reload-test")
-	require.Nil(t, err)
-	defer os.RemoveAll(rdbDir)
-	// we want directory to exist
-	signal := NewFullReloadSignal(rdbDir)
-	err = th.Reload(*signal)
-	require.NotNil(t, err)
-	require.Zero(t, ctr["DNS_db.reload"])
-	require.NotZero(t, ctr["DNS_db.ErrReloadTimeout"])
+	for _, config := range testaid.TestDBs {
+		if config.Driver == "cdb" {
+			continue
+		}
+		t.Run(config.Driver+"/"+config.Flavour, func(t *testing.T) {
+			th := OpenDbForTesting(t, &config)
+			ctr := stats.NewCounters()
+			th.stats = ctr
+			// setting reload timeout to 0 to simulate timeout
+			th.dbConfig.ReloadTimeout = 0 * time.Millisecond
+			// we want directory to exist
+			signal := NewFullReloadSignal(t.TempDir())
+			err := th.Reload(*signal)
+			require.NotNil(t, err)
+			require.Zero(t, ctr["DNS_db.reload"])
+			require.NotZero(t, ctr["DNS_db.ErrReloadTimeout"])
+		})
+	}
 }
 
 func TestReloadFullTimeoutCDB(t *testing.T) {
@@ -2285,6 +2540,88 @@ func TestWatchControlDirAndReloadFull(t *testing.T) {
 	}
 }
 
+// makeOverlayCDB compiles an overlay answering overlay.example.com with ip.
+func makeOverlayCDB(t *testing.T, ip string) string {
+	dir := t.TempDir()
+	input := path.Join(dir, "data")
+	require.NoError(t, os.WriteFile(input, []byte("+overlay.example.com,"+ip+",180\n"), 0o644))
+	p := path.Join(dir, "data.cdb")
+	_, err := cdb.CreateCDB(input, p, nil)
+	require.NoError(t, err)
+	return p
+}
+
+// queryOverlay returns the addresses answered to the A query of
+// overlay.example.com.
+func queryOverlay(t *testing.T, th *FBDNSDB) []string {
+	rec, err := th.QuerySingle("A", "overlay.example.com", "127.0.0.1", "", 10)
+	require.NoError(t, err)
+	var ips []string
+	for _, rr := range rec.Msg.Answer {
+		ips = append(ips, rr.(*dns.A).A.String())
+	}
+	return ips
+}
+
+func TestReloadLayer(t *testing.T) {
+	dbConfig := DBConfig{
+		Path:          testaid.TestCDB.Path,
+		Overlays:      []string{makeOverlayCDB(t, "192.0.2.1")},
+		Driver:        testaid.TestCDB.Driver,
+		ReloadTimeout: 10 * time.Second,
+	}
+	ctr := stats.NewCounters()
+	th, err := NewFBDNSDBBasic(HandlerConfig{}, dbConfig, CacheConfig{}, &DummyLogger{}, ctr)
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	defer th.Close()
+	require.Equal(t, []string{"192.0.2.1"}, queryOverlay(t, th))
+
+	overlay := makeOverlayCDB(t, "192.0.2.2")
+	require.NoError(t, th.Reload(*NewLayerReloadSignal(FullReload, 1, overlay)))
+	require.Equal(t, int64(2), ctr["DNS_db.reload"])
+	require.Equal(t, []string{overlay}, th.dbConfig.Overlays)
+	require.Equal(t, testaid.TestCDB.Path, th.dbConfig.Path)
+	require.Equal(t, []string{"192.0.2.2"}, queryOverlay(t, th))
+
+	require.NoError(t, th.Reload(*NewLayerReloadSignal(PartialReload, 0, "")))
+	require.Equal(t, []string{"192.0.2.2"}, queryOverlay(t, th))
+
+	require.Error(t, th.Reload(*NewLayerReloadSignal(PartialReload, 2, "")))
+	// the caller's config is not updated by the reloads
+	require.NotEqual(t, overlay, dbConfig.Overlays[0])
+}
+
+func TestWatchControlDirAndReloadLayer(t *testing.T) {
+	th := OpenDbForTesting(t, &testaid.TestCDB)
+	th.dbConfig.Overlays = []string{testaid.TestCDB.Path}
+	ctlDir := t.TempDir()
+	th.dbConfig.ControlPath = ctlDir
+	watcher, err := prepareDBWatcher(th.dbConfig.ControlPath)
+	if watcher != nil {
+		defer watcher.Close()
+	}
+	require.NoError(t, err)
+	go func() {
+		err := th.watchControlDirAndReload(watcher)
+		require.NoError(t, err)
+	}()
+
+	// Simulate touch of the file
+	time.Sleep(1 * time.Millisecond)
+	emptyFile, err := os.Create(path.Join(ctlDir, ControlFilePartialReload+".1"))
+	require.Nil(t, err)
+	emptyFile.Close()
+
+	select {
+	case reload := <-th.ReloadChan:
+		expectedSignal := *NewLayerReloadSignal(PartialReload, 1, "")
+		require.Equal(t, expectedSignal, reload)
+	case <-time.After(2 * time.Second):
+		t.Errorf("Expected to receive PartialReloadSignal for layer 1 in ReloadChan, but did not")
+	}
+}
+
 func TestDNSDBMinimalNSInAuth(t *testing.T) {
 	testCases := []struct {
 		qname          string
@@ -2793,3 +3130,135 @@ func TestCompressionEnforced(t *testing.T) {
 		}
 	}
 }
+
+func TestAliasFlattening(t *testing.T) {
+	testCases := []struct {
+		qname          string
+		qtype          uint16
+		expectedCode   int
+		expectedAnswer []string
+		expectedStat   string
+	}{
+		{
+			qname:          "alias.example.org.",
+			qtype:          dns.TypeA,
+			expectedCode:   dns.RcodeSuccess,
+			expectedAnswer: []string{"alias.example.org.\t180\tIN\tA\t1.1.1.1"},
+			expectedStat:   "DNS_alias.queries",
+		},
+		// minimum TTL of the chain
+		{
+			qname:          "alias2.example.org.",
+			qtype:          dns.TypeAAAA,
+			expectedCode:   dns.RcodeSuccess,
+			expectedAnswer: []string{"alias2.example.org.\t60\tIN\tAAAA\tfd24:7859:f076:2a21::1"},
+			expectedStat:   "DNS_alias.queries",
+		},
+		// ALIAS is not answered for other types
+		{
+			qname:        "alias.example.org.",
+			qtype:        dns.TypeTXT,
+			expectedCode: dns.RcodeSuccess,
+		},
+		{
+			qname:        "alias.example.org.",
+			qtype:        dns.TypeANY,
+			expectedCode: dns.RcodeSuccess,
+		},
+		{
+			qname:        "alias.example.org.",
+			qtype:        db.TypeALIAS,
+			expectedCode: dns.RcodeSuccess,
+		},
+		{
+			qname:        "loop1.example.org.",
+			qtype:        dns.TypeA,
+			expectedCode: dns.RcodeServerFailure,
+			expectedStat: "DNS_alias.cycle",
+		},
+		{
+			qname:        "outside.example.org.",
+			qtype:        dns.TypeA,
+			expectedCode: dns.RcodeServerFailure,
+			expectedStat: "DNS_alias.out_of_db",
+		},
+	}
+	for _, db := range testaid.TestDBs {
+		th := OpenDbForTesting(t, &db)
+		defer th.Close()
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s/%s/%s", db.Driver, tc.qname, dns.TypeToString[tc.qtype]), func(t *testing.T) {
+				ctr := stats.NewCounters()
+				th.stats = ctr
+				req := new(dns.Msg)
+				req.SetQuestion(tc.qname, tc.qtype)
+				rec := dnstest.NewRecorder(&test.ResponseWriter{})
+				code, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+				require.NoError(t, err)
+				require.Equal(t, tc.expectedCode, code)
+				if tc.expectedStat != "" {
+					require.NotZero(t, ctr[tc.expectedStat])
+				}
+				if code != dns.RcodeSuccess {
+					return
+				}
+				answer := []string{}
+				for _, rr := range rec.Msg.Answer {
+					answer = append(answer, rr.String())
+				}
+				require.ElementsMatch(t, tc.expectedAnswer, answer)
+			})
+		}
+	}
+}
+
+// TestAliasFlatteningCNAMEChasing checks that the ALIAS found when chasing a
+// CNAME is flattened too.
+func TestAliasFlatteningCNAMEChasing(t *testing.T) {
+	for _, db := range testaid.TestDBs {
+		th := OpenDbForTesting(t, &db)
+		defer th.Close()
+		th.handlerConfig.CNAMEChasing = true
+		th.handlerConfig.MaxCNAMEHops = 2
+		t.Run(db.Driver, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion("cname2alias.example.org.", dns.TypeA)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			code, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+			require.NoError(t, err)
+			require.Equal(t, dns.RcodeSuccess, code)
+			answer := []string{}
+			for _, rr := range rec.Msg.Answer {
+				answer = append(answer, rr.String())
+			}
+			require.Equal(t, []string{
+				"cname2alias.example.org.\t3600\tIN\tCNAME\talias.example.org.",
+				"alias.example.org.\t180\tIN\tA\t1.1.1.1",
+			}, answer)
+		})
+	}
+}
+
+// TestAliasFlatteningWeighted checks that the located and weighted records
+// of the target are sampled for the location of the query.
+func TestAliasFlatteningWeighted(t *testing.T) {
+	for _, db := range testaid.TestDBs {
+		th := OpenDbForTesting(t, &db)
+		defer th.Close()
+		t.Run(db.Driver, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion("aliasfoo.example.org.", dns.TypeA)
+			rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: "1.1.1.1"})
+			code, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+			require.NoError(t, err)
+			require.Equal(t, dns.RcodeSuccess, code)
+			require.Len(t, rec.Msg.Answer, 1)
+			a, ok := rec.Msg.Answer[0].(*dns.A)
+			require.True(t, ok)
+			require.Equal(t, "aliasfoo.example.org.", a.Hdr.Name)
+			require.Equal(t, uint32(180), a.Hdr.Ttl)
+			// resolver 1.1.1.1 is in location 2
+			require.Equal(t, "1.1.1.2", a.A.String())
+		})
+	}
+}
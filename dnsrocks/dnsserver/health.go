@@ -0,0 +1,218 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dnsserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// ControlFileHealth is the file of the control directory holding the health
+// state, see ParseHealth. Its creation, e.g. by renaming a new version over
+// it, updates the health state.
+const ControlFileHealth = "health"
+
+// maxHealthBody is the maximum size of the health state pushed over HTTP.
+const maxHealthBody = 1 << 20
+
+// HealthState holds the health of the tags of the A/AAAA records, fed from the
+// control directory or over HTTP. Tags are healthy unless stated otherwise. It
+// implements db.HealthChecker.
+type HealthState struct {
+	mu        sync.RWMutex
+	unhealthy map[string]bool
+	// generation is bumped by each change of the health of the tags, which
+	// tells the answers looked up under an older state, see cacheAnswer.
+	generation uint64
+}
+
+// NewHealthState returns a HealthState where all tags are healthy.
+func NewHealthState() *HealthState {
+	return &HealthState{unhealthy: make(map[string]bool)}
+}
+
+// Healthy implements db.HealthChecker.
+func (s *HealthState) Healthy(tag string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.unhealthy[tag]
+}
+
+// Generation returns the generation of the health state, bumped by each
+// change of the health of the tags.
+func (s *HealthState) Generation() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.generation
+}
+
+// ifGeneration calls f, holding off the changes of the health state, if its
+// generation is still gen. It returns whether f was called.
+func (s *HealthState) ifGeneration(gen uint64, f func()) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.generation != gen {
+		return false
+	}
+	f()
+	return true
+}
+
+// Set replaces the unhealthy tags. It returns the tags whose health changed.
+func (s *HealthState) Set(unhealthy map[string]bool) map[string]struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := make(map[string]struct{})
+	for tag := range s.unhealthy {
+		if !unhealthy[tag] {
+			changed[tag] = struct{}{}
+		}
+	}
+	for tag := range unhealthy {
+		if !s.unhealthy[tag] {
+			changed[tag] = struct{}{}
+		}
+	}
+	s.unhealthy = unhealthy
+	if len(changed) > 0 {
+		s.generation++
+	}
+	return changed
+}
+
+// ParseHealth parses a health state: one tag per line followed by its health,
+// "up" or "down". Empty lines and lines starting with # are ignored. It
+// returns the unhealthy tags.
+func ParseHealth(r io.Reader) (map[string]bool, error) {
+	unhealthy := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 2 {
+			return nil, fmt.Errorf("line %d: expected <tag> <up|down>, got %q", n, line)
+		}
+		switch f[1] {
+		case "up":
+			delete(unhealthy, f[0])
+		case "down":
+			unhealthy[f[0]] = true
+		default:
+			return nil, fmt.Errorf("line %d: invalid health %q for tag %s", n, f[1], f[0])
+		}
+	}
+	return unhealthy, scanner.Err()
+}
+
+// UpdateHealth replaces the health state with the one read from r, see
+// ParseHealth, and evicts the cached answers depending on the tags whose
+// health changed.
+func (h *FBDNSDB) UpdateHealth(r io.Reader) error {
+	unhealthy, err := ParseHealth(r)
+	if err != nil {
+		h.stats.IncrementCounter("DNS_health.parse_error")
+		return err
+	}
+	changed := h.health.Set(unhealthy)
+	h.stats.IncrementCounter("DNS_health.update")
+	if len(changed) == 0 || !h.cacheConfig.Enabled || h.lru == nil {
+		return nil
+	}
+	for _, key := range h.lru.Keys() {
+		v, ok := h.lru.Peek(key)
+		if !ok {
+			continue
+		}
+		for _, tag := range v.(cacheEntry).tags {
+			if _, ok := changed[tag]; ok {
+				h.lru.Remove(key)
+				h.stats.IncrementCounter("DNS_health.cache_evicted")
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// cacheAnswer caches the answer looked up under the given generation of the
+// health state. Answers depending on health tags are dropped when the health
+// changed since, as UpdateHealth may have evicted their cached version already.
+func (h *FBDNSDB) cacheAnswer(key string, entry cacheEntry, healthGen uint64) {
+	if len(entry.tags) == 0 {
+		h.lru.Add(key, entry)
+		return
+	}
+	if !h.health.ifGeneration(healthGen, func() { h.lru.Add(key, entry) }) {
+		h.stats.IncrementCounter("DNS_health.cache_skipped")
+	}
+}
+
+// loadHealthFile updates the health state from the control directory, if the
+// health file is there.
+func (h *FBDNSDB) loadHealthFile() error {
+	if h.dbConfig.ControlPath == "" {
+		return nil
+	}
+	f, err := os.Open(path.Join(h.dbConfig.ControlPath, ControlFileHealth))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	return h.UpdateHealth(f)
+}
+
+// HealthHandler returns the HTTP handler to which the health state is pushed,
+// see ParseHealth, which replaces the current one of each of the DBs.
+func HealthHandler(dbs ...*FBDNSDB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHealthBody))
+		if err == nil {
+			for _, h := range dbs {
+				if err = h.UpdateHealth(bytes.NewReader(body)); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			glog.Errorf("Failed to update health state: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dnsserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+)
+
+func TestParseHealth(t *testing.T) {
+	unhealthy, err := ParseHealth(strings.NewReader("# backends\npool-a down\n\npool-b up\npool-c down\npool-c up\n"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"pool-a": true}, unhealthy)
+
+	_, err = ParseHealth(strings.NewReader("pool-a\n"))
+	require.Error(t, err)
+	_, err = ParseHealth(strings.NewReader("pool-a sick\n"))
+	require.Error(t, err)
+}
+
+func TestHealthStateSet(t *testing.T) {
+	s := NewHealthState()
+	require.True(t, s.Healthy("pool-a"))
+	require.True(t, s.Healthy("pool-b"))
+	gen := s.Generation()
+
+	changed := s.Set(map[string]bool{"pool-a": true})
+	require.Equal(t, map[string]struct{}{"pool-a": {}}, changed)
+	require.False(t, s.Healthy("pool-a"))
+	require.True(t, s.Healthy("pool-b"))
+	require.NotEqual(t, gen, s.Generation())
+	gen = s.Generation()
+
+	// unchanged
+	require.Empty(t, s.Set(map[string]bool{"pool-a": true}))
+	require.Equal(t, gen, s.Generation())
+
+	changed = s.Set(map[string]bool{"pool-b": true})
+	require.Equal(t, map[string]struct{}{"pool-a": {}, "pool-b": {}}, changed)
+	require.False(t, s.ifGeneration(gen, func() { t.Fatal("called under a newer generation") }))
+}
+
+// queryHealth returns the addresses answered for health.example.org.
+func queryHealth(t *testing.T, th *FBDNSDB) []string {
+	req := new(dns.Msg)
+	req.SetQuestion("health.example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rcode, err := th.ServeDNSWithRCODE(CreateTestContext(10), rec, req)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, rcode)
+	var addrs []string
+	for _, rr := range rec.Msg.Answer {
+		addrs = append(addrs, rr.(*dns.A).A.String())
+	}
+	return addrs
+}
+
+func TestHandlerHealthCacheEviction(t *testing.T) {
+	ctr := stats.NewCounters()
+	th := createFBDNSDBWithCache(t, ctr)
+
+	require.ElementsMatch(t, []string{"1.1.1.10", "1.1.1.11"}, queryHealth(t, th))
+	require.ElementsMatch(t, []string{"1.1.1.10", "1.1.1.11"}, queryHealth(t, th))
+	require.Equal(t, int64(1), ctr["DNS_cache.hit"])
+
+	// an unrelated answer stays cached
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.org.", dns.TypeA)
+	_, err := th.ServeDNSWithRCODE(CreateTestContext(10), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	require.NoError(t, err)
+
+	require.NoError(t, th.UpdateHealth(strings.NewReader("pool-a down\n")))
+	require.Equal(t, int64(1), ctr["DNS_health.cache_evicted"])
+	require.Equal(t, []string{"1.1.1.11"}, queryHealth(t, th))
+	require.Equal(t, int64(1), ctr["DNS_cache.hit"])
+
+	require.NoError(t, th.UpdateHealth(strings.NewReader("pool-a down\npool-b down\n")))
+	require.ElementsMatch(t, []string{"1.1.1.10", "1.1.1.11"}, queryHealth(t, th), "fail open")
+}
+
+func TestHandlerHealthStaleAnswerNotCached(t *testing.T) {
+	ctr := stats.NewCounters()
+	th := createFBDNSDBWithCache(t, ctr)
+
+	// an answer looked up before a health change is not cached after it
+	gen := th.health.Generation()
+	require.NoError(t, th.UpdateHealth(strings.NewReader("pool-a down\n")))
+	th.cacheAnswer("stale", cacheEntry{response: new(dns.Msg), tags: []string{"pool-a"}}, gen)
+	require.False(t, th.lru.Contains("stale"))
+	require.Equal(t, int64(1), ctr["DNS_health.cache_skipped"])
+
+	// unless it does not depend on the health
+	th.cacheAnswer("untagged", cacheEntry{response: new(dns.Msg)}, gen)
+	require.True(t, th.lru.Contains("untagged"))
+}
+
+func TestHealthControlFile(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, ControlFileHealth), []byte("pool-b down\n"), 0o644)
+	require.NoError(t, err)
+
+	ctr := stats.NewCounters()
+	th := createFBDNSDBWithCache(t, ctr)
+	th.dbConfig.ControlPath = dir
+	require.NoError(t, th.loadHealthFile())
+	require.Equal(t, []string{"1.1.1.10"}, queryHealth(t, th))
+}
+
+func TestHealthHandler(t *testing.T) {
+	ctr := stats.NewCounters()
+	th := createFBDNSDBWithCache(t, ctr)
+	other := createFBDNSDBWithCache(t, stats.NewCounters())
+	h := HealthHandler(th, other)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/", strings.NewReader("pool-a maybe\n")))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/", strings.NewReader("pool-a down\n")))
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, []string{"1.1.1.11"}, queryHealth(t, th))
+	require.Equal(t, []string{"1.1.1.11"}, queryHealth(t, other))
+}
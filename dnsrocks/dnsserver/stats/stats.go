@@ -43,3 +43,35 @@ func (s *DummyStats) IncrementCounter(_ string) {}
 
 // AddSample stub implementation
 func (s *DummyStats) AddSample(_ string, _ int64) {}
+
+// PrefixedStats collects statistics into Stats with keys prefixed by Prefix,
+// e.g. to tell apart those of several handlers sharing one collector.
+type PrefixedStats struct {
+	Stats  Stats
+	Prefix string
+}
+
+// ResetCounterTo sets the prefixed key to the value.
+func (s *PrefixedStats) ResetCounterTo(key string, value int64) {
+	s.Stats.ResetCounterTo(s.Prefix+key, value)
+}
+
+// ResetCounter resets the prefixed key to zero.
+func (s *PrefixedStats) ResetCounter(key string) {
+	s.Stats.ResetCounter(s.Prefix + key)
+}
+
+// IncrementCounterBy increments the prefixed key by the value.
+func (s *PrefixedStats) IncrementCounterBy(key string, value int64) {
+	s.Stats.IncrementCounterBy(s.Prefix+key, value)
+}
+
+// IncrementCounter increments the prefixed key by one.
+func (s *PrefixedStats) IncrementCounter(key string) {
+	s.Stats.IncrementCounter(s.Prefix + key)
+}
+
+// AddSample adds a sample to the prefixed key.
+func (s *PrefixedStats) AddSample(key string, value int64) {
+	s.Stats.AddSample(s.Prefix+key, value)
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dnsserver
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/facebook/dns/dnsrocks/db"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// stickyPrefixV4 is the length of the prefix of an IPv4 resolver address
+	// that identifies a client when there is no ECS option.
+	stickyPrefixV4 = 24
+	// stickyPrefixV6 is the length of the prefix of an IPv6 resolver address
+	// that identifies a client when there is no ECS option.
+	stickyPrefixV6 = 56
+)
+
+// clientPrefix returns the subnet identifying the client for sticky weighted
+// answers: the ECS subnet if any, the resolver prefix otherwise.
+func clientPrefix(ecs *dns.EDNS0_SUBNET, ip string) string {
+	var (
+		addr net.IP
+		ones int
+	)
+	if ecs != nil {
+		addr, ones = ecs.Address, int(ecs.SourceNetmask)
+	} else {
+		addr = net.ParseIP(ip)
+		ones = stickyPrefixV6
+		if addr.To4() != nil {
+			ones = stickyPrefixV4
+		}
+	}
+	if v4 := addr.To4(); v4 != nil {
+		addr = v4
+	}
+	if addr == nil {
+		return ""
+	}
+	ipnet := net.IPNet{IP: addr.Mask(net.CIDRMask(ones, len(addr)*8)), Mask: net.CIDRMask(ones, len(addr)*8)}
+	return ipnet.String()
+}
+
+// zoneSerial returns the serial of the SOA of the zone cut, or 0 if there is
+// none.
+func zoneSerial(reader db.Reader, zoneCut []byte, locID db.ID) uint32 {
+	m := new(dns.Msg)
+	db.FindSOA(reader, zoneCut, ".", locID, m)
+	for _, rr := range m.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial
+		}
+	}
+	return 0
+}
+
+// minTTL returns the lowest TTL of the answer and authority records of m, for
+// how long sticky weighted answers are cached.
+func minTTL(m *dns.Msg) uint32 {
+	var ttl uint32
+	for i, rr := range slices.Concat(m.Answer, m.Ns) {
+		if i == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	return ttl
+}
+
+// stickySeed returns the seed of the weighted random sample of the answers to
+// qname for the client prefix, see db.Wrs.Seed. It changes with the serial of
+// the zone, so that the clients are spread again when the zone is updated.
+func stickySeed(prefix string, qname string, serial uint32) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(prefix))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.ToLower(qname)))
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], serial)
+	h.Write(b[:])
+	// 0 means random sampling
+	return h.Sum64() | 1
+}
@@ -0,0 +1,368 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata/quote"
+	"github.com/facebook/dns/dnsrocks/dnsserver"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/logger"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+// axfrMessageSize is the size, uncompressed, above which the records of a
+// zone transfer are sent in a new message.
+const axfrMessageSize = 16 * 1024
+
+// AXFRConfig contains the config for outbound full (RFC 5936) and incremental
+// (RFC 1995) zone transfers.
+type AXFRConfig struct {
+	// AllowedSubnets are the subnets of the secondaries allowed to transfer
+	// zones. Zone transfers are disabled when empty.
+	AllowedSubnets ipNets
+	// Location is the ID of the location whose records are transferred along
+	// with the default location ones, escaped as in the data file (e.g.
+	// "\000\001"). Only the default location is transferred when empty.
+	Location string
+}
+
+// Enabled returns whether zone transfers are allowed from any subnet.
+func (c *AXFRConfig) Enabled() bool {
+	return len(c.AllowedSubnets) > 0
+}
+
+// parseLocationID converts a location, escaped as in the data file, to the
+// location ID used in DB keys.
+func parseLocationID(s string) (db.ID, error) {
+	loc, err := quote.Bunquote([]byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid location %q: %w", s, err)
+	}
+	switch {
+	case len(loc) < 2:
+		return db.ZeroID, nil
+	case len(loc) == 2:
+		return db.ID(loc), nil
+	case len(loc) > 255:
+		return nil, fmt.Errorf("location %q too long: %d>255", s, len(loc))
+	default:
+		// long IDs are prefixed with their length
+		return db.ID(append([]byte{0xff, byte(len(loc))}, loc...)), nil
+	}
+}
+
+type axfrHandler struct {
+	db      *dnsserver.FBDNSDB
+	allowed ipNets
+	locID   db.ID
+	stats   stats.Stats
+	Next    plugin.Handler
+}
+
+// newAXFRHandler initializes a new axfrHandler.
+// It serves the zones whose SOA is in the DB to the allowed subnets, over TCP
+// and DoT only, as zone transfers can span several messages. IXFR queries are
+// answered from the zone history recorded as diffs are applied.
+func newAXFRHandler(tdb *dnsserver.FBDNSDB, conf *AXFRConfig, stats stats.Stats) (*axfrHandler, error) {
+	locID, err := parseLocationID(conf.Location)
+	if err != nil {
+		return nil, err
+	}
+	return &axfrHandler{db: tdb, allowed: conf.AllowedSubnets, locID: locID, stats: stats}, nil
+}
+
+func (h *axfrHandler) isAllowed(ip net.IP) bool {
+	for _, n := range h.allowed {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// reply writes an empty response with the given rcode.
+func (h *axfrHandler) reply(state request.Request, rcode int) (int, error) {
+	m := new(dns.Msg)
+	m.SetRcode(state.Req, rcode)
+	state.SizeAndDo(m)
+	if err := state.W.WriteMsg(m); err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	return rcode, nil
+}
+
+func (h *axfrHandler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	switch r.Question[0].Qtype {
+	case dns.TypeAXFR:
+	case dns.TypeIXFR:
+		return h.serveIXFR(request.Request{W: w, Req: r})
+	default:
+		return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+	}
+	state := request.Request{W: w, Req: r}
+	h.stats.IncrementCounter("DNS_axfr.requests")
+
+	if !isStreaming(state) {
+		h.stats.IncrementCounter("DNS_axfr.refused.transport")
+		return h.reply(state, dns.RcodeRefused)
+	}
+	if !h.isAllowed(net.ParseIP(state.IP())) {
+		h.stats.IncrementCounter("DNS_axfr.refused.acl")
+		return h.reply(state, dns.RcodeRefused)
+	}
+
+	reader, zone, soa, rcode := h.findZone(state, "DNS_axfr")
+	if reader == nil {
+		return h.reply(state, rcode)
+	}
+	defer reader.Close()
+	return h.transfer(state, reader, zone, soa)
+}
+
+// isStreaming returns whether the response can span several messages, which
+// DoH and DoQ responses can't.
+func isStreaming(state request.Request) bool {
+	_, ok := logger.RequestTransport(state)
+	return !ok && state.Proto() == "tcp"
+}
+
+// findZone acquires a reader, and finds the SOA record of the zone queried,
+// counting failures under the given stats prefix. On failure, the reader is
+// nil and the rcode to reply with is returned.
+func (h *axfrHandler) findZone(state request.Request, prefix string) (db.Reader, []byte, dns.RR, int) {
+	reader, err := h.db.AcquireReader()
+	if err != nil {
+		h.stats.IncrementCounter("DNS_db.read_error")
+		glog.Errorf("Zone transfer of %s: failed to acquire reader: %v", state.Name(), err)
+		return nil, nil, nil, dns.RcodeServerFailure
+	}
+
+	zone := make([]byte, 255)
+	offset, err := dns.PackDomainName(state.Name(), zone, 0, nil, false)
+	if err != nil {
+		reader.Close()
+		h.stats.IncrementCounter("DNS_error.pack_domain_fail")
+		return nil, nil, nil, dns.RcodeFormatError
+	}
+	zone = zone[:offset]
+
+	soa := new(dns.Msg)
+	db.FindSOA(reader, zone, state.Name(), h.locID, soa)
+	if len(soa.Ns) == 0 {
+		reader.Close()
+		h.stats.IncrementCounter(prefix + ".notauth")
+		return nil, nil, nil, dns.RcodeNotAuth
+	}
+	return reader, zone, soa.Ns[0], dns.RcodeSuccess
+}
+
+// transfer streams the whole zone, in answer to an AXFR query, or an IXFR one
+// the history can't answer.
+func (h *axfrHandler) transfer(state request.Request, reader db.Reader, zone []byte, soa dns.RR) (int, error) {
+	t := newAXFRTransfer(state, reader, zone, h.locID)
+	err := t.add(soa)
+	if err == nil {
+		err = reader.ForEachZoneRecord(zone, h.locID, t.addOwner)
+	}
+	if err == nil {
+		err = t.add(soa)
+	}
+	if err == nil {
+		err = t.flush()
+	}
+	switch {
+	case errors.Is(err, db.ErrZoneWalkUnsupported) && t.sent == 0:
+		h.stats.IncrementCounter("DNS_axfr.unsupported")
+		return h.reply(state, dns.RcodeNotImplemented)
+	case err != nil:
+		h.stats.IncrementCounter("DNS_axfr.error")
+		glog.Errorf("AXFR of %s to %s failed after %d messages: %v", state.Name(), state.IP(), t.sent, err)
+		return t.abort(h)
+	}
+	h.stats.IncrementCounter("DNS_axfr.success")
+	glog.Infof("AXFR of %s to %s: %d records in %d messages", state.Name(), state.IP(), t.records, t.sent)
+	return dns.RcodeSuccess, nil
+}
+
+func (h *axfrHandler) Name() string { return "axfr" }
+
+// axfrTransfer streams the records of a zone, in as many messages as needed.
+type axfrTransfer struct {
+	state   request.Request
+	filter  *zoneFilter
+	pending []dns.RR
+	size    int
+	records int
+	sent    int
+}
+
+func newAXFRTransfer(state request.Request, reader db.Reader, zone []byte, locID db.ID) *axfrTransfer {
+	return &axfrTransfer{state: state, filter: newZoneFilter(reader, zone, locID)}
+}
+
+// addOwner adds the records of an owner name of the zone, see zoneFilter.
+func (t *axfrTransfer) addOwner(owner []byte, rrs []dns.RR) error {
+	rrs, err := t.filter.records(owner, rrs)
+	if err != nil {
+		return err
+	}
+	for _, rr := range rrs {
+		if err := t.add(rr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zoneFilter selects the records of the owner names below a zone apex which
+// are part of the zone. Below a zone cut, these are only the delegation NS and
+// DS records, and the glue: the A and AAAA records of the name servers of the
+// delegation below the cut. The records of child zones hosted in the same DB
+// are left out. The SOA record of the apex is left out as well, as it
+// delimits the transfers.
+type zoneFilter struct {
+	reader db.Reader
+	zone   []byte
+	locID  db.ID
+	// nameServers caches the name servers of the zone cuts, by cut
+	nameServers map[string]map[string]bool
+}
+
+func newZoneFilter(reader db.Reader, zone []byte, locID db.ID) *zoneFilter {
+	return &zoneFilter{reader: reader, zone: zone, locID: locID, nameServers: make(map[string]map[string]bool)}
+}
+
+// records returns the records of the packed owner name which are part of the
+// zone.
+func (z *zoneFilter) records(owner []byte, rrs []dns.RR) ([]dns.RR, error) {
+	apex := string(owner) == string(z.zone)
+	cut := z.zone
+	if !apex {
+		var err error
+		if _, _, cut, err = z.reader.IsAuthoritative(owner, z.locID); err != nil {
+			return nil, err
+		}
+	}
+	delegation := !apex && string(cut) == string(owner)
+	// cut is a suffix of owner, so a longer cut is below the zone apex
+	below := len(cut) > len(z.zone) && !delegation
+	if below {
+		nameServers, err := z.cutNameServers(cut)
+		if err != nil {
+			return nil, err
+		}
+		name, _, err := dns.UnpackDomainName(owner, 0)
+		if err != nil {
+			return nil, err
+		}
+		if !nameServers[dns.CanonicalName(name)] {
+			return nil, nil
+		}
+	}
+
+	var selected []dns.RR
+	for _, rr := range rrs {
+		rrtype := rr.Header().Rrtype
+		switch {
+		case apex && rrtype == dns.TypeSOA:
+			// sent first and last
+			continue
+		case delegation && rrtype != dns.TypeNS && rrtype != dns.TypeDS:
+			continue
+		case below && rrtype != dns.TypeA && rrtype != dns.TypeAAAA:
+			continue
+		}
+		selected = append(selected, rr)
+	}
+	return selected, nil
+}
+
+// cutNameServers returns the canonical names of the name servers of the
+// packed zone cut.
+func (z *zoneFilter) cutNameServers(cut []byte) (map[string]bool, error) {
+	if nameServers, ok := z.nameServers[string(cut)]; ok {
+		return nameServers, nil
+	}
+	nameServers := make(map[string]bool)
+	err := z.reader.ForEachResourceRecord(cut, z.locID, func(row []byte) error {
+		rec, err := db.ExtractRRFromRow(row, false)
+		if err != nil || rec.Qtype != dns.TypeNS {
+			// nolint: nilerr
+			return nil
+		}
+		target, _, err := dns.UnpackDomainName(row, rec.Offset)
+		if err != nil {
+			return err
+		}
+		nameServers[dns.CanonicalName(target)] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	z.nameServers[string(cut)] = nameServers
+	return nameServers, nil
+}
+
+// abort ends a failed transfer, with a SERVFAIL response if nothing was sent
+// yet.
+func (t *axfrTransfer) abort(h *axfrHandler) (int, error) {
+	if t.sent == 0 {
+		return h.reply(t.state, dns.RcodeServerFailure)
+	}
+	// The transfer can't be aborted cleanly once started.
+	return dns.RcodeServerFailure, nil
+}
+
+func (t *axfrTransfer) add(rr dns.RR) error {
+	t.pending = append(t.pending, rr)
+	t.size += dns.Len(rr)
+	t.records++
+	if t.size < axfrMessageSize {
+		return nil
+	}
+	return t.flush()
+}
+
+func (t *axfrTransfer) flush() error {
+	if len(t.pending) == 0 {
+		return nil
+	}
+	m := new(dns.Msg)
+	m.SetReply(t.state.Req)
+	m.Authoritative = true
+	m.Compress = true
+	m.Answer = t.pending
+	t.state.SizeAndDo(m)
+	if err := t.state.W.WriteMsg(m); err != nil {
+		return err
+	}
+	t.pending = nil
+	t.size = 0
+	t.sent++
+	return nil
+}
@@ -0,0 +1,250 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"crypto/tls"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+// transferZone requests an AXFR of zone, and returns the records received
+// until the closing SOA, or the rcode of the failed transfer.
+func transferZone(t *testing.T, network, addr, zone string) ([]dns.RR, int) {
+	c := &dns.Client{Net: network, TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	co, err := c.Dial(addr)
+	require.NoError(t, err)
+	defer co.Close()
+
+	m := new(dns.Msg)
+	m.SetAxfr(zone)
+	require.NoError(t, co.WriteMsg(m))
+
+	var rrs []dns.RR
+	for {
+		require.NoError(t, co.SetReadDeadline(time.Now().Add(2*time.Second)))
+		r, err := co.ReadMsg()
+		require.NoError(t, err)
+		require.Equal(t, m.Id, r.Id)
+		if r.Rcode != dns.RcodeSuccess {
+			return nil, r.Rcode
+		}
+		require.True(t, r.Authoritative)
+		rrs = append(rrs, r.Answer...)
+		if len(rrs) > 1 && rrs[len(rrs)-1].Header().Rrtype == dns.TypeSOA {
+			return rrs, dns.RcodeSuccess
+		}
+	}
+}
+
+// axfrTestDBs returns the test databases zones can be transferred from, the
+// ones with v2 keys.
+func axfrTestDBs() []testaid.TestDB {
+	var dbs []testaid.TestDB
+	for _, testDB := range testaid.TestDBs {
+		if testDB.Flavour == testaid.TestRDBV2.Flavour {
+			dbs = append(dbs, testDB)
+		}
+	}
+	return dbs
+}
+
+func makeAXFRTestServerConfig(t *testing.T, testDB testaid.TestDB, tls bool) ServerConfig {
+	config := makeTestServerConfig(true, tls)
+	config.DBConfig.Driver = testDB.Driver
+	config.DBConfig.Path = testDB.Path
+	require.NoError(t, config.AXFRConfig.AllowedSubnets.Set("::1/128"))
+	return config
+}
+
+func TestAXFR(t *testing.T) {
+	for _, testDB := range axfrTestDBs() {
+		t.Run(testDB.Driver, func(t *testing.T) {
+			certfile := testaid.MkTestCert(t)
+			defer os.Remove(certfile)
+			config := makeAXFRTestServerConfig(t, testDB, true)
+			config.TLSConfig.CertFile = certfile
+			config.TLSConfig.KeyFile = certfile
+			portMap, srv := makeTestServer(t, config)
+			defer srv.Shutdown()
+
+			for _, network := range []string{"tcp", "tcp-tls"} {
+				t.Run(network, func(t *testing.T) {
+					rrs, rcode := transferZone(t, network, portMap[network], "example.net.")
+					require.Equal(t, dns.RcodeSuccess, rcode)
+					require.Equal(t, dns.TypeSOA, rrs[0].Header().Rrtype)
+					require.Equal(t, rrs[0].String(), rrs[len(rrs)-1].String())
+					// SOA twice, NS, MX, the wildcard CNAME and the default location
+					// records of the other names.
+					require.Len(t, rrs, 16)
+					for _, rr := range rrs {
+						require.True(t, dns.IsSubDomain("example.net.", rr.Header().Name), rr.String())
+						require.NotEqual(t, "foo.example.net.", rr.Header().Name, "location specific record transferred")
+					}
+				})
+			}
+
+			t.Run("delegation", func(t *testing.T) {
+				rrs, rcode := transferZone(t, "tcp", portMap["tcp"], "example.com.")
+				require.Equal(t, dns.RcodeSuccess, rcode)
+				var delegation, glue int
+				for _, rr := range rrs {
+					if !strings.HasSuffix(rr.Header().Name, "nonauth.example.com.") {
+						continue
+					}
+					switch rr.Header().Rrtype {
+					case dns.TypeNS:
+						delegation++
+					case dns.TypeA, dns.TypeAAAA:
+						glue++
+					default:
+						t.Errorf("unexpected record below the zone cut: %s", rr)
+					}
+				}
+				require.Equal(t, 2, delegation)
+				require.Equal(t, 4, glue)
+			})
+
+			t.Run("not a zone", func(t *testing.T) {
+				_, rcode := transferZone(t, "tcp", portMap["tcp"], "foo.example.net.")
+				require.Equal(t, dns.RcodeNotAuth, rcode)
+			})
+
+			t.Run("udp", func(t *testing.T) {
+				m := new(dns.Msg)
+				m.SetAxfr("example.net.")
+				r, err := dns.Exchange(m, portMap["udp"])
+				require.NoError(t, err)
+				require.Equal(t, dns.RcodeRefused, r.Rcode)
+			})
+		})
+	}
+}
+
+func TestAXFRLocation(t *testing.T) {
+	for _, testDB := range axfrTestDBs() {
+		t.Run(testDB.Driver, func(t *testing.T) {
+			config := makeAXFRTestServerConfig(t, testDB, false)
+			config.AXFRConfig.Location = `\000\001`
+			portMap, srv := makeTestServer(t, config)
+			defer srv.Shutdown()
+
+			rrs, rcode := transferZone(t, "tcp", portMap["tcp"], "example.net.")
+			require.Equal(t, dns.RcodeSuccess, rcode)
+			// foo and cnamemap only have records in locations.
+			require.Len(t, rrs, 19)
+		})
+	}
+}
+
+func TestAXFRRefused(t *testing.T) {
+	for _, testDB := range axfrTestDBs() {
+		t.Run(testDB.Driver, func(t *testing.T) {
+			config := makeAXFRTestServerConfig(t, testDB, false)
+			config.AXFRConfig.AllowedSubnets = nil
+			require.NoError(t, config.AXFRConfig.AllowedSubnets.Set("192.0.2.0/24"))
+			portMap, srv := makeTestServer(t, config)
+			defer srv.Shutdown()
+
+			_, rcode := transferZone(t, "tcp", portMap["tcp"], "example.net.")
+			require.Equal(t, dns.RcodeRefused, rcode)
+		})
+	}
+}
+
+// childZoneData has a child zone of example.com in the same DB, and a
+// delegation with an address below it which is not one of its name servers.
+const childZoneData = `Zexample.com,a.ns.example.com,dns.example.com,123,7200,1800,604800,120,120,,
+&example.com,,a.ns.example.com,172800,,
++a.ns.example.com,192.0.2.1,172800,,
+Zchild.example.com,ns.child.example.com,dns.example.com,1,7200,1800,604800,120,120,,
+&child.example.com,,ns.child.example.com,172800,,
+&child.example.com,,a.ns.example.com,172800,,
++ns.child.example.com,192.0.2.53,172800,,
++www.child.example.com,192.0.2.80,3600,,
+'child.example.com,child zone,3600
+&deleg.example.com,,ns.deleg.example.com,172800,,
++ns.deleg.example.com,192.0.2.54,172800,,
++other.deleg.example.com,192.0.2.55,172800,,
+`
+
+func TestAXFRChildZone(t *testing.T) {
+	testDB := testaid.TestPDBV2
+	testDB.Path = testaid.CompileDB(t, testDB, childZoneData)
+	portMap, srv := makeTestServer(t, makeAXFRTestServerConfig(t, testDB, false))
+	defer srv.Shutdown()
+
+	transferred := func(zone string) []string {
+		rrs, rcode := transferZone(t, "tcp", portMap["tcp"], zone)
+		require.Equal(t, dns.RcodeSuccess, rcode)
+		var got []string
+		for _, rr := range rrs[1 : len(rrs)-1] {
+			got = append(got, strings.Join(strings.Fields(rr.String()), " "))
+		}
+		return got
+	}
+
+	// only the delegations and the addresses of their name servers are part
+	// of the parent zone
+	require.ElementsMatch(t, []string{
+		"example.com. 172800 IN NS a.ns.example.com.",
+		"a.ns.example.com. 172800 IN A 192.0.2.1",
+		"child.example.com. 172800 IN NS ns.child.example.com.",
+		"child.example.com. 172800 IN NS a.ns.example.com.",
+		"ns.child.example.com. 172800 IN A 192.0.2.53",
+		"deleg.example.com. 172800 IN NS ns.deleg.example.com.",
+		"ns.deleg.example.com. 172800 IN A 192.0.2.54",
+	}, transferred("example.com."))
+
+	require.ElementsMatch(t, []string{
+		"child.example.com. 172800 IN NS ns.child.example.com.",
+		"child.example.com. 172800 IN NS a.ns.example.com.",
+		"child.example.com. 3600 IN TXT \"child zone\"",
+		"ns.child.example.com. 172800 IN A 192.0.2.53",
+		"www.child.example.com. 3600 IN A 192.0.2.80",
+	}, transferred("child.example.com."))
+}
+
+func TestAXFRUnsupportedDB(t *testing.T) {
+	config := makeAXFRTestServerConfig(t, testaid.TestCDB, false)
+	portMap, srv := makeTestServer(t, config)
+	defer srv.Shutdown()
+
+	_, rcode := transferZone(t, "tcp", portMap["tcp"], "example.net.")
+	require.Equal(t, dns.RcodeNotImplemented, rcode)
+}
+
+func TestParseLocationID(t *testing.T) {
+	testCases := map[string][]byte{
+		"":          {0, 0},
+		`\000\001`:  {0, 1},
+		"other":     {0xff, 5, 'o', 't', 'h', 'e', 'r'},
+		`\001\002z`: {0xff, 3, 1, 2, 'z'},
+	}
+	for s, want := range testCases {
+		id, err := parseLocationID(s)
+		require.NoError(t, err)
+		require.Equal(t, want, []byte(id), s)
+	}
+}
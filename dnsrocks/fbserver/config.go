@@ -23,7 +23,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/facebook/dns/dnsrocks/cookie"
 	"github.com/facebook/dns/dnsrocks/dnsserver"
+	"github.com/facebook/dns/dnsrocks/notify"
+	"github.com/facebook/dns/dnsrocks/rrl"
 	"github.com/facebook/dns/dnsrocks/tlsconfig"
 
 	"github.com/golang/glog"
@@ -41,8 +44,18 @@ type ServerConfig struct {
 	TCPIdleTimeout time.Duration
 	NumCPU         int
 	MaxConcurrency int
+	RRLConfig      rrl.Config
+	CookieConfig   cookie.Config
 	ReadTimeout    time.Duration
 	TLSConfig      tlsconfig.TLSConfig
+	DoH            bool
+	DoHConfig      DoHConfig
+	DoQ            bool
+	DoQConfig      DoQConfig
+	ProxyProtocol  ProxyProtocolConfig
+	AXFRConfig     AXFRConfig
+	UpdateConfig   UpdateConfig
+	NotifyConfig   notify.Config
 	HandlerConfig  dnsserver.HandlerConfig
 	CacheConfig    dnsserver.CacheConfig
 	DBConfig       dnsserver.DBConfig
@@ -51,6 +64,25 @@ type ServerConfig struct {
 	DNSSECConfig   DNSSECConfig
 	NSID           bool
 	PrivateInfo    bool
+	// HealthAddr is the address of the HTTP endpoint to which the health state
+	// of the record health tags is pushed, disabled if empty.
+	HealthAddr string
+	// Views are the split-horizon views, each answering the queries received
+	// on its listener IPs or from its client subnets from its own DB.
+	Views []ViewConfig
+}
+
+// DoHConfig contains the config for the DNS over HTTPS listener. Certificates
+// and session ticket keys are shared with the TLS listener's TLSConfig.
+type DoHConfig struct {
+	Port int
+	Path string
+}
+
+// DoQConfig contains the config for the DNS over QUIC listener. Certificates
+// and session ticket keys are shared with the TLS listener's TLSConfig.
+type DoQConfig struct {
+	Port int
 }
 
 type ipAns map[string]int
@@ -85,8 +117,40 @@ func (ipans ipAns) Set(v string) error {
 	return nil
 }
 
+type ipNets []*net.IPNet
+
+func (nets *ipNets) String() string {
+	if nets == nil {
+		return ""
+	}
+	vals := make([]string, 0, len(*nets))
+	for _, n := range *nets {
+		vals = append(vals, n.String())
+	}
+	return strings.Join(vals, ",")
+}
+
+// Support setting ipNets with a comma separated list of subnets, or several
+// times.
+func (nets *ipNets) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return fmt.Errorf("invalid subnet %q: %w", s, err)
+		}
+		*nets = append(*nets, n)
+	}
+	return nil
+}
+
 // NewServerConfig returns a fully initialized server configuration.
 func NewServerConfig() (s ServerConfig) {
 	s.IPAns = make(ipAns)
+	s.NotifyConfig.Targets = make(notify.Targets)
+	s.DoHConfig.Path = dohDefaultPath
 	return
 }
@@ -17,11 +17,15 @@
 package fbserver
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/quic-go/quic-go"
+
 	"github.com/facebook/dns/dnsrocks/metrics"
 )
 
@@ -256,6 +260,157 @@ func (pcm *PacketConnectionMonitor) SetWriteDeadline(t time.Time) error {
 	return pcm.connection.SetWriteDeadline(t)
 }
 
+// QUICConnectionMonitor is a wrapper around `quic.Conn` which serves to log
+// per connection and per stream metrics.
+type QUICConnectionMonitor struct {
+	connection    *quic.Conn
+	transportName MonitorType
+	stats         *metrics.Stats
+	closeOnce     sync.Once
+}
+
+// NewQUICConnectionMonitor makes a new QUICConnectionMonitor from `quic.Conn`.
+// It also initializes and increments the appropriate counters for the connection.
+func NewQUICConnectionMonitor(c *quic.Conn, transportName MonitorType, s *metrics.Stats) *QUICConnectionMonitor {
+	cm := &QUICConnectionMonitor{
+		connection:    c,
+		transportName: transportName,
+		stats:         s,
+	}
+
+	if cm.stats == nil {
+		cm.stats = metrics.NewStats()
+	}
+
+	cm.stats.IncrementCounter(formatConnectionMonitorStatName(cm.transportName, "Accept", true))
+	cm.stats.IncrementCounter(formatConnectionMonitorStatName(cm.transportName, "Count", false))
+
+	return cm
+}
+
+// AcceptStream calls `quic.Conn.AcceptStream` and wraps the stream in a
+// QUICStreamMonitor.
+func (cm *QUICConnectionMonitor) AcceptStream(ctx context.Context) (*QUICStreamMonitor, error) {
+	stream, err := cm.connection.AcceptStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewQUICStreamMonitor(stream, cm.transportName, cm.stats), nil
+}
+
+// CloseWithError calls `quic.Conn.CloseWithError` and then increments
+// counters. Only the first call is accounted for.
+func (cm *QUICConnectionMonitor) CloseWithError(code quic.ApplicationErrorCode, desc string) error {
+	err := cm.connection.CloseWithError(code, desc)
+	cm.closeOnce.Do(func() {
+		cm.stats.IncrementCounterBy(formatConnectionMonitorStatName(cm.transportName, "Close", true), 1)
+		cm.stats.IncrementCounterBy(formatConnectionMonitorStatName(cm.transportName, "Count", false), -1)
+	})
+	return err
+}
+
+// LocalAddr is a passthrough for `quic.Conn.LocalAddr`
+func (cm *QUICConnectionMonitor) LocalAddr() net.Addr {
+	return cm.connection.LocalAddr()
+}
+
+// RemoteAddr is a passthrough for `quic.Conn.RemoteAddr`
+func (cm *QUICConnectionMonitor) RemoteAddr() net.Addr {
+	return cm.connection.RemoteAddr()
+}
+
+// ConnectionState returns the TLS state of the `quic.Conn`
+func (cm *QUICConnectionMonitor) ConnectionState() tls.ConnectionState {
+	return cm.connection.ConnectionState().TLS
+}
+
+// QUICStreamMonitor is a wrapper around `quic.Stream` which serves to log per
+// stream metrics.
+type QUICStreamMonitor struct {
+	stream        *quic.Stream
+	transportName MonitorType
+	stats         *metrics.Stats
+	closeOnce     sync.Once
+}
+
+// NewQUICStreamMonitor makes a new QUICStreamMonitor from `quic.Stream`.
+// It also initializes and increments the appropriate counters for the stream.
+func NewQUICStreamMonitor(s *quic.Stream, transportName MonitorType, st *metrics.Stats) *QUICStreamMonitor {
+	sm := &QUICStreamMonitor{
+		stream:        s,
+		transportName: transportName,
+		stats:         st,
+	}
+
+	if sm.stats == nil {
+		sm.stats = metrics.NewStats()
+	}
+
+	sm.stats.IncrementCounter(formatStreamMonitorStatName(sm.transportName, "Accept", true))
+	sm.stats.IncrementCounter(formatStreamMonitorStatName(sm.transportName, "Count", false))
+
+	return sm
+}
+
+// Read is a passthrough for `quic.Stream.Read`
+func (sm *QUICStreamMonitor) Read(b []byte) (int, error) {
+	return sm.stream.Read(b)
+}
+
+// Write is a passthrough for `quic.Stream.Write`
+func (sm *QUICStreamMonitor) Write(b []byte) (int, error) {
+	return sm.stream.Write(b)
+}
+
+// Close calls `quic.Stream.Close` and then increments counters. Only the first
+// call to Close or Cancel is accounted for.
+func (sm *QUICStreamMonitor) Close() error {
+	err := sm.stream.Close()
+	sm.done()
+	return err
+}
+
+// Cancel aborts both directions of the `quic.Stream` with the given error
+// code and then increments counters.
+func (sm *QUICStreamMonitor) Cancel(code quic.StreamErrorCode) {
+	sm.stream.CancelRead(code)
+	sm.stream.CancelWrite(code)
+	sm.done()
+}
+
+// Context is a passthrough for `quic.Stream.Context`
+func (sm *QUICStreamMonitor) Context() context.Context {
+	return sm.stream.Context()
+}
+
+// SetReadDeadline is a passthrough for `quic.Stream.SetReadDeadline`
+func (sm *QUICStreamMonitor) SetReadDeadline(t time.Time) error {
+	return sm.stream.SetReadDeadline(t)
+}
+
+// SetWriteDeadline is a passthrough for `quic.Stream.SetWriteDeadline`
+func (sm *QUICStreamMonitor) SetWriteDeadline(t time.Time) error {
+	return sm.stream.SetWriteDeadline(t)
+}
+
+// done increments the counters of a terminated stream.
+func (sm *QUICStreamMonitor) done() {
+	sm.closeOnce.Do(func() {
+		sm.stats.IncrementCounterBy(formatStreamMonitorStatName(sm.transportName, "Close", true), 1)
+		sm.stats.IncrementCounterBy(formatStreamMonitorStatName(sm.transportName, "Count", false), -1)
+	})
+}
+
+// formatStreamMonitorStatName formats keys for the stream stats
+func formatStreamMonitorStatName(connectionType MonitorType, statName string, call bool) string {
+	op := ""
+	if call {
+		op = "_calls"
+	}
+
+	return fmt.Sprintf("%s_stream_%s%s", connectionType, statName, op)
+}
+
 // formatConnectionMonitorStatName formats keys for the stats
 func formatConnectionMonitorStatName(connectionType MonitorType, statName string, call bool) string {
 	op := ""
@@ -17,7 +17,11 @@
 package fbserver
 
 import (
+	"context"
 	"strings"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/dnssec"
@@ -30,7 +34,14 @@ import (
 // e.g comma-separated lists.
 type DNSSECConfig struct {
 	Zones string
-	Keys  string
+	// Keys are key files or directories of key files
+	Keys string
+	// KeysReloadInterval is the interval at which the keys are reloaded, to
+	// honor their timing metadata. 0 disables periodic reloads.
+	KeysReloadInterval time.Duration
+	// ControlPath is the directory watched for the ControlFileKeysReload
+	// file, which triggers a reload of the keys.
+	ControlPath string
 }
 
 const (
@@ -116,12 +127,106 @@ func keyParse(keyfiles []string) ([]*dnssec.DNSKEY, error) {
 	return keys, nil
 }
 
-func newDNSSECHandler(srv *Server, next plugin.Handler) (dnssec.Dnssec, error) {
-	cZones := strings.Split(srv.conf.DNSSECConfig.Zones, ",")
-	cKeys := strings.Split(srv.conf.DNSSECConfig.Keys, ",")
-	zones, keys, splitkeys, ca, err := initializeZonesKeys(cZones, cKeys)
-	if err != nil {
-		return dnssec.Dnssec{}, err
+// newDNSSECHandler returns the handler signing the answers of next with the
+// keys.
+func newDNSSECHandler(srv *Server, keys *dnssecKeys, next plugin.Handler) plugin.Handler {
+	presigned := &presignedHandler{stats: srv.stats, Next: next}
+	return &rolloverHandler{keys: keys, Next: presigned}
+}
+
+// presignedHandler writes the responses carrying the stored signatures of all
+// their RRsets as is, bypassing online signing. The other responses are
+// signed online, without their stored signatures.
+type presignedHandler struct {
+	stats stats.Stats
+	Next  plugin.Handler
+}
+
+// ServeDNS implements the plugin.Handler interface.
+func (h *presignedHandler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	// Responses are only signed online for DO queries.
+	if dw, ok := w.(*dnssec.ResponseWriter); ok {
+		w = &presignedWriter{ResponseWriter: dw, stats: h.stats}
+	}
+	return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+}
+
+// Name returns the handler's name.
+func (h *presignedHandler) Name() string { return "presigned" }
+
+// presignedWriter writes the presigned responses to the writer below the
+// online signing one.
+type presignedWriter struct {
+	*dnssec.ResponseWriter
+	stats stats.Stats
+}
+
+// WriteMsg implements the dns.ResponseWriter interface.
+func (w *presignedWriter) WriteMsg(m *dns.Msg) error {
+	if presigned(m) {
+		w.stats.IncrementCounter("DNS_dnssec.presigned")
+		return w.ResponseWriter.ResponseWriter.WriteMsg(m)
+	}
+	w.stats.IncrementCounter("DNS_dnssec.online")
+	if len(m.Question) == 0 || m.Question[0].Qtype != dns.TypeRRSIG {
+		m.Answer = withoutSignatures(m.Answer)
+		m.Ns = withoutSignatures(m.Ns)
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+type rrsetKey struct {
+	name  string
+	rtype uint16
+}
+
+// presigned returns whether all the RRsets of the answer and authority
+// sections of a response carry their stored signatures. Negative and wildcard
+// answers also need their NSEC3 proof, otherwise they are signed online, as
+// are referrals.
+func presigned(m *dns.Msg) bool {
+	if !m.Authoritative {
+		return false
+	}
+	proven := false
+	for _, rr := range m.Ns {
+		proven = proven || rr.Header().Rrtype == dns.TypeNSEC3
+	}
+	if len(m.Answer) == 0 && !proven {
+		return false
+	}
+	signed := make(map[rrsetKey]bool)
+	for _, section := range [][]dns.RR{m.Answer, m.Ns} {
+		for _, rr := range section {
+			sig, ok := rr.(*dns.RRSIG)
+			if !ok {
+				continue
+			}
+			signed[rrsetKey{strings.ToLower(rr.Header().Name), sig.TypeCovered}] = true
+			if int(sig.Labels) < dns.CountLabel(sig.Hdr.Name) && !proven {
+				// synthesized from a wildcard
+				return false
+			}
+		}
+	}
+	for _, section := range [][]dns.RR{m.Answer, m.Ns} {
+		for _, rr := range section {
+			h := rr.Header()
+			if h.Rrtype != dns.TypeRRSIG && !signed[rrsetKey{strings.ToLower(h.Name), h.Rrtype}] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// withoutSignatures returns the records but the RRSIG ones.
+func withoutSignatures(rrs []dns.RR) []dns.RR {
+	kept := rrs[:0]
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != dns.TypeRRSIG {
+			kept = append(kept, rr)
+		}
 	}
-	return dnssec.New(zones, keys, splitkeys, next, ca), nil
+	return kept
 }
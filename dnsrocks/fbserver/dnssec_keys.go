@@ -0,0 +1,308 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/sign"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/dnssec"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/request"
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+const (
+	// dnskeyTTL is the TTL of the key records published at the zone apexes.
+	dnskeyTTL = 3600
+	// apexSignatureValidity is how long the signatures of the key records
+	// are valid for. Keys are reloaded well before they expire.
+	apexSignatureValidity = 8 * 24 * time.Hour
+	// apexSignatureInception allows for clock skew.
+	apexSignatureInception = 3 * time.Hour
+)
+
+// apexRRset is a key RRset published at a zone apex, along with its
+// signatures.
+type apexRRset struct {
+	rrs  []dns.RR
+	sigs []dns.RR
+}
+
+// keyState holds the keys of the signed zones in their state at the time of
+// a reload.
+type keyState struct {
+	zones []string
+	// keys are the active keys, signing online
+	keys      []*dnssec.DNSKEY
+	splitkeys bool
+	cache     *cache.Cache
+	// apex holds the key RRsets of the zones, indexed by zone and type
+	apex map[string]map[uint16]apexRRset
+}
+
+// dnssecKeys holds the DNSSEC keys of the signed zones. They are reloaded on
+// a schedule, and when the ControlFileKeysReload file is created in the
+// control directory, so that their timing metadata are honored and keys can
+// be rolled over without restarts.
+type dnssecKeys struct {
+	zones []string
+	paths []string
+	conf  *DNSSECConfig
+	stats stats.Stats
+	state atomic.Pointer[keyState]
+	// reloadMu serializes the reloads
+	reloadMu sync.Mutex
+}
+
+// newDNSSECKeys loads the keys of the DNSSEC config.
+func newDNSSECKeys(conf *DNSSECConfig, s stats.Stats) (*dnssecKeys, error) {
+	k := &dnssecKeys{
+		zones: strings.Split(conf.Zones, ","),
+		paths: strings.Split(conf.Keys, ","),
+		conf:  conf,
+		stats: s,
+	}
+	if err := k.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// reload loads the keys in their state at now. The previous keys are kept if
+// they cannot be loaded.
+func (k *dnssecKeys) reload(now time.Time) error {
+	k.reloadMu.Lock()
+	defer k.reloadMu.Unlock()
+
+	st, err := k.load(now)
+	if err != nil {
+		k.stats.IncrementCounter("DNS_dnssec.keys.reload_error")
+		return err
+	}
+	k.state.Store(st)
+	k.stats.IncrementCounter("DNS_dnssec.keys.reload")
+	k.stats.ResetCounterTo("DNS_dnssec.keys.active", int64(len(st.keys)))
+	return nil
+}
+
+func (k *dnssecKeys) load(now time.Time) (*keyState, error) {
+	names, err := sign.ExpandKeyFiles(k.paths)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := sign.ParseKeyFiles(names)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, key := range sign.ActiveKeys(keys, now) {
+		files = append(files, key.File)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no active DNSSEC key in %v", k.paths)
+	}
+	zones, active, splitkeys, ca, err := initializeZonesKeys(k.zones, files)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &keyState{
+		zones:     zones,
+		keys:      active,
+		splitkeys: splitkeys,
+		cache:     ca,
+		apex:      make(map[string]map[uint16]apexRRset),
+	}
+	for _, zone := range zones {
+		zoneKeys := sign.ZoneKeys(zone, keys)
+		if len(zoneKeys) == 0 {
+			// The keys are shared by all the zones.
+			zoneKeys = keys
+		}
+		rrsets, err := apexRRsets(zone, zoneKeys, now)
+		if err != nil {
+			return nil, err
+		}
+		st.apex[zone] = rrsets
+		for _, key := range zoneKeys {
+			glog.V(1).Infof("DNSSEC key %d of %s is %s", key.DNSKEY.KeyTag(), zone, key.Timing.State(now))
+		}
+	}
+	return st, nil
+}
+
+// apexRRsets returns the signed key RRsets published at the apex of a zone at
+// now. The DNSKEY RRset is signed by the active key signing keys, so that
+// both keys sign it during a rollover, and the other ones by the active zone
+// signing keys, if the keys are split.
+func apexRRsets(zone string, keys []*sign.Key, now time.Time) (map[uint16]apexRRset, error) {
+	apex := sign.ApexRecords(zone, keys, now, dnskeyTTL)
+
+	var ksk, zsk []*sign.Key
+	for _, key := range sign.ActiveKeys(keys, now) {
+		if key.IsKSK() {
+			ksk = append(ksk, key)
+		} else {
+			zsk = append(zsk, key)
+		}
+	}
+	all := append(append([]*sign.Key{}, ksk...), zsk...)
+	if len(ksk) == 0 {
+		ksk = all
+	}
+	if len(zsk) == 0 {
+		zsk = all
+	}
+
+	rrsets := make(map[uint16]apexRRset)
+	for _, set := range []struct {
+		qtype  uint16
+		rrs    []dns.RR
+		keys   []*sign.Key
+		always bool
+	}{
+		{qtype: dns.TypeDNSKEY, rrs: apex.DNSKEY, keys: ksk, always: true},
+		{qtype: dns.TypeCDS, rrs: apex.CDS, keys: zsk},
+		{qtype: dns.TypeCDNSKEY, rrs: apex.CDNSKEY, keys: zsk},
+	} {
+		if len(set.rrs) == 0 {
+			if set.always {
+				rrsets[set.qtype] = apexRRset{}
+			}
+			continue
+		}
+		rrset := apexRRset{rrs: set.rrs}
+		for _, key := range set.keys {
+			sig, err := key.Sign(set.rrs, zone, now.Add(-apexSignatureInception), now.Add(apexSignatureValidity))
+			if err != nil {
+				return nil, fmt.Errorf("could not sign %s %s: %w", zone, dns.TypeToString[set.qtype], err)
+			}
+			rrset.sigs = append(rrset.sigs, sig)
+		}
+		rrsets[set.qtype] = rrset
+	}
+	return rrsets, nil
+}
+
+// run reloads the keys until ctx is done.
+func (k *dnssecKeys) run(ctx context.Context) {
+	var tick <-chan time.Time
+	if k.conf.KeysReloadInterval > 0 {
+		ticker := time.NewTicker(k.conf.KeysReloadInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var events <-chan fsnotify.Event
+	if k.conf.ControlPath != "" {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			glog.Errorf("Can't setup DNSSEC keys control watcher: %v", err)
+		} else {
+			defer watcher.Close()
+			if err = watcher.Add(k.conf.ControlPath); err != nil {
+				glog.Errorf("Can't watch DNSSEC keys control directory %s: %v", k.conf.ControlPath, err)
+			} else {
+				events = watcher.Events
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case ev := <-events:
+			if ev.Op&fsnotify.Create == 0 || path.Base(ev.Name) != dnsserver.ControlFileKeysReload {
+				continue
+			}
+			glog.Infof("Found DNSSEC keys reload trigger file")
+			if err := os.RemoveAll(ev.Name); err != nil {
+				glog.Errorf("Failed to remove %s: %v", ev.Name, err)
+			}
+		}
+		if err := k.reload(time.Now()); err != nil {
+			glog.Errorf("Failed to reload DNSSEC keys, keeping the previous ones: %v", err)
+		}
+	}
+}
+
+// rolloverHandler answers the key RRsets of the zone apexes according to the
+// rollover state of the keys, and signs the other answers online with the
+// active keys.
+type rolloverHandler struct {
+	keys *dnssecKeys
+	Next plugin.Handler
+	// online signs with the keys of a state
+	online atomic.Pointer[onlineSigner]
+}
+
+type onlineSigner struct {
+	state  *keyState
+	dnssec dnssec.Dnssec
+}
+
+// ServeDNS implements the plugin.Handler interface.
+func (h *rolloverHandler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	st := h.keys.state.Load()
+	state := request.Request{W: w, Req: r}
+	if rrset, ok := st.apex[state.Name()][state.QType()]; ok {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		for _, rr := range rrset.rrs {
+			m.Answer = append(m.Answer, dns.Copy(rr))
+		}
+		if state.Do() {
+			for _, rr := range rrset.sigs {
+				m.Answer = append(m.Answer, dns.Copy(rr))
+			}
+		}
+		state.SizeAndDo(m)
+		h.keys.stats.IncrementCounter("DNS_dnssec.apex_keys")
+		if err := w.WriteMsg(m); err != nil {
+			return dns.RcodeServerFailure, err
+		}
+		return dns.RcodeSuccess, nil
+	}
+
+	s := h.online.Load()
+	if s == nil || s.state != st {
+		s = &onlineSigner{state: st, dnssec: dnssec.New(st.zones, st.keys, st.splitkeys, h.Next, st.cache)}
+		h.online.Store(s)
+	}
+	return s.dnssec.ServeDNS(ctx, w, r)
+}
+
+// Name returns the handler's name.
+func (h *rolloverHandler) Name() string { return "dnssec" }
@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKey writes a new key of example.com. with timing metadata in dir, as
+// dnssec-keygen does, and returns it.
+func writeKey(t *testing.T, dir string, flags uint16, timing string) *dns.DNSKEY {
+	k := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	privateKey, err := k.Generate(256)
+	require.NoError(t, err)
+	base := filepath.Join(dir, fmt.Sprintf("Kexample.com.+013+%05d", k.KeyTag()))
+	require.NoError(t, os.WriteFile(base+".key", []byte(k.String()+"\n"), 0o644))
+	require.NoError(t, os.WriteFile(base+".private", []byte(k.PrivateKeyString(privateKey)+timing), 0o600))
+	return k
+}
+
+func TestDNSSECKeysRollover(t *testing.T) {
+	now := time.Now().UTC()
+	ts := func(d time.Duration) string { return now.Add(d).Format("20060102150405") }
+	dir := t.TempDir()
+
+	zsk := writeKey(t, dir, dns.ZONE, "Activate: "+ts(-48*time.Hour)+"\n")
+	// pre-published ZSK
+	nextZSK := writeKey(t, dir, dns.ZONE, "Publish: "+ts(-time.Hour)+"\nActivate: "+ts(time.Hour)+"\n")
+	// KSK double-signature rollover
+	ksk := writeKey(t, dir, dns.ZONE|dns.SEP, "Activate: "+ts(-48*time.Hour)+"\nInactive: "+ts(time.Hour)+"\nDelete: "+ts(2*time.Hour)+"\n")
+	nextKSK := writeKey(t, dir, dns.ZONE|dns.SEP, "Activate: "+ts(-time.Hour)+"\n")
+	// not published yet
+	writeKey(t, dir, dns.ZONE, "Publish: "+ts(24*time.Hour)+"\nActivate: "+ts(48*time.Hour)+"\n")
+
+	conf := &DNSSECConfig{Zones: "Example.com", Keys: dir}
+	keys, err := newDNSSECKeys(conf, &stats.DummyStats{})
+	require.NoError(t, err)
+
+	backend := test.HandlerFunc(func(_ context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		m.Answer = []dns.RR{test.A("www.example.com. 300 IN A 192.0.2.1")}
+		return dns.RcodeSuccess, w.WriteMsg(m)
+	})
+	h := &rolloverHandler{keys: keys, Next: backend}
+
+	query := func(qname string, qtype uint16) *dns.Msg {
+		r := new(dns.Msg)
+		r.SetQuestion(qname, qtype)
+		r.SetEdns0(4096, true)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err := h.ServeDNS(context.Background(), rec, r)
+		require.NoError(t, err)
+		return rec.Msg
+	}
+	split := func(m *dns.Msg) (rrs []dns.RR, sigs []*dns.RRSIG) {
+		for _, rr := range m.Answer {
+			if sig, ok := rr.(*dns.RRSIG); ok {
+				sigs = append(sigs, sig)
+			} else {
+				rrs = append(rrs, rr)
+			}
+		}
+		return rrs, sigs
+	}
+	tags := func(sigs []*dns.RRSIG) []uint16 {
+		var tags []uint16
+		for _, sig := range sigs {
+			tags = append(tags, sig.KeyTag)
+		}
+		return tags
+	}
+
+	// Both KSKs sign the DNSKEY RRset, which has the pre-published ZSK.
+	rrs, sigs := split(query("example.com.", dns.TypeDNSKEY))
+	require.Len(t, rrs, 4)
+	require.ElementsMatch(t, []uint16{ksk.KeyTag(), nextKSK.KeyTag()}, tags(sigs))
+	for _, sig := range sigs {
+		k := ksk
+		if sig.KeyTag == nextKSK.KeyTag() {
+			k = nextKSK
+		}
+		require.NoError(t, sig.Verify(k, rrs))
+	}
+
+	rrs, sigs = split(query("example.com.", dns.TypeCDS))
+	require.Len(t, rrs, 2)
+	require.Equal(t, []uint16{zsk.KeyTag()}, tags(sigs))
+
+	// Only the active ZSK signs online.
+	_, sigs = split(query("www.example.com.", dns.TypeA))
+	require.Equal(t, []uint16{zsk.KeyTag()}, tags(sigs))
+
+	// Once the rollovers are done, the old KSK is removed and the new ZSK
+	// signs too.
+	require.NoError(t, keys.reload(now.Add(3*time.Hour)))
+	rrs, sigs = split(query("example.com.", dns.TypeDNSKEY))
+	require.Len(t, rrs, 3)
+	require.Equal(t, []uint16{nextKSK.KeyTag()}, tags(sigs))
+	rrs, _ = split(query("example.com.", dns.TypeCDNSKEY))
+	require.Len(t, rrs, 1)
+	_, sigs = split(query("www.example.com.", dns.TypeA))
+	require.ElementsMatch(t, []uint16{zsk.KeyTag(), nextZSK.KeyTag()}, tags(sigs))
+
+	// Failed reloads keep the previous keys.
+	conf.Keys = filepath.Join(dir, "missing")
+	keys.paths = []string{conf.Keys}
+	require.Error(t, keys.reload(now))
+	rrs, _ = split(query("example.com.", dns.TypeDNSKEY))
+	require.Len(t, rrs, 3)
+}
@@ -19,6 +19,8 @@ package fbserver
 import (
 	"github.com/facebook/dns/dnsrocks/testutils"
 
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
 
 	"testing"
@@ -60,3 +62,45 @@ func TestInitializeZonesKeys(t *testing.T) {
 	require.NotNilf(t, cache, "cache is not expected to be nil")
 	require.NotNilf(t, keys, "keys is not expected to be nil")
 }
+
+func TestPresigned(t *testing.T) {
+	a := test.A("www.example.com. 300 IN A 192.0.2.1")
+	aSig := test.RRSIG("www.example.com. 300 IN RRSIG A 13 3 300 20300101000000 20200101000000 28484 example.com. c2ln")
+	cname := test.CNAME("foo.example.com. 300 IN CNAME www.example.com.")
+	cnameSig := test.RRSIG("Foo.example.com. 300 IN RRSIG CNAME 13 3 300 20300101000000 20200101000000 28484 example.com. c2ln")
+	soa := test.SOA("example.com. 300 IN SOA ns.example.com. admin.example.com. 1 2 3 4 5")
+	soaSig := test.RRSIG("example.com. 300 IN RRSIG SOA 13 2 300 20300101000000 20200101000000 28484 example.com. c2ln")
+	nsec3, err := dns.NewRR("2vptu5timamqttgl4luu9kg21e0aor3s.example.com. 300 IN NSEC3 1 0 0 - 2VPTU5TIMAMQTTGL4LUU9KG21E0AOR3T A RRSIG")
+	require.NoError(t, err)
+	nsec3Sig := test.RRSIG("2vptu5timamqttgl4luu9kg21e0aor3s.example.com. 300 IN RRSIG NSEC3 13 3 300 20300101000000 20200101000000 28484 example.com. c2ln")
+	wildcard := test.A("foo.example.com. 300 IN A 192.0.2.1")
+	wildcardSig := test.RRSIG("foo.example.com. 300 IN RRSIG A 13 2 300 20300101000000 20200101000000 28484 example.com. c2ln")
+
+	testCases := []struct {
+		name      string
+		answer    []dns.RR
+		ns        []dns.RR
+		presigned bool
+	}{
+		{name: "signed", answer: []dns.RR{a, aSig}, presigned: true},
+		{name: "signed chain", answer: []dns.RR{cname, a, cnameSig, aSig}, presigned: true},
+		{name: "unsigned", answer: []dns.RR{a}},
+		{name: "partially signed", answer: []dns.RR{cname, a, aSig}},
+		{name: "negative", ns: []dns.RR{soa}},
+		{name: "proven negative", ns: []dns.RR{soa, soaSig, nsec3, nsec3Sig}, presigned: true},
+		{name: "unsigned proof", ns: []dns.RR{soa, soaSig, nsec3}},
+		{name: "wildcard", answer: []dns.RR{wildcard, wildcardSig}},
+		{name: "proven wildcard", answer: []dns.RR{wildcard, wildcardSig}, ns: []dns.RR{nsec3, nsec3Sig}, presigned: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := new(dns.Msg)
+			m.Authoritative = true
+			m.Answer = tc.answer
+			m.Ns = tc.ns
+			require.Equal(t, tc.presigned, presigned(m))
+		})
+	}
+
+	require.Equal(t, []dns.RR{cname, a}, withoutSignatures([]dns.RR{cname, a, cnameSig, aSig}))
+}
@@ -0,0 +1,264 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/logger"
+	"github.com/facebook/dns/dnsrocks/throttle"
+)
+
+const (
+	// dohMimeType is the media type of DNS messages carried over HTTPS.
+	dohMimeType = "application/dns-message"
+	// dohDefaultPath is the URI path DoH queries are served on by default.
+	dohDefaultPath = "/dns-query"
+	// dohQueryParam is the GET query parameter holding the base64url encoded
+	// DNS message.
+	dohQueryParam = "dns"
+)
+
+// dohServer serves DNS over HTTPS (RFC 8484) queries through the same handler
+// chain as the UDP, TCP and TLS servers. Its shape mimics [dns.Server] so that
+// it can be managed alongside them.
+type dohServer struct {
+	Addr     string
+	Net      string
+	Listener net.Listener
+	// If NotifyStartedFunc is set it is called once the server has started listening.
+	NotifyStartedFunc func()
+
+	server *http.Server
+}
+
+// ActivateAndServe starts serving HTTP requests on the server listener.
+func (s *dohServer) ActivateAndServe() error {
+	if s.NotifyStartedFunc != nil {
+		s.NotifyStartedFunc()
+	}
+	err := s.server.Serve(s.Listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown gracefully shuts down the HTTP server.
+func (s *dohServer) Shutdown() error {
+	return s.server.Shutdown(context.Background())
+}
+
+// dohHandler is a [http.Handler] decoding DoH requests and passing them to
+// a [dns.Handler].
+type dohHandler struct {
+	path     string
+	handler  dns.Handler
+	throttle *throttle.Handler
+	monitor  *Monitor
+}
+
+// ServeHTTP implements the [http.Handler] interface.
+func (h *dohHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != h.path {
+		http.NotFound(w, r)
+		return
+	}
+	buf, status, err := dohReadRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	req := new(dns.Msg)
+	if err = req.Unpack(buf); err != nil {
+		http.Error(w, fmt.Sprintf("invalid DNS message: %v", err), http.StatusBadRequest)
+		return
+	}
+	h.monitor.incStat("Read")
+
+	rw := newDoHResponseWriter(r)
+	// Same policy as dns.DefaultMsgAcceptFunc, which DoH queries do not go
+	// through: only queries with exactly one question make it to the handler.
+	if len(req.Question) != 1 {
+		m := new(dns.Msg)
+		m.SetRcodeFormatError(req)
+		_ = rw.WriteMsg(m)
+	} else {
+		if h.throttle != nil {
+			if err = h.throttle.Acquire(r.Context()); err != nil {
+				http.Error(w, "query throttled", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		h.handler.ServeDNS(rw, req)
+	}
+
+	if rw.msg == nil {
+		http.Error(w, "no response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", dohMimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(rw.msg)))
+	if rw.maxAge >= 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", rw.maxAge))
+	}
+	if _, err = w.Write(rw.msg); err != nil {
+		glog.Errorf("Failed to write DoH response to %s: %v", r.RemoteAddr, err)
+	}
+}
+
+// dohReadRequest extracts the wire format DNS message from a GET or POST DoH
+// request. On error, it also returns the HTTP status to reply with.
+func dohReadRequest(r *http.Request) ([]byte, int, error) {
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query().Get(dohQueryParam)
+		if q == "" {
+			return nil, http.StatusBadRequest, fmt.Errorf("missing %q query parameter", dohQueryParam)
+		}
+		buf, err := base64.RawURLEncoding.DecodeString(q)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid %q query parameter: %w", dohQueryParam, err)
+		}
+		return buf, http.StatusOK, nil
+	case http.MethodPost:
+		if ct := r.Header.Get("Content-Type"); ct != dohMimeType {
+			return nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q", ct)
+		}
+		buf, err := io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize+1))
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("failed to read request body: %w", err)
+		}
+		if len(buf) > dns.MaxMsgSize {
+			return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("DNS message too large")
+		}
+		return buf, http.StatusOK, nil
+	default:
+		return nil, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)
+	}
+}
+
+// dohResponseWriter is a [dns.ResponseWriter] buffering the response to a
+// single DoH request.
+type dohResponseWriter struct {
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	tlsState   *tls.ConnectionState
+	msg        []byte
+	// maxAge is the freshness lifetime of the response, -1 if unknown.
+	maxAge int64
+}
+
+// newDoHResponseWriter creates a dohResponseWriter with the addresses of the
+// connection the HTTP request was received on.
+func newDoHResponseWriter(r *http.Request) *dohResponseWriter {
+	w := &dohResponseWriter{tlsState: r.TLS, maxAge: -1}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		w.localAddr = addr
+	}
+	if addrPort, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		w.remoteAddr = net.TCPAddrFromAddrPort(addrPort)
+	} else {
+		w.remoteAddr = &net.TCPAddr{}
+	}
+	return w
+}
+
+// LocalAddr implements the [dns.ResponseWriter] interface.
+func (w *dohResponseWriter) LocalAddr() net.Addr {
+	return w.localAddr
+}
+
+// RemoteAddr implements the [dns.ResponseWriter] interface.
+func (w *dohResponseWriter) RemoteAddr() net.Addr {
+	return w.remoteAddr
+}
+
+// WriteMsg implements the [dns.ResponseWriter] interface.
+func (w *dohResponseWriter) WriteMsg(m *dns.Msg) error {
+	buf, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	w.msg = buf
+	w.maxAge = dohMaxAge(m)
+	return nil
+}
+
+// Write implements the [dns.ResponseWriter] interface.
+func (w *dohResponseWriter) Write(buf []byte) (int, error) {
+	w.msg = append([]byte(nil), buf...)
+	w.maxAge = -1
+	return len(buf), nil
+}
+
+// Close implements the [dns.ResponseWriter] interface.
+func (w *dohResponseWriter) Close() error { return nil }
+
+// TsigStatus implements the [dns.ResponseWriter] interface.
+func (w *dohResponseWriter) TsigStatus() error { return nil }
+
+// TsigTimersOnly implements the [dns.ResponseWriter] interface.
+func (w *dohResponseWriter) TsigTimersOnly(bool) {}
+
+// Hijack implements the [dns.ResponseWriter] interface.
+func (w *dohResponseWriter) Hijack() {}
+
+// ConnectionState implements the [dns.ConnectionStater] interface.
+func (w *dohResponseWriter) ConnectionState() *tls.ConnectionState {
+	return w.tlsState
+}
+
+// Transport implements the [logger.Transporter] interface.
+func (w *dohResponseWriter) Transport() string {
+	return logger.TransportDoH
+}
+
+// dohMaxAge returns the HTTP freshness lifetime of a DNS response, which per
+// RFC 8484 section 5.1 is the smallest TTL in the answer section, or the SOA
+// minimum for negative answers. It returns -1 when there is nothing to base
+// it on.
+func dohMaxAge(m *dns.Msg) int64 {
+	var maxAge int64 = math.MaxUint32 + 1
+	for _, rr := range m.Answer {
+		maxAge = min(maxAge, int64(rr.Header().Ttl))
+	}
+	if len(m.Answer) == 0 {
+		for _, rr := range m.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				maxAge = min(maxAge, int64(soa.Hdr.Ttl), int64(soa.Minttl))
+			}
+		}
+	}
+	if maxAge > math.MaxUint32 {
+		return -1
+	}
+	return maxAge
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/logger"
+	"github.com/facebook/dns/dnsrocks/metrics"
+)
+
+// newTestDoHHandler returns a dohHandler answering every query with a single
+// A record, and recording the protocol reported for the request.
+func newTestDoHHandler(proto *string) *dohHandler {
+	h := test.HandlerFunc(func(_ context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		state := request.Request{W: w, Req: r}
+		*proto = logger.RequestProtocol(state)
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, test.A("example.com. 300 IN A 192.0.2.1"))
+		return dns.RcodeSuccess, w.WriteMsg(m)
+	})
+	return &dohHandler{
+		path:    dohDefaultPath,
+		handler: &serveMux{defaultHandler: h},
+		monitor: NewMonitor(nil, monitorDoH, metrics.NewStats()),
+	}
+}
+
+func packTestQuery(t *testing.T, questions int) []byte {
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	for i := 1; i < questions; i++ {
+		m.Question = append(m.Question, m.Question[0])
+	}
+	if questions == 0 {
+		m.Question = nil
+	}
+	buf, err := m.Pack()
+	require.NoError(t, err)
+	return buf
+}
+
+func TestDoHHandlerErrors(t *testing.T) {
+	var proto string
+	h := newTestDoHHandler(&proto)
+
+	testCases := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{
+			name:   "wrong path",
+			req:    httptest.NewRequest(http.MethodGet, "/foo?dns=AAAB", nil),
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "missing dns parameter",
+			req:    httptest.NewRequest(http.MethodGet, dohDefaultPath, nil),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid base64",
+			req:    httptest.NewRequest(http.MethodGet, dohDefaultPath+"?dns=!!!", nil),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid DNS message",
+			req:    httptest.NewRequest(http.MethodPost, dohDefaultPath, strings.NewReader("foo")),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "bad content type",
+			req:    httptest.NewRequest(http.MethodPost, dohDefaultPath, bytes.NewReader(packTestQuery(t, 1))),
+			status: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:   "bad method",
+			req:    httptest.NewRequest(http.MethodPut, dohDefaultPath, nil),
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.name == "invalid DNS message" {
+				tc.req.Header.Set("Content-Type", dohMimeType)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, tc.req)
+			require.Equal(t, tc.status, rec.Code)
+		})
+	}
+}
+
+func TestDoHHandlerPost(t *testing.T) {
+	var proto string
+	h := newTestDoHHandler(&proto)
+
+	req := httptest.NewRequest(http.MethodPost, dohDefaultPath, bytes.NewReader(packTestQuery(t, 1)))
+	req.Header.Set("Content-Type", dohMimeType)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, dohMimeType, rec.Header().Get("Content-Type"))
+	require.Equal(t, "max-age=300", rec.Header().Get("Cache-Control"))
+	require.Equal(t, "DOH", proto)
+	r := new(dns.Msg)
+	require.NoError(t, r.Unpack(rec.Body.Bytes()))
+	require.Len(t, r.Answer, 1)
+	require.Equal(t, int64(1), h.monitor.stats.Get()[formatMonitorStatName(monitorDoH, "Read")])
+}
+
+// TestDoHHandlerQuestions tests that queries without exactly one question get
+// a FORMERR, like they would on the other transports.
+func TestDoHHandlerQuestions(t *testing.T) {
+	var proto string
+	h := newTestDoHHandler(&proto)
+
+	for _, questions := range []int{0, 2} {
+		req := httptest.NewRequest(http.MethodPost, dohDefaultPath, bytes.NewReader(packTestQuery(t, questions)))
+		req.Header.Set("Content-Type", dohMimeType)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		r := new(dns.Msg)
+		require.NoError(t, r.Unpack(rec.Body.Bytes()))
+		require.Equal(t, dns.RcodeFormatError, r.Rcode)
+		require.Empty(t, rec.Header().Get("Cache-Control"))
+	}
+	require.Empty(t, proto, "handler should not have been called")
+}
+
+func TestDoHMaxAge(t *testing.T) {
+	m := new(dns.Msg)
+	require.Equal(t, int64(-1), dohMaxAge(m))
+
+	m.Ns = append(m.Ns, test.SOA("example.com. 600 IN SOA ns.example.com. hostmaster.example.com. 1 7200 1800 86400 120"))
+	require.Equal(t, int64(120), dohMaxAge(m))
+
+	m.Answer = append(m.Answer, test.A("example.com. 300 IN A 192.0.2.1"), test.A("example.com. 30 IN A 192.0.2.2"))
+	require.Equal(t, int64(30), dohMaxAge(m))
+}
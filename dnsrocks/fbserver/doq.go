@@ -0,0 +1,276 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+
+	"github.com/facebook/dns/dnsrocks/logger"
+	"github.com/facebook/dns/dnsrocks/throttle"
+)
+
+// doqALPN is the ALPN token of DNS over QUIC, see RFC 9250 section 4.1.1.
+const doqALPN = "doq"
+
+// DoQ error codes, see RFC 9250 section 4.3.
+const (
+	doqNoError          = 0x0
+	doqInternalError    = 0x1
+	doqProtocolError    = 0x2
+	doqRequestCancelled = 0x3
+	doqExcessiveLoad    = 0x4
+)
+
+// doqServer serves DNS over QUIC (RFC 9250) queries through the same handler
+// chain as the UDP, TCP and TLS servers. Its shape mimics [dns.Server] so that
+// it can be managed alongside them.
+type doqServer struct {
+	Addr     string
+	Net      string
+	Listener *QUICMonitor
+	// If NotifyStartedFunc is set it is called once the server has started listening.
+	NotifyStartedFunc func()
+	// ReadTimeout bounds the time to receive a query once its stream is opened.
+	ReadTimeout time.Duration
+
+	handler  dns.Handler
+	throttle *throttle.Handler
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newDoQServer creates a doqServer accepting connections from l.
+func newDoQServer(addr string, l *QUICMonitor, h dns.Handler, th *throttle.Handler) *doqServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &doqServer{
+		Addr:     addr,
+		Net:      "doq",
+		Listener: l,
+		handler:  h,
+		throttle: th,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// ActivateAndServe accepts QUIC connections until the server is shut down.
+func (s *doqServer) ActivateAndServe() error {
+	if s.NotifyStartedFunc != nil {
+		s.NotifyStartedFunc()
+	}
+	for {
+		conn, err := s.Listener.Accept(s.ctx)
+		if err != nil {
+			if s.ctx.Err() != nil || errors.Is(err, quic.ErrServerClosed) {
+				return nil
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Shutdown closes the listener, and with it all the established connections.
+func (s *doqServer) Shutdown() error {
+	s.cancel()
+	err := s.Listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+// serveConn serves every stream opened by the client on a connection.
+func (s *doqServer) serveConn(conn *QUICConnectionMonitor) {
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		_ = conn.CloseWithError(doqNoError, "")
+	}()
+	for {
+		stream, err := conn.AcceptStream(s.ctx)
+		if err != nil {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveStream(conn, stream)
+		}()
+	}
+}
+
+// serveStream reads the single query sent on a stream, and writes back the
+// response before closing the stream, as per RFC 9250 section 4.2.
+func (s *doqServer) serveStream(conn *QUICConnectionMonitor, stream *QUICStreamMonitor) {
+	if s.ReadTimeout > 0 {
+		_ = stream.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+	}
+	buf, err := readDoQMessage(stream)
+	if err != nil {
+		glog.V(2).Infof("Failed to read DoQ query from %s: %v", conn.RemoteAddr(), err)
+		stream.Cancel(doqProtocolError)
+		return
+	}
+	req := new(dns.Msg)
+	if err = req.Unpack(buf); err != nil {
+		stream.Cancel(doqProtocolError)
+		_ = conn.CloseWithError(doqProtocolError, "invalid DNS message")
+		return
+	}
+	// RFC 9250 section 4.2.1: the Message ID MUST be set to 0.
+	if req.Id != 0 {
+		stream.Cancel(doqProtocolError)
+		_ = conn.CloseWithError(doqProtocolError, "non-zero message ID")
+		return
+	}
+	s.Listener.incStat("Read")
+
+	w := &doqResponseWriter{conn: conn, stream: stream}
+	// Same policy as dns.DefaultMsgAcceptFunc, which DoQ queries do not go
+	// through: only queries with exactly one question make it to the handler.
+	if len(req.Question) != 1 {
+		m := new(dns.Msg)
+		m.SetRcodeFormatError(req)
+		_ = w.WriteMsg(m)
+	} else {
+		if s.throttle != nil {
+			if err = s.throttle.Acquire(stream.Context()); err != nil {
+				stream.Cancel(doqExcessiveLoad)
+				return
+			}
+		}
+		s.handler.ServeDNS(w, req)
+	}
+	if !w.written {
+		stream.Cancel(doqInternalError)
+		return
+	}
+	_ = stream.Close()
+}
+
+// readDoQMessage reads a DNS message prefixed with its 2-octet length. The
+// client must close its side of the stream once the query is sent, so the
+// stream is read to its end.
+func readDoQMessage(r io.Reader) ([]byte, error) {
+	buf, err := io.ReadAll(io.LimitReader(r, 2+dns.MaxMsgSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 2 {
+		return nil, fmt.Errorf("message too short: %d", len(buf))
+	}
+	l := int(binary.BigEndian.Uint16(buf))
+	if l == 0 || l != len(buf)-2 {
+		return nil, fmt.Errorf("message length %d does not match stream length %d", l, len(buf)-2)
+	}
+	return buf[2:], nil
+}
+
+// doqResponseWriter is a [dns.ResponseWriter] writing the response to a single
+// DoQ query on its stream.
+type doqResponseWriter struct {
+	conn    *QUICConnectionMonitor
+	stream  *QUICStreamMonitor
+	written bool
+}
+
+// LocalAddr implements the [dns.ResponseWriter] interface.
+func (w *doqResponseWriter) LocalAddr() net.Addr {
+	return w.conn.LocalAddr()
+}
+
+// RemoteAddr implements the [dns.ResponseWriter] interface.
+// QUIC streams are reliable and not bounded by the UDP payload size, but
+// [request.Request] derives both from the address type. A [net.TCPAddr] is
+// thus reported, so that responses aren't truncated.
+func (w *doqResponseWriter) RemoteAddr() net.Addr {
+	if addr, ok := w.conn.RemoteAddr().(*net.UDPAddr); ok {
+		return &net.TCPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone}
+	}
+	return w.conn.RemoteAddr()
+}
+
+// WriteMsg implements the [dns.ResponseWriter] interface.
+func (w *doqResponseWriter) WriteMsg(m *dns.Msg) error {
+	buf, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// Write implements the [dns.ResponseWriter] interface.
+func (w *doqResponseWriter) Write(buf []byte) (int, error) {
+	if len(buf) > dns.MaxMsgSize {
+		return 0, fmt.Errorf("message too large: %d", len(buf))
+	}
+	if w.written {
+		return 0, fmt.Errorf("response already written")
+	}
+	w.written = true
+	msg := make([]byte, 2+len(buf))
+	binary.BigEndian.PutUint16(msg, uint16(len(buf)))
+	copy(msg[2:], buf)
+	if _, err := w.stream.Write(msg); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+// Close implements the [dns.ResponseWriter] interface.
+func (w *doqResponseWriter) Close() error {
+	w.stream.Cancel(doqRequestCancelled)
+	return nil
+}
+
+// TsigStatus implements the [dns.ResponseWriter] interface.
+func (w *doqResponseWriter) TsigStatus() error { return nil }
+
+// TsigTimersOnly implements the [dns.ResponseWriter] interface.
+func (w *doqResponseWriter) TsigTimersOnly(bool) {}
+
+// Hijack implements the [dns.ResponseWriter] interface.
+func (w *doqResponseWriter) Hijack() {}
+
+// ConnectionState implements the [dns.ConnectionStater] interface.
+func (w *doqResponseWriter) ConnectionState() *tls.ConnectionState {
+	state := w.conn.ConnectionState()
+	return &state
+}
+
+// Transport implements the [logger.Transporter] interface.
+func (w *doqResponseWriter) Transport() string {
+	return logger.TransportDoQ
+}
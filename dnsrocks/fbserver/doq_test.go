@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadDoQMessage(t *testing.T) {
+	testCases := []struct {
+		name    string
+		stream  []byte
+		msg     []byte
+		wantErr bool
+	}{
+		{
+			name:   "valid message",
+			stream: []byte{0, 3, 'f', 'o', 'o'},
+			msg:    []byte{'f', 'o', 'o'},
+		},
+		{
+			name:    "empty stream",
+			stream:  []byte{},
+			wantErr: true,
+		},
+		{
+			name:    "truncated length",
+			stream:  []byte{0},
+			wantErr: true,
+		},
+		{
+			name:    "zero length",
+			stream:  []byte{0, 0},
+			wantErr: true,
+		},
+		{
+			name:    "short message",
+			stream:  []byte{0, 4, 'f', 'o', 'o'},
+			wantErr: true,
+		},
+		{
+			name:    "trailing data",
+			stream:  []byte{0, 2, 'f', 'o', 'o'},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := readDoQMessage(bytes.NewReader(tc.stream))
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.msg, msg)
+		})
+	}
+}
@@ -0,0 +1,357 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
+
+	"github.com/coredns/coredns/request"
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+// errIncompleteHistory is returned when the zone history does not reach back
+// to the serial of the client.
+var errIncompleteHistory = errors.New("zone history is incomplete")
+
+// ixfrSerial returns the serial of the SOA record in the authority section of
+// an IXFR query, see RFC 1995 section 3.
+func ixfrSerial(r *dns.Msg) (uint32, bool) {
+	if len(r.Ns) != 1 {
+		return 0, false
+	}
+	soa, ok := r.Ns[0].(*dns.SOA)
+	if !ok {
+		return 0, false
+	}
+	return soa.Serial, true
+}
+
+// zoneHistory returns the history entries of the zone, leading from serial to
+// current.
+func zoneHistory(reader db.Reader, zone []byte, serial, current uint32) ([]*rdb.HistoryEntry, error) {
+	var history []*rdb.HistoryEntry
+	seen := make(map[uint32]bool)
+	for serial != current {
+		if seen[serial] {
+			return nil, fmt.Errorf("zone history loops back to serial %d", serial)
+		}
+		seen[serial] = true
+
+		var v []byte
+		err := reader.ForEach(rdb.HistoryKey(zone, serial), func(value []byte) error {
+			v = value
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, errIncompleteHistory
+		}
+		e := new(rdb.HistoryEntry)
+		if err = e.UnmarshalBinary(v); err != nil {
+			return nil, fmt.Errorf("serial %d: %w", serial, err)
+		}
+		history = append(history, e)
+		serial = e.NewSerial
+	}
+	return history, nil
+}
+
+// errDelegationChanged is returned when the zone history changes the name
+// servers of a zone cut below the apex.
+var errDelegationChanged = errors.New("zone history changes a delegation")
+
+// ownerRows are the rows of an owner name, by location.
+type ownerRows map[string][][]byte
+
+// clone returns a copy of the rows, sharing the values.
+func (o ownerRows) clone() ownerRows {
+	c := make(ownerRows, len(o))
+	for loc, rows := range o {
+		c[loc] = slices.Clone(rows)
+	}
+	return c
+}
+
+// revert undoes the change of a record of the owner.
+func (o ownerRows) revert(loc string, value []byte, added bool) {
+	if !added {
+		o[loc] = append(o[loc], value)
+		return
+	}
+	rows := o[loc]
+	if i := slices.IndexFunc(rows, func(row []byte) bool { return bytes.Equal(row, value) }); i >= 0 {
+		o[loc] = slices.Delete(rows, i, i+1)
+	}
+}
+
+// historyDiff computes the difference sequences of the history entries, as
+// AXFR would transfer the zone at each serial: the records of the names the
+// entries change, in the first location of chain with records for them AND
+// the default location, selected by filter. The states of these names at the
+// older serials are rebuilt from their current records, reverting the
+// entries one after the other. The zone cuts, which filter finds in the
+// current DB, must be the same at every serial: errDelegationChanged is
+// returned when an entry changes the NS records of a name below the apex.
+func historyDiff(reader db.Reader, filter *zoneFilter, history []*rdb.HistoryEntry, chain []db.ID) (deleted, added [][]dns.RR, err error) {
+	locations := append([]db.ID{db.ZeroID}, chain...)
+	transferred := func(loc db.ID) bool {
+		return slices.ContainsFunc(locations, func(id db.ID) bool { return bytes.Equal(id, loc) })
+	}
+
+	// owners lists the names each entry changes, in order
+	owners := make([][]string, len(history))
+	// states holds the rows of the changed names at each serial, the last
+	// one being the current state
+	states := make([]map[string]ownerRows, len(history)+1)
+	states[len(history)] = make(map[string]ownerRows)
+	for i, e := range history {
+		seen := make(map[string]bool)
+		for _, r := range slices.Concat(e.Deleted, e.Added) {
+			owner, loc, err := db.SplitResourceRecordKey(r.Key)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !transferred(loc) {
+				continue
+			}
+			if rec, err := db.ExtractRRFromRow(r.Value, false); err == nil && rec.Qtype == dns.TypeNS && !bytes.Equal(owner, filter.zone) {
+				return nil, nil, errDelegationChanged
+			}
+			if !seen[string(owner)] {
+				seen[string(owner)] = true
+				owners[i] = append(owners[i], string(owner))
+			}
+			if _, ok := states[len(history)][string(owner)]; ok {
+				continue
+			}
+			rows := make(ownerRows)
+			for _, id := range locations {
+				err := reader.ForEachLocationRecord(owner, id, func(row []byte) error {
+					rows[string(id)] = append(rows[string(id)], bytes.Clone(row))
+					return nil
+				})
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+			states[len(history)][string(owner)] = rows
+		}
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		states[i] = make(map[string]ownerRows, len(states[i+1]))
+		for owner, rows := range states[i+1] {
+			states[i][owner] = rows.clone()
+		}
+		for _, change := range []struct {
+			records []dnsdata.MapRecord
+			added   bool
+		}{{history[i].Added, true}, {history[i].Deleted, false}} {
+			for _, r := range change.records {
+				owner, loc, err := db.SplitResourceRecordKey(r.Key)
+				if err != nil {
+					return nil, nil, err
+				}
+				if transferred(loc) {
+					states[i][string(owner)].revert(string(loc), r.Value, change.added)
+				}
+			}
+		}
+	}
+
+	deleted = make([][]dns.RR, len(history))
+	added = make([][]dns.RR, len(history))
+	for i := range history {
+		for _, owner := range owners[i] {
+			before, err := transferredRecords(filter, []byte(owner), states[i][owner], chain)
+			if err != nil {
+				return nil, nil, err
+			}
+			after, err := transferredRecords(filter, []byte(owner), states[i+1][owner], chain)
+			if err != nil {
+				return nil, nil, err
+			}
+			deleted[i] = append(deleted[i], subtractRecords(before, after)...)
+			added[i] = append(added[i], subtractRecords(after, before)...)
+		}
+	}
+	return deleted, added, nil
+}
+
+// transferredRecords returns the records AXFR transfers for the rows of an
+// owner name: those of the first location of chain with rows AND the default
+// location, selected by filter.
+func transferredRecords(filter *zoneFilter, owner []byte, rows ownerRows, chain []db.ID) ([]dns.RR, error) {
+	selected := rows[string(db.ZeroID)]
+	for _, id := range chain {
+		if len(rows[string(id)]) > 0 {
+			selected = slices.Concat(rows[string(id)], selected)
+			break
+		}
+	}
+	var rrs []dns.RR
+	for _, row := range selected {
+		rr, err := db.UnpackOwnerRecord(owner, row)
+		if err != nil {
+			glog.Errorf("Skipping resource record of %v: %v", owner, err)
+			continue
+		}
+		rrs = append(rrs, rr)
+	}
+	return filter.records(owner, rrs)
+}
+
+// subtractRecords returns the records of a which are not in b.
+func subtractRecords(a, b []dns.RR) []dns.RR {
+	left := make(map[string]int, len(b))
+	for _, rr := range b {
+		left[rr.String()]++
+	}
+	var diff []dns.RR
+	for _, rr := range a {
+		if left[rr.String()] > 0 {
+			left[rr.String()]--
+			continue
+		}
+		diff = append(diff, rr)
+	}
+	return diff
+}
+
+// withSerial returns a copy of the SOA record with another serial.
+func withSerial(soa *dns.SOA, serial uint32) *dns.SOA {
+	c := dns.Copy(soa).(*dns.SOA)
+	c.Serial = serial
+	return c
+}
+
+// serveIXFR answers an IXFR query with the difference sequences from the
+// client's serial to the current one, see RFC 1995 section 4. The whole zone
+// is sent instead when the history does not reach back far enough.
+func (h *axfrHandler) serveIXFR(state request.Request) (int, error) {
+	h.stats.IncrementCounter("DNS_ixfr.requests")
+
+	serial, ok := ixfrSerial(state.Req)
+	if !ok {
+		h.stats.IncrementCounter("DNS_ixfr.formerr")
+		return h.reply(state, dns.RcodeFormatError)
+	}
+	if !h.isAllowed(net.ParseIP(state.IP())) {
+		h.stats.IncrementCounter("DNS_ixfr.refused.acl")
+		return h.reply(state, dns.RcodeRefused)
+	}
+
+	reader, zone, rr, rcode := h.findZone(state, "DNS_ixfr")
+	if reader == nil {
+		return h.reply(state, rcode)
+	}
+	defer reader.Close()
+	soa := rr.(*dns.SOA)
+
+	t := newAXFRTransfer(state, reader, zone, h.locID)
+	// A single SOA record tells the client it is up to date or, over UDP,
+	// that it should retry over TCP.
+	upToDate := dnsdata.SerialNewerOrEqual(serial, soa.Serial)
+	if upToDate || !isStreaming(state) {
+		if upToDate {
+			h.stats.IncrementCounter("DNS_ixfr.uptodate")
+		} else {
+			h.stats.IncrementCounter("DNS_ixfr.retry_tcp")
+		}
+		err := t.add(soa)
+		if err == nil {
+			err = t.flush()
+		}
+		if err != nil {
+			glog.Errorf("IXFR of %s to %s failed: %v", state.Name(), state.IP(), err)
+			return t.abort(h)
+		}
+		return dns.RcodeSuccess, nil
+	}
+
+	history, err := zoneHistory(reader, zone, serial, soa.Serial)
+	if err != nil {
+		if !errors.Is(err, errIncompleteHistory) {
+			glog.Errorf("IXFR of %s to %s: %v", state.Name(), state.IP(), err)
+		}
+		h.stats.IncrementCounter("DNS_ixfr.fallback")
+		return h.transfer(state, reader, zone, soa)
+	}
+
+	// The records of the locations AXFR transfers, see ForEachZoneRecord.
+	chain, err := reader.LocationChain(h.locID)
+	var deleted, added [][]dns.RR
+	if err == nil {
+		deleted, added, err = historyDiff(reader, t.filter, history, chain)
+	}
+	if err != nil {
+		if !errors.Is(err, errDelegationChanged) {
+			glog.Errorf("IXFR of %s to %s: %v", state.Name(), state.IP(), err)
+		}
+		h.stats.IncrementCounter("DNS_ixfr.fallback")
+		return h.transfer(state, reader, zone, soa)
+	}
+
+	err = t.add(soa)
+	for i, e := range history {
+		if err != nil {
+			break
+		}
+		err = t.addAll(withSerial(soa, serial), deleted[i])
+		if err == nil {
+			err = t.addAll(withSerial(soa, e.NewSerial), added[i])
+		}
+		serial = e.NewSerial
+	}
+	if err == nil {
+		err = t.add(soa)
+	}
+	if err == nil {
+		err = t.flush()
+	}
+	if err != nil {
+		h.stats.IncrementCounter("DNS_ixfr.error")
+		glog.Errorf("IXFR of %s to %s failed after %d messages: %v", state.Name(), state.IP(), t.sent, err)
+		return t.abort(h)
+	}
+	h.stats.IncrementCounter("DNS_ixfr.success")
+	glog.Infof("IXFR of %s to %s: %d changes, %d records in %d messages", state.Name(), state.IP(), len(history), t.records, t.sent)
+	return dns.RcodeSuccess, nil
+}
+
+// addAll adds a SOA record delimiting a difference sequence, and its records.
+func (t *axfrTransfer) addAll(soa dns.RR, rrs []dns.RR) error {
+	if err := t.add(soa); err != nil {
+		return err
+	}
+	for _, rr := range rrs {
+		if err := t.add(rr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
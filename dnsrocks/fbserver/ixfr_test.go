@@ -0,0 +1,183 @@
+//go:build cgo
+
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
+	"github.com/facebook/dns/dnsrocks/testaid"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// incrementalTransfer requests an IXFR of zone from serial, and returns the
+// records received, or the rcode of the failed transfer.
+func incrementalTransfer(t *testing.T, network, addr, zone string, serial uint32) ([]dns.RR, int) {
+	c := &dns.Client{Net: network}
+	co, err := c.Dial(addr)
+	require.NoError(t, err)
+	defer co.Close()
+
+	m := new(dns.Msg)
+	m.SetIxfr(zone, serial, "a.ns."+zone, "dns."+zone)
+	require.NoError(t, co.WriteMsg(m))
+
+	var rrs []dns.RR
+	for {
+		require.NoError(t, co.SetReadDeadline(time.Now().Add(2*time.Second)))
+		r, err := co.ReadMsg()
+		require.NoError(t, err)
+		require.Equal(t, m.Id, r.Id)
+		if r.Rcode != dns.RcodeSuccess {
+			return nil, r.Rcode
+		}
+		rrs = append(rrs, r.Answer...)
+		// A single SOA, or the current SOA twice.
+		last := rrs[len(rrs)-1]
+		if len(rrs) == 1 || last.Header().Rrtype == dns.TypeSOA && last.String() == rrs[0].String() {
+			return rrs, dns.RcodeSuccess
+		}
+	}
+}
+
+func soaSerials(rrs []dns.RR) []uint32 {
+	var serials []uint32
+	for _, rr := range rrs {
+		if soa, ok := rr.(*dns.SOA); ok {
+			serials = append(serials, soa.Serial)
+		}
+	}
+	return serials
+}
+
+func TestIXFR(t *testing.T) {
+	diffs := []string{`
+-Zexample.org,a.ns.example.org,dns.example.org,123,7200,1800,604800,120,120,,
++Zexample.org,a.ns.example.org,dns.example.org,124,7200,1800,604800,120,120,,
+++ixfr.example.org,192.0.2.1,3600,,
+++ixfr.example.org,192.0.2.2,3600,,\000\001
+++ixfr.example.org,192.0.2.3,3600,,\000\002
+++ixfr.example.org,192.0.2.5,3600,,\000\042
+`, `
+-Zexample.org,a.ns.example.org,dns.example.org,124,7200,1800,604800,120,120,,
++Zexample.org,a.ns.example.org,dns.example.org,125,7200,1800,604800,120,120,,
+-+ixfr.example.org,192.0.2.1,3600,,
+++ixfr2.example.org,192.0.2.4,3600,,
+++ixfr3.example.org,192.0.2.6,3600,,\000\043
+++chain.example.org,192.0.2.7,3600,,\000\043
+`}
+	db, err := rdb.NewUpdater(testaid.TestRDBV2.Path)
+	require.NoError(t, err)
+	for _, diff := range diffs {
+		require.NoError(t, db.ApplyDiff(strings.NewReader(diff), 1))
+	}
+	db.Close()
+
+	config := makeAXFRTestServerConfig(t, testaid.TestRDBV2, false)
+	config.AXFRConfig.Location = `\000\001`
+	portMap, srv := makeTestServer(t, config)
+	defer srv.Shutdown()
+
+	t.Run("incremental", func(t *testing.T) {
+		rrs, rcode := incrementalTransfer(t, "tcp", portMap["tcp"], "example.org.", 123)
+		require.Equal(t, dns.RcodeSuccess, rcode)
+		require.Equal(t, []uint32{125, 123, 124, 124, 125, 125}, soaSerials(rrs))
+		var got []string
+		for _, rr := range rrs {
+			if rr.Header().Rrtype != dns.TypeSOA {
+				got = append(got, rr.Header().Name+" "+rr.(*dns.A).A.String())
+			}
+		}
+		// Records of other locations are not transferred, the others are in
+		// the same order as with AXFR.
+		require.Equal(t, []string{
+			"ixfr.example.org. 192.0.2.2",
+			"ixfr.example.org. 192.0.2.1",
+			"ixfr.example.org. 192.0.2.1",
+			"ixfr2.example.org. 192.0.2.4",
+		}, got)
+	})
+
+	t.Run("location chain", func(t *testing.T) {
+		config := makeAXFRTestServerConfig(t, testaid.TestRDBV2, false)
+		config.AXFRConfig.Location = `\000\041`
+		portMap, srv := makeTestServer(t, config)
+		defer srv.Shutdown()
+
+		rrs, rcode := incrementalTransfer(t, "tcp", portMap["tcp"], "example.org.", 123)
+		require.Equal(t, dns.RcodeSuccess, rcode)
+		var got []string
+		for _, rr := range rrs {
+			if rr.Header().Rrtype != dns.TypeSOA {
+				got = append(got, rr.Header().Name+" "+rr.(*dns.A).A.String())
+			}
+		}
+		// Records of the parent locations are transferred, unless the
+		// location has records for the name, as with AXFR.
+		require.Equal(t, []string{
+			"ixfr.example.org. 192.0.2.5",
+			"ixfr.example.org. 192.0.2.1",
+			"ixfr.example.org. 192.0.2.1",
+			"ixfr2.example.org. 192.0.2.4",
+			"ixfr3.example.org. 192.0.2.6",
+		}, got)
+	})
+
+	t.Run("partial", func(t *testing.T) {
+		rrs, rcode := incrementalTransfer(t, "tcp", portMap["tcp"], "example.org.", 124)
+		require.Equal(t, dns.RcodeSuccess, rcode)
+		require.Equal(t, []uint32{125, 124, 125, 125}, soaSerials(rrs))
+	})
+
+	t.Run("up to date", func(t *testing.T) {
+		rrs, rcode := incrementalTransfer(t, "tcp", portMap["tcp"], "example.org.", 125)
+		require.Equal(t, dns.RcodeSuccess, rcode)
+		require.Equal(t, []uint32{125}, soaSerials(rrs))
+		require.Len(t, rrs, 1)
+	})
+
+	t.Run("fallback to AXFR", func(t *testing.T) {
+		rrs, rcode := incrementalTransfer(t, "tcp", portMap["tcp"], "example.org.", 100)
+		require.Equal(t, dns.RcodeSuccess, rcode)
+		require.Equal(t, []uint32{125, 125}, soaSerials(rrs))
+		require.Greater(t, len(rrs), 2)
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		m := new(dns.Msg)
+		m.SetIxfr("example.org.", 123, "a.ns.example.org.", "dns.example.org.")
+		r, err := dns.Exchange(m, portMap["udp"])
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeSuccess, r.Rcode)
+		require.Equal(t, []uint32{125}, soaSerials(r.Answer))
+		require.Len(t, r.Answer, 1)
+	})
+
+	t.Run("no SOA", func(t *testing.T) {
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeIXFR)
+		r, err := dns.Exchange(m, portMap["udp"])
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeFormatError, r.Rcode)
+	})
+}
@@ -22,6 +22,8 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/quic-go/quic-go"
+
 	"github.com/facebook/dns/dnsrocks/metrics"
 )
 
@@ -33,23 +35,73 @@ func listenUDP(addr string, conf net.ListenConfig) (net.PacketConn, error) {
 
 // listenTCP configures a socket with SO_REUSEPORT. It then uses the socket to
 // create an unencrypted, monitored TLS net.Listener. The monitor sends
-// connection metrics to fb303.
-func listenTCP(addr string, conf net.ListenConfig, stats *metrics.Stats) (*Monitor, error) {
+// connection metrics to fb303. If enabled, PROXY headers from trusted proxies
+// are parsed before anything else is read from the connections.
+func listenTCP(addr string, conf net.ListenConfig, proxyConf *ProxyProtocolConfig, stats *metrics.Stats) (*Monitor, error) {
 	list, err := conf.Listen(context.Background(), "tcp", addr)
 	if err != nil {
 		return nil, err
 	}
+	if proxyConf.Enabled {
+		list = newProxyProtoListener(list, proxyConf)
+	}
 	return NewMonitor(list, monitorTCP, stats), nil
 }
 
 // listenTLS configures a socket with SO_REUSEPORT. It then uses the socket to
 // create an encrypted, monitored TLS net.Listener. The monitor sends connection
-// metrics to fb303.
-func listenTLS(addr string, conf net.ListenConfig, tlsConf *tls.Config, stats *metrics.Stats) (*Monitor, error) {
+// metrics to fb303. If enabled, PROXY headers from trusted proxies are parsed
+// before the TLS handshake.
+func listenTLS(addr string, conf net.ListenConfig, tlsConf *tls.Config, proxyConf *ProxyProtocolConfig, stats *metrics.Stats) (*Monitor, error) {
 	tcpList, err := conf.Listen(context.Background(), "tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("could not open tcp listener for tls conn: %w", err)
 	}
+	if proxyConf.Enabled {
+		tcpList = newProxyProtoListener(tcpList, proxyConf)
+	}
 	tlsList := tls.NewListener(tcpList, tlsConf)
 	return NewMonitor(tlsList, monitorTCPWithTLS, stats), nil
 }
+
+// listenDoH configures a socket with SO_REUSEPORT. It then uses the socket to
+// create an encrypted TLS net.Listener negotiating HTTP/2 and HTTP/1.1. The
+// monitor wraps the underlying TCP listener, so that the HTTP server still
+// sees the TLS connections, and sends connection metrics to fb303.
+func listenDoH(addr string, conf net.ListenConfig, tlsConf *tls.Config, stats *metrics.Stats) (*Monitor, net.Listener, error) {
+	tcpList, err := conf.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open tcp listener for https conn: %w", err)
+	}
+	m := NewMonitor(tcpList, monitorDoH, stats)
+	tlsConf.NextProtos = []string{"h2", "http/1.1"}
+	return m, tls.NewListener(m, tlsConf), nil
+}
+
+// listenQUIC configures a socket with SO_REUSEPORT. It then uses the socket to
+// create a monitored QUIC listener negotiating DoQ. The monitor sends
+// connection and stream metrics to fb303.
+// The QUIC stack clones the TLS config it is given, so the handshake config
+// is fetched from tlsConf on every connection in order to pick up rotated
+// session ticket keys.
+func listenQUIC(addr string, conf net.ListenConfig, tlsConf *tls.Config, quicConf *quic.Config, stats *metrics.Stats) (*QUICMonitor, error) {
+	pc, err := conf.ListenPacket(context.Background(), "udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not open udp listener for quic conn: %w", err)
+	}
+	tlsConf.NextProtos = []string{doqALPN}
+	tlsConf.MinVersion = tls.VersionTLS13
+	l, err := quic.Listen(pc, &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		NextProtos:   tlsConf.NextProtos,
+		Certificates: tlsConf.Certificates,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return tlsConf, nil
+		},
+	}, quicConf)
+	if err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("could not open quic listener: %w", err)
+	}
+	return NewQUICMonitor(l, pc, monitorDoQ, stats), nil
+}
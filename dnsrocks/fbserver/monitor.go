@@ -17,9 +17,12 @@
 package fbserver
 
 import (
+	"context"
 	"fmt"
 	"net"
 
+	"github.com/quic-go/quic-go"
+
 	"github.com/facebook/dns/dnsrocks/metrics"
 )
 
@@ -31,6 +34,8 @@ const (
 	monitorTCP        MonitorType = "tcp"     // Unencrypted TCP
 	monitorTCPWithTLS MonitorType = "tcp-tls" // TLS encrypted TCP.
 	monitorUDP        MonitorType = "udp"     // UDP
+	monitorDoH        MonitorType = "doh"     // DNS over HTTPS, monitored below TLS.
+	monitorDoQ        MonitorType = "doq"     // DNS over QUIC.
 )
 
 // Monitor is a net.Listener that logs and captures socket metrics.
@@ -84,6 +89,71 @@ func (m *Monitor) Addr() net.Addr {
 	return m.listener.Addr()
 }
 
+// QUICMonitor is a QUIC listener that logs and captures connection and
+// stream metrics, the same way Monitor does for net.Listener.
+type QUICMonitor struct {
+	listener      *quic.Listener
+	conn          net.PacketConn
+	transportName MonitorType
+	stats         *metrics.Stats
+}
+
+// NewQUICMonitor creates a QUICMonitor from a quic.Listener and the
+// net.PacketConn it is listening on.
+func NewQUICMonitor(l *quic.Listener, c net.PacketConn, t MonitorType, s *metrics.Stats) *QUICMonitor {
+	m := &QUICMonitor{
+		listener:      l,
+		conn:          c,
+		transportName: t,
+		stats:         s,
+	}
+
+	if m.stats == nil {
+		m.stats = metrics.NewStats()
+	}
+	for _, method := range []string{"Accept", "Close", "Addr", "Read"} {
+		m.stats.ResetCounter(formatMonitorStatName(m.transportName, method))
+	}
+	for _, name := range []string{"Accept", "Close"} {
+		m.stats.ResetCounter(formatConnectionMonitorStatName(m.transportName, name, true))
+		m.stats.ResetCounter(formatStreamMonitorStatName(m.transportName, name, true))
+	}
+	m.stats.ResetCounter(formatConnectionMonitorStatName(m.transportName, "Count", false))
+	m.stats.ResetCounter(formatStreamMonitorStatName(m.transportName, "Count", false))
+	return m
+}
+
+// Accept monitors quic.Listener.Accept.
+func (m *QUICMonitor) Accept(ctx context.Context) (*QUICConnectionMonitor, error) {
+	conn, err := m.listener.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.incStat("Accept")
+	return NewQUICConnectionMonitor(conn, m.transportName, m.stats), nil
+}
+
+// Close monitors quic.Listener.Close, and closes the underlying
+// net.PacketConn which is not owned by the quic.Listener.
+func (m *QUICMonitor) Close() error {
+	if err := m.listener.Close(); err != nil {
+		return err
+	}
+	m.incStat("Close")
+	return m.conn.Close()
+}
+
+// Addr increments a counter and then calls quic.Listener.Addr.
+func (m *QUICMonitor) Addr() net.Addr {
+	m.incStat("Addr")
+	return m.listener.Addr()
+}
+
+// incStat increments a QUICMonitor ODS counter.
+func (m *QUICMonitor) incStat(method string) {
+	m.stats.IncrementCounter(formatMonitorStatName(m.transportName, method))
+}
+
 // initListenerStats clears a list method call counters to a default value.
 func (m *Monitor) initListenerStats(methods ...string) {
 	for _, method := range methods {
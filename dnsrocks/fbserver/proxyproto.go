@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"net"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// ProxyProtocolConfig contains the config for parsing PROXY protocol (v1 and
+// v2) headers sent by L4 load balancers on the TCP and TLS listeners.
+type ProxyProtocolConfig struct {
+	Enabled bool
+	// TrustedSubnets are the subnets of the proxies allowed to send a PROXY
+	// header. Connections from any other peer are served as is.
+	TrustedSubnets ipNets
+	// ReadHeaderTimeout bounds the time to read the PROXY header, 0 to use
+	// the library default.
+	ReadHeaderTimeout time.Duration
+}
+
+// newProxyProtoListener wraps l so that connections from trusted proxies get
+// their PROXY header parsed, and report the client address it carries as
+// their remote address. Connections from trusted proxies without a header,
+// e.g. health checks, are accepted too.
+func newProxyProtoListener(l net.Listener, conf *ProxyProtocolConfig) net.Listener {
+	return &proxyproto.Listener{
+		Listener:          l,
+		ConnPolicy:        proxyProtoPolicy(conf.TrustedSubnets),
+		ReadHeaderTimeout: conf.ReadHeaderTimeout,
+	}
+}
+
+// proxyProtoPolicy returns a proxyproto.ConnPolicyFunc using the PROXY header
+// from the trusted subnets, and skipping its parsing for everyone else.
+func proxyProtoPolicy(trusted ipNets) proxyproto.ConnPolicyFunc {
+	return func(opts proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+		addr, ok := opts.Upstream.(*net.TCPAddr)
+		if !ok {
+			return proxyproto.SKIP, nil
+		}
+		for _, n := range trusted {
+			if n.Contains(addr.IP) {
+				return proxyproto.USE, nil
+			}
+		}
+		return proxyproto.SKIP, nil
+	}
+}
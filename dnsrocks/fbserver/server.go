@@ -23,6 +23,7 @@ import (
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"strconv"
 	"strings"
 	"sync"
@@ -33,13 +34,17 @@ import (
 	"github.com/coredns/coredns/plugin/bufsize"
 	"github.com/golang/glog"
 	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
 	"golang.org/x/sys/unix"
 
+	"github.com/facebook/dns/dnsrocks/cookie"
 	"github.com/facebook/dns/dnsrocks/db"
 	"github.com/facebook/dns/dnsrocks/dnsserver"
 	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
 	"github.com/facebook/dns/dnsrocks/metrics"
+	"github.com/facebook/dns/dnsrocks/notify"
 	"github.com/facebook/dns/dnsrocks/nsid"
+	"github.com/facebook/dns/dnsrocks/rrl"
 	"github.com/facebook/dns/dnsrocks/throttle"
 	"github.com/facebook/dns/dnsrocks/tlsconfig"
 	"github.com/facebook/dns/dnsrocks/whoami"
@@ -47,9 +52,22 @@ import (
 
 // Server collects all of the running servers and the server configurations.
 type Server struct {
-	conf            ServerConfig
-	db              *dnsserver.FBDNSDB
-	servers         []*dns.Server
+	conf ServerConfig
+	db   *dnsserver.FBDNSDB
+	// views are the split-horizon views, each with its own DB.
+	views      []*view
+	servers    []*dns.Server
+	dohServers []*dohServer
+	doqServers []*doqServer
+	// cookieCancel stops the reload of the cookie secrets.
+	cookieCancel context.CancelFunc
+	// dnssecCancel stops the reload of the DNSSEC keys.
+	dnssecCancel context.CancelFunc
+	// tsigSecret holds the secrets of the TSIG keys allowed to update zones.
+	tsigSecret map[string]string
+	// notifiers notify the secondaries of the zones changed by the reloads of
+	// the default DB and of the views.
+	notifiers       []*notify.Notifier
 	stats           stats.Stats
 	metricsExporter anyMetricsExporter
 	// If NotifyStartedFunc is set it is called once the server has started listening.
@@ -105,7 +123,12 @@ func NewServer(conf ServerConfig, logger dnsserver.Logger, stats stats.Stats, me
 	tdb, err := dnsserver.NewFBDNSDB(conf.HandlerConfig, conf.DBConfig, conf.CacheConfig, logger, stats)
 	failOnErr(err, "Error creating TinyDB handle")
 	failOnErr(tdb.Load(), "Error loading TinyDB")
-	return &Server{conf: conf, db: tdb, stats: stats, metricsExporter: metricsExporter}
+	views, err := newViews(&conf, logger, stats)
+	failOnErr(err, "Error creating views")
+	for _, v := range views {
+		failOnErr(v.db.Load(), fmt.Sprintf("Error loading DB of view %s", v.name))
+	}
+	return &Server{conf: conf, db: tdb, views: views, stats: stats, metricsExporter: metricsExporter}
 }
 
 // monitoredReader is a wrapper around dns default reader which serves to log the number of "read"
@@ -148,17 +171,19 @@ func (srv *Server) initUDPServer(addr string, h dns.Handler) (*dns.Server, error
 		return nil, fmt.Errorf("failed to init UDP server: %w", err)
 	}
 	return &dns.Server{
-		Addr:       addr,
-		Net:        "udp",
-		PacketConn: pc,
-		Handler:    h,
+		Addr:          addr,
+		Net:           "udp",
+		PacketConn:    pc,
+		Handler:       h,
+		TsigSecret:    srv.tsigSecret,
+		MsgAcceptFunc: srv.msgAcceptFunc(),
 	}, nil
 }
 
 // initTCPServer opens a monitored TCP socket and returns a DNS server ready
 // for ActivateAndServe.
 func (srv *Server) initTCPServer(addr string, h dns.Handler, s *metrics.Stats) (*dns.Server, error) {
-	l, err := listenTCP(addr, srv.listenConf(), s)
+	l, err := listenTCP(addr, srv.listenConf(), &srv.conf.ProxyProtocol, s)
 	if err != nil {
 		return nil, fmt.Errorf("failed to init TCP server: %w", err)
 	}
@@ -168,6 +193,8 @@ func (srv *Server) initTCPServer(addr string, h dns.Handler, s *metrics.Stats) (
 		Listener:       l,
 		Handler:        h,
 		DecorateReader: newMonitoredReader(l),
+		TsigSecret:     srv.tsigSecret,
+		MsgAcceptFunc:  srv.msgAcceptFunc(),
 	}, nil
 }
 
@@ -180,7 +207,7 @@ func (srv *Server) initTLSServer(ctx context.Context, addr string, h dns.Handler
 		return nil, fmt.Errorf("failed to init TLS config: %w", err)
 	}
 	// This listener is owned by [dns.Server], and will be closed by [dns.Server.Shutdown].
-	l, err := listenTLS(addr, srv.listenConf(), tlsConf, s) //nolint:contextcheck
+	l, err := listenTLS(addr, srv.listenConf(), tlsConf, &srv.conf.ProxyProtocol, s) //nolint:contextcheck
 	if err != nil {
 		return nil, fmt.Errorf("failed to init TCP/TLS server: %w", err)
 	}
@@ -191,9 +218,128 @@ func (srv *Server) initTLSServer(ctx context.Context, addr string, h dns.Handler
 		TLSConfig:      tlsConf,
 		Handler:        h,
 		DecorateReader: newMonitoredReader(l),
+		TsigSecret:     srv.tsigSecret,
+		MsgAcceptFunc:  srv.msgAcceptFunc(),
 	}, nil
 }
 
+// initDoHServer loads TLS certificates and session keys, before opening a
+// monitored HTTPS socket and returns a DoH server ready for ActivateAndServe.
+// The context is used to teminate the session ticket refresh goroutine.
+func (srv *Server) initDoHServer(ctx context.Context, addr string, h dns.Handler, th *throttle.Handler, s *metrics.Stats) (*dohServer, error) {
+	tlsConf, err := tlsconfig.InitTLSConfig(ctx, &srv.conf.TLSConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init TLS config: %w", err)
+	}
+	// This listener is owned by [dohServer], and will be closed by [dohServer.Shutdown].
+	m, l, err := listenDoH(addr, srv.listenConf(), tlsConf, s) //nolint:contextcheck
+	if err != nil {
+		return nil, fmt.Errorf("failed to init DoH server: %w", err)
+	}
+	return &dohServer{
+		Addr:     addr,
+		Net:      "doh",
+		Listener: l,
+		server: &http.Server{
+			Addr:      addr,
+			TLSConfig: tlsConf,
+			Handler: &dohHandler{
+				path:     srv.conf.DoHConfig.Path,
+				handler:  h,
+				throttle: th,
+				monitor:  m,
+			},
+			ReadHeaderTimeout: srv.conf.ReadTimeout,
+			IdleTimeout:       srv.conf.TCPIdleTimeout,
+		},
+	}, nil
+}
+
+// initDoQServer loads TLS certificates and session keys, before opening a
+// monitored QUIC socket and returns a DoQ server ready for ActivateAndServe.
+// The context is used to teminate the session ticket refresh goroutine.
+func (srv *Server) initDoQServer(ctx context.Context, addr string, h dns.Handler, th *throttle.Handler, s *metrics.Stats) (*doqServer, error) {
+	tlsConf, err := tlsconfig.InitTLSConfig(ctx, &srv.conf.TLSConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init TLS config: %w", err)
+	}
+	quicConf := &quic.Config{
+		MaxIdleTimeout: srv.conf.TCPIdleTimeout,
+		// RFC 9250 only uses client-initiated bidirectional streams.
+		MaxIncomingUniStreams: -1,
+	}
+	// This listener is owned by [doqServer], and will be closed by [doqServer.Shutdown].
+	l, err := listenQUIC(addr, srv.listenConf(), tlsConf, quicConf, s) //nolint:contextcheck
+	if err != nil {
+		return nil, fmt.Errorf("failed to init DoQ server: %w", err)
+	}
+	return newDoQServer(addr, l, h, th), nil
+}
+
+// zoneHandlers returns the handlers of the dynamic updates and zone transfers
+// of the zones of tdb, in front of it, which are only added if enabled. The
+// secondaries are notified of the zones changed by its reloads if enabled.
+func (srv *Server) zoneHandlers(tdb *dnsserver.FBDNSDB, s stats.Stats) (plugin.Handler, error) {
+	var handler plugin.Handler = tdb
+	// Only add updateHandler to the plugin chain if some keys may update zones.
+	if srv.conf.UpdateConfig.Enabled() {
+		glog.Infof("Enabling dynamic updates of %s with keys from %s", tdb.DBPath(), srv.conf.UpdateConfig.KeyFile)
+		updateHandler, err := newUpdateHandler(tdb, &srv.conf.UpdateConfig, s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize updateHandler: %w", err)
+		}
+		srv.tsigSecret = updateHandler.secrets()
+		updateHandler.Next = handler
+		handler = updateHandler
+	} else {
+		glog.Infof("-update-keyfile was not specified, not initializing updateHandler")
+	}
+	// Secondaries are notified of the changes of the zones they transfer.
+	if srv.conf.NotifyConfig.Enabled() {
+		glog.Infof("Enabling NOTIFY of the changes of %s to %s", tdb.DBPath(), srv.conf.NotifyConfig.Targets.String())
+		notifier, err := notify.NewNotifier(srv.conf.NotifyConfig, s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize notifier: %w", err)
+		}
+		srv.notifiers = append(srv.notifiers, notifier)
+		tdb.AddReloadObserver(notifier)
+	} else {
+		glog.Infof("-notify was not specified, not notifying secondaries")
+	}
+	// Only add axfrHandler to the plugin chain if some secondaries are allowed.
+	if srv.conf.AXFRConfig.Enabled() {
+		glog.Infof("Enabling AXFR of %s for %s", tdb.DBPath(), srv.conf.AXFRConfig.AllowedSubnets.String())
+		axfrHandler, err := newAXFRHandler(tdb, &srv.conf.AXFRConfig, s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize axfrHandler: %w", err)
+		}
+		axfrHandler.Next = handler
+		handler = axfrHandler
+	} else {
+		glog.Infof("-axfr-allow was not specified, not initializing axfrHandler")
+	}
+	return handler, nil
+}
+
+// healthHandler returns the HTTP handler to which the health state is pushed,
+// which replaces that of the default DB and of all the views.
+func (srv *Server) healthHandler() http.Handler {
+	dbs := []*dnsserver.FBDNSDB{srv.db}
+	for _, v := range srv.views {
+		dbs = append(dbs, v.db)
+	}
+	return dnsserver.HealthHandler(dbs...)
+}
+
+// msgAcceptFunc returns the policy deciding which messages are handled, which
+// only differs from the default one when dynamic updates are enabled.
+func (srv *Server) msgAcceptFunc() dns.MsgAcceptFunc {
+	if srv.tsigSecret == nil {
+		return nil
+	}
+	return acceptUpdates
+}
+
 // listenConf returns the listener config used by individual servers to spawn
 // listeners.
 func (srv *Server) listenConf() net.ListenConfig {
@@ -218,6 +364,10 @@ func (srv *Server) Start() (err error) {
 		nsidHandler      *nsid.Handler
 		throttleHandler  *throttle.Handler
 		throttleLimiter  *throttle.Limiter
+		rrlHandler       *rrl.Handler
+		rrlLimiter       *rrl.Limiter
+		cookieHandler    *cookie.Handler
+		cookieSecrets    *cookie.Secrets
 		numListeners     = srv.conf.ReusePort
 	)
 
@@ -237,6 +387,21 @@ func (srv *Server) Start() (err error) {
 		return srv.conf.TCPIdleTimeout
 	}
 
+	// The zone transfers and updates of the clients of a view are served from
+	// its DB, so its handlers sit behind the viewHandler.
+	if defaultHandler, err = srv.zoneHandlers(srv.db, srv.stats); err != nil {
+		return err
+	}
+	// Only add viewHandler to the plugin chain if some views are configured.
+	if len(srv.views) > 0 {
+		glog.Infof("Enabling %d split-horizon views", len(srv.views))
+		for _, v := range srv.views {
+			if v.handler, err = srv.zoneHandlers(v.db, v.stats); err != nil {
+				return fmt.Errorf("view %q: %w", v.name, err)
+			}
+		}
+		defaultHandler = &viewHandler{views: srv.views, Next: defaultHandler}
+	}
 	if srv.conf.TLSConfig.DoTTLSAEnabled {
 		glog.Infof("Enabling DoTTLSAHandler")
 		if !srv.conf.TLS {
@@ -296,6 +461,47 @@ func (srv *Server) Start() (err error) {
 		go throttle.Monitor(throttleLimiter, srv.stats, time.Second)
 	}
 
+	// Share one response rate limiter across all IPs.
+	if srv.conf.RRLConfig.Enabled() {
+		glog.Infof("Enabling RRL with %d responses per second, log-only=%v", srv.conf.RRLConfig.ResponsesPerSecond, srv.conf.RRLConfig.LogOnly)
+		if rrlLimiter, err = rrl.NewLimiter(srv.conf.RRLConfig); err != nil {
+			return fmt.Errorf("failed to initialize RRL: %w", err)
+		}
+	} else {
+		glog.Info("-rrl-responses-per-second was not specified, disabling RRL")
+	}
+
+	// Share the cookie secrets across all IPs.
+	if srv.conf.CookieConfig.Enabled {
+		glog.Infof("Enabling DNS Cookies with require=%v, exempt=%v", srv.conf.CookieConfig.Require, srv.conf.CookieConfig.Exempt)
+		var ctx context.Context
+		ctx, srv.cookieCancel = context.WithCancel(context.Background())
+		if cookieSecrets, err = cookie.NewSecrets(ctx, &srv.conf.CookieConfig); err != nil {
+			return fmt.Errorf("failed to initialize cookie secrets: %w", err)
+		}
+	} else {
+		glog.Info("-cookie was not specified, disabling DNS Cookies")
+	}
+
+	var dnssecKeys *dnssecKeys
+	if srv.conf.DNSSECConfig.Zones != "" && srv.conf.DNSSECConfig.Keys != "" {
+		if dnssecKeys, err = newDNSSECKeys(&srv.conf.DNSSECConfig, srv.stats); err != nil {
+			return fmt.Errorf("failed to load DNSSEC keys: %w", err)
+		}
+		var ctx context.Context
+		ctx, srv.dnssecCancel = context.WithCancel(context.Background())
+		go dnssecKeys.run(ctx)
+	}
+
+	if srv.conf.HealthAddr != "" {
+		glog.Infof("Serving the health state endpoint on %s", srv.conf.HealthAddr)
+		go func() {
+			if err := http.ListenAndServe(srv.conf.HealthAddr, srv.healthHandler()); err != nil {
+				glog.Errorf("Health state endpoint failed: %v", err)
+			}
+		}()
+	}
+
 	// For each configured IP, we may start a number of DNS servers for each
 	// transport protocol.
 	for ip, maxAns := range srv.conf.IPAns {
@@ -308,16 +514,12 @@ func (srv *Server) Start() (err error) {
 			defaultHandler: maxAnswerHandler,
 		}
 
-		if srv.conf.DNSSECConfig.Zones != "" && srv.conf.DNSSECConfig.Keys != "" {
+		if dnssecKeys != nil {
 			glog.Infof(
 				"Enabling DNSSEC Handler for Zones: '%s', Keys: '%s'",
 				srv.conf.DNSSECConfig.Zones,
 				srv.conf.DNSSECConfig.Keys)
-			dnssecHandler, err := newDNSSECHandler(srv, maxAnswerHandler)
-			if err != nil {
-				return fmt.Errorf("failed to initialize dnssecHandler: %w", err)
-			}
-			handler.defaultHandler = dnssecHandler
+			handler.defaultHandler = newDNSSECHandler(srv, dnssecKeys, maxAnswerHandler)
 		} else {
 			glog.Infof(
 				"Not enabling DNSSEC Handler, either DNSSEC zones or keys are not specified. Zones: '%s', Keys: '%s'",
@@ -337,10 +539,25 @@ func (srv *Server) Start() (err error) {
 			glog.Infof("Max UDP size not set")
 		}
 
+		if rrlLimiter != nil {
+			rrlHandler = rrl.NewHandler(rrlLimiter, srv.stats)
+			rrlHandler.Next = handler.defaultHandler
+			handler.defaultHandler = rrlHandler
+		}
+
+		if cookieSecrets != nil {
+			cookieHandler = cookie.NewHandler(cookieSecrets, &srv.conf.CookieConfig, srv.stats)
+			cookieHandler.Next = handler.defaultHandler
+			handler.defaultHandler = cookieHandler
+		}
+
 		if throttleLimiter != nil {
 			throttleHandler = throttle.NewHandler(throttleLimiter)
 			throttleHandler.Next = handler.defaultHandler
 			handler.defaultHandler = throttleHandler
+			if cookieHandler != nil && srv.conf.CookieConfig.Exempt {
+				throttleHandler.Bypass = cookieHandler.ValidCookie
+			}
 		}
 
 		addr := joinAddress(ip, srv.conf.Port)
@@ -420,6 +637,53 @@ func (srv *Server) Start() (err error) {
 					cancel()
 				}()
 			}
+
+			// Optionally start a DoH server for the address as well.
+			if srv.conf.DoH {
+				addr := joinAddress(ip, srv.conf.DoHConfig.Port)
+				ctx, cancel := context.WithCancel(context.Background())
+				s, err := srv.initDoHServer(ctx, addr, handler, throttleHandler, stats)
+				if err != nil {
+					cancel()
+					return err
+				}
+				s.NotifyStartedFunc = srv.NotifyStartedFunc
+				srv.dohServers = append(srv.dohServers, s)
+				// Server never calls Done() method, it only provides
+				// this wg for client to use.
+				srv.ServersStartedWG.Add(1)
+				go func() {
+					err := s.ActivateAndServe()
+					if err != nil {
+						glog.Errorf("DoH server for %s failed to start: %v", addr, err)
+					}
+					cancel()
+				}()
+			}
+
+			// Optionally start a DoQ server for the address as well.
+			if srv.conf.DoQ {
+				addr := joinAddress(ip, srv.conf.DoQConfig.Port)
+				ctx, cancel := context.WithCancel(context.Background())
+				s, err := srv.initDoQServer(ctx, addr, handler, throttleHandler, stats)
+				if err != nil {
+					cancel()
+					return err
+				}
+				s.ReadTimeout = srv.conf.ReadTimeout
+				s.NotifyStartedFunc = srv.NotifyStartedFunc
+				srv.doqServers = append(srv.doqServers, s)
+				// Server never calls Done() method, it only provides
+				// this wg for client to use.
+				srv.ServersStartedWG.Add(1)
+				go func() {
+					err := s.ActivateAndServe()
+					if err != nil {
+						glog.Errorf("DoQ server for %s failed to start: %v", addr, err)
+					}
+					cancel()
+				}()
+			}
 		}
 	}
 
@@ -428,7 +692,7 @@ func (srv *Server) Start() (err error) {
 
 // Shutdown shuts down all the underlying servers and close the DB.
 func (srv *Server) Shutdown() {
-	glog.Infof("Shutting down %d servers", len(srv.servers))
+	glog.Infof("Shutting down %d servers", len(srv.servers)+len(srv.dohServers)+len(srv.doqServers))
 	for _, s := range srv.servers {
 		glog.Infof("Shutting down %s/%s", s.Addr, s.Net)
 		err := s.Shutdown()
@@ -436,12 +700,45 @@ func (srv *Server) Shutdown() {
 			glog.Errorf("%v", err)
 		}
 	}
+	for _, s := range srv.dohServers {
+		glog.Infof("Shutting down %s/%s", s.Addr, s.Net)
+		err := s.Shutdown()
+		if err != nil {
+			glog.Errorf("%v", err)
+		}
+	}
+	for _, s := range srv.doqServers {
+		glog.Infof("Shutting down %s/%s", s.Addr, s.Net)
+		err := s.Shutdown()
+		if err != nil {
+			glog.Errorf("%v", err)
+		}
+	}
+	if srv.cookieCancel != nil {
+		srv.cookieCancel()
+	}
+	if srv.dnssecCancel != nil {
+		srv.dnssecCancel()
+	}
 	srv.db.Close()
+	for _, v := range srv.views {
+		v.db.Close()
+	}
+	for _, n := range srv.notifiers {
+		n.Close()
+	}
 }
 
-// ReloadDB refreshes the data view
+// ReloadDB refreshes the data view of each DB layer, and those of all the views
 func (srv *Server) ReloadDB() {
-	srv.db.ReloadChan <- *dnsserver.NewPartialReloadSignal()
+	for layer := 0; layer <= len(srv.conf.DBConfig.Overlays); layer++ {
+		srv.db.ReloadChan <- *dnsserver.NewLayerReloadSignal(dnsserver.PartialReload, layer, "")
+	}
+	for _, v := range srv.views {
+		for layer := 0; layer <= len(v.dbConfig.Overlays); layer++ {
+			v.db.ReloadChan <- *dnsserver.NewLayerReloadSignal(dnsserver.PartialReload, layer, "")
+		}
+	}
 }
 
 // ValidateDbKey checks whether record of certain key is in db
@@ -450,8 +747,8 @@ func (srv *Server) ValidateDbKey(dbKey []byte) error {
 }
 
 // getDBTimestamp will lookup `dbTimestampKey` in the DB and log its value in
-// `cdb.timestamp` counter.
-func (srv *Server) getDBTimestamp() error {
+// `cdb.timestamp` counter of stats.
+func getDBTimestamp(tdb *dnsserver.FBDNSDB, stats stats.Stats) error {
 	var (
 		err            error
 		rec            db.ResourceRecord
@@ -459,7 +756,7 @@ func (srv *Server) getDBTimestamp() error {
 		timestampFound bool
 	)
 
-	srv.stats.IncrementCounter(DBTimestampNumRun)
+	stats.IncrementCounter(DBTimestampNumRun)
 	parseResult := func(result []byte) error {
 		if errors.Is(err, io.EOF) {
 			return nil
@@ -474,7 +771,7 @@ func (srv *Server) getDBTimestamp() error {
 		if rec.Qtype == dns.TypeTXT {
 			timestamp, err = strconv.ParseInt(string(result[rec.Offset+1:]), 10, 64)
 			if err != nil {
-				srv.stats.IncrementCounter(DBTimestampInvalidTXT)
+				stats.IncrementCounter(DBTimestampInvalidTXT)
 				// nolint: nilerr
 				return nil
 			}
@@ -483,25 +780,25 @@ func (srv *Server) getDBTimestamp() error {
 		return nil
 	}
 
-	reader, err := srv.db.AcquireReader()
+	reader, err := tdb.AcquireReader()
 	if err != nil {
-		srv.stats.IncrementCounter(DBTimestampDBReadError)
+		stats.IncrementCounter(DBTimestampDBReadError)
 		return err
 	}
 	err = reader.ForEachResourceRecord(dbTimestampName, db.ZeroID, parseResult)
 	reader.Close()
 	if err != nil {
-		srv.stats.IncrementCounter(DBTimestampKeySearchError)
+		stats.IncrementCounter(DBTimestampKeySearchError)
 		return err
 	}
 	if !timestampFound {
-		srv.stats.IncrementCounter(DBTimestampKeyNotFound)
+		stats.IncrementCounter(DBTimestampKeyNotFound)
 		return fmt.Errorf("timestamp key not found")
 	}
-	srv.stats.ResetCounterTo(DBTimestamp, timestamp)
+	stats.ResetCounterTo(DBTimestamp, timestamp)
 
 	freshness := time.Now().Unix() - timestamp
-	srv.stats.ResetCounterTo(DBFreshness, freshness)
+	stats.ResetCounterTo(DBFreshness, freshness)
 
 	return nil
 }
@@ -511,11 +808,28 @@ func (srv *Server) DumpBackendStats() {
 	ticker := time.NewTicker(BackendStatsInterval * time.Second)
 	for range ticker.C {
 		srv.db.ReportBackendStats()
+		for _, v := range srv.views {
+			v.db.ReportBackendStats()
+		}
 	}
 }
 
-// LogMapAge will log DB timestamp every `DBTimestampInterval`.
+// LogMapAge will log DB timestamp every `DBTimestampInterval`, for the
+// default DB and those of the views.
 func (srv *Server) LogMapAge() {
+	resetDBTimestampCounters(srv.stats)
+	for _, v := range srv.views {
+		resetDBTimestampCounters(v.stats)
+	}
+	srv.logMapAge()
+	ticker := time.NewTicker(DBTimestampInterval * time.Second)
+	for range ticker.C {
+		srv.logMapAge()
+	}
+}
+
+// resetDBTimestampCounters resets the counters of getDBTimestamp in stats.
+func resetDBTimestampCounters(stats stats.Stats) {
 	for _, k := range []string{
 		DBTimestampDBReadError,
 		DBTimestampInvalidTXT,
@@ -524,15 +838,18 @@ func (srv *Server) LogMapAge() {
 		DBTimestampNumRun,
 		DBTimestamp,
 	} {
-		srv.stats.ResetCounter(k)
+		stats.ResetCounter(k)
 	}
-	if err := srv.getDBTimestamp(); err != nil {
+}
+
+// logMapAge logs the DB timestamp of the default DB and those of the views.
+func (srv *Server) logMapAge() {
+	if err := getDBTimestamp(srv.db, srv.stats); err != nil {
 		glog.Errorf("LogMapAge: %s", err)
 	}
-	ticker := time.NewTicker(DBTimestampInterval * time.Second)
-	for range ticker.C {
-		if err := srv.getDBTimestamp(); err != nil {
-			glog.Errorf("LogMapAge: %s", err)
+	for _, v := range srv.views {
+		if err := getDBTimestamp(v.db, v.stats); err != nil {
+			glog.Errorf("LogMapAge of view %s: %s", v.name, err)
 		}
 	}
 }
@@ -17,11 +17,15 @@
 package fbserver
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"math"
 	"net"
+	"net/http"
 	"os"
 	"runtime"
 	"strconv"
@@ -29,11 +33,15 @@ import (
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/pires/go-proxyproto"
+	"github.com/quic-go/quic-go"
 	"github.com/stretchr/testify/require"
 
+	"github.com/facebook/dns/dnsrocks/cookie"
 	"github.com/facebook/dns/dnsrocks/dnsserver"
 	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
 	"github.com/facebook/dns/dnsrocks/metrics"
+	"github.com/facebook/dns/dnsrocks/rrl"
 	"github.com/facebook/dns/dnsrocks/testaid"
 )
 
@@ -75,6 +83,12 @@ func makeTestServer(t testing.TB, config ServerConfig) (map[string]string, *Serv
 	if config.TLS {
 		numServers++
 	}
+	if config.DoH {
+		numServers++
+	}
+	if config.DoQ {
+		numServers++
+	}
 	serverUpChan := make(chan string)
 	onUp := func() {
 		serverUpChan <- "up"
@@ -95,6 +109,12 @@ func makeTestServer(t testing.TB, config ServerConfig) (map[string]string, *Serv
 			m[s.Net] = s.PacketConn.LocalAddr().String()
 		}
 	}
+	for _, s := range srv.dohServers {
+		m[s.Net] = s.Listener.Addr().String()
+	}
+	for _, s := range srv.doqServers {
+		m[s.Net] = s.Listener.Addr().String()
+	}
 	return m, srv
 }
 
@@ -126,6 +146,32 @@ func RunUDPTLSTestServer(t *testing.T) (map[string]string, *Server) {
 	return makeTestServer(t, config)
 }
 
+// RunUDPDoHTestServer spins up a standalone UDP DNS server.
+// returns a map of `network`/listening address.
+// network should only be `udp` or `doh` in this case.
+func RunUDPDoHTestServer(t *testing.T) (map[string]string, *Server) {
+	certfile := testaid.MkTestCert(t)
+	defer os.Remove(certfile)
+	config := makeTestServerConfig(false, false)
+	config.DoH = true
+	config.TLSConfig.CertFile = certfile
+	config.TLSConfig.KeyFile = certfile
+	return makeTestServer(t, config)
+}
+
+// RunUDPDoQTestServer spins up a standalone UDP DNS server.
+// returns a map of `network`/listening address.
+// network should only be `udp` or `doq` in this case.
+func RunUDPDoQTestServer(t *testing.T) (map[string]string, *Server) {
+	certfile := testaid.MkTestCert(t)
+	defer os.Remove(certfile)
+	config := makeTestServerConfig(false, false)
+	config.DoQ = true
+	config.TLSConfig.CertFile = certfile
+	config.TLSConfig.KeyFile = certfile
+	return makeTestServer(t, config)
+}
+
 // TestRunUDPTestServer simple test to ensure that we only create a UDP server.
 func TestRunUDPTestServer(t *testing.T) {
 	portMap, srv := RunUDPTestServer(t)
@@ -288,6 +334,322 @@ func TestTLSDNSServerWithQueries(t *testing.T) {
 	}
 }
 
+// TestProxyProtocol tests that the client address carried by the PROXY
+// header of trusted proxies is the one seen by the handlers, on both the TCP
+// and TLS listeners.
+func TestProxyProtocol(t *testing.T) {
+	certfile := testaid.MkTestCert(t)
+	defer os.Remove(certfile)
+	config := makeTestServerConfig(true, true)
+	config.TLSConfig.CertFile = certfile
+	config.TLSConfig.KeyFile = certfile
+	config.WhoamiDomain = "whoami.example.com"
+	config.ProxyProtocol.Enabled = true
+	require.Nil(t, config.ProxyProtocol.TrustedSubnets.Set("::1/128"))
+	portMap, srv := makeTestServer(t, config)
+	defer srv.Shutdown()
+
+	src := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5353}
+	for _, network := range []string{"tcp", "tcp-tls"} {
+		t.Run(network, func(t *testing.T) {
+			conn, err := net.Dial("tcp", portMap[network])
+			require.Nil(t, err)
+			defer conn.Close()
+			header := proxyproto.HeaderProxyFromAddrs(2, src, conn.RemoteAddr())
+			_, err = header.WriteTo(conn)
+			require.Nil(t, err)
+			if network == "tcp-tls" {
+				conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+			}
+
+			m := new(dns.Msg)
+			m.SetQuestion("whoami.example.com.", dns.TypeTXT)
+			r := exchange(t, &dns.Conn{Conn: conn}, new(dns.Client), m)
+			require.Contains(t, txtStrings(r), "source "+src.String())
+		})
+	}
+}
+
+// TestProxyProtocolUntrusted tests that connections from peers outside of the
+// trusted subnets are served without parsing a PROXY header.
+func TestProxyProtocolUntrusted(t *testing.T) {
+	config := makeTestServerConfig(true, false)
+	config.WhoamiDomain = "whoami.example.com"
+	config.ProxyProtocol.Enabled = true
+	require.Nil(t, config.ProxyProtocol.TrustedSubnets.Set("192.0.2.0/24"))
+	portMap, srv := makeTestServer(t, config)
+	defer srv.Shutdown()
+
+	c := new(dns.Client)
+	c.Net = "tcp"
+	co, err := c.Dial(portMap["tcp"])
+	require.Nil(t, err)
+	defer co.Close()
+	m := new(dns.Msg)
+	m.SetQuestion("whoami.example.com.", dns.TypeTXT)
+	r := exchange(t, co, c, m)
+	require.Contains(t, txtStrings(r), "source "+co.LocalAddr().String())
+}
+
+// txtStrings returns the strings of all the TXT records in the answer section.
+func txtStrings(r *dns.Msg) []string {
+	var txts []string
+	for _, rr := range r.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			txts = append(txts, txt.Txt...)
+		}
+	}
+	return txts
+}
+
+// TestCookieExemptsFromRRL tests that queries with a valid server cookie are
+// exempt from response rate limiting, when configured to.
+func TestCookieExemptsFromRRL(t *testing.T) {
+	config := makeTestServerConfig(false, false)
+	config.RRLConfig = rrl.Config{
+		ResponsesPerSecond: 1,
+		Window:             time.Second,
+		IPv4PrefixLen:      24,
+		IPv6PrefixLen:      56,
+		MaxEntries:         100,
+	}
+	config.CookieConfig = cookie.Config{Enabled: true, Exempt: true}
+	portMap, srv := makeTestServer(t, config)
+	defer srv.Shutdown()
+
+	c := new(dns.Client)
+	co, err := c.Dial(portMap["udp"])
+	require.Nil(t, err)
+	defer co.Close()
+	query := func(cookie string) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion("foo2.example.com.", dns.TypeA)
+		m.SetEdns0(4096, false)
+		m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+		return m
+	}
+
+	// Learn a server cookie, which uses the only token of the bucket.
+	r := exchange(t, co, c, query("0102030405060708"))
+	require.NotEmpty(t, r.Answer)
+	var clientServer string
+	for _, o := range r.IsEdns0().Option {
+		if ck, ok := o.(*dns.EDNS0_COOKIE); ok {
+			clientServer = ck.Cookie
+		}
+	}
+	require.Len(t, clientServer, 48)
+
+	for range 5 {
+		r = exchange(t, co, c, query(clientServer))
+		require.NotEmpty(t, r.Answer)
+	}
+
+	// Without the server cookie, responses are dropped.
+	m := query("0102030405060708")
+	require.Nil(t, co.WriteMsg(m))
+	require.Nil(t, co.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, err = co.ReadMsg()
+	require.Error(t, err)
+}
+
+// TestRunUDPDoHTestServer simple test to ensure that we create a DoH server.
+// Currently, there is always a UDP server which is started.
+func TestRunUDPDoHTestServer(t *testing.T) {
+	portMap, srv := RunUDPDoHTestServer(t)
+	defer srv.Shutdown()
+	require.Len(t, portMap, 2)
+	require.Contains(t, portMap, "udp")
+	require.Contains(t, portMap, "doh")
+}
+
+// TestDoHDNSServerWithQueries creates a standalone DoH server and test a
+// couple of DNS queries against it, using both GET and POST.
+func TestDoHDNSServerWithQueries(t *testing.T) {
+	portMap, srv := RunUDPDoHTestServer(t)
+	defer srv.Shutdown()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		},
+		Timeout: 2 * time.Second,
+	}
+	url := fmt.Sprintf("https://%s%s", portMap["doh"], dohDefaultPath)
+
+	testCases := []struct {
+		qname  string
+		target string
+	}{
+		{
+			qname:  "foo2.example.com.",
+			target: "some-other.domain.",
+		},
+		{
+			qname:  "cnamemap.example.net.",
+			target: "bar.example.net.",
+		},
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		for _, tc := range testCases {
+			t.Run(method+"/"+tc.qname, func(t *testing.T) {
+				m := new(dns.Msg)
+				m.SetQuestion(tc.qname, dns.TypeA)
+				m.Id = 0
+				buf, err := m.Pack()
+				require.Nil(t, err)
+
+				var req *http.Request
+				if method == http.MethodGet {
+					req, err = http.NewRequest(method, url+"?dns="+base64.RawURLEncoding.EncodeToString(buf), nil)
+				} else {
+					req, err = http.NewRequest(method, url, bytes.NewReader(buf))
+					req.Header.Set("Content-Type", dohMimeType)
+				}
+				require.Nil(t, err)
+				req.Header.Set("Accept", dohMimeType)
+
+				resp, err := client.Do(req)
+				require.Nil(t, err)
+				defer resp.Body.Close()
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+				require.Equal(t, dohMimeType, resp.Header.Get("Content-Type"))
+				require.Equal(t, 2, resp.ProtoMajor)
+				body, err := io.ReadAll(resp.Body)
+				require.Nil(t, err)
+
+				r := new(dns.Msg)
+				require.Nil(t, r.Unpack(body))
+				require.NotEqual(t, 0, len(r.Answer))
+
+				cname := r.Answer[0].(*dns.CNAME).Target
+				require.Equal(t, tc.target, cname)
+				require.Equal(t, fmt.Sprintf("max-age=%d", r.Answer[0].Header().Ttl), resp.Header.Get("Cache-Control"))
+			})
+		}
+	}
+}
+
+// TestRunUDPDoQTestServer simple test to ensure that we create a DoQ server.
+// Currently, there is always a UDP server which is started.
+func TestRunUDPDoQTestServer(t *testing.T) {
+	portMap, srv := RunUDPDoQTestServer(t)
+	defer srv.Shutdown()
+	require.Len(t, portMap, 2)
+	require.Contains(t, portMap, "udp")
+	require.Contains(t, portMap, "doq")
+}
+
+// exchangeDoQ sends a query on a new stream of a DoQ connection and reads
+// back the response.
+func exchangeDoQ(t *testing.T, conn *quic.Conn, m *dns.Msg) *dns.Msg {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	stream, err := conn.OpenStreamSync(ctx)
+	require.Nil(t, err)
+	require.Nil(t, stream.SetDeadline(time.Now().Add(2*time.Second)))
+
+	buf, err := m.Pack()
+	require.Nil(t, err)
+	_, err = stream.Write(append([]byte{byte(len(buf) >> 8), byte(len(buf))}, buf...))
+	require.Nil(t, err)
+	// The client must indicate the end of the query with a FIN.
+	require.Nil(t, stream.Close())
+
+	resp, err := io.ReadAll(stream)
+	require.Nil(t, err)
+	require.GreaterOrEqual(t, len(resp), 2)
+	require.Equal(t, len(resp)-2, int(resp[0])<<8|int(resp[1]))
+	r := new(dns.Msg)
+	require.Nil(t, r.Unpack(resp[2:]))
+	return r
+}
+
+// TestDoQDNSServerWithQueries creates a standalone DoQ server and test a
+// couple of DNS queries against it, one stream per query over a single
+// connection.
+func TestDoQDNSServerWithQueries(t *testing.T) {
+	portMap, srv := RunUDPDoQTestServer(t)
+	defer srv.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, portMap["doq"], &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{doqALPN},
+	}, nil)
+	require.Nil(t, err)
+	defer conn.CloseWithError(doqNoError, "") //nolint:errcheck
+
+	testCases := []struct {
+		qname  string
+		target string
+	}{
+		{
+			qname:  "foo2.example.com.",
+			target: "some-other.domain.",
+		},
+		{
+			qname:  "cnamemap.example.net.",
+			target: "bar.example.net.",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.qname, func(t *testing.T) {
+			m := new(dns.Msg)
+			m.SetQuestion(tc.qname, dns.TypeA)
+			m.Id = 0
+			r := exchangeDoQ(t, conn, m)
+			require.Equal(t, uint16(0), r.Id)
+			require.NotEqual(t, 0, len(r.Answer))
+
+			cname := r.Answer[0].(*dns.CNAME).Target
+			require.Equal(t, tc.target, cname)
+		})
+	}
+
+	stats := srv.doqServers[0].Listener.stats.Get()
+	require.Equal(t, int64(1), stats[formatConnectionMonitorStatName(monitorDoQ, "Accept", true)])
+	require.Equal(t, int64(len(testCases)), stats[formatStreamMonitorStatName(monitorDoQ, "Accept", true)])
+	require.Equal(t, int64(len(testCases)), stats[formatMonitorStatName(monitorDoQ, "Read")])
+}
+
+// TestDoQNonZeroMessageID tests that the server closes connections sending
+// queries with a non-zero message ID, as mandated by RFC 9250.
+func TestDoQNonZeroMessageID(t *testing.T) {
+	portMap, srv := RunUDPDoQTestServer(t)
+	defer srv.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, portMap["doq"], &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{doqALPN},
+	}, nil)
+	require.Nil(t, err)
+
+	m := new(dns.Msg)
+	m.SetQuestion("foo2.example.com.", dns.TypeA)
+	m.Id = 1234
+	stream, err := conn.OpenStreamSync(ctx)
+	require.Nil(t, err)
+	buf, err := m.Pack()
+	require.Nil(t, err)
+	_, err = stream.Write(append([]byte{byte(len(buf) >> 8), byte(len(buf))}, buf...))
+	require.Nil(t, err)
+	require.Nil(t, stream.Close())
+
+	select {
+	case <-conn.Context().Done():
+	case <-ctx.Done():
+		require.Fail(t, "connection was not closed")
+	}
+	var appErr *quic.ApplicationError
+	require.ErrorAs(t, context.Cause(conn.Context()), &appErr)
+	require.Equal(t, quic.ApplicationErrorCode(doqProtocolError), appErr.ErrorCode)
+}
+
 // exchange sends and reads a message over an existing connection.
 // This is heavily copied from github.com/miekg/dns/client.go code at
 // https://fburl.com/puemzrx0
@@ -0,0 +1,743 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb/dbdiff"
+	"github.com/facebook/dns/dnsrocks/dnsserver"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/logger"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+// tsigFudge is the time difference, in seconds, allowed between the signing
+// of a response and its verification.
+const tsigFudge = 300
+
+// UpdateConfig contains the config for dynamic updates (RFC 2136).
+type UpdateConfig struct {
+	// KeyFile is the path of the TSIG keys allowed to update zones, as BIND
+	// key statements listing the zones each key may update. Updates are
+	// disabled when empty.
+	KeyFile string
+}
+
+// Enabled returns whether dynamic updates are enabled.
+func (c *UpdateConfig) Enabled() bool {
+	return c.KeyFile != ""
+}
+
+// tsigKey is a TSIG key allowed to update zones.
+type tsigKey struct {
+	algorithm string
+	secret    string
+	// zones are the zones the key may update.
+	zones []string
+}
+
+// tsigAlgorithms are the algorithms TSIG keys may use.
+var tsigAlgorithms = map[string]bool{
+	dns.HmacSHA1:   true,
+	dns.HmacSHA224: true,
+	dns.HmacSHA256: true,
+	dns.HmacSHA384: true,
+	dns.HmacSHA512: true,
+}
+
+// keyFileTokens splits a key file into its tokens, dropping comments and
+// the quotes of strings.
+func keyFileTokens(data string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(data); {
+		switch c := data[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '#' || strings.HasPrefix(data[i:], "//"):
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case c == '{' || c == '}' || c == ';':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			j := strings.IndexByte(data[i+1:], '"')
+			if j < 0 {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, data[i+1:i+1+j])
+			i += j + 2
+		default:
+			j := i
+			for j < len(data) && !strings.ContainsRune(" \t\r\n{};\"#", rune(data[j])) {
+				j++
+			}
+			tokens = append(tokens, data[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// parseTSIGKeys parses BIND key statements, with a zone clause for each zone
+// the key may update, e.g.
+//
+//	key "update.example.com." {
+//		algorithm hmac-sha256;
+//		secret "c2VjcmV0";
+//		zone "example.com.";
+//	};
+func parseTSIGKeys(data string) (map[string]tsigKey, error) {
+	tokens, err := keyFileTokens(data)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]tsigKey)
+	for len(tokens) > 0 {
+		if len(tokens) < 3 || tokens[0] != "key" || tokens[2] != "{" {
+			return nil, fmt.Errorf("expected key statement, got %q", tokens[0])
+		}
+		name := dns.CanonicalName(tokens[1])
+		tokens = tokens[3:]
+		var key tsigKey
+		for len(tokens) > 0 && tokens[0] != "}" {
+			if len(tokens) < 3 || tokens[2] != ";" {
+				return nil, fmt.Errorf("malformed %q clause in key %s", tokens[0], name)
+			}
+			switch tokens[0] {
+			case "algorithm":
+				key.algorithm = dns.CanonicalName(tokens[1])
+			case "secret":
+				key.secret = tokens[1]
+			case "zone":
+				key.zones = append(key.zones, dns.CanonicalName(tokens[1]))
+			default:
+				return nil, fmt.Errorf("unknown %q clause in key %s", tokens[0], name)
+			}
+			tokens = tokens[3:]
+		}
+		if len(tokens) < 2 || tokens[1] != ";" {
+			return nil, fmt.Errorf("unterminated key %s", name)
+		}
+		tokens = tokens[2:]
+		if !tsigAlgorithms[key.algorithm] {
+			return nil, fmt.Errorf("unsupported algorithm %q for key %s", key.algorithm, name)
+		}
+		if s, err := base64.StdEncoding.DecodeString(key.secret); err != nil || len(s) == 0 {
+			return nil, fmt.Errorf("invalid secret for key %s", name)
+		}
+		if len(key.zones) == 0 {
+			return nil, fmt.Errorf("no zone for key %s", name)
+		}
+		keys[name] = key
+	}
+	return keys, nil
+}
+
+// loadTSIGKeys loads the keys of a key file.
+func loadTSIGKeys(path string) (map[string]tsigKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseTSIGKeys(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse TSIG keys from %s: %w", path, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no TSIG key in %s", path)
+	}
+	return keys, nil
+}
+
+// acceptUpdates extends dns.DefaultMsgAcceptFunc to UPDATE messages, whose
+// prerequisite and update sections may hold any number of records.
+func acceptUpdates(dh dns.Header) dns.MsgAcceptAction {
+	const qr = 1 << 15
+	if opcode := int(dh.Bits>>11) & 0xF; opcode == dns.OpcodeUpdate && dh.Bits&qr == 0 && dh.Qdcount == 1 {
+		return dns.MsgAccept
+	}
+	return dns.DefaultMsgAcceptFunc(dh)
+}
+
+// errUpdateDBLocked is returned when the DB updates are written to is held
+// open by another writer, e.g. dnsrocks-applyrdb or a secondary.
+var errUpdateDBLocked = errors.New("DB is locked by another writer")
+
+// updateDB is the DB updates are written to, a RocksDB opened as its primary.
+type updateDB interface {
+	IsV2KeySyntaxUsed() bool
+	NameRecords(name []byte) ([]dnsdata.MapRecord, error)
+	ApplyEntries(entries []*dbdiff.Entry) error
+	Flush() error
+	Close() error
+}
+
+type updateHandler struct {
+	db    *dnsserver.FBDNSDB
+	keys  map[string]tsigKey
+	stats stats.Stats
+	// mu serializes updates, which read the records they change.
+	mu   sync.Mutex
+	Next plugin.Handler
+}
+
+// newUpdateHandler initializes a new updateHandler.
+// It applies the UPDATE messages signed by one of the keys of the key file to
+// the default location records of the zones whose SOA is in the DB, and then
+// reloads the DB so that the changes are served.
+func newUpdateHandler(tdb *dnsserver.FBDNSDB, conf *UpdateConfig, stats stats.Stats) (*updateHandler, error) {
+	keys, err := loadTSIGKeys(conf.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if tdb.DBDriver() != "rocksdb" {
+		glog.Warningf("Dynamic updates are not supported by the %s driver, and will be rejected", tdb.DBDriver())
+	}
+	return &updateHandler{db: tdb, keys: keys, stats: stats}, nil
+}
+
+// secrets returns the secrets of the keys, as expected by [dns.Server].
+func (h *updateHandler) secrets() map[string]string {
+	secrets := make(map[string]string, len(h.keys))
+	for name, key := range h.keys {
+		secrets[name] = key.secret
+	}
+	return secrets
+}
+
+func (h *updateHandler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	if r.Opcode != dns.OpcodeUpdate {
+		return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+	}
+	h.stats.IncrementCounter("DNS_update.requests")
+	state := request.Request{W: w, Req: r}
+
+	m := new(dns.Msg)
+	rcode := h.authenticate(state)
+	if rcode == dns.RcodeSuccess {
+		rcode = h.authorize(state)
+		if rcode == dns.RcodeSuccess {
+			rcode = h.update(state)
+		}
+		if rcode == dns.RcodeSuccess {
+			h.stats.IncrementCounter("DNS_update.success")
+		}
+		m.SetRcode(r, rcode)
+		state.SizeAndDo(m)
+		// Responses to authenticated requests are signed with the same key.
+		t := r.IsTsig()
+		m.SetTsig(t.Hdr.Name, t.Algorithm, tsigFudge, time.Now().Unix())
+	} else {
+		m.SetRcode(r, rcode)
+		state.SizeAndDo(m)
+	}
+	if err := w.WriteMsg(m); err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	return rcode, nil
+}
+
+// authenticate checks that the request is signed by one of the keys. The
+// signature itself is verified by [dns.Server], which DoH and DoQ requests do
+// not go through.
+func (h *updateHandler) authenticate(state request.Request) int {
+	if _, ok := logger.RequestTransport(state); ok {
+		h.stats.IncrementCounter("DNS_update.refused")
+		return dns.RcodeRefused
+	}
+	t := state.Req.IsTsig()
+	if t == nil {
+		h.stats.IncrementCounter("DNS_update.refused")
+		return dns.RcodeRefused
+	}
+	key, ok := h.keys[dns.CanonicalName(t.Hdr.Name)]
+	if !ok || key.algorithm != dns.CanonicalName(t.Algorithm) {
+		h.stats.IncrementCounter("DNS_update.notauth")
+		glog.Warningf("Update of %s from %s: unknown key %s", state.Name(), state.IP(), t.Hdr.Name)
+		return dns.RcodeNotAuth
+	}
+	if err := state.W.TsigStatus(); err != nil {
+		h.stats.IncrementCounter("DNS_update.notauth")
+		glog.Warningf("Update of %s from %s: %v", state.Name(), state.IP(), err)
+		return dns.RcodeNotAuth
+	}
+	return dns.RcodeSuccess
+}
+
+// authorize checks that the key of an authenticated request may update its
+// zone.
+func (h *updateHandler) authorize(state request.Request) int {
+	t := state.Req.IsTsig()
+	zone := dns.CanonicalName(state.Name())
+	if !slices.Contains(h.keys[dns.CanonicalName(t.Hdr.Name)].zones, zone) {
+		h.stats.IncrementCounter("DNS_update.refused")
+		glog.Warningf("Update of %s from %s: key %s may not update the zone", zone, state.IP(), t.Hdr.Name)
+		return dns.RcodeRefused
+	}
+	return dns.RcodeSuccess
+}
+
+// update applies an authenticated request, and returns the rcode to reply
+// with.
+func (h *updateHandler) update(state request.Request) int {
+	r := state.Req
+	if len(r.Question) != 1 || r.Question[0].Qtype != dns.TypeSOA || r.Question[0].Qclass != dns.ClassINET {
+		h.stats.IncrementCounter("DNS_update.formerr")
+		return dns.RcodeFormatError
+	}
+	if h.db.DBDriver() != "rocksdb" {
+		h.stats.IncrementCounter("DNS_update.unsupported")
+		return dns.RcodeNotImplemented
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rcode, changed, err := h.apply(dns.CanonicalName(state.Name()), r.Answer, r.Ns)
+	if errors.Is(err, errUpdateDBLocked) {
+		h.stats.IncrementCounter("DNS_update.locked")
+		glog.Errorf("Update of %s: %v, updates are rejected until it is released", state.Name(), err)
+		return dns.RcodeServerFailure
+	}
+	if err != nil {
+		h.stats.IncrementCounter("DNS_update.error")
+		glog.Errorf("Update of %s: %v", state.Name(), err)
+		return dns.RcodeServerFailure
+	}
+	if rcode != dns.RcodeSuccess {
+		h.stats.IncrementCounter(fmt.Sprintf("DNS_update.%s", strings.ToLower(dns.RcodeToString[rcode])))
+		return rcode
+	}
+	if !changed {
+		return dns.RcodeSuccess
+	}
+	// The reader catches up with the primary, and the cache is purged.
+	if err = h.db.Reload(*dnsserver.NewPartialReloadSignal()); err != nil {
+		h.stats.IncrementCounter("DNS_update.error")
+		glog.Errorf("Update of %s: failed to reload DB: %v", state.Name(), err)
+		return dns.RcodeServerFailure
+	}
+	return dns.RcodeSuccess
+}
+
+// apply checks the prerequisites of an update, and writes its changes to the
+// DB, along with the bumped SOA record. It returns whether the zone changed.
+func (h *updateHandler) apply(zone string, prereqs, updates []dns.RR) (int, bool, error) {
+	u, err := openUpdateDB(h.db.DBPath())
+	if err != nil {
+		return dns.RcodeServerFailure, false, err
+	}
+	defer u.Close()
+	if !u.IsV2KeySyntaxUsed() {
+		return dns.RcodeNotImplemented, false, nil
+	}
+
+	z := &zoneUpdate{db: u, zone: zone, names: make(map[string]*nameUpdate)}
+	soa, err := z.soa()
+	if err != nil {
+		return dns.RcodeServerFailure, false, err
+	}
+	if soa == nil {
+		return dns.RcodeNotAuth, false, nil
+	}
+	if rcode, err := z.checkPrerequisites(prereqs); rcode != dns.RcodeSuccess || err != nil {
+		return rcode, false, err
+	}
+	if rcode := z.prescan(updates); rcode != dns.RcodeSuccess {
+		return rcode, false, nil
+	}
+	for _, rr := range updates {
+		if err = z.update(rr); err != nil {
+			return dns.RcodeServerFailure, false, err
+		}
+	}
+	if !z.changed {
+		return dns.RcodeSuccess, false, nil
+	}
+	if !z.soaChanged {
+		// RFC 2136 section 3.6: the serial is bumped once per update.
+		bumped := dns.Copy(soa).(*dns.SOA)
+		bumped.Serial++
+		z.replace(z.names[zone], soa, bumped)
+	}
+	deleted, added, err := z.diff()
+	if err != nil {
+		return dns.RcodeServerFailure, false, err
+	}
+	err = u.ApplyEntries([]*dbdiff.Entry{
+		{Op: dbdiff.DelOp, Records: deleted},
+		{Op: dbdiff.AddOp, Records: added},
+	})
+	if err == nil {
+		// The reader only catches up with the changes written to disk.
+		err = u.Flush()
+	}
+	return dns.RcodeSuccess, err == nil, err
+}
+
+// Name returns the handler's name.
+func (h *updateHandler) Name() string { return "update" }
+
+// nameUpdate holds the default location records of a name, as they are
+// changed by an update.
+type nameUpdate struct {
+	// stored are the records in the DB, by record.
+	stored map[dns.RR]dnsdata.MapRecord
+	rrs    []dns.RR
+}
+
+// zoneUpdate holds the names of a zone read by an update.
+type zoneUpdate struct {
+	db         updateDB
+	zone       string
+	names      map[string]*nameUpdate
+	changed    bool
+	soaChanged bool
+}
+
+// load returns the records of a name, read from the DB on first use.
+func (z *zoneUpdate) load(name string) (*nameUpdate, error) {
+	name = dns.CanonicalName(name)
+	if n, ok := z.names[name]; ok {
+		return n, nil
+	}
+	packed := make([]byte, 256)
+	off, err := dns.PackDomainName(name, packed, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	records, err := z.db.NameRecords(packed[:off])
+	if err != nil {
+		return nil, err
+	}
+	n := &nameUpdate{stored: make(map[dns.RR]dnsdata.MapRecord)}
+	for _, r := range records {
+		rr, _, err := db.UnpackResourceRecord(r.Key, r.Value)
+		if err != nil {
+			return nil, err
+		}
+		// skip the records of the wildcard below the name
+		if dns.CanonicalName(rr.Header().Name) != name {
+			continue
+		}
+		n.stored[rr] = r
+		n.rrs = append(n.rrs, rr)
+	}
+	z.names[name] = n
+	return n, nil
+}
+
+// soa returns the SOA record of the zone, if it is in the DB.
+func (z *zoneUpdate) soa() (*dns.SOA, error) {
+	n, err := z.load(z.zone)
+	if err != nil {
+		return nil, err
+	}
+	for _, rr := range n.rrs {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa, nil
+		}
+	}
+	return nil, nil
+}
+
+// hasType returns whether there are records of the given type.
+func hasType(rrs []dns.RR, t uint16) bool {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == t {
+			return true
+		}
+	}
+	return false
+}
+
+// isMetaType returns whether a type is a query or meta type, which can't be
+// stored.
+func isMetaType(t uint16) bool {
+	switch t {
+	case dns.TypeANY, dns.TypeAXFR, dns.TypeIXFR, dns.TypeMAILA, dns.TypeMAILB,
+		dns.TypeOPT, dns.TypeTSIG, dns.TypeTKEY:
+		return true
+	}
+	return false
+}
+
+// checkPrerequisites checks the prerequisite section, as per RFC 2136
+// section 3.2.
+func (z *zoneUpdate) checkPrerequisites(prereqs []dns.RR) (int, error) {
+	type rrset struct {
+		name  string
+		rtype uint16
+	}
+	var (
+		order  []rrset
+		values = make(map[rrset][]dns.RR)
+	)
+	for _, rr := range prereqs {
+		h := rr.Header()
+		if h.Ttl != 0 {
+			return dns.RcodeFormatError, nil
+		}
+		if !dns.IsSubDomain(z.zone, h.Name) {
+			return dns.RcodeNotZone, nil
+		}
+		n, err := z.load(h.Name)
+		if err != nil {
+			return dns.RcodeServerFailure, err
+		}
+		switch h.Class {
+		case dns.ClassANY:
+			if h.Rdlength != 0 {
+				return dns.RcodeFormatError, nil
+			}
+			if h.Rrtype == dns.TypeANY && len(n.rrs) == 0 {
+				return dns.RcodeNameError, nil
+			}
+			if h.Rrtype != dns.TypeANY && !hasType(n.rrs, h.Rrtype) {
+				return dns.RcodeNXRrset, nil
+			}
+		case dns.ClassNONE:
+			if h.Rdlength != 0 {
+				return dns.RcodeFormatError, nil
+			}
+			if h.Rrtype == dns.TypeANY && len(n.rrs) > 0 {
+				return dns.RcodeYXDomain, nil
+			}
+			if h.Rrtype != dns.TypeANY && hasType(n.rrs, h.Rrtype) {
+				return dns.RcodeYXRrset, nil
+			}
+		case dns.ClassINET:
+			if isMetaType(h.Rrtype) {
+				return dns.RcodeFormatError, nil
+			}
+			k := rrset{dns.CanonicalName(h.Name), h.Rrtype}
+			if _, ok := values[k]; !ok {
+				order = append(order, k)
+			}
+			values[k] = append(values[k], rr)
+		default:
+			return dns.RcodeFormatError, nil
+		}
+	}
+	// Value dependent prerequisites must match whole RRsets, TTLs aside.
+	for _, k := range order {
+		n := z.names[k.name]
+		var stored []dns.RR
+		for _, rr := range n.rrs {
+			if rr.Header().Rrtype == k.rtype {
+				stored = append(stored, rr)
+			}
+		}
+		if !containsAll(stored, values[k]) || !containsAll(values[k], stored) {
+			return dns.RcodeNXRrset, nil
+		}
+	}
+	return dns.RcodeSuccess, nil
+}
+
+// containsAll returns whether every record of b has a duplicate in a.
+func containsAll(a, b []dns.RR) bool {
+	for _, rb := range b {
+		found := false
+		for _, ra := range a {
+			if dns.IsDuplicate(ra, rb) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// prescan checks the update section, as per RFC 2136 section 3.4.1. Wildcard
+// owner names are refused, as generic records can't be stored under them.
+func (z *zoneUpdate) prescan(updates []dns.RR) int {
+	for _, rr := range updates {
+		h := rr.Header()
+		if !dns.IsSubDomain(z.zone, h.Name) {
+			return dns.RcodeNotZone
+		}
+		if strings.HasPrefix(h.Name, "*.") {
+			return dns.RcodeRefused
+		}
+		switch h.Class {
+		case dns.ClassINET:
+			if isMetaType(h.Rrtype) {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassANY:
+			if h.Ttl != 0 || h.Rdlength != 0 || (isMetaType(h.Rrtype) && h.Rrtype != dns.TypeANY) {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassNONE:
+			if h.Ttl != 0 || isMetaType(h.Rrtype) {
+				return dns.RcodeFormatError
+			}
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// replace replaces a record of a name, or adds it if old is nil.
+func (z *zoneUpdate) replace(n *nameUpdate, old, rr dns.RR) {
+	if old != nil {
+		z.remove(n, func(r dns.RR) bool { return r == old })
+	}
+	n.rrs = append(n.rrs, rr)
+	z.changed = true
+}
+
+// remove removes the records of a name matching f.
+func (z *zoneUpdate) remove(n *nameUpdate, f func(dns.RR) bool) {
+	rrs := n.rrs[:0]
+	for _, rr := range n.rrs {
+		if f(rr) {
+			z.changed = true
+		} else {
+			rrs = append(rrs, rr)
+		}
+	}
+	n.rrs = rrs
+}
+
+// update applies a record of the update section, as per RFC 2136 section
+// 3.4.2.
+func (z *zoneUpdate) update(rr dns.RR) error {
+	h := rr.Header()
+	n, err := z.load(h.Name)
+	if err != nil {
+		return err
+	}
+	apex := dns.CanonicalName(h.Name) == z.zone
+	switch h.Class {
+	case dns.ClassINET:
+		switch {
+		case h.Rrtype == dns.TypeSOA:
+			soa, err := z.soa()
+			if err != nil {
+				return err
+			}
+			if !apex || !dnsdata.SerialNewerOrEqual(rr.(*dns.SOA).Serial, soa.Serial) || rr.(*dns.SOA).Serial == soa.Serial {
+				return nil
+			}
+			z.replace(n, soa, rr)
+			z.soaChanged = true
+			return nil
+		case h.Rrtype == dns.TypeCNAME:
+			if len(n.rrs) > 0 && !hasType(n.rrs, dns.TypeCNAME) {
+				return nil
+			}
+			for _, r := range n.rrs {
+				if r.Header().Rrtype == dns.TypeCNAME {
+					if !dns.IsDuplicate(r, rr) || r.Header().Ttl != h.Ttl {
+						z.replace(n, r, rr)
+					}
+					return nil
+				}
+			}
+		case hasType(n.rrs, dns.TypeCNAME):
+			return nil
+		}
+		for _, r := range n.rrs {
+			if dns.IsDuplicate(r, rr) {
+				if r.Header().Ttl != h.Ttl {
+					z.replace(n, r, rr)
+				}
+				return nil
+			}
+		}
+		z.replace(n, nil, rr)
+	case dns.ClassANY:
+		z.remove(n, func(r dns.RR) bool {
+			t := r.Header().Rrtype
+			if apex && (t == dns.TypeSOA || t == dns.TypeNS) {
+				return false
+			}
+			return h.Rrtype == dns.TypeANY || t == h.Rrtype
+		})
+	case dns.ClassNONE:
+		if apex && h.Rrtype == dns.TypeSOA {
+			return nil
+		}
+		in := dns.Copy(rr)
+		in.Header().Class = dns.ClassINET
+		nsCount := 0
+		for _, r := range n.rrs {
+			if r.Header().Rrtype == dns.TypeNS {
+				nsCount++
+			}
+		}
+		z.remove(n, func(r dns.RR) bool {
+			if !dns.IsDuplicate(r, in) {
+				return false
+			}
+			// the last NS record of the zone can't be deleted
+			return !apex || h.Rrtype != dns.TypeNS || nsCount > 1
+		})
+	}
+	return nil
+}
+
+// diff returns the records to delete from, and to add to the DB.
+func (z *zoneUpdate) diff() (deleted, added []dnsdata.MapRecord, err error) {
+	codec := new(dnsdata.Codec)
+	codec.Features.UseV2Keys = true
+	for _, n := range z.names {
+		kept := make(map[dns.RR]bool)
+		for _, rr := range n.rrs {
+			if _, ok := n.stored[rr]; ok {
+				kept[rr] = true
+				continue
+			}
+			line, err := dnsdata.RRLine(rr)
+			if err != nil {
+				return nil, nil, err
+			}
+			records, err := codec.ConvertLn([]byte(line))
+			if err != nil {
+				return nil, nil, fmt.Errorf("conversion error for %s: %w", rr, err)
+			}
+			added = append(added, records...)
+		}
+		for rr, r := range n.stored {
+			if !kept[rr] {
+				deleted = append(deleted, r)
+			}
+		}
+	}
+	return deleted, added, nil
+}
@@ -0,0 +1,40 @@
+//go:build cgo
+
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
+)
+
+// openUpdateDB opens the RocksDB at path as its primary, to write updates to.
+// It fails with errUpdateDBLocked if another process holds its LOCK.
+func openUpdateDB(path string) (updateDB, error) {
+	u, err := rdb.NewUpdater(path)
+	if err != nil {
+		// RocksDB reports the LOCK held by another process as an IO error.
+		if strings.Contains(err.Error(), "While lock file") {
+			return nil, fmt.Errorf("%w: %s: %w", errUpdateDBLocked, path, err)
+		}
+		return nil, err
+	}
+	return u, nil
+}
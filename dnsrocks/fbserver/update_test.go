@@ -0,0 +1,265 @@
+//go:build cgo
+
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
+	"github.com/facebook/dns/dnsrocks/testaid"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testTSIGKey    = "update.key."
+	testTSIGSecret = "c2VjcmV0LWZvci10ZXN0cw=="
+)
+
+func TestParseTSIGKeys(t *testing.T) {
+	keys, err := parseTSIGKeys(`
+# comment
+key "Update.Key" {
+	algorithm hmac-sha256;
+	secret "` + testTSIGSecret + `";
+	zone "Example.com";
+	zone "example.org.";
+};
+// comment
+key other. { algorithm HMAC-SHA512; secret "b3RoZXI="; zone other.; };
+`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]tsigKey{
+		"update.key.": {algorithm: dns.HmacSHA256, secret: testTSIGSecret, zones: []string{"example.com.", "example.org."}},
+		"other.":      {algorithm: dns.HmacSHA512, secret: "b3RoZXI=", zones: []string{"other."}},
+	}, keys)
+
+	for _, data := range []string{
+		`key "k." { algorithm hmac-md5; secret "b3RoZXI="; zone "z."; };`,
+		`key "k." { algorithm hmac-sha256; secret "not base64"; zone "z."; };`,
+		`key "k." { algorithm hmac-sha256; secret "b3RoZXI="; zone "z."; }`,
+		`key "k." { algorithm hmac-sha256; secret "b3RoZXI="; owner "x"; zone "z."; };`,
+		`key "k." { algorithm hmac-sha256 secret "b3RoZXI="; zone "z."; };`,
+		`key "k." { algorithm hmac-sha256; secret "b3RoZXI="; };`,
+		`server 192.0.2.1 { keys { k.; }; };`,
+		`key "k.`,
+	} {
+		_, err := parseTSIGKeys(data)
+		require.Error(t, err, data)
+	}
+}
+
+// makeUpdateTestServerConfig returns the config of a server allowed to update
+// a RDB holding the update.test zone only, with a key for this zone and
+// missing.test.
+func makeUpdateTestServerConfig(t *testing.T) ServerConfig {
+	dir := t.TempDir()
+	data := `
+Zupdate.test,ns1.update.test,hostmaster.update.test,10,7200,1800,604800,120,120
+&update.test,,ns1.update.test,3600
+&update.test,,ns2.update.test,3600
++www.update.test,192.0.2.1,3600
+`
+	dbpath := filepath.Join(dir, "rdb")
+	require.NoError(t, os.Mkdir(dbpath, 0o755))
+	_, err := rdb.Compile(strings.NewReader(data), 1, dbpath, rdb.CompilationOptions{UseV2KeySyntax: true})
+	require.NoError(t, err)
+	keyfile := filepath.Join(dir, "keys.conf")
+	require.NoError(t, os.WriteFile(keyfile, []byte(`key "`+testTSIGKey+`" { algorithm hmac-sha256; secret "`+testTSIGSecret+`"; zone update.test.; zone missing.test.; };`), 0o600))
+
+	config := makeTestServerConfig(true, false)
+	config.IPAns["::1"] = 8
+	config.DBConfig.Driver = "rocksdb"
+	config.DBConfig.Path = dbpath
+	config.DBConfig.ReloadTimeout = time.Second
+	config.UpdateConfig.KeyFile = keyfile
+	return config
+}
+
+// sendUpdate sends an update signed with the given secret, unsigned if empty,
+// and returns its rcode.
+func sendUpdate(t *testing.T, addr, secret string, m *dns.Msg) int {
+	c := &dns.Client{Net: "tcp"}
+	if secret != "" {
+		c.TsigSecret = map[string]string{testTSIGKey: secret}
+		m.SetTsig(testTSIGKey, dns.HmacSHA256, 300, 0)
+	}
+	r, _, err := c.Exchange(m, addr)
+	// The client refuses the signature of NOTAUTH responses.
+	if r == nil || r.Rcode != dns.RcodeNotAuth {
+		require.NoError(t, err)
+	}
+	return r.Rcode
+}
+
+// lookup returns the records of a name and type served over UDP.
+func lookup(t *testing.T, addr, name string, qtype uint16) []string {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	r, err := dns.Exchange(m, addr)
+	require.NoError(t, err)
+	var rrs []string
+	for _, rr := range r.Answer {
+		rrs = append(rrs, strings.TrimPrefix(rr.String(), rr.Header().String()))
+	}
+	return rrs
+}
+
+func TestUpdate(t *testing.T) {
+	portMap, srv := makeTestServer(t, makeUpdateTestServerConfig(t))
+	defer srv.Shutdown()
+	addr := portMap["tcp"]
+	newUpdate := func() *dns.Msg {
+		m := new(dns.Msg)
+		m.SetUpdate("update.test.")
+		return m
+	}
+	serial := func() string {
+		return strings.Fields(lookup(t, portMap["udp"], "update.test.", dns.TypeSOA)[0])[2]
+	}
+
+	t.Run("insert", func(t *testing.T) {
+		m := newUpdate()
+		m.RRsetUsed([]dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "www.update.test.", Rrtype: dns.TypeA}}})
+		m.Insert([]dns.RR{
+			testRR(t, "www.update.test. 300 IN A 192.0.2.2"),
+			testRR(t, `txt.update.test. 300 IN TXT "hello"`),
+		})
+		require.Equal(t, dns.RcodeSuccess, sendUpdate(t, addr, testTSIGSecret, m))
+		require.ElementsMatch(t, []string{"192.0.2.1", "192.0.2.2"}, lookup(t, portMap["udp"], "www.update.test.", dns.TypeA))
+		require.Equal(t, []string{`"hello"`}, lookup(t, portMap["udp"], "txt.update.test.", dns.TypeTXT))
+		require.Equal(t, "11", serial())
+	})
+
+	t.Run("prerequisites", func(t *testing.T) {
+		m := newUpdate()
+		m.NameNotUsed([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "www.update.test."}}})
+		m.Insert([]dns.RR{testRR(t, "www.update.test. 300 IN A 192.0.2.3")})
+		require.Equal(t, dns.RcodeYXDomain, sendUpdate(t, addr, testTSIGSecret, m))
+
+		m = newUpdate()
+		m.Used([]dns.RR{testRR(t, "www.update.test. 0 IN A 192.0.2.1")})
+		m.Insert([]dns.RR{testRR(t, "www.update.test. 300 IN A 192.0.2.3")})
+		require.Equal(t, dns.RcodeNXRrset, sendUpdate(t, addr, testTSIGSecret, m), "the RRset has two records")
+		require.Len(t, lookup(t, portMap["udp"], "www.update.test.", dns.TypeA), 2)
+		require.Equal(t, "11", serial())
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		m := newUpdate()
+		m.Remove([]dns.RR{testRR(t, "www.update.test. 300 IN A 192.0.2.1")})
+		m.RemoveRRset([]dns.RR{&dns.NS{Hdr: dns.RR_Header{Name: "update.test.", Rrtype: dns.TypeNS}}})
+		m.RemoveName([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "txt.update.test."}}})
+		require.Equal(t, dns.RcodeSuccess, sendUpdate(t, addr, testTSIGSecret, m))
+		require.Equal(t, []string{"192.0.2.2"}, lookup(t, portMap["udp"], "www.update.test.", dns.TypeA))
+		require.Empty(t, lookup(t, portMap["udp"], "txt.update.test.", dns.TypeTXT))
+		// The NS records of the apex can't be deleted as a whole.
+		require.Len(t, lookup(t, portMap["udp"], "update.test.", dns.TypeNS), 2)
+		require.Equal(t, "12", serial())
+	})
+
+	t.Run("soa", func(t *testing.T) {
+		m := newUpdate()
+		m.Insert([]dns.RR{testRR(t, "update.test. 120 IN SOA ns1.update.test. hostmaster.update.test. 20 7200 1800 604800 120")})
+		require.Equal(t, dns.RcodeSuccess, sendUpdate(t, addr, testTSIGSecret, m))
+		require.Equal(t, "20", serial())
+	})
+
+	t.Run("zone", func(t *testing.T) {
+		m := newUpdate()
+		m.Insert([]dns.RR{testRR(t, "www.example.org. 300 IN A 192.0.2.3")})
+		require.Equal(t, dns.RcodeNotZone, sendUpdate(t, addr, testTSIGSecret, m))
+
+		m = new(dns.Msg)
+		m.SetUpdate("missing.test.")
+		require.Equal(t, dns.RcodeNotAuth, sendUpdate(t, addr, testTSIGSecret, m))
+	})
+
+	t.Run("key scope", func(t *testing.T) {
+		// The key may only update the zones of its key statement.
+		m := new(dns.Msg)
+		m.SetUpdate("www.update.test.")
+		m.Insert([]dns.RR{testRR(t, "www.update.test. 300 IN A 192.0.2.3")})
+		require.Equal(t, dns.RcodeRefused, sendUpdate(t, addr, testTSIGSecret, m))
+		require.Equal(t, []string{"192.0.2.2"}, lookup(t, portMap["udp"], "www.update.test.", dns.TypeA))
+	})
+
+	t.Run("authentication", func(t *testing.T) {
+		m := newUpdate()
+		m.Insert([]dns.RR{testRR(t, "www.update.test. 300 IN A 192.0.2.3")})
+		require.Equal(t, dns.RcodeRefused, sendUpdate(t, addr, "", m))
+
+		m = newUpdate()
+		m.Insert([]dns.RR{testRR(t, "www.update.test. 300 IN A 192.0.2.3")})
+		require.Equal(t, dns.RcodeNotAuth, sendUpdate(t, addr, "d3Jvbmc=", m))
+		require.Equal(t, []string{"192.0.2.2"}, lookup(t, portMap["udp"], "www.update.test.", dns.TypeA))
+	})
+}
+
+func TestUpdateLocked(t *testing.T) {
+	config := makeUpdateTestServerConfig(t)
+	// another writer holds the DB when the server starts
+	u, err := rdb.NewUpdater(config.DBConfig.Path)
+	require.NoError(t, err)
+	portMap, srv := makeTestServer(t, config)
+	defer srv.Shutdown()
+
+	newUpdate := func() *dns.Msg {
+		m := new(dns.Msg)
+		m.SetUpdate("update.test.")
+		m.Insert([]dns.RR{testRR(t, "www.update.test. 300 IN A 192.0.2.3")})
+		return m
+	}
+	require.Equal(t, dns.RcodeServerFailure, sendUpdate(t, portMap["tcp"], testTSIGSecret, newUpdate()))
+
+	// the DB is opened once released
+	require.NoError(t, u.Close())
+	require.Equal(t, dns.RcodeSuccess, sendUpdate(t, portMap["tcp"], testTSIGSecret, newUpdate()))
+	require.ElementsMatch(t, []string{"192.0.2.1", "192.0.2.3"}, lookup(t, portMap["udp"], "www.update.test.", dns.TypeA))
+
+	// the DB is only held open by updates, so other writers may use it
+	// between them
+	u, err = rdb.NewUpdater(config.DBConfig.Path)
+	require.NoError(t, err)
+	require.NoError(t, u.Close())
+}
+
+func TestUpdateCDB(t *testing.T) {
+	config := makeUpdateTestServerConfig(t)
+	config.DBConfig.Driver = testaid.TestCDB.Driver
+	config.DBConfig.Path = testaid.TestCDB.Path
+	portMap, srv := makeTestServer(t, config)
+	defer srv.Shutdown()
+
+	m := new(dns.Msg)
+	m.SetUpdate("example.com.")
+	m.Insert([]dns.RR{testRR(t, "www.example.com. 300 IN A 192.0.2.3")})
+	require.Equal(t, dns.RcodeNotImplemented, sendUpdate(t, portMap["tcp"], testTSIGSecret, m))
+}
+
+func testRR(t *testing.T, s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	require.NoError(t, err)
+	return rr
+}
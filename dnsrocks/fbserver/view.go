@@ -0,0 +1,242 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+// ViewConfig is the config of a split-horizon view: the queries received on
+// one of its listener IPs, or from one of its client subnets, are answered
+// from its own DB with its own handler config, instead of the default ones.
+type ViewConfig struct {
+	Name string
+	// IPs are the listener IPs of the view, each of them must also be
+	// configured with -ip.
+	IPs []string
+	// ClientSubnets are the subnets of the clients of the view.
+	ClientSubnets []string
+	// StatsPrefix prefixes the stats of the view, "view.<name>." if empty.
+	StatsPrefix   string
+	DBConfig      dnsserver.DBConfig
+	HandlerConfig dnsserver.HandlerConfig
+}
+
+// ReadViews reads a JSON list of view configs from path. The DB and handler
+// configs of the views default to dbConfig and handlerConfig, except for the
+// DB, overlay and control paths which each view must set on its own.
+func ReadViews(path string, dbConfig dnsserver.DBConfig, handlerConfig dnsserver.HandlerConfig) ([]ViewConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw []json.RawMessage
+	if err = json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse views from %s: %w", path, err)
+	}
+	dbConfig.Path = ""
+	dbConfig.Overlays = nil
+	dbConfig.ControlPath = ""
+	views := make([]ViewConfig, 0, len(raw))
+	for _, r := range raw {
+		v := ViewConfig{DBConfig: dbConfig, HandlerConfig: handlerConfig}
+		if err = json.Unmarshal(r, &v); err != nil {
+			return nil, fmt.Errorf("failed to parse views from %s: %w", path, err)
+		}
+		views = append(views, v)
+	}
+	return views, nil
+}
+
+// view is a split-horizon view with its own DB.
+type view struct {
+	name     string
+	ips      map[string]bool
+	subnets  []*net.IPNet
+	dbConfig dnsserver.DBConfig
+	db       *dnsserver.FBDNSDB
+	// handler serves the queries of the view: its DB, behind the handlers of
+	// its zone transfers and updates, see Server.zoneHandlers.
+	handler plugin.Handler
+	stats   stats.Stats
+}
+
+// newView creates the DB handler of a view, without loading it.
+func newView(c ViewConfig, conf *ServerConfig, logger dnsserver.Logger, s stats.Stats) (*view, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("view has no name")
+	}
+	if len(c.IPs) == 0 && len(c.ClientSubnets) == 0 {
+		return nil, fmt.Errorf("view %q has neither listener IPs nor client subnets", c.Name)
+	}
+	if c.DBConfig.Path == "" {
+		return nil, fmt.Errorf("view %q has no DB path", c.Name)
+	}
+	v := &view{name: c.Name, ips: make(map[string]bool), dbConfig: c.DBConfig}
+	for _, s := range c.IPs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("view %q has invalid listener IP %q", c.Name, s)
+		}
+		if _, ok := conf.IPAns[ip.String()]; !ok {
+			return nil, fmt.Errorf("view %q listens on %s, which is not a configured IP", c.Name, ip)
+		}
+		v.ips[ip.String()] = true
+	}
+	for _, s := range c.ClientSubnets {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("view %q has invalid client subnet %q: %w", c.Name, s, err)
+		}
+		v.subnets = append(v.subnets, n)
+	}
+	prefix := c.StatsPrefix
+	if prefix == "" {
+		prefix = "view." + c.Name + "."
+	}
+	v.stats = &stats.PrefixedStats{Stats: s, Prefix: prefix}
+	var err error
+	if v.db, err = dnsserver.NewFBDNSDB(c.HandlerConfig, c.DBConfig, conf.CacheConfig, logger, v.stats); err != nil {
+		return nil, fmt.Errorf("failed to create DB of view %q: %w", c.Name, err)
+	}
+	return v, nil
+}
+
+// newViews creates the DB handlers of the views of conf, checking that no two
+// views share a name, a DB path or a control directory.
+func newViews(conf *ServerConfig, logger dnsserver.Logger, s stats.Stats) ([]*view, error) {
+	names := make(map[string]bool)
+	paths := map[string]bool{conf.DBConfig.Path: true}
+	controlPaths := map[string]bool{conf.DBConfig.ControlPath: true}
+	views := make([]*view, 0, len(conf.Views))
+	for _, c := range conf.Views {
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate view %q", c.Name)
+		}
+		if paths[c.DBConfig.Path] {
+			return nil, fmt.Errorf("view %q shares its DB path %s", c.Name, c.DBConfig.Path)
+		}
+		if c.DBConfig.ControlPath != "" && controlPaths[c.DBConfig.ControlPath] {
+			return nil, fmt.Errorf("view %q shares its control directory %s", c.Name, c.DBConfig.ControlPath)
+		}
+		v, err := newView(c, conf, logger, s)
+		if err != nil {
+			return nil, err
+		}
+		names[c.Name] = true
+		paths[c.DBConfig.Path] = true
+		controlPaths[c.DBConfig.ControlPath] = true
+		views = append(views, v)
+	}
+	return views, nil
+}
+
+// matches returns whether the query received on localIP from clientIP belongs
+// to the view.
+func (v *view) matches(localIP, clientIP net.IP) bool {
+	if localIP != nil && v.ips[localIP.String()] {
+		return true
+	}
+	if clientIP != nil {
+		for _, n := range v.subnets {
+			if n.Contains(clientIP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// viewHandler answers the queries, zone transfers and updates from the DB of
+// the first view they belong to, and passes the others to the next handler,
+// that of the default DB.
+type viewHandler struct {
+	views []*view
+	Next  plugin.Handler
+}
+
+// addrIP returns the IP of a listener or client address.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// ServeDNS implements the plugin.Handler interface.
+func (h *viewHandler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	localIP := addrIP(w.LocalAddr())
+	clientIP := net.ParseIP(state.IP())
+	for _, v := range h.views {
+		if v.matches(localIP, clientIP) {
+			v.stats.IncrementCounter("DNS_view.queries")
+			return v.handler.ServeDNS(ctx, w, r)
+		}
+	}
+	return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+}
+
+// Name implements the Handler interface.
+func (h *viewHandler) Name() string { return "viewHandler" }
+
+// WatchViewsAndReload refreshes the data view of each view on its DB file
+// change or control file signals, for the views which enable them.
+func (srv *Server) WatchViewsAndReload() {
+	for _, v := range srv.views {
+		if v.dbConfig.WatchDB {
+			go func() {
+				// If watcher fails - shutdown
+				if err := v.db.WatchDBAndReload(); err != nil {
+					glog.Errorf("Error watching db of view %s: %s", v.name, err)
+					srv.Shutdown()
+				}
+			}()
+		}
+		if v.dbConfig.ControlPath != "" {
+			go func() {
+				// If watcher fails - shutdown
+				if err := v.db.WatchControlDirAndReload(); err != nil {
+					glog.Errorf("Error watching control dir of view %s: %s", v.name, err)
+					srv.Shutdown()
+				}
+			}()
+		}
+	}
+}
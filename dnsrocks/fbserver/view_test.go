@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fbserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata/cdb"
+	"github.com/facebook/dns/dnsrocks/dnsserver"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/testaid"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// viewData is the data of the test view, answering foo2.example.com with an A
+// record instead of the CNAME of the default DB.
+const viewData = `Zexample.com,a.ns.example.com,dns.example.com,123,7200,1800,604800,120,120,,
+&example.com,,a.ns.example.com,172800,,
++foo2.example.com,10.1.2.3,300,,
+`
+
+// makeViewCDB compiles viewData to a CDB and returns its path.
+func makeViewCDB(t *testing.T) string {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "data")
+	require.NoError(t, os.WriteFile(input, []byte(viewData), 0o644))
+	path := filepath.Join(dir, "data.cdb")
+	_, err := cdb.CreateCDB(input, path, nil)
+	require.NoError(t, err)
+	return path
+}
+
+func TestReadViews(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "views.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+  {"Name": "internal", "ClientSubnets": ["10.0.0.0/8"], "DBConfig": {"Path": "/var/dns/internal"}, "HandlerConfig": {"CNAMEChasing": true}},
+  {"Name": "lab", "IPs": ["192.0.2.53"], "StatsPrefix": "lab.", "DBConfig": {"Path": "/var/dns/lab", "ControlPath": "/var/dns/lab-control", "Driver": "cdb"}}
+]`), 0o644))
+	dbConfig := dnsserver.DBConfig{Path: "/var/dns/public", ControlPath: "/var/dns/control", Driver: "rocksdb", ReloadInterval: 10}
+	handlerConfig := dnsserver.HandlerConfig{MaxCNAMEHops: 5}
+
+	views, err := ReadViews(path, dbConfig, handlerConfig)
+	require.NoError(t, err)
+	require.Equal(t, []ViewConfig{
+		{
+			Name:          "internal",
+			ClientSubnets: []string{"10.0.0.0/8"},
+			DBConfig:      dnsserver.DBConfig{Path: "/var/dns/internal", Driver: "rocksdb", ReloadInterval: 10},
+			HandlerConfig: dnsserver.HandlerConfig{CNAMEChasing: true, MaxCNAMEHops: 5},
+		},
+		{
+			Name:          "lab",
+			IPs:           []string{"192.0.2.53"},
+			StatsPrefix:   "lab.",
+			DBConfig:      dnsserver.DBConfig{Path: "/var/dns/lab", ControlPath: "/var/dns/lab-control", Driver: "cdb", ReloadInterval: 10},
+			HandlerConfig: dnsserver.HandlerConfig{MaxCNAMEHops: 5},
+		},
+	}, views)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"Name": "internal"}`), 0o644))
+	_, err = ReadViews(path, dbConfig, handlerConfig)
+	require.Error(t, err)
+}
+
+func TestNewViewsInvalid(t *testing.T) {
+	conf := makeTestServerConfig(false, false)
+	viewDB := dnsserver.DBConfig{Driver: "cdb", Path: "/var/dns/internal"}
+	testCases := []struct {
+		name  string
+		views []ViewConfig
+	}{
+		{
+			name:  "no name",
+			views: []ViewConfig{{ClientSubnets: []string{"10.0.0.0/8"}, DBConfig: viewDB}},
+		},
+		{
+			name:  "no selector",
+			views: []ViewConfig{{Name: "internal", DBConfig: viewDB}},
+		},
+		{
+			name:  "no path",
+			views: []ViewConfig{{Name: "internal", ClientSubnets: []string{"10.0.0.0/8"}}},
+		},
+		{
+			name:  "unconfigured ip",
+			views: []ViewConfig{{Name: "internal", IPs: []string{"192.0.2.53"}, DBConfig: viewDB}},
+		},
+		{
+			name:  "invalid subnet",
+			views: []ViewConfig{{Name: "internal", ClientSubnets: []string{"10.0.0.0"}, DBConfig: viewDB}},
+		},
+		{
+			name:  "default path",
+			views: []ViewConfig{{Name: "internal", ClientSubnets: []string{"10.0.0.0/8"}, DBConfig: conf.DBConfig}},
+		},
+		{
+			name: "duplicate",
+			views: []ViewConfig{
+				{Name: "internal", ClientSubnets: []string{"10.0.0.0/8"}, DBConfig: viewDB},
+				{Name: "internal", ClientSubnets: []string{"172.16.0.0/12"}, DBConfig: dnsserver.DBConfig{Driver: "cdb", Path: "/var/dns/other"}},
+			},
+		},
+		{
+			name: "shared control path",
+			views: []ViewConfig{
+				{Name: "internal", ClientSubnets: []string{"10.0.0.0/8"}, DBConfig: dnsserver.DBConfig{Driver: "cdb", Path: "/var/dns/internal", ControlPath: "/var/dns/control"}},
+				{Name: "lab", ClientSubnets: []string{"172.16.0.0/12"}, DBConfig: dnsserver.DBConfig{Driver: "cdb", Path: "/var/dns/lab", ControlPath: "/var/dns/control"}},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf.Views = tc.views
+			_, err := newViews(&conf, &dnsserver.DummyLogger{}, &stats.DummyStats{})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestViews(t *testing.T) {
+	path := makeViewCDB(t)
+	testCases := []struct {
+		name string
+		view ViewConfig
+		want dns.RR
+	}{
+		{
+			name: "client subnet",
+			view: ViewConfig{Name: "internal", ClientSubnets: []string{"10.0.0.0/8", "::1/128"}},
+			want: test.A("foo2.example.com. 300 IN A 10.1.2.3"),
+		},
+		{
+			name: "listener ip",
+			view: ViewConfig{Name: "internal", IPs: []string{"::1"}},
+			want: test.A("foo2.example.com. 300 IN A 10.1.2.3"),
+		},
+		{
+			name: "no match",
+			view: ViewConfig{Name: "internal", ClientSubnets: []string{"10.0.0.0/8"}},
+			want: test.CNAME("foo2.example.com. 1800 IN CNAME some-other.domain."),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := makeTestServerConfig(false, false)
+			tc.view.DBConfig = dnsserver.DBConfig{Driver: "cdb", Path: path}
+			config.Views = []ViewConfig{tc.view}
+			portMap, srv := makeTestServer(t, config)
+			defer srv.Shutdown()
+
+			c := new(dns.Client)
+			m := new(dns.Msg)
+			m.SetQuestion("foo2.example.com.", dns.TypeA)
+			r, _, err := c.Exchange(m, portMap["udp"])
+			require.NoError(t, err)
+			require.NotEmpty(t, r.Answer)
+			require.Equal(t, tc.want.String(), r.Answer[0].String())
+		})
+	}
+}
+
+// TestViewAXFR checks that the zones are transferred from the DB of the view
+// of the secondary.
+func TestViewAXFR(t *testing.T) {
+	config := makeTestServerConfig(true, false)
+	require.NoError(t, config.AXFRConfig.AllowedSubnets.Set("::1/128"))
+	config.Views = []ViewConfig{{
+		Name:          "internal",
+		ClientSubnets: []string{"::1/128"},
+		DBConfig:      dnsserver.DBConfig{Driver: testaid.TestPDBV2.Driver, Path: testaid.TestPDBV2.Path},
+	}}
+	portMap, srv := makeTestServer(t, config)
+	defer srv.Shutdown()
+
+	// The default CDB does not support zone transfers.
+	rrs, rcode := transferZone(t, "tcp", portMap["tcp"], "example.net.")
+	require.Equal(t, dns.RcodeSuccess, rcode)
+	require.Len(t, rrs, 16)
+}
+
+func TestViewStats(t *testing.T) {
+	counters := stats.NewCounters()
+	conf := makeTestServerConfig(false, false)
+	conf.Views = []ViewConfig{
+		{Name: "internal", ClientSubnets: []string{"10.0.0.0/8"}, DBConfig: dnsserver.DBConfig{Driver: "cdb", Path: makeViewCDB(t)}},
+		{Name: "lab", StatsPrefix: "lab.", ClientSubnets: []string{"172.16.0.0/12"}, DBConfig: dnsserver.DBConfig{Driver: "cdb", Path: makeViewCDB(t)}},
+	}
+	views, err := newViews(&conf, &dnsserver.DummyLogger{}, counters)
+	require.NoError(t, err)
+	for _, v := range views {
+		require.NoError(t, v.db.Load())
+		defer v.db.Close()
+	}
+	require.Equal(t, int64(1), counters["view.internal.DNS_db.reload"])
+	require.Equal(t, int64(1), counters["lab.DNS_db.reload"])
+}
@@ -0,0 +1,278 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package geoimport generates the % records mapping subnets to locations from
+// the GeoIP databases in the MMDB format.
+package geoimport
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/quote"
+)
+
+// Mapping maps the countries, regions and ASNs of the GeoIP databases to
+// location IDs. ASNs take precedence over regions, which take precedence
+// over countries.
+type Mapping struct {
+	countries map[string]dnsdata.Loc
+	regions   map[string]dnsdata.Loc
+	asns      map[uint64]dnsdata.Loc
+}
+
+// ParseMapping parses a mapping, one entry per line:
+//
+//	country,<ISO 3166-1 code>,<location>
+//	region,<ISO 3166-1 code>-<ISO 3166-2 subdivision code>,<location>
+//	asn,<AS number>,<location>
+//
+// Locations are escaped as in the data files. Empty lines and lines starting
+// with # are ignored.
+func ParseMapping(r io.Reader) (*Mapping, error) {
+	m := &Mapping{
+		countries: make(map[string]dnsdata.Loc),
+		regions:   make(map[string]dnsdata.Loc),
+		asns:      make(map[uint64]dnsdata.Loc),
+	}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Split(line, ",")
+		if len(f) != 3 {
+			return nil, fmt.Errorf("line %d: expected <kind>,<code>,<location>, got %q", n, line)
+		}
+		lo, err := quote.Bunquote([]byte(f[2]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if len(lo) < 2 || len(lo) > 255 {
+			return nil, fmt.Errorf("line %d: invalid location %q", n, f[2])
+		}
+		code := strings.ToUpper(f[1])
+		switch f[0] {
+		case "country":
+			m.countries[code] = lo
+		case "region":
+			m.regions[code] = lo
+		case "asn":
+			asn, err := strconv.ParseUint(strings.TrimPrefix(code, "AS"), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid AS number %q", n, f[1])
+			}
+			m.asns[asn] = lo
+		default:
+			return nil, fmt.Errorf("line %d: unknown kind %q", n, f[0])
+		}
+	}
+	return m, scanner.Err()
+}
+
+// lookup returns the value found at path in the data of a network, following
+// the first element of arrays.
+func lookup(v any, path ...string) any {
+	for _, k := range path {
+		if a, ok := v.([]any); ok {
+			if len(a) == 0 {
+				return nil
+			}
+			v = a[0]
+		}
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// Locate returns the location of the data of a network, nil if it is not
+// mapped. It also returns the key it was mapped by, e.g. "country:US", or
+// the one describing it if not mapped.
+func (m *Mapping) Locate(data any) (dnsdata.Loc, string) {
+	asn, hasASN := lookup(data, "autonomous_system_number").(uint64)
+	if lo, ok := m.asns[asn]; hasASN && ok {
+		return lo, fmt.Sprintf("asn:%d", asn)
+	}
+	country, _ := lookup(data, "country", "iso_code").(string)
+	if country == "" {
+		country, _ = lookup(data, "registered_country", "iso_code").(string)
+	}
+	if subdivision, _ := lookup(data, "subdivisions", "iso_code").(string); country != "" && subdivision != "" {
+		region := country + "-" + subdivision
+		if lo, ok := m.regions[region]; ok {
+			return lo, "region:" + region
+		}
+	}
+	if country != "" {
+		key := "country:" + country
+		return m.countries[country], key
+	}
+	if hasASN {
+		return nil, fmt.Sprintf("asn:%d", asn)
+	}
+	return nil, "unknown"
+}
+
+// LocatedNetwork is a network mapped to a location.
+type LocatedNetwork struct {
+	IPNet *net.IPNet
+	Loc   dnsdata.Loc
+}
+
+// Result is the result of an import.
+type Result struct {
+	// Networks are the networks mapped to a location, with the adjacent ones
+	// of the same location collapsed.
+	Networks []LocatedNetwork
+	// Gaps are the networks not mapped to any location, collapsed.
+	Gaps []*net.IPNet
+	// Unmapped counts the networks of the database not mapped, by the key
+	// describing them, see Mapping.Locate.
+	Unmapped map[string]int
+	// CoverageV4 and CoverageV6 are the fractions of the IPv4 and IPv6
+	// address spaces mapped to a location.
+	CoverageV4 float64
+	CoverageV6 float64
+}
+
+// collapser collapses the adjacent networks of a same location.
+type collapser struct {
+	stack []LocatedNetwork
+}
+
+// add adds the network following the last one added. As the networks are
+// added in order, two networks of the same size are siblings only if they
+// are adjacent.
+func (c *collapser) add(n LocatedNetwork) {
+	c.stack = append(c.stack, n)
+	for len(c.stack) >= 2 {
+		a, b := c.stack[len(c.stack)-2], c.stack[len(c.stack)-1]
+		ones, bits := a.IPNet.Mask.Size()
+		bOnes, bBits := b.IPNet.Mask.Size()
+		if ones == 0 || ones != bOnes || bits != bBits || !bytes.Equal(a.Loc, b.Loc) {
+			return
+		}
+		parent := net.CIDRMask(ones-1, bits)
+		if !a.IPNet.IP.Mask(parent).Equal(b.IPNet.IP.Mask(parent)) {
+			return
+		}
+		c.stack = c.stack[:len(c.stack)-2]
+		c.stack = append(c.stack, LocatedNetwork{IPNet: &net.IPNet{IP: a.IPNet.IP, Mask: parent}, Loc: a.Loc})
+	}
+}
+
+// Import maps the networks of the database to locations.
+func Import(db *MMDB, m *Mapping) (*Result, error) {
+	var (
+		located  collapser
+		gaps     collapser
+		result   = &Result{Unmapped: make(map[string]int)}
+		keys     = make(map[int]string)
+		lookedUp = make(map[int]dnsdata.Loc)
+	)
+	err := db.Networks(func(n Network) error {
+		lo, ok := lookedUp[n.offset]
+		if !ok {
+			data, err := db.Data(n)
+			if err != nil {
+				return fmt.Errorf("%s: %w", n.IPNet, err)
+			}
+			lo, keys[n.offset] = m.Locate(data)
+			if !n.HasData() {
+				keys[n.offset] = "no data"
+			}
+			lookedUp[n.offset] = lo
+		}
+		ones, bits := n.IPNet.Mask.Size()
+		if lo == nil {
+			result.Unmapped[keys[n.offset]]++
+			gaps.add(LocatedNetwork{IPNet: n.IPNet})
+			return nil
+		}
+		if bits == 32 {
+			result.CoverageV4 += math.Ldexp(1, -ones)
+		} else {
+			result.CoverageV6 += math.Ldexp(1, -ones)
+		}
+		located.add(LocatedNetwork{IPNet: n.IPNet, Loc: lo})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Networks = located.stack
+	for _, n := range gaps.stack {
+		result.Gaps = append(result.Gaps, n.IPNet)
+	}
+	return result, nil
+}
+
+// WriteRecords writes the % records of the networks for the map ID lmap.
+func WriteRecords(w io.Writer, networks []LocatedNetwork, lmap dnsdata.Lmap) error {
+	bw := bufio.NewWriter(w)
+	for _, n := range networks {
+		bw.WriteString("%")
+		dnsdata.Putloctext(bw, n.Loc)
+		bw.Write(dnsdata.NSEP)
+		bw.WriteString(n.IPNet.String())
+		bw.Write(dnsdata.NSEP)
+		dnsdata.Putlmaptext(bw, lmap)
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// WriteReport writes a summary of the coverage of the address spaces, and
+// of the networks not mapped.
+func (r *Result) WriteReport(w io.Writer) error {
+	var v4, v6 int
+	for _, n := range r.Gaps {
+		if len(n.IP) == net.IPv4len {
+			v4++
+		} else {
+			v6++
+		}
+	}
+	fmt.Fprintf(w, "IPv4: %.2f%% of the address space mapped, %d gaps\n", r.CoverageV4*100, v4)
+	fmt.Fprintf(w, "IPv6: %.2f%% of the address space mapped, %d gaps\n", r.CoverageV6*100, v6)
+	keys := make([]string, 0, len(r.Unmapped))
+	for k := range r.Unmapped {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(cmp.Compare(r.Unmapped[b], r.Unmapped[a]), cmp.Compare(a, b))
+	})
+	for _, k := range keys {
+		if _, err := fmt.Fprintf(w, "unmapped %s: %d networks\n", k, r.Unmapped[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package geoimport
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+const testMapping = `# locations
+country,AU,au
+country,us,us
+region,US-CA,ca
+asn,AS64500,\000\003
+`
+
+func TestParseMapping(t *testing.T) {
+	m, err := ParseMapping(strings.NewReader(testMapping))
+	require.NoError(t, err)
+	require.Equal(t, map[string]dnsdata.Loc{"AU": dnsdata.Loc("au"), "US": dnsdata.Loc("us")}, m.countries)
+	require.Equal(t, map[string]dnsdata.Loc{"US-CA": dnsdata.Loc("ca")}, m.regions)
+	require.Equal(t, map[uint64]dnsdata.Loc{64500: {0, 3}}, m.asns)
+
+	for _, invalid := range []string{
+		"country,AU",
+		"city,Sydney,au",
+		"country,AU,a",
+		"asn,x,au",
+	} {
+		_, err := ParseMapping(strings.NewReader(invalid))
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	m, err := ParseMapping(strings.NewReader(testMapping))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		data any
+		loc  dnsdata.Loc
+		key  string
+	}{
+		{data: normalize(city("AU", "NSW")), loc: dnsdata.Loc("au"), key: "country:AU"},
+		{data: normalize(city("US", "CA")), loc: dnsdata.Loc("ca"), key: "region:US-CA"},
+		{data: normalize(city("US", "NY")), loc: dnsdata.Loc("us"), key: "country:US"},
+		{data: normalize(city("FR", "")), key: "country:FR"},
+		{
+			data: map[string]any{"registered_country": map[string]any{"iso_code": "AU"}},
+			loc:  dnsdata.Loc("au"),
+			key:  "country:AU",
+		},
+		{
+			data: map[string]any{"country": map[string]any{"iso_code": "US"}, "autonomous_system_number": uint64(64500)},
+			loc:  dnsdata.Loc{0, 3},
+			key:  "asn:64500",
+		},
+		{data: map[string]any{"autonomous_system_number": uint64(64501)}, key: "asn:64501"},
+		{data: nil, key: "unknown"},
+	}
+	for _, tc := range testCases {
+		loc, key := m.Locate(tc.data)
+		require.Equal(t, tc.loc, loc)
+		require.Equal(t, tc.key, key)
+	}
+}
+
+func TestImport(t *testing.T) {
+	m, err := ParseMapping(strings.NewReader(testMapping))
+	require.NoError(t, err)
+	db, err := NewMMDB(writeTestMMDB(t, 6, 28, []testEntry{
+		// collapsed into 1.0.0.0/23
+		{cidr: "1.0.0.0/25", data: city("AU", "NSW")},
+		{cidr: "1.0.0.128/25", data: city("AU", "VIC")},
+		{cidr: "1.0.1.0/24", data: city("AU", "")},
+		{cidr: "1.0.2.0/24", data: city("FR", "")},
+		{cidr: "1.0.3.0/24", data: city("US", "CA")},
+		{cidr: "2001:db8::/33", data: map[string]any{"autonomous_system_number": uint32(64500)}},
+		{cidr: "2001:db8:8000::/33", data: map[string]any{"autonomous_system_number": uint32(64500)}},
+	}))
+	require.NoError(t, err)
+
+	result, err := Import(db, m)
+	require.NoError(t, err)
+
+	out := new(bytes.Buffer)
+	require.NoError(t, WriteRecords(out, result.Networks, dnsdata.Lmap("m1")))
+	require.Equal(t, `%\141\165,1.0.0.0/23,\155\061
+%\143\141,1.0.3.0/24,\155\061
+%\000\003,2001:db8::/32,\155\061
+`, out.String())
+
+	var gaps []string
+	for _, n := range result.Gaps {
+		gaps = append(gaps, n.String())
+	}
+	require.Contains(t, gaps, "1.0.2.0/24")
+	require.Contains(t, gaps, "0.0.0.0/8")
+	require.Contains(t, gaps, "2001:db9::/32")
+	require.Equal(t, 1, result.Unmapped["country:FR"])
+	require.InDelta(t, 3.0/(1<<24), result.CoverageV4, 1e-15)
+
+	report := new(bytes.Buffer)
+	require.NoError(t, result.WriteReport(report))
+	require.Contains(t, report.String(), "unmapped country:FR: 1 networks\n")
+	require.Contains(t, report.String(), "IPv4: 0.00% of the address space mapped")
+}
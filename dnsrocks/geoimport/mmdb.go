@@ -0,0 +1,356 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package geoimport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+)
+
+// metadataMarker precedes the metadata at the end of MMDB files.
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// dataSectionSeparator is the number of zero bytes between the search tree
+// and the data section.
+const dataSectionSeparator = 16
+
+// ErrInvalidMMDB is returned for files not in the MMDB format.
+var ErrInvalidMMDB = errors.New("invalid MMDB file")
+
+// Metadata holds the metadata of a MMDB file, see
+// https://maxmind.github.io/MaxMind-DB/#database-metadata.
+type Metadata struct {
+	NodeCount    uint32
+	RecordSize   uint16
+	IPVersion    uint16
+	DatabaseType string
+	BuildEpoch   uint64
+}
+
+// MMDB is a MaxMind DB file, as published by MaxMind or DB-IP, loaded in
+// memory.
+type MMDB struct {
+	Metadata Metadata
+	buf      []byte
+	tree     []byte
+	data     []byte
+}
+
+// OpenMMDB loads the MMDB file at path.
+func OpenMMDB(path string) (*MMDB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewMMDB(buf)
+}
+
+// NewMMDB parses the content of a MMDB file.
+func NewMMDB(buf []byte) (*MMDB, error) {
+	i := bytes.LastIndex(buf, metadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: no metadata", ErrInvalidMMDB)
+	}
+	v, _, err := decode(buf[i+len(metadataMarker):], 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %w", ErrInvalidMMDB, err)
+	}
+	meta, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidMMDB)
+	}
+	m := &MMDB{buf: buf}
+	nodeCount, _ := meta["node_count"].(uint64)
+	recordSize, _ := meta["record_size"].(uint64)
+	ipVersion, _ := meta["ip_version"].(uint64)
+	m.Metadata = Metadata{
+		NodeCount:  uint32(nodeCount),
+		RecordSize: uint16(recordSize),
+		IPVersion:  uint16(ipVersion),
+	}
+	m.Metadata.DatabaseType, _ = meta["database_type"].(string)
+	m.Metadata.BuildEpoch, _ = meta["build_epoch"].(uint64)
+
+	switch m.Metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: unsupported record size %d", ErrInvalidMMDB, m.Metadata.RecordSize)
+	}
+	if m.Metadata.IPVersion != 4 && m.Metadata.IPVersion != 6 {
+		return nil, fmt.Errorf("%w: unsupported IP version %d", ErrInvalidMMDB, m.Metadata.IPVersion)
+	}
+	treeSize := int(m.Metadata.RecordSize) * 2 / 8 * int(m.Metadata.NodeCount)
+	if treeSize+dataSectionSeparator > i {
+		return nil, fmt.Errorf("%w: search tree larger than the file", ErrInvalidMMDB)
+	}
+	m.tree = buf[:treeSize]
+	m.data = buf[treeSize+dataSectionSeparator : i]
+	return m, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node.
+func (m *MMDB) record(node uint32, bit byte) uint32 {
+	switch m.Metadata.RecordSize {
+	case 24:
+		b := m.tree[node*6+uint32(bit)*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		b := m.tree[node*7:]
+		if bit == 0 {
+			return uint32(b[3]&0xf0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		return binary.BigEndian.Uint32(m.tree[node*8+uint32(bit)*4:])
+	}
+}
+
+// Network is a network of the search tree, along with the offset of its data
+// in the data section, or -1 if it has none.
+type Network struct {
+	IPNet  *net.IPNet
+	offset int
+}
+
+// HasData returns whether there is data for the network.
+func (n Network) HasData() bool {
+	return n.offset >= 0
+}
+
+// Networks calls f for each network of the search tree, in order, IPv4 ones
+// first. They cover the whole address space. The IPv4 networks are not
+// repeated under their IPv6 aliases, e.g. ::ffff:0:0/96.
+func (m *MMDB) Networks(f func(n Network) error) error {
+	nodeCount := m.Metadata.NodeCount
+	if m.Metadata.IPVersion == 4 {
+		return m.walk(0, make(net.IP, net.IPv4len), 0, nodeCount, f)
+	}
+	// the IPv4 networks are the ones below ::/96
+	ipv4Start := uint32(0)
+	for range 96 {
+		if ipv4Start >= nodeCount {
+			break
+		}
+		ipv4Start = m.record(ipv4Start, 0)
+	}
+	if err := m.walk(ipv4Start, make(net.IP, net.IPv4len), 0, ipv4Start, f); err != nil {
+		return err
+	}
+	return m.walk(0, make(net.IP, net.IPv6len), 0, ipv4Start, f)
+}
+
+// walk calls f for the networks below the record rec of the network ip/depth.
+// The subtree of the IPv4 networks, skip, is left out of the IPv6 ones.
+func (m *MMDB) walk(rec uint32, ip net.IP, depth int, skip uint32, f func(n Network) error) error {
+	bits := len(ip) * 8
+	nodeCount := m.Metadata.NodeCount
+	if len(ip) == net.IPv6len && depth > 0 {
+		if rec == skip && rec < nodeCount {
+			return nil
+		}
+		if depth == 96 && bytes.Equal(ip[:12], make([]byte, 12)) {
+			return nil
+		}
+	}
+	if rec < nodeCount && depth < bits {
+		for bit := range byte(2) {
+			sub := make(net.IP, len(ip))
+			copy(sub, ip)
+			if bit == 1 {
+				sub[depth/8] |= 0x80 >> (depth % 8)
+			}
+			if err := m.walk(m.record(rec, bit), sub, depth+1, skip, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	n := Network{IPNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(depth, bits)}, offset: -1}
+	switch {
+	case rec == nodeCount:
+	case rec > nodeCount:
+		n.offset = int(rec-nodeCount) - dataSectionSeparator
+		if n.offset < 0 || n.offset >= len(m.data) {
+			return fmt.Errorf("%w: data pointer %d out of range for %s", ErrInvalidMMDB, rec, n.IPNet)
+		}
+	default:
+		return fmt.Errorf("%w: search tree deeper than %d bits", ErrInvalidMMDB, bits)
+	}
+	return f(n)
+}
+
+// Data decodes the data of a network.
+func (m *MMDB) Data(n Network) (any, error) {
+	if !n.HasData() {
+		return nil, nil
+	}
+	v, _, err := decode(m.data, n.offset)
+	return v, err
+}
+
+// MMDB data types, see https://maxmind.github.io/MaxMind-DB/#output-data-section.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+var errTruncated = errors.New("truncated data")
+
+// decode decodes the value at offset of the data section buf. It returns the
+// offset following it. Maps are decoded as map[string]any, arrays as []any,
+// unsigned integers as uint64, signed ones as int64, floats as float64 and
+// uint128 as *big.Int.
+func decode(buf []byte, offset int) (any, int, error) {
+	if offset >= len(buf) {
+		return nil, 0, errTruncated
+	}
+	ctrl := buf[offset]
+	offset++
+	typ := int(ctrl >> 5)
+	if typ == typePointer {
+		size := int(ctrl>>3) & 0x3
+		if offset+size+1 > len(buf) {
+			return nil, 0, errTruncated
+		}
+		p := 0
+		if size < 3 {
+			p = int(ctrl & 0x7)
+		}
+		for _, b := range buf[offset : offset+size+1] {
+			p = p<<8 | int(b)
+		}
+		p += [...]int{0, 2048, 526336, 0}[size]
+		v, _, err := decode(buf, p)
+		return v, offset + size + 1, err
+	}
+	if typ == typeExtended {
+		if offset >= len(buf) {
+			return nil, 0, errTruncated
+		}
+		typ = int(buf[offset]) + 7
+		offset++
+	}
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(buf) {
+			return nil, 0, errTruncated
+		}
+		v := 0
+		for _, b := range buf[offset : offset+n] {
+			v = v<<8 | int(b)
+		}
+		size = v + [...]int{29, 285, 65821}[n-1]
+		offset += n
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, size)
+		for range size {
+			k, next, err := decode(buf, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key of type %T", k)
+			}
+			if m[key], offset, err = decode(buf, next); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, size)
+		for i := range a {
+			var err error
+			if a[i], offset, err = decode(buf, offset); err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, 0, fmt.Errorf("unexpected data type %d", typ)
+	}
+
+	if offset+size > len(buf) {
+		return nil, 0, errTruncated
+	}
+	b := buf[offset : offset+size]
+	offset += size
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return bytes.Clone(b), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("integer of size %d", size)
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("int32 of size %d", size)
+		}
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), offset, nil
+	case typeUint128:
+		return new(big.Int).SetBytes(b), offset, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown data type %d", typ)
+	}
+}
@@ -0,0 +1,308 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package geoimport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testEntry is a network of a generated MMDB, along with its data.
+type testEntry struct {
+	cidr string
+	data map[string]any
+}
+
+// mmdbWriter generates MMDB files for the tests.
+type mmdbWriter struct {
+	data *bytes.Buffer
+	// keys holds the offsets of the map keys written, which are then
+	// written as pointers to exercise them.
+	keys map[string]int
+}
+
+func (w *mmdbWriter) ctrl(typ int, size int) {
+	var ext []byte
+	if typ > 7 {
+		ext = []byte{byte(typ - 7)}
+		typ = 0
+	}
+	var extra []byte
+	switch {
+	case size < 29:
+	case size < 285:
+		extra = []byte{byte(size - 29)}
+		size = 29
+	case size < 65821:
+		extra = binary.BigEndian.AppendUint16(nil, uint16(size-285))
+		size = 30
+	default:
+		extra = binary.BigEndian.AppendUint32(nil, uint32(size-65821))[1:]
+		size = 31
+	}
+	w.data.WriteByte(byte(typ<<5 | size))
+	w.data.Write(ext)
+	w.data.Write(extra)
+}
+
+func (w *mmdbWriter) uint(typ int, v uint64) {
+	b := binary.BigEndian.AppendUint64(nil, v)
+	b = bytes.TrimLeft(b, "\x00")
+	w.ctrl(typ, len(b))
+	w.data.Write(b)
+}
+
+func (w *mmdbWriter) encode(v any) {
+	switch v := v.(type) {
+	case string:
+		w.ctrl(typeString, len(v))
+		w.data.WriteString(v)
+	case uint16:
+		w.uint(typeUint16, uint64(v))
+	case uint32:
+		w.uint(typeUint32, uint64(v))
+	case uint64:
+		w.uint(typeUint64, v)
+	case []any:
+		w.ctrl(typeArray, len(v))
+		for _, e := range v {
+			w.encode(e)
+		}
+	case map[string]any:
+		w.ctrl(typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			if off, ok := w.keys[k]; ok && off < 2048 {
+				w.data.WriteByte(byte(typePointer<<5 | off>>8))
+				w.data.WriteByte(byte(off))
+			} else {
+				w.keys[k] = w.data.Len()
+				w.encode(k)
+			}
+			w.encode(v[k])
+		}
+	default:
+		panic(v)
+	}
+}
+
+// writeTestMMDB generates a MMDB file holding the entries.
+func writeTestMMDB(t *testing.T, ipVersion uint16, recordSize uint16, entries []testEntry) []byte {
+	const empty = -1
+	// records >= 0 are nodes, < empty are data offsets
+	nodes := [][2]int{{empty, empty}}
+	w := &mmdbWriter{data: new(bytes.Buffer), keys: make(map[string]int)}
+	for _, e := range entries {
+		_, ipnet, err := net.ParseCIDR(e.cidr)
+		require.NoError(t, err)
+		ones, _ := ipnet.Mask.Size()
+		ip := ipnet.IP
+		if ipVersion == 6 {
+			if ip.To4() != nil {
+				ones += 96
+			}
+			ip = ip.To16()
+			if ipnet.IP.To4() != nil {
+				copy(ip, make([]byte, 12))
+			}
+		} else if ip = ip.To4(); ip == nil {
+			continue
+		}
+		node := 0
+		for depth := range ones {
+			bit := ip[depth/8] >> (7 - depth%8) & 1
+			if depth == ones-1 {
+				nodes[node][bit] = empty - 1 - w.data.Len()
+				break
+			}
+			if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+		w.encode(e.data)
+	}
+
+	nodeCount := len(nodes)
+	tree := new(bytes.Buffer)
+	for _, n := range nodes {
+		var rec [2]uint32
+		for i, r := range n {
+			switch {
+			case r >= 0:
+				rec[i] = uint32(r)
+			case r == empty:
+				rec[i] = uint32(nodeCount)
+			default:
+				rec[i] = uint32(nodeCount + dataSectionSeparator + empty - 1 - r)
+			}
+		}
+		switch recordSize {
+		case 24:
+			tree.Write(binary.BigEndian.AppendUint32(nil, rec[0])[1:])
+			tree.Write(binary.BigEndian.AppendUint32(nil, rec[1])[1:])
+		case 28:
+			tree.Write(binary.BigEndian.AppendUint32(nil, rec[0])[1:])
+			tree.WriteByte(byte(rec[0]>>24)<<4 | byte(rec[1]>>24))
+			tree.Write(binary.BigEndian.AppendUint32(nil, rec[1])[1:])
+		case 32:
+			tree.Write(binary.BigEndian.AppendUint32(nil, rec[0]))
+			tree.Write(binary.BigEndian.AppendUint32(nil, rec[1]))
+		}
+	}
+
+	out := bytes.NewBuffer(tree.Bytes())
+	out.Write(make([]byte, dataSectionSeparator))
+	out.Write(w.data.Bytes())
+	out.Write(metadataMarker)
+	meta := &mmdbWriter{data: out, keys: make(map[string]int)}
+	meta.encode(map[string]any{
+		"node_count":    uint32(nodeCount),
+		"record_size":   recordSize,
+		"ip_version":    ipVersion,
+		"database_type": "Test-City",
+		"build_epoch":   uint64(1700000000),
+	})
+	return out.Bytes()
+}
+
+func city(country string, subdivision string) map[string]any {
+	data := map[string]any{"country": map[string]any{"iso_code": country}}
+	if subdivision != "" {
+		data["subdivisions"] = []any{map[string]any{"iso_code": subdivision}}
+	}
+	return data
+}
+
+func TestMMDBNetworks(t *testing.T) {
+	entries := []testEntry{
+		{cidr: "1.0.0.0/24", data: city("AU", "")},
+		{cidr: "1.0.1.0/24", data: city("AU", "NSW")},
+		{cidr: "2001:db8::/32", data: map[string]any{"autonomous_system_number": uint32(64500)}},
+	}
+	for _, ipVersion := range []uint16{4, 6} {
+		for _, recordSize := range []uint16{24, 28, 32} {
+			db, err := NewMMDB(writeTestMMDB(t, ipVersion, recordSize, entries))
+			require.NoError(t, err)
+			require.Equal(t, Metadata{
+				NodeCount:    db.Metadata.NodeCount,
+				RecordSize:   recordSize,
+				IPVersion:    ipVersion,
+				DatabaseType: "Test-City",
+				BuildEpoch:   1700000000,
+			}, db.Metadata)
+
+			var (
+				found []string
+				v4    float64
+			)
+			err = db.Networks(func(n Network) error {
+				ones, bits := n.IPNet.Mask.Size()
+				if bits == 32 {
+					v4 += 1 / float64(uint64(1)<<ones)
+				}
+				if !n.HasData() {
+					return nil
+				}
+				data, err := db.Data(n)
+				require.NoError(t, err)
+				for _, e := range entries {
+					if e.cidr == n.IPNet.String() {
+						require.Equal(t, normalize(e.data), data)
+					}
+				}
+				found = append(found, n.IPNet.String())
+				return nil
+			})
+			require.NoError(t, err)
+			expected := []string{"1.0.0.0/24", "1.0.1.0/24"}
+			if ipVersion == 6 {
+				expected = append(expected, "2001:db8::/32")
+			}
+			require.Equal(t, expected, found, "IPv%d, %d bits", ipVersion, recordSize)
+			require.InDelta(t, 1, v4, 1e-12, "the IPv4 networks cover its address space once")
+		}
+	}
+}
+
+// normalize converts the integers of the test data to the decoded ones.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case uint32:
+		return uint64(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = normalize(e)
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			out[k] = normalize(e)
+		}
+		return out
+	}
+	return v
+}
+
+func TestDecode(t *testing.T) {
+	long := bytes.Repeat([]byte("a"), 30)
+	testCases := []struct {
+		name     string
+		buf      []byte
+		offset   int
+		expected any
+	}{
+		{name: "string", buf: []byte("\x43abc"), expected: "abc"},
+		{name: "long string", buf: append([]byte{0x5d, 1}, long...), expected: string(long)},
+		{name: "uint16", buf: []byte{0xa2, 0x12, 0x34}, expected: uint64(0x1234)},
+		{name: "int32", buf: []byte{0x04, 0x01, 0xff, 0xff, 0xff, 0xfe}, expected: int64(-2)},
+		{name: "uint128", buf: []byte{0x01, 0x03, 0x01}, expected: big.NewInt(1)},
+		{name: "bool", buf: []byte{0x01, 0x07}, expected: true},
+		{name: "double", buf: []byte{0x68, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, expected: 1.5},
+		{name: "pointer", buf: []byte("\x43abc\x20\x00"), offset: 4, expected: "abc"},
+		{name: "array", buf: []byte{0x02, 0x04, 0x41, 'a', 0x41, 'b'}, expected: []any{"a", "b"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			v, next, err := decode(tc.buf, tc.offset)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, v)
+			require.Equal(t, len(tc.buf), next)
+		})
+	}
+
+	_, _, err := decode([]byte("\x43ab"), 0)
+	require.ErrorIs(t, err, errTruncated)
+}
+
+func TestNewMMDBInvalid(t *testing.T) {
+	_, err := NewMMDB([]byte("not a database"))
+	require.ErrorIs(t, err, ErrInvalidMMDB)
+}
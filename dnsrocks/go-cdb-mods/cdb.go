@@ -211,3 +211,32 @@ func (c *Cdb) ForEachKeys(f func(keyHash uint32, key, value []byte)) (err error)
 	}
 	return
 }
+
+// ForEachRecord calls a function with the key and value of every record, in
+// the order they were written. If the function returns an error, the loop
+// stops.
+func (c *Cdb) ForEachRecord(f func(key, value []byte) error) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = e.(error)
+		}
+	}()
+
+	var context = NewContext()
+
+	// The records are followed by the hash tables, the first one being
+	// pointed at by the header.
+	eod, _ := c.readNums(0, context)
+	for pos := headerSize; pos < eod; {
+		klen, vlen := c.readNums(pos, context)
+		x := pos + 8
+		kval := c.mmappedData[x : x+klen]
+		x += klen
+		vval := c.mmappedData[x : x+vlen]
+		if err = f(kval, vval); err != nil {
+			return err
+		}
+		pos = x + vlen
+	}
+	return nil
+}
@@ -52,6 +52,10 @@ var (
 	localRand = db.NewRand()
 )
 
+// dnstapSocketProtocolDOQ is DNS over QUIC in the dnstap schema, which
+// golang-dnstap doesn't define yet.
+const dnstapSocketProtocolDOQ dnstap.SocketProtocol = 7
+
 // DNSTapLogger logs to dnstap output
 type DNSTapLogger struct {
 	dnsTapOutput anyDNSTapOutPut
@@ -139,6 +143,9 @@ func (l *DNSTapLogger) Log(state request.Request, r *dns.Msg, _ *dns.EDNS0_SUBNE
 	if err != nil {
 		glog.Errorf("Failed to set ResponseAddress %v for dnstap message", state.W.RemoteAddr())
 	}
+	if proto, ok := dnstapSocketProtocol(state); ok {
+		m.SocketProtocol = &proto
+	}
 	msg.SetQueryTime(m, time.Now())
 	msg.SetType(m, dnstap.Message_AUTH_QUERY)
 	buf, _ = r.Pack()
@@ -162,6 +169,24 @@ func (l *DNSTapLogger) Log(state request.Request, r *dns.Msg, _ *dns.EDNS0_SUBNE
 	}
 }
 
+// dnstapSocketProtocol returns the dnstap protocol of the request when it can't
+// be derived from the address type by msg.SetQueryAddress, e.g. DoT, DoH or DoQ.
+func dnstapSocketProtocol(state request.Request) (dnstap.SocketProtocol, bool) {
+	if transport, ok := RequestTransport(state); ok {
+		switch transport {
+		case TransportDoH:
+			return dnstap.SocketProtocol_DOH, true
+		case TransportDoQ:
+			return dnstapSocketProtocolDOQ, true
+		}
+		return 0, false
+	}
+	if stater, ok := state.W.(dns.ConnectionStater); ok && stater.ConnectionState() != nil {
+		return dnstap.SocketProtocol_DOT, true
+	}
+	return 0, false
+}
+
 // LogFailed is used to log failures
 func (l *DNSTapLogger) LogFailed(state request.Request, ecs *dns.EDNS0_SUBNET, loc *db.Location, responseTime int64) {
 	m := new(dns.Msg)
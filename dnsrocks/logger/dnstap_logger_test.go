@@ -20,7 +20,9 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/coredns/coredns/plugin/test"
 	"github.com/coredns/coredns/request"
+	dnstap "github.com/dnstap/golang-dnstap"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
 )
@@ -186,3 +188,23 @@ func TestIsSonarInvalidNames(t *testing.T) {
 		})
 	}
 }
+
+// TestDnstapSocketProtocol tests that transports which cannot be derived from
+// the address type are recorded with their own dnstap protocol.
+func TestDnstapSocketProtocol(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+
+	_, ok := dnstapSocketProtocol(request.Request{W: &test.ResponseWriter{TCP: true}, Req: m})
+	require.False(t, ok)
+
+	w := &transportResponseWriter{ResponseWriter: test.ResponseWriter{TCP: true}, transport: TransportDoH}
+	proto, ok := dnstapSocketProtocol(request.Request{W: w, Req: m})
+	require.True(t, ok)
+	require.Equal(t, dnstap.SocketProtocol_DOH, proto)
+
+	w.transport = TransportDoQ
+	proto, ok = dnstapSocketProtocol(request.Request{W: w, Req: m})
+	require.True(t, ok)
+	require.Equal(t, dnstapSocketProtocolDOQ, proto)
+}
@@ -65,9 +65,36 @@ type Config struct {
 	Category      string
 }
 
+// Transport names reported by a Transporter.
+const (
+	TransportDoH = "doh" // DNS over HTTPS (RFC 8484)
+	TransportDoQ = "doq" // DNS over QUIC (RFC 9250)
+)
+
+// Transporter is implemented by [dns.ResponseWriter]s serving queries over a
+// transport that cannot be told apart from the address type alone (e.g. DoH
+// runs over TCP, but isn't plain TCP nor DoT, DoQ runs over UDP).
+type Transporter interface {
+	Transport() string
+}
+
+// RequestTransport returns the transport reported by the request's
+// [dns.ResponseWriter], if it implements Transporter. An empty transport is
+// not reported, which lets wrapping writers forward it unconditionally.
+func RequestTransport(state request.Request) (string, bool) {
+	if t, ok := state.W.(Transporter); ok && t.Transport() != "" {
+		return t.Transport(), true
+	}
+	return "", false
+}
+
 // RequestProtocol return a string version of the protocol (UDP, TCP or TLS)
 // TLS has its version appended if it is available (which should always be)
+// Transports reported through Transporter (e.g. DOH) take precedence.
 func RequestProtocol(state request.Request) string {
+	if transport, ok := RequestTransport(state); ok {
+		return strings.ToUpper(transport)
+	}
 	proto := state.Proto() // Protocol used
 	if proto == "tcp" {
 		var tls *tls.ConnectionState
@@ -19,10 +19,20 @@ package logger
 import (
 	"testing"
 
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
 )
 
+// transportResponseWriter is a test.ResponseWriter reporting a Transport.
+type transportResponseWriter struct {
+	test.ResponseWriter
+	transport string
+}
+
+func (w *transportResponseWriter) Transport() string { return w.transport }
+
 // TestCollectDNSFlags tests CollectDNSFlags sets the right value w.r.t. each flag
 func TestCollectDNSFlags(t *testing.T) {
 	m := new(dns.Msg)
@@ -58,3 +68,42 @@ func TestCollectDNSFlags(t *testing.T) {
 	flagsAfter := CollectDNSFlags(m)
 	require.Equal(t, flagsBefore, flagsAfter)
 }
+
+// TestRequestProtocol tests that RequestProtocol reports the address based
+// protocol, unless the ResponseWriter reports its own transport.
+func TestRequestProtocol(t *testing.T) {
+	testCases := []struct {
+		name     string
+		w        dns.ResponseWriter
+		expected string
+	}{
+		{
+			name:     "udp",
+			w:        &test.ResponseWriter{},
+			expected: "UDP",
+		},
+		{
+			name:     "tcp",
+			w:        &test.ResponseWriter{TCP: true},
+			expected: "TCP",
+		},
+		{
+			name:     "doh",
+			w:        &transportResponseWriter{ResponseWriter: test.ResponseWriter{TCP: true}, transport: TransportDoH},
+			expected: "DOH",
+		},
+		{
+			name:     "doq",
+			w:        &transportResponseWriter{transport: TransportDoQ},
+			expected: "DOQ",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := new(dns.Msg)
+			m.SetQuestion("example.com.", dns.TypeA)
+			state := request.Request{W: tc.w, Req: m}
+			require.Equal(t, tc.expected, RequestProtocol(state))
+		})
+	}
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package notify sends NOTIFY messages (RFC 1996) to the secondaries of the
+// zones whose SOA serial changed as the DB got reloaded.
+package notify
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+// Targets are the addresses of the secondaries to notify, by zone.
+type Targets map[string][]string
+
+func (t Targets) String() string {
+	zones := make([]string, 0, len(t))
+	for zone, addrs := range t {
+		zones = append(zones, zone+"="+strings.Join(addrs, ","))
+	}
+	sort.Strings(zones)
+	return strings.Join(zones, " ")
+}
+
+// Set adds the targets of a zone, given as zone=addr[,addr...]. The port of
+// the addresses defaults to 53.
+func (t Targets) Set(v string) error {
+	zone, addrs, ok := strings.Cut(v, "=")
+	if !ok || zone == "" || addrs == "" {
+		return fmt.Errorf("expected zone=addr[,addr...], got %q", v)
+	}
+	zone = dns.CanonicalName(zone)
+	for _, addr := range strings.Split(addrs, ",") {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "53")
+		}
+		host, _, _ := net.SplitHostPort(addr)
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid target %q for zone %s", addr, zone)
+		}
+		t[zone] = append(t[zone], addr)
+	}
+	return nil
+}
+
+// Config contains the config for sending NOTIFY messages.
+type Config struct {
+	// Targets are the secondaries notified of the changes of each zone.
+	// Nothing is sent when empty.
+	Targets Targets
+	// Retries is the number of times a NOTIFY is sent again to a target
+	// which did not acknowledge it.
+	Retries int
+	// Timeout is the time to wait for the acknowledgement of a target.
+	Timeout time.Duration
+	// Backoff is the delay before the first retry, doubled before each of
+	// the next ones.
+	Backoff time.Duration
+}
+
+// Enabled returns whether some zones have targets.
+func (c *Config) Enabled() bool {
+	return len(c.Targets) > 0
+}
+
+// Notifier is a [dnsserver.ReloadObserver] sending NOTIFY messages for the
+// zones whose SOA serial changed across a reload.
+type Notifier struct {
+	conf   Config
+	zones  map[string][]byte
+	client *dns.Client
+	stats  stats.Stats
+	// before are the SOA records of the zones before the ongoing reload.
+	before map[string]*dns.SOA
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewNotifier initializes a new Notifier.
+func NewNotifier(conf Config, stats stats.Stats) (*Notifier, error) {
+	if conf.Retries < 0 {
+		return nil, fmt.Errorf("invalid number of retries: %d", conf.Retries)
+	}
+	if conf.Timeout <= 0 {
+		return nil, fmt.Errorf("invalid timeout: %v", conf.Timeout)
+	}
+	n := &Notifier{
+		conf:   conf,
+		zones:  make(map[string][]byte),
+		client: &dns.Client{Timeout: conf.Timeout},
+		stats:  stats,
+		done:   make(chan struct{}),
+	}
+	for zone := range conf.Targets {
+		packed := make([]byte, 255)
+		off, err := dns.PackDomainName(zone, packed, 0, nil, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zone %q: %w", zone, err)
+		}
+		n.zones[zone] = packed[:off]
+	}
+	return n, nil
+}
+
+// soaRecords returns the default location SOA records of the zones in the DB.
+func (n *Notifier) soaRecords(r db.Reader) map[string]*dns.SOA {
+	records := make(map[string]*dns.SOA, len(n.zones))
+	for zone, packed := range n.zones {
+		m := new(dns.Msg)
+		db.FindSOA(r, packed, zone, db.ZeroID, m)
+		if len(m.Ns) > 0 {
+			if soa, ok := m.Ns[0].(*dns.SOA); ok {
+				records[zone] = soa
+			}
+		}
+	}
+	return records
+}
+
+// BeforeReload implements the [dnsserver.ReloadObserver] interface.
+func (n *Notifier) BeforeReload(r db.Reader) {
+	n.before = n.soaRecords(r)
+}
+
+// AfterReload implements the [dnsserver.ReloadObserver] interface.
+func (n *Notifier) AfterReload(r db.Reader) {
+	n.notifyChanged(n.before, n.soaRecords(r))
+}
+
+// notifyChanged notifies the targets of the zones whose serial changed, or
+// which were added.
+func (n *Notifier) notifyChanged(before, after map[string]*dns.SOA) {
+	for zone, soa := range after {
+		if old, ok := before[zone]; ok && old.Serial == soa.Serial {
+			continue
+		}
+		glog.Infof("Serial of %s changed to %d, notifying %d secondaries", zone, soa.Serial, len(n.conf.Targets[zone]))
+		for _, target := range n.conf.Targets[zone] {
+			n.wg.Add(1)
+			go func() {
+				defer n.wg.Done()
+				n.notify(soa, target)
+			}()
+		}
+	}
+}
+
+// notify sends a NOTIFY to a target until it is acknowledged, or retries are
+// exhausted.
+func (n *Notifier) notify(soa *dns.SOA, target string) {
+	m := new(dns.Msg)
+	m.SetNotify(soa.Hdr.Name)
+	m.Answer = []dns.RR{soa}
+	prefix := fmt.Sprintf("DNS_notify.%s", target)
+	backoff := n.conf.Backoff
+	for attempt := 0; ; attempt++ {
+		r, _, err := n.client.Exchange(m, target)
+		if err == nil && r.Rcode != dns.RcodeSuccess {
+			err = fmt.Errorf("rcode %s", dns.RcodeToString[r.Rcode])
+		}
+		if err == nil {
+			n.stats.IncrementCounter(prefix + ".success")
+			return
+		}
+		if attempt == n.conf.Retries {
+			n.stats.IncrementCounter(prefix + ".failure")
+			glog.Errorf("Failed to notify %s of serial %d of %s: %v", target, soa.Serial, soa.Hdr.Name, err)
+			return
+		}
+		n.stats.IncrementCounter(prefix + ".retry")
+		select {
+		case <-time.After(backoff):
+		case <-n.done:
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// Close stops the retries of pending notifications, and waits for them.
+func (n *Notifier) Close() {
+	close(n.done)
+	n.wg.Wait()
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package notify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/testaid"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(testaid.Run(m, "../testdata/data"))
+}
+
+// lockedCounters are stats safe for use by concurrent notifications.
+type lockedCounters struct {
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+func newLockedCounters() *lockedCounters {
+	return &lockedCounters{counters: make(map[string]int64)}
+}
+
+func (s *lockedCounters) ResetCounterTo(key string, value int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[key] = value
+}
+
+func (s *lockedCounters) ResetCounter(key string) { s.ResetCounterTo(key, 0) }
+
+func (s *lockedCounters) IncrementCounterBy(key string, value int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[key] += value
+}
+
+func (s *lockedCounters) IncrementCounter(key string) { s.IncrementCounterBy(key, 1) }
+
+func (s *lockedCounters) AddSample(string, int64) {}
+
+func (s *lockedCounters) get(key string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counters[key]
+}
+
+// startSecondary starts a server acknowledging NOTIFY messages, and returns
+// its address and the zones and serials it was notified of.
+func startSecondary(t *testing.T) (string, chan string) {
+	notified := make(chan string, 10)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Opcode != dns.OpcodeNotify {
+			m.Rcode = dns.RcodeRefused
+		} else if soa, ok := r.Answer[0].(*dns.SOA); ok {
+			notified <- fmt.Sprintf("%s %d", soa.Hdr.Name, soa.Serial)
+		}
+		_ = w.WriteMsg(m)
+	})}
+	go func() { _ = s.ActivateAndServe() }()
+	t.Cleanup(func() { _ = s.Shutdown() })
+	return pc.LocalAddr().String(), notified
+}
+
+func testSOA(zone string, serial uint32) *dns.SOA {
+	return &dns.SOA{
+		Hdr:    dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 120},
+		Ns:     "ns." + zone,
+		Mbox:   "dns." + zone,
+		Serial: serial,
+	}
+}
+
+func TestTargetsSet(t *testing.T) {
+	targets := make(Targets)
+	require.NoError(t, targets.Set("Example.com=192.0.2.1,[2001:db8::1]:5353"))
+	require.NoError(t, targets.Set("example.com.=2001:db8::2"))
+	require.Equal(t, Targets{
+		"example.com.": {"192.0.2.1:53", "[2001:db8::1]:5353", "[2001:db8::2]:53"},
+	}, targets)
+	require.Equal(t, "example.com.=192.0.2.1:53,[2001:db8::1]:5353,[2001:db8::2]:53", targets.String())
+
+	for _, v := range []string{"example.com", "=192.0.2.1", "example.com=", "example.com=ns.example.net"} {
+		require.Error(t, targets.Set(v), v)
+	}
+}
+
+func TestNotifyChanged(t *testing.T) {
+	addr, notified := startSecondary(t)
+	s := newLockedCounters()
+	n, err := NewNotifier(Config{
+		Targets: Targets{"example.com.": {addr}, "example.org.": {addr}, "example.net.": {addr}},
+		Timeout: time.Second,
+	}, s)
+	require.NoError(t, err)
+	defer n.Close()
+
+	n.notifyChanged(
+		map[string]*dns.SOA{"example.com.": testSOA("example.com.", 1), "example.org.": testSOA("example.org.", 1)},
+		map[string]*dns.SOA{"example.com.": testSOA("example.com.", 2), "example.org.": testSOA("example.org.", 1), "example.net.": testSOA("example.net.", 1)},
+	)
+	n.wg.Wait()
+	close(notified)
+	var got []string
+	for z := range notified {
+		got = append(got, z)
+	}
+	// Unchanged zones are not notified, added ones are.
+	require.ElementsMatch(t, []string{"example.com. 2", "example.net. 1"}, got)
+	require.Equal(t, int64(2), s.get("DNS_notify."+addr+".success"))
+}
+
+func TestNotifyRetries(t *testing.T) {
+	// A secondary which never answers.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	addr := pc.LocalAddr().String()
+
+	s := newLockedCounters()
+	n, err := NewNotifier(Config{
+		Targets: Targets{"example.com.": {addr}},
+		Retries: 2,
+		Timeout: 20 * time.Millisecond,
+		Backoff: time.Millisecond,
+	}, s)
+	require.NoError(t, err)
+	defer n.Close()
+
+	n.notifyChanged(nil, map[string]*dns.SOA{"example.com.": testSOA("example.com.", 1)})
+	n.wg.Wait()
+	require.Equal(t, int64(2), s.get("DNS_notify."+addr+".retry"))
+	require.Equal(t, int64(1), s.get("DNS_notify."+addr+".failure"))
+	require.Zero(t, s.get("DNS_notify."+addr+".success"))
+}
+
+func TestNotifierReload(t *testing.T) {
+	addr, notified := startSecondary(t)
+	n, err := NewNotifier(Config{
+		Targets: Targets{"example.com.": {addr}, "not-in-db.example.": {addr}},
+		Timeout: time.Second,
+	}, newLockedCounters())
+	require.NoError(t, err)
+	defer n.Close()
+
+	d, err := db.Open(testaid.TestCDB.Path, testaid.TestCDB.Driver)
+	require.NoError(t, err)
+	defer d.Destroy()
+	r, err := db.NewReader(d)
+	require.NoError(t, err)
+	defer r.Close()
+
+	n.BeforeReload(r)
+	require.Len(t, n.before, 1)
+	require.Equal(t, uint32(123), n.before["example.com."].Serial)
+	n.AfterReload(r)
+	n.wg.Wait()
+	require.Empty(t, notified, "serial did not change")
+
+	n.before["example.com."] = testSOA("example.com.", 122)
+	n.AfterReload(r)
+	require.Equal(t, "example.com. 123", <-notified)
+}
+
+func TestNewNotifierInvalidConfig(t *testing.T) {
+	_, err := NewNotifier(Config{Timeout: time.Second, Retries: -1}, newLockedCounters())
+	require.Error(t, err)
+	_, err = NewNotifier(Config{}, newLockedCounters())
+	require.Error(t, err)
+}
@@ -18,9 +18,11 @@ package nsid
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/hex"
 
 	"github.com/facebook/dns/dnsrocks/debuginfo"
+	"github.com/facebook/dns/dnsrocks/logger"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/request"
@@ -79,3 +81,21 @@ func (w nsidResponseWriter) WriteMsg(response *dns.Msg) error {
 
 	return w.ResponseWriter.WriteMsg(response)
 }
+
+// ConnectionState forwards the TLS state of w.ResponseWriter, if any, so that
+// debug info generated down the chain still reports the right protocol.
+func (w nsidResponseWriter) ConnectionState() *tls.ConnectionState {
+	if stater, ok := w.ResponseWriter.(dns.ConnectionStater); ok {
+		return stater.ConnectionState()
+	}
+	return nil
+}
+
+// Transport forwards the transport of w.ResponseWriter, if any, so that
+// debug info generated down the chain still reports the right protocol.
+func (w nsidResponseWriter) Transport() string {
+	if t, ok := w.ResponseWriter.(logger.Transporter); ok {
+		return t.Transport()
+	}
+	return ""
+}
@@ -24,13 +24,23 @@ import (
 
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
 	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/facebook/dns/dnsrocks/debuginfo"
+	"github.com/facebook/dns/dnsrocks/logger"
 )
 
+// transportResponseWriter is a test.ResponseWriter reporting a Transport.
+type transportResponseWriter struct {
+	test.ResponseWriter
+	transport string
+}
+
+func (w *transportResponseWriter) Transport() string { return w.transport }
+
 func TestNSID(t *testing.T) {
 	w := &test.ResponseWriter{}
 
@@ -168,3 +178,33 @@ func TestEDNSIsDisabled(t *testing.T) {
 	// This handler doesn't add EDNS.
 	assert.Nil(t, rec.Msg.IsEdns0())
 }
+
+// TestNSIDForwardsTransport tests that the handlers down the chain still see
+// the transport of the original ResponseWriter.
+func TestNSIDForwardsTransport(t *testing.T) {
+	for _, transport := range []string{"", logger.TransportDoQ} {
+		t.Run(transport, func(t *testing.T) {
+			w := &transportResponseWriter{transport: transport}
+
+			req := new(dns.Msg)
+			req.SetQuestion(dns.Fqdn("example.com."), dns.TypeA)
+			req.SetEdns0(1234, false)
+			req.IsEdns0().Option = append(req.IsEdns0().Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+
+			h, err := NewHandler(false)
+			require.NoError(t, err)
+			var proto string
+			h.Next = test.HandlerFunc(func(_ context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+				proto = logger.RequestProtocol(request.Request{W: w, Req: r})
+				return dns.RcodeSuccess, nil
+			})
+			_, err = h.ServeDNS(context.TODO(), w, req)
+			require.NoError(t, err)
+			if transport == "" {
+				assert.Equal(t, "UDP", proto)
+			} else {
+				assert.Equal(t, "DOQ", proto)
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package rrl implements DNS Response Rate Limiting, which mitigates the use
+// of the server as an amplifier in reflection attacks over UDP.
+package rrl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/cookie"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+	"github.com/golang/glog"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/miekg/dns"
+)
+
+// Config contains the config for response rate limiting.
+type Config struct {
+	// ResponsesPerSecond is the rate of identical responses allowed per
+	// client prefix. 0 disables response rate limiting.
+	ResponsesPerSecond int
+	// NXDomainsPerSecond is the rate of NXDOMAIN responses allowed per client
+	// prefix and zone. 0 to use ResponsesPerSecond.
+	NXDomainsPerSecond int
+	// ErrorsPerSecond is the rate of error responses allowed per client
+	// prefix. 0 to use ResponsesPerSecond.
+	ErrorsPerSecond int
+	// Window is the period over which responses are accounted. Buckets hold
+	// up to rate * Window tokens, which bounds bursts.
+	Window time.Duration
+	// Slip is the ratio of limited responses sent as truncated, so that
+	// legitimate clients retry over TCP. 0 drops all of them, 1 truncates
+	// all of them.
+	Slip int
+	// IPv4PrefixLen and IPv6PrefixLen are the lengths of the prefixes clients
+	// are aggregated by.
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+	// LogOnly accounts and logs limited responses, but still sends them.
+	LogOnly bool
+	// Exempt is a comma separated list of subnets never rate limited.
+	Exempt string
+	// MaxEntries bounds the number of buckets kept in memory. 0 to use
+	// DefaultMaxEntries.
+	MaxEntries int
+}
+
+// DefaultMaxEntries is the number of buckets kept in memory by default.
+const DefaultMaxEntries = 100000
+
+// Enabled returns whether response rate limiting is configured.
+func (c *Config) Enabled() bool {
+	return c.ResponsesPerSecond > 0
+}
+
+// responseClass is the category of a response, responses of distinct classes
+// are accounted separately.
+type responseClass uint8
+
+const (
+	classResponse responseClass = iota
+	classNoData
+	classReferral
+	classNXDomain
+	classError
+)
+
+var classNames = map[responseClass]string{
+	classResponse: "response",
+	classNoData:   "nodata",
+	classReferral: "referral",
+	classNXDomain: "nxdomain",
+	classError:    "error",
+}
+
+func (c responseClass) String() string {
+	return classNames[c]
+}
+
+// action is what to do with a response.
+type action uint8
+
+const (
+	actionSend action = iota
+	actionDrop
+	actionSlip
+)
+
+// bucket is a token bucket accounting the responses of a given key.
+type bucket struct {
+	sync.Mutex
+	tokens float64
+	last   time.Time
+	// limited counts the responses limited since the bucket ran out of
+	// tokens, and is used to pick the responses to slip.
+	limited int
+}
+
+// Limiter keeps the token buckets of all the clients. It is shared by all the
+// handlers of a server.
+type Limiter struct {
+	conf   Config
+	exempt []*net.IPNet
+	// mu makes the lookup and insertion of buckets atomic.
+	mu      sync.Mutex
+	buckets *lru.Cache
+	v4Mask  net.IPMask
+	v6Mask  net.IPMask
+	now     func() time.Time
+}
+
+// NewLimiter initializes a new Limiter.
+func NewLimiter(conf Config) (*Limiter, error) {
+	if conf.ResponsesPerSecond <= 0 {
+		return nil, fmt.Errorf("invalid responses per second: %d", conf.ResponsesPerSecond)
+	}
+	if conf.NXDomainsPerSecond < 0 || conf.ErrorsPerSecond < 0 {
+		return nil, fmt.Errorf("invalid nxdomains per second: %d or errors per second: %d", conf.NXDomainsPerSecond, conf.ErrorsPerSecond)
+	}
+	if conf.NXDomainsPerSecond == 0 {
+		conf.NXDomainsPerSecond = conf.ResponsesPerSecond
+	}
+	if conf.ErrorsPerSecond == 0 {
+		conf.ErrorsPerSecond = conf.ResponsesPerSecond
+	}
+	if conf.Window <= 0 {
+		return nil, fmt.Errorf("invalid window: %v", conf.Window)
+	}
+	if conf.Slip < 0 {
+		return nil, fmt.Errorf("invalid slip: %d", conf.Slip)
+	}
+	if conf.IPv4PrefixLen < 0 || conf.IPv4PrefixLen > 8*net.IPv4len {
+		return nil, fmt.Errorf("invalid IPv4 prefix length: %d", conf.IPv4PrefixLen)
+	}
+	if conf.IPv6PrefixLen < 0 || conf.IPv6PrefixLen > 8*net.IPv6len {
+		return nil, fmt.Errorf("invalid IPv6 prefix length: %d", conf.IPv6PrefixLen)
+	}
+	if conf.MaxEntries < 0 {
+		return nil, fmt.Errorf("invalid max entries: %d", conf.MaxEntries)
+	}
+	if conf.MaxEntries == 0 {
+		conf.MaxEntries = DefaultMaxEntries
+	}
+	exempt, err := parseSubnets(conf.Exempt)
+	if err != nil {
+		return nil, err
+	}
+	buckets, err := lru.New(conf.MaxEntries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create buckets cache: %w", err)
+	}
+	return &Limiter{
+		conf:    conf,
+		exempt:  exempt,
+		buckets: buckets,
+		v4Mask:  net.CIDRMask(conf.IPv4PrefixLen, 8*net.IPv4len),
+		v6Mask:  net.CIDRMask(conf.IPv6PrefixLen, 8*net.IPv6len),
+		now:     time.Now,
+	}, nil
+}
+
+func parseSubnets(s string) ([]*net.IPNet, error) {
+	var subnets []*net.IPNet
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exempt subnet %q: %w", v, err)
+		}
+		subnets = append(subnets, n)
+	}
+	return subnets, nil
+}
+
+// isExempt returns whether responses to ip are never rate limited.
+func (l *Limiter) isExempt(ip net.IP) bool {
+	for _, n := range l.exempt {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// rate returns the number of responses per second allowed for a class.
+func (l *Limiter) rate(class responseClass) int {
+	switch class {
+	case classNXDomain:
+		return l.conf.NXDomainsPerSecond
+	case classError:
+		return l.conf.ErrorsPerSecond
+	default:
+		return l.conf.ResponsesPerSecond
+	}
+}
+
+// prefix returns the prefix ip is accounted under.
+func (l *Limiter) prefix(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(l.v4Mask)
+	}
+	return ip.Mask(l.v6Mask)
+}
+
+// check accounts a response of class for name to ip, and returns what to do
+// with it. It also returns whether the bucket just started being limited.
+func (l *Limiter) check(ip net.IP, class responseClass, name string) (action, bool) {
+	key := fmt.Sprintf("%s/%d/%s", l.prefix(ip), class, name)
+	rate := float64(l.rate(class))
+	burst := rate * l.conf.Window.Seconds()
+	now := l.now()
+
+	l.mu.Lock()
+	v, ok := l.buckets.Get(key)
+	b, _ := v.(*bucket)
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets.Add(key, b)
+	}
+	l.mu.Unlock()
+
+	b.Lock()
+	defer b.Unlock()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(burst, b.tokens+rate*elapsed.Seconds())
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = 0
+		return actionSend, false
+	}
+	b.limited++
+	if l.conf.Slip > 0 && b.limited%l.conf.Slip == 0 {
+		return actionSlip, b.limited == 1
+	}
+	return actionDrop, b.limited == 1
+}
+
+// classify returns the class of a response, and the name it is accounted
+// under: the query name for positive answers, the zone for negative answers
+// and the delegation for referrals, so that random subdomains can't be used
+// to escape limiting. Errors are all accounted together.
+func classify(m *dns.Msg) (responseClass, string) {
+	qname, qtype := "", uint16(0)
+	if len(m.Question) > 0 {
+		qname, qtype = strings.ToLower(m.Question[0].Name), m.Question[0].Qtype
+	}
+	switch m.Rcode {
+	case dns.RcodeSuccess:
+		if len(m.Answer) > 0 {
+			return classResponse, fmt.Sprintf("%s/%d", qname, qtype)
+		}
+		for _, rr := range m.Ns {
+			switch rr.Header().Rrtype {
+			case dns.TypeSOA:
+				return classNoData, strings.ToLower(rr.Header().Name)
+			case dns.TypeNS:
+				return classReferral, strings.ToLower(rr.Header().Name)
+			}
+		}
+		return classNoData, qname
+	case dns.RcodeNameError:
+		for _, rr := range m.Ns {
+			if rr.Header().Rrtype == dns.TypeSOA {
+				return classNXDomain, strings.ToLower(rr.Header().Name)
+			}
+		}
+		return classNXDomain, qname
+	default:
+		return classError, ""
+	}
+}
+
+// Handler is a [plugin.Handler] rate limiting the responses sent over UDP.
+// Responses over other transports, or to queries with a valid server cookie,
+// can't be spoofed, and are never limited.
+type Handler struct {
+	lim   *Limiter
+	stats stats.Stats
+	Next  plugin.Handler
+}
+
+// NewHandler initializes a new rate limiting Handler.
+func NewHandler(lim *Limiter, stats stats.Stats) *Handler {
+	return &Handler{lim: lim, stats: stats}
+}
+
+// ServeDNS implements the [plugin.Handler] interface.
+func (h *Handler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	if state.Proto() != "udp" {
+		return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+	}
+	ip := net.ParseIP(state.IP())
+	if ip == nil || h.lim.isExempt(ip) || cookie.IsExempt(ctx) {
+		h.stats.IncrementCounter("DNS_rrl.exempt")
+		return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+	}
+	rw := &responseWriter{ResponseWriter: w, handler: h, ip: ip, req: r}
+	return plugin.NextOrFailure(h.Name(), h.Next, ctx, rw, r)
+}
+
+// Name returns the handler's name.
+func (h *Handler) Name() string { return "rrl" }
+
+// responseWriter applies rate limiting to the response written.
+type responseWriter struct {
+	dns.ResponseWriter
+	handler *Handler
+	ip      net.IP
+	req     *dns.Msg
+}
+
+// WriteMsg implements the [dns.ResponseWriter] interface.
+func (w *responseWriter) WriteMsg(m *dns.Msg) error {
+	h := w.handler
+	class, name := classify(m)
+	act, started := h.lim.check(w.ip, class, name)
+	if act == actionSend {
+		return w.ResponseWriter.WriteMsg(m)
+	}
+
+	h.stats.IncrementCounter("DNS_rrl.limited." + class.String())
+	if h.lim.conf.LogOnly {
+		if started {
+			glog.Infof("RRL: would limit %s responses for %s to %s", class, name, h.lim.prefix(w.ip))
+		}
+		if act == actionSlip {
+			h.stats.IncrementCounter("DNS_rrl.would_slip")
+		} else {
+			h.stats.IncrementCounter("DNS_rrl.would_drop")
+		}
+		return w.ResponseWriter.WriteMsg(m)
+	}
+
+	if started {
+		glog.V(1).Infof("RRL: limiting %s responses for %s to %s", class, name, h.lim.prefix(w.ip))
+	}
+	if act == actionDrop {
+		h.stats.IncrementCounter("DNS_rrl.dropped")
+		return nil
+	}
+	h.stats.IncrementCounter("DNS_rrl.slipped")
+	tc := new(dns.Msg)
+	tc.SetReply(w.req)
+	tc.Authoritative = m.Authoritative
+	tc.Rcode = m.Rcode
+	tc.Truncated = true
+	if opt := m.IsEdns0(); opt != nil {
+		tc.Extra = []dns.RR{opt}
+	}
+	return w.ResponseWriter.WriteMsg(tc)
+}
+
+// Write implements the [dns.ResponseWriter] interface.
+func (w *responseWriter) Write(buf []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil {
+		return w.ResponseWriter.Write(buf)
+	}
+	if err := w.WriteMsg(m); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
@@ -0,0 +1,288 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rrl
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() Config {
+	return Config{
+		ResponsesPerSecond: 2,
+		Window:             time.Second,
+		Slip:               2,
+		IPv4PrefixLen:      24,
+		IPv6PrefixLen:      56,
+		MaxEntries:         100,
+	}
+}
+
+// newTestLimiter returns a Limiter whose clock is only moved by the test.
+func newTestLimiter(t *testing.T, conf Config) (*Limiter, *time.Time) {
+	lim, err := NewLimiter(conf)
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	lim.now = func() time.Time { return now }
+	return lim, &now
+}
+
+func TestNewLimiterInvalidConfig(t *testing.T) {
+	testCases := map[string]func(c *Config){
+		"no rate":       func(c *Config) { c.ResponsesPerSecond = 0 },
+		"negative rate": func(c *Config) { c.ErrorsPerSecond = -1 },
+		"no window":     func(c *Config) { c.Window = 0 },
+		"negative slip": func(c *Config) { c.Slip = -1 },
+		"ipv4 prefix":   func(c *Config) { c.IPv4PrefixLen = 33 },
+		"ipv6 prefix":   func(c *Config) { c.IPv6PrefixLen = 129 },
+		"exempt subnet": func(c *Config) { c.Exempt = "192.0.2.0/24,foo" },
+		"max entries":   func(c *Config) { c.MaxEntries = -1 },
+	}
+	for name, f := range testCases {
+		t.Run(name, func(t *testing.T) {
+			conf := testConfig()
+			f(&conf)
+			_, err := NewLimiter(conf)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestNewLimiterDefaultMaxEntries(t *testing.T) {
+	conf := testConfig()
+	conf.MaxEntries = 0
+	lim, err := NewLimiter(conf)
+	require.NoError(t, err)
+	require.Equal(t, DefaultMaxEntries, lim.conf.MaxEntries)
+}
+
+func TestClassify(t *testing.T) {
+	soa := test.SOA("example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 2 3 4 5")
+	testCases := []struct {
+		name   string
+		rcode  int
+		answer []dns.RR
+		ns     []dns.RR
+		// noQuestion clears the question section of the response.
+		noQuestion bool
+		class      responseClass
+		key        string
+	}{
+		{
+			name:   "answer",
+			answer: []dns.RR{test.A("Foo.example.com. 60 IN A 192.0.2.1")},
+			class:  classResponse,
+			key:    "foo.example.com./1",
+		},
+		{
+			name:  "nodata",
+			ns:    []dns.RR{soa},
+			class: classNoData,
+			key:   "example.com.",
+		},
+		{
+			name:  "referral",
+			ns:    []dns.RR{test.NS("sub.example.com. 60 IN NS ns.sub.example.com.")},
+			class: classReferral,
+			key:   "sub.example.com.",
+		},
+		{
+			name:  "nxdomain",
+			rcode: dns.RcodeNameError,
+			ns:    []dns.RR{soa},
+			class: classNXDomain,
+			key:   "example.com.",
+		},
+		{
+			name:       "answer without question",
+			answer:     []dns.RR{test.A("Foo.example.com. 60 IN A 192.0.2.1")},
+			noQuestion: true,
+			class:      classResponse,
+			key:        "/0",
+		},
+		{
+			name:  "error",
+			rcode: dns.RcodeRefused,
+			class: classError,
+			key:   "",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := new(dns.Msg)
+			m.SetQuestion("Foo.example.com.", dns.TypeA)
+			m.Rcode = tc.rcode
+			m.Answer = tc.answer
+			m.Ns = tc.ns
+			if tc.noQuestion {
+				m.Question = nil
+			}
+			class, key := classify(m)
+			require.Equal(t, tc.class, class)
+			require.Equal(t, tc.key, key)
+		})
+	}
+}
+
+func TestLimiterCheck(t *testing.T) {
+	lim, now := newTestLimiter(t, testConfig())
+	ip := net.ParseIP("192.0.2.1")
+
+	act, _ := lim.check(ip, classResponse, "foo.example.com./1")
+	require.Equal(t, actionSend, act)
+	// The bucket is shared by the whole prefix.
+	act, _ = lim.check(net.ParseIP("192.0.2.200"), classResponse, "foo.example.com./1")
+	require.Equal(t, actionSend, act)
+
+	act, started := lim.check(ip, classResponse, "foo.example.com./1")
+	require.Equal(t, actionDrop, act)
+	require.True(t, started)
+	act, started = lim.check(ip, classResponse, "foo.example.com./1")
+	require.Equal(t, actionSlip, act)
+	require.False(t, started)
+
+	// Other names, classes and prefixes have their own bucket.
+	act, _ = lim.check(ip, classResponse, "bar.example.com./1")
+	require.Equal(t, actionSend, act)
+	act, _ = lim.check(ip, classError, "")
+	require.Equal(t, actionSend, act)
+	act, _ = lim.check(net.ParseIP("192.0.3.1"), classResponse, "foo.example.com./1")
+	require.Equal(t, actionSend, act)
+
+	// Tokens are refilled over time.
+	*now = now.Add(500 * time.Millisecond)
+	act, _ = lim.check(ip, classResponse, "foo.example.com./1")
+	require.Equal(t, actionSend, act)
+	act, _ = lim.check(ip, classResponse, "foo.example.com./1")
+	require.Equal(t, actionDrop, act)
+}
+
+func TestLimiterCheckIPv6Prefix(t *testing.T) {
+	lim, _ := newTestLimiter(t, testConfig())
+
+	for range 2 {
+		act, _ := lim.check(net.ParseIP("2001:db8:0:1::1"), classResponse, "foo.example.com./1")
+		require.Equal(t, actionSend, act)
+	}
+	act, _ := lim.check(net.ParseIP("2001:db8:0:ff::1"), classResponse, "foo.example.com./1")
+	require.Equal(t, actionDrop, act)
+	act, _ = lim.check(net.ParseIP("2001:db8:0:100::1"), classResponse, "foo.example.com./1")
+	require.Equal(t, actionSend, act)
+}
+
+// answerHandler always answers with an A record.
+var answerHandler = plugin.HandlerFunc(func(_ context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	m.Answer = []dns.RR{test.A("example.com. 60 IN A 192.0.2.1")}
+	return dns.RcodeSuccess, w.WriteMsg(m)
+})
+
+// serve sends n queries through h from w, and returns the responses written.
+func serve(t *testing.T, h *Handler, w dns.ResponseWriter, n int) []*dns.Msg {
+	var msgs []*dns.Msg
+	for range n {
+		req := new(dns.Msg)
+		req.SetQuestion("example.com.", dns.TypeA)
+		rec := dnstest.NewRecorder(w)
+		_, err := h.ServeDNS(context.Background(), rec, req)
+		require.NoError(t, err)
+		if rec.Msg != nil {
+			msgs = append(msgs, rec.Msg)
+		}
+	}
+	return msgs
+}
+
+func TestHandlerLimitsUDP(t *testing.T) {
+	lim, _ := newTestLimiter(t, testConfig())
+	s := stats.NewCounters()
+	h := NewHandler(lim, s)
+	h.Next = answerHandler
+
+	msgs := serve(t, h, &test.ResponseWriter{}, 6)
+	// 2 answers, then 1 out of 2 limited responses is slipped.
+	require.Len(t, msgs, 4)
+	for _, m := range msgs[:2] {
+		require.False(t, m.Truncated)
+		require.Len(t, m.Answer, 1)
+	}
+	for _, m := range msgs[2:] {
+		require.True(t, m.Truncated)
+		require.Empty(t, m.Answer)
+		require.True(t, m.Authoritative)
+	}
+	require.Equal(t, int64(4), s["DNS_rrl.limited.response"])
+	require.Equal(t, int64(2), s["DNS_rrl.dropped"])
+	require.Equal(t, int64(2), s["DNS_rrl.slipped"])
+}
+
+func TestHandlerLogOnly(t *testing.T) {
+	conf := testConfig()
+	conf.LogOnly = true
+	lim, _ := newTestLimiter(t, conf)
+	s := stats.NewCounters()
+	h := NewHandler(lim, s)
+	h.Next = answerHandler
+
+	msgs := serve(t, h, &test.ResponseWriter{}, 6)
+	require.Len(t, msgs, 6)
+	for _, m := range msgs {
+		require.False(t, m.Truncated)
+		require.Len(t, m.Answer, 1)
+	}
+	require.Equal(t, int64(4), s["DNS_rrl.limited.response"])
+	require.Equal(t, int64(2), s["DNS_rrl.would_drop"])
+	require.Equal(t, int64(2), s["DNS_rrl.would_slip"])
+	require.Zero(t, s["DNS_rrl.dropped"])
+}
+
+func TestHandlerExempt(t *testing.T) {
+	conf := testConfig()
+	conf.Exempt = "10.240.0.0/16"
+	lim, _ := newTestLimiter(t, conf)
+	s := stats.NewCounters()
+	h := NewHandler(lim, s)
+	h.Next = answerHandler
+
+	// test.ResponseWriter queries come from 10.240.0.1.
+	msgs := serve(t, h, &test.ResponseWriter{}, 6)
+	require.Len(t, msgs, 6)
+	require.Equal(t, int64(6), s["DNS_rrl.exempt"])
+
+	// TCP is never limited.
+	conf.Exempt = ""
+	lim, _ = newTestLimiter(t, conf)
+	h = NewHandler(lim, s)
+	h.Next = answerHandler
+	msgs = serve(t, h, &test.ResponseWriter{TCP: true}, 6)
+	require.Len(t, msgs, 6)
+	for _, m := range msgs {
+		require.False(t, m.Truncated)
+	}
+}
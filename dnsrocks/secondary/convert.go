@@ -0,0 +1,62 @@
+//go:build cgo
+
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secondary
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+
+	"github.com/miekg/dns"
+)
+
+// mapRecords converts resource records into their DB form. Duplicates are
+// dropped, as they would be stored twice.
+func mapRecords(codec *dnsdata.Codec, rrs []dns.RR) ([]dnsdata.MapRecord, error) {
+	var records []dnsdata.MapRecord
+	seen := make(map[string]bool)
+	for _, rr := range rrs {
+		line, err := dnsdata.RRLine(rr)
+		if err != nil {
+			return nil, err
+		}
+		m, err := codec.ConvertLn([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("conversion error for %s: %w", rr, err)
+		}
+		for _, r := range m {
+			k := recordKey(r)
+			if !seen[k] {
+				seen[k] = true
+				records = append(records, r)
+			}
+		}
+	}
+	return records, nil
+}
+
+// recordKey returns a map key identifying a record by its DB key and value.
+func recordKey(r dnsdata.MapRecord) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d:", len(r.Key))
+	b.Write(r.Key)
+	b.Write(r.Value)
+	return b.String()
+}
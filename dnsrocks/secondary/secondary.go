@@ -0,0 +1,522 @@
+//go:build cgo
+
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package secondary keeps zones transferred from a primary server up to date
+// in a RocksDB, acting as a secondary server (RFC 1034 section 4.3.5).
+package secondary
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb/dbdiff"
+	"github.com/facebook/dns/dnsrocks/dnsserver"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultTimeout is the timeout of queries and transfers, unless
+	// configured.
+	defaultTimeout = 10 * time.Second
+	// initialRetry is the retry interval before the SOA of a zone is known.
+	initialRetry = time.Minute
+	// minInterval bounds the refresh and retry intervals of the SOA records,
+	// so that a zero value does not make us hammer the primary.
+	minInterval = 5 * time.Second
+)
+
+// Config contains the config of a secondary.
+type Config struct {
+	// Primary is the address of the primary server, as host:port.
+	Primary string
+	// Zones are the names of the zones transferred from the primary.
+	Zones []string
+	// DBPath is the path of the RDB the zones are written to. It must use V2
+	// keys, and can be compiled from an empty data file.
+	DBPath string
+	// ControlPath is the control directory of the dnsrocks instance serving
+	// the DB, where a partial reload is triggered after each update. No
+	// reload is triggered when empty.
+	ControlPath string
+	// NotifyAddr is the address NOTIFY messages (RFC 1996) of the primary are
+	// received on, over UDP. NOTIFY is not supported when empty.
+	NotifyAddr string
+	// Timeout is the timeout of queries and transfers to the primary.
+	Timeout time.Duration
+}
+
+// zone is the refresh state of a zone.
+type zone struct {
+	name   string
+	packed []byte
+	notify chan struct{}
+	// soa is the last SOA record of the primary, whose timers are used
+	soa         *dns.SOA
+	lastSuccess time.Time
+	expired     bool
+}
+
+// Secondary transfers zones from a primary into a RocksDB.
+type Secondary struct {
+	conf       *Config
+	db         *rdb.RDB
+	codec      *dnsdata.Codec
+	primaryIPs []net.IP
+	zones      map[string]*zone
+	// mu serializes the DB updates
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// New opens the DB of a new Secondary.
+func New(conf *Config) (*Secondary, error) {
+	if conf.Primary == "" || len(conf.Zones) == 0 {
+		return nil, errors.New("a primary and zones are required")
+	}
+	host, _, err := net.SplitHostPort(conf.Primary)
+	if err != nil {
+		return nil, fmt.Errorf("invalid primary address %q: %w", conf.Primary, err)
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve primary %q: %w", host, err)
+	}
+
+	s := &Secondary{conf: conf, primaryIPs: ips, zones: make(map[string]*zone), now: time.Now}
+	for _, name := range conf.Zones {
+		name = dns.CanonicalName(name)
+		packed := make([]byte, 255)
+		n, err := dns.PackDomainName(name, packed, 0, nil, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zone %q: %w", name, err)
+		}
+		s.zones[name] = &zone{name: name, packed: packed[:n], notify: make(chan struct{}, 1)}
+	}
+
+	if s.db, err = rdb.NewUpdater(conf.DBPath); err != nil {
+		return nil, err
+	}
+	// Zones are replaced by walking their records, which requires sorted keys.
+	if !s.db.IsV2KeySyntaxUsed() {
+		s.db.Close()
+		return nil, fmt.Errorf("%s does not use V2 keys", conf.DBPath)
+	}
+	s.codec = new(dnsdata.Codec)
+	s.codec.Features.UseV2Keys = true
+	return s, nil
+}
+
+// Close closes the DB.
+func (s *Secondary) Close() error {
+	return s.db.Close()
+}
+
+func (s *Secondary) timeout() time.Duration {
+	if s.conf.Timeout > 0 {
+		return s.conf.Timeout
+	}
+	return defaultTimeout
+}
+
+// Run keeps the zones up to date, and receives NOTIFY messages if configured,
+// until the context is done.
+func (s *Secondary) Run(ctx context.Context) error {
+	if s.conf.NotifyAddr != "" {
+		server := &dns.Server{Addr: s.conf.NotifyAddr, Net: "udp", Handler: s}
+		errc := make(chan error, 1)
+		server.NotifyStartedFunc = func() { errc <- nil }
+		go func() {
+			if err := server.ListenAndServe(); err != nil {
+				errc <- err
+			}
+		}()
+		if err := <-errc; err != nil {
+			return fmt.Errorf("failed to listen for NOTIFY on %s: %w", s.conf.NotifyAddr, err)
+		}
+		glog.Infof("Listening for NOTIFY on %s", s.conf.NotifyAddr)
+		defer func() {
+			if err := server.Shutdown(); err != nil {
+				glog.Errorf("Failed to stop the NOTIFY listener: %v", err)
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for _, z := range s.zones {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runZone(ctx, z)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// runZone refreshes a zone on its timers, or when notified.
+func (s *Secondary) runZone(ctx context.Context, z *zone) {
+	z.lastSuccess = s.now()
+	for {
+		timer := time.NewTimer(s.refresh(z))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-z.notify:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// seconds converts a SOA timer to a duration, at least minInterval.
+func seconds(s uint32) time.Duration {
+	return max(time.Duration(s)*time.Second, minInterval)
+}
+
+// refresh brings a zone up to date, expiring it if the primary could not be
+// reached for too long, and returns when it should be refreshed next, see
+// RFC 1034 section 4.3.5.
+func (s *Secondary) refresh(z *zone) time.Duration {
+	err := s.update(z)
+	if err == nil {
+		z.lastSuccess = s.now()
+		z.expired = false
+		return seconds(z.soa.Refresh)
+	}
+	glog.Errorf("Failed to refresh zone %s from %s: %v", z.name, s.conf.Primary, err)
+	if z.soa == nil {
+		return initialRetry
+	}
+	if !z.expired && s.now().Sub(z.lastSuccess) > seconds(z.soa.Expire) {
+		if err = s.expire(z); err != nil {
+			glog.Errorf("Failed to expire zone %s: %v", z.name, err)
+		} else {
+			glog.Warningf("Zone %s expired, no longer serving it", z.name)
+			z.expired = true
+		}
+	}
+	return seconds(z.soa.Retry)
+}
+
+// primarySOA queries the SOA record of a zone from the primary.
+func (s *Secondary) primarySOA(z *zone) (*dns.SOA, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(z.name, dns.TypeSOA)
+	c := &dns.Client{Timeout: s.timeout()}
+	r, _, err := c.Exchange(m, s.conf.Primary)
+	if err == nil && r.Truncated {
+		c.Net = "tcp"
+		r, _, err = c.Exchange(m, s.conf.Primary)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("SOA query failed: %s", dns.RcodeToString[r.Rcode])
+	}
+	for _, rr := range r.Answer {
+		if soa, ok := rr.(*dns.SOA); ok && dns.CanonicalName(soa.Hdr.Name) == z.name {
+			return soa, nil
+		}
+	}
+	return nil, errors.New("no SOA record in the answer")
+}
+
+// update transfers a zone if the primary has a newer version of it,
+// incrementally if possible.
+func (s *Secondary) update(z *zone) error {
+	soa, err := s.primarySOA(z)
+	if err != nil {
+		return err
+	}
+	z.soa = soa
+	serial, found, err := s.db.ZoneSerial(z.packed)
+	if err != nil {
+		return err
+	}
+	if found && dnsdata.SerialNewerOrEqual(serial, soa.Serial) {
+		return nil
+	}
+	if found {
+		err = s.transfer(z, serial, true)
+		if err == nil {
+			return nil
+		}
+		glog.Warningf("IXFR of %s failed, falling back to AXFR: %v", z.name, err)
+	}
+	return s.transfer(z, 0, false)
+}
+
+// transfer runs an AXFR, or an IXFR from serial, and applies it to the DB.
+func (s *Secondary) transfer(z *zone, serial uint32, incremental bool) error {
+	m := new(dns.Msg)
+	if incremental {
+		m.SetIxfr(z.name, serial, ".", ".")
+	} else {
+		m.SetAxfr(z.name)
+	}
+	t := &dns.Transfer{DialTimeout: s.timeout(), ReadTimeout: s.timeout(), WriteTimeout: s.timeout()}
+	env, err := t.In(m, s.conf.Primary)
+	if err != nil {
+		return err
+	}
+	var rrs []dns.RR
+	for e := range env {
+		// keep reading until the channel is closed
+		if e.Error != nil && err == nil {
+			err = e.Error
+		}
+		rrs = append(rrs, e.RR...)
+	}
+	if err != nil {
+		return err
+	}
+	if len(rrs) == 0 {
+		return errors.New("empty transfer")
+	}
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return errors.New("transfer does not start with a SOA record")
+	}
+	if len(rrs) == 1 {
+		// up to date
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if second, ok := rrs[1].(*dns.SOA); incremental && ok && second.Serial == serial && len(rrs) > 2 {
+		err = s.applyIncremental(z, rrs[1:len(rrs)-1])
+	} else {
+		err = s.applyFull(z, rrs[:len(rrs)-1])
+	}
+	if err != nil {
+		return err
+	}
+	glog.Infof("Transferred zone %s from %s: serial %d, %d records", z.name, s.conf.Primary, soa.Serial, len(rrs))
+	return s.reload()
+}
+
+// inZone filters out the records outside of the zone, which a primary has no
+// authority over.
+func (z *zone) inZone(rrs []dns.RR) []dns.RR {
+	filtered := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		if !dns.IsSubDomain(z.name, rr.Header().Name) {
+			glog.Warningf("Ignoring record outside of zone %s: %s", z.name, rr)
+			continue
+		}
+		filtered = append(filtered, rr)
+	}
+	return filtered
+}
+
+// applyFull replaces the records of a zone with the ones of an AXFR. Only the
+// difference is written, so that it can be transferred incrementally. The
+// records of the child zones stored in the same DB are left untouched.
+func (s *Secondary) applyFull(z *zone, rrs []dns.RR) error {
+	records, err := mapRecords(s.codec, z.inZone(rrs))
+	if err != nil {
+		return err
+	}
+	existing, err := s.db.ZoneRecords(z.packed)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]bool, len(existing))
+	for _, r := range existing {
+		current[recordKey(r)] = true
+	}
+	var added []dnsdata.MapRecord
+	for _, r := range records {
+		k := recordKey(r)
+		if current[k] {
+			delete(current, k)
+			continue
+		}
+		// The delegation NS and glue records of a child zone are stored
+		// along with the records of the child, which are not part of the
+		// existing ones.
+		stored, err := s.isStored(r)
+		if err != nil {
+			return err
+		}
+		if !stored {
+			added = append(added, r)
+		}
+	}
+	var deleted []dnsdata.MapRecord
+	for _, r := range existing {
+		if current[recordKey(r)] {
+			deleted = append(deleted, r)
+		}
+	}
+	return s.apply(deleted, added)
+}
+
+// isStored returns whether a record is already in the DB.
+func (s *Secondary) isStored(r dnsdata.MapRecord) (bool, error) {
+	found := false
+	err := s.db.ForEach(r.Key, func(v []byte) error {
+		if bytes.Equal(v, r.Value) {
+			found = true
+		}
+		return nil
+	}, rdb.NewContext())
+	return found, err
+}
+
+// applyIncremental applies the difference sequences of an IXFR, starting with
+// the old SOA record, see RFC 1995 section 4.
+func (s *Secondary) applyIncremental(z *zone, rrs []dns.RR) error {
+	var deleted, added []dns.RR
+	adding := true
+	for _, rr := range z.inZone(rrs) {
+		// SOA records of the zone delimit the sequences, and are themselves
+		// deleted and added.
+		if rr.Header().Rrtype == dns.TypeSOA && dns.CanonicalName(rr.Header().Name) == z.name {
+			adding = !adding
+		}
+		if adding {
+			added = append(added, rr)
+		} else {
+			deleted = append(deleted, rr)
+		}
+	}
+	del, err := mapRecords(s.codec, deleted)
+	if err != nil {
+		return err
+	}
+	add, err := mapRecords(s.codec, added)
+	if err != nil {
+		return err
+	}
+	// Records deleted by a later sequence than they were added in, and
+	// conversely, cancel out.
+	dels := make(map[string]int, len(del))
+	for i, r := range del {
+		dels[recordKey(r)] = i
+	}
+	var netAdded []dnsdata.MapRecord
+	for _, r := range add {
+		if i, ok := dels[recordKey(r)]; ok {
+			delete(dels, recordKey(r))
+			del[i].Key = nil
+			continue
+		}
+		netAdded = append(netAdded, r)
+	}
+	var netDeleted []dnsdata.MapRecord
+	for _, r := range del {
+		if r.Key != nil {
+			netDeleted = append(netDeleted, r)
+		}
+	}
+	return s.apply(netDeleted, netAdded)
+}
+
+// apply writes the changes in a single batch.
+func (s *Secondary) apply(deleted, added []dnsdata.MapRecord) error {
+	return s.db.ApplyEntries([]*dbdiff.Entry{
+		{Op: dbdiff.DelOp, Records: deleted},
+		{Op: dbdiff.AddOp, Records: added},
+	})
+}
+
+// expire deletes the records of a zone, as a secondary must stop answering
+// for a zone it could not refresh before it expired. The records of its child
+// zones are kept.
+func (s *Secondary) expire(z *zone) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, err := s.db.ZoneRecords(z.packed)
+	if err != nil {
+		return err
+	}
+	if err = s.apply(existing, nil); err != nil {
+		return err
+	}
+	return s.reload()
+}
+
+// reload triggers a partial reload of the DB by the dnsrocks instance serving
+// it.
+func (s *Secondary) reload() error {
+	if s.conf.ControlPath == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path.Join(s.conf.ControlPath, dnsserver.ControlFilePartialReload), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to trigger reload: %w", err)
+	}
+	return f.Close()
+}
+
+// ServeDNS implements the [dns.Handler] interface, receiving NOTIFY messages
+// from the primary.
+func (s *Secondary) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	if r.Opcode != dns.OpcodeNotify || len(r.Question) != 1 {
+		m.SetRcode(r, dns.RcodeNotImplemented)
+	} else if z, ok := s.zones[dns.CanonicalName(r.Question[0].Name)]; !ok || !s.isPrimary(w.RemoteAddr()) {
+		glog.Warningf("Refusing NOTIFY of %s from %s", r.Question[0].Name, w.RemoteAddr())
+		m.SetRcode(r, dns.RcodeRefused)
+	} else {
+		glog.Infof("Received NOTIFY of %s from %s", z.name, w.RemoteAddr())
+		m.SetReply(r)
+		m.Authoritative = true
+		select {
+		case z.notify <- struct{}{}:
+		default:
+			// a refresh is already pending
+		}
+	}
+	if err := w.WriteMsg(m); err != nil {
+		glog.Errorf("Failed to reply to NOTIFY: %v", err)
+	}
+}
+
+func (s *Secondary) isPrimary(addr net.Addr) bool {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		return false
+	}
+	for _, p := range s.primaryIPs {
+		if p.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,384 @@
+//go:build cgo
+
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secondary
+
+import (
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
+	"github.com/facebook/dns/dnsrocks/dnsserver"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// testPrimary is a primary server for example.org. serving its versions.
+type testPrimary struct {
+	sync.Mutex
+	// versions are the zone contents, SOA first
+	versions map[uint32][]dns.RR
+	current  uint32
+	// history indicates whether IXFR can be answered incrementally
+	history bool
+	queries map[uint16]int
+}
+
+func zoneVersion(serial uint32, records ...string) []dns.RR {
+	soa := test.SOA("example.org. 3600 IN SOA ns1.example.org. admin.example.org. 0 60 30 600 60")
+	soa.Serial = serial
+	rrs := []dns.RR{soa}
+	for _, r := range records {
+		rr, err := dns.NewRR(r)
+		if err != nil {
+			panic(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+func (p *testPrimary) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	p.Lock()
+	defer p.Unlock()
+	q := r.Question[0]
+	p.queries[q.Qtype]++
+	current := p.versions[p.current]
+	soa := current[0]
+
+	var rrs []dns.RR
+	switch q.Qtype {
+	case dns.TypeSOA:
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{soa}
+		_ = w.WriteMsg(m)
+		return
+	case dns.TypeIXFR:
+		serial := r.Ns[0].(*dns.SOA).Serial
+		old, ok := p.versions[serial]
+		if p.history && ok {
+			// a single difference sequence, from the client version
+			rrs = append(rrs, soa, old[0])
+			rrs = append(rrs, difference(old[1:], current[1:])...)
+			rrs = append(rrs, soa)
+			rrs = append(rrs, difference(current[1:], old[1:])...)
+			rrs = append(rrs, soa)
+			break
+		}
+		fallthrough
+	case dns.TypeAXFR:
+		rrs = append(append([]dns.RR{}, current...), soa)
+	}
+	ch := make(chan *dns.Envelope, 1)
+	ch <- &dns.Envelope{RR: rrs}
+	close(ch)
+	tr := new(dns.Transfer)
+	_ = tr.Out(w, r, ch)
+	_ = w.Close()
+}
+
+// difference returns the records of a missing from b.
+func difference(a, b []dns.RR) []dns.RR {
+	var diff []dns.RR
+	for _, rr := range a {
+		found := false
+		for _, other := range b {
+			if dns.IsDuplicate(rr, other) {
+				found = true
+			}
+		}
+		if !found {
+			diff = append(diff, rr)
+		}
+	}
+	return diff
+}
+
+// startPrimary serves p over UDP and TCP on the same port.
+func startPrimary(t *testing.T, p *testPrimary) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	for _, s := range []*dns.Server{{PacketConn: pc, Handler: p}, {Listener: l, Handler: p}} {
+		go func() { _ = s.ActivateAndServe() }()
+		t.Cleanup(func() { _ = s.Shutdown() })
+	}
+	return pc.LocalAddr().String()
+}
+
+func newTestSecondary(t *testing.T, primary string) *Secondary {
+	return newTestSecondaryWithData(t, primary, "")
+}
+
+// newTestSecondaryWithData returns a secondary of example.org. whose DB is
+// compiled from data.
+func newTestSecondaryWithData(t *testing.T, primary, data string) *Secondary {
+	dbpath := t.TempDir()
+	_, err := rdb.Compile(strings.NewReader(data), 1, dbpath, rdb.CompilationOptions{UseV2KeySyntax: true})
+	require.NoError(t, err)
+	s, err := New(&Config{
+		Primary:     primary,
+		Zones:       []string{"Example.org"},
+		DBPath:      dbpath,
+		ControlPath: t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// servedRecords returns the records of example.org. as served from the DB.
+func servedRecords(t *testing.T, s *Secondary) []string {
+	d, err := db.Open(s.conf.DBPath, "rocksdb")
+	require.NoError(t, err)
+	defer d.Destroy()
+	reader, err := db.NewReader(d)
+	require.NoError(t, err)
+	defer reader.Close()
+	var records []string
+	err = reader.ForEachZoneRecord(s.zones["example.org."].packed, db.ZeroID, func(_ []byte, rrs []dns.RR) error {
+		for _, rr := range rrs {
+			records = append(records, rr.String())
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	sort.Strings(records)
+	return records
+}
+
+func rrStrings(rrs []dns.RR) []string {
+	var records []string
+	for _, rr := range rrs {
+		records = append(records, rr.String())
+	}
+	sort.Strings(records)
+	return records
+}
+
+func TestSecondary(t *testing.T) {
+	v1 := zoneVersion(1,
+		"example.org. 3600 IN NS ns1.example.org.",
+		"example.org. 3600 IN MX 10 mail.example.org.",
+		`example.org. 3600 IN TXT "v=spf1 -all" "second string"`,
+		"ns1.example.org. 3600 IN A 192.0.2.1",
+		"www.example.org. 300 IN AAAA 2001:db8::1",
+		"www.example.org. 300 IN A 192.0.2.2",
+		"*.wild.example.org. 300 IN A 192.0.2.3",
+		"_sip._tcp.example.org. 300 IN SRV 1 2 5060 sip.example.org.",
+		"example.org. 300 IN CAA 0 issue \"ca.example.net\"",
+		"other.example.net. 300 IN A 192.0.2.4",
+	)
+	v2 := zoneVersion(2,
+		"example.org. 3600 IN NS ns1.example.org.",
+		"example.org. 3600 IN MX 10 mail.example.org.",
+		`example.org. 3600 IN TXT "v=spf1 -all" "second string"`,
+		"ns1.example.org. 3600 IN A 192.0.2.1",
+		"www.example.org. 300 IN AAAA 2001:db8::1",
+		"*.wild.example.org. 300 IN A 192.0.2.3",
+		"_sip._tcp.example.org. 300 IN SRV 1 2 5060 sip.example.org.",
+		"example.org. 300 IN CAA 0 issue \"ca.example.net\"",
+		"new.example.org. 300 IN CNAME www.example.org.",
+	)
+	v3 := zoneVersion(3, "example.org. 3600 IN NS ns1.example.org.")
+
+	p := &testPrimary{versions: map[uint32][]dns.RR{1: v1, 2: v2, 3: v3}, current: 1, history: true, queries: make(map[uint16]int)}
+	s := newTestSecondary(t, startPrimary(t, p))
+	z := s.zones["example.org."]
+	reloadFile := path.Join(s.conf.ControlPath, dnsserver.ControlFilePartialReload)
+
+	// out of zone records are not transferred
+	expected := func(v []dns.RR) []string {
+		return rrStrings(z.inZone(v))
+	}
+
+	require.Equal(t, 60*time.Second, s.refresh(z))
+	require.Equal(t, 1, p.queries[dns.TypeAXFR])
+	require.Equal(t, expected(v1), servedRecords(t, s))
+	require.FileExists(t, reloadFile)
+	require.NoError(t, os.Remove(reloadFile))
+
+	// up to date
+	s.refresh(z)
+	require.Equal(t, 1, p.queries[dns.TypeAXFR])
+	require.Zero(t, p.queries[dns.TypeIXFR])
+	require.NoFileExists(t, reloadFile)
+
+	p.current = 2
+	s.refresh(z)
+	require.Equal(t, 1, p.queries[dns.TypeIXFR])
+	require.Equal(t, 1, p.queries[dns.TypeAXFR])
+	require.Equal(t, expected(v2), servedRecords(t, s))
+	require.FileExists(t, reloadFile)
+
+	// the primary answers the IXFR with the whole zone
+	p.current = 3
+	p.history = false
+	s.refresh(z)
+	require.Equal(t, 2, p.queries[dns.TypeIXFR])
+	require.Equal(t, 1, p.queries[dns.TypeAXFR])
+	require.Equal(t, expected(v3), servedRecords(t, s))
+
+	// the zone history is recorded, and can be transferred further
+	v, err := s.db.Find(rdb.HistoryKey(z.packed, 2), rdb.NewContext())
+	require.NoError(t, err)
+	e := new(rdb.HistoryEntry)
+	require.NoError(t, e.UnmarshalBinary(v))
+	require.Equal(t, uint32(3), e.NewSerial)
+}
+
+func TestSecondaryExpire(t *testing.T) {
+	p := &testPrimary{versions: map[uint32][]dns.RR{1: zoneVersion(1, "www.example.org. 300 IN A 192.0.2.2")}, current: 1, queries: make(map[uint16]int)}
+	addr := startPrimary(t, p)
+	s := newTestSecondary(t, addr)
+	s.conf.Timeout = 100 * time.Millisecond
+	z := s.zones["example.org."]
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+
+	s.refresh(z)
+	serial, found, err := s.db.ZoneSerial(z.packed)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint32(1), serial)
+
+	// the primary is unreachable
+	s.conf.Primary = "127.0.0.1:1"
+	now = now.Add(5 * time.Minute)
+	require.Equal(t, 30*time.Second, s.refresh(z))
+	_, found, err = s.db.ZoneSerial(z.packed)
+	require.NoError(t, err)
+	require.True(t, found)
+
+	now = now.Add(10 * time.Minute)
+	s.refresh(z)
+	require.True(t, z.expired)
+	_, found, err = s.db.ZoneSerial(z.packed)
+	require.NoError(t, err)
+	require.False(t, found)
+	require.Empty(t, servedRecords(t, s))
+
+	// the zone is transferred again once the primary is back
+	s.conf.Primary = addr
+	s.refresh(z)
+	require.False(t, z.expired)
+	require.Len(t, servedRecords(t, s), 2)
+}
+
+func TestSecondaryChildZone(t *testing.T) {
+	// sub.example.org. is served from the same DB, but not transferred
+	child := `Zsub.example.org,ns.sub.example.org,admin.example.org,5,60,30,600,60,300,,
+&sub.example.org,,ns.sub.example.org,300,,
++a.sub.example.org,192.0.2.10,300,,
+`
+	delegation := []string{
+		"sub.example.org. 300 IN NS ns.sub.example.org.",
+		"ns.sub.example.org. 300 IN A 192.0.2.9",
+	}
+	p := &testPrimary{
+		versions: map[uint32][]dns.RR{
+			1: zoneVersion(1, delegation...),
+			2: zoneVersion(2, append(delegation, "www.example.org. 300 IN A 192.0.2.2")...),
+		},
+		current: 1,
+		queries: make(map[uint16]int),
+	}
+	s := newTestSecondaryWithData(t, startPrimary(t, p), child)
+	z := s.zones["example.org."]
+
+	childRecords := func() map[string]int {
+		counts := make(map[string]int)
+		for _, name := range []string{"sub.example.org.", "a.sub.example.org.", "ns.sub.example.org."} {
+			packed := make([]byte, 255)
+			n, err := dns.PackDomainName(name, packed, 0, nil, false)
+			require.NoError(t, err)
+			records, err := s.db.NameRecords(packed[:n])
+			require.NoError(t, err)
+			counts[name] = len(records)
+		}
+		return counts
+	}
+	before := childRecords()
+	require.Equal(t, 2, before["sub.example.org."], "SOA and NS")
+	require.Equal(t, 1, before["a.sub.example.org."])
+
+	// full transfers, the second one answering an IXFR
+	s.refresh(z)
+	p.current = 2
+	s.refresh(z)
+	require.Equal(t, 2, p.queries[dns.TypeAXFR]+p.queries[dns.TypeIXFR])
+	after := childRecords()
+	require.Equal(t, before["sub.example.org."], after["sub.example.org."], "the delegation is not added twice")
+	require.Equal(t, before["a.sub.example.org."], after["a.sub.example.org."])
+	require.Equal(t, 1, after["ns.sub.example.org."], "the glue is added once")
+
+	serial, found, err := s.db.ZoneSerial([]byte("\003sub\007example\003org\000"))
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint32(5), serial)
+
+	require.NoError(t, s.expire(z))
+	_, found, err = s.db.ZoneSerial(z.packed)
+	require.NoError(t, err)
+	require.False(t, found)
+	require.Equal(t, 1, childRecords()["a.sub.example.org."], "the child zone outlives its parent")
+}
+
+func TestSecondaryNotify(t *testing.T) {
+	p := &testPrimary{versions: map[uint32][]dns.RR{1: zoneVersion(1)}, current: 1, queries: make(map[uint16]int)}
+	s := newTestSecondary(t, startPrimary(t, p))
+	z := s.zones["example.org."]
+
+	notify := func(zone, ip string) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetNotify(zone)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: ip})
+		s.ServeDNS(rec, m)
+		return rec.Msg
+	}
+
+	r := notify("example.org.", "127.0.0.1")
+	require.Equal(t, dns.RcodeSuccess, r.Rcode)
+	require.Equal(t, dns.OpcodeNotify, r.Opcode)
+	require.True(t, r.Authoritative)
+	require.Len(t, z.notify, 1)
+	// notifications are coalesced
+	notify("example.org.", "127.0.0.1")
+	require.Len(t, z.notify, 1)
+
+	require.Equal(t, dns.RcodeRefused, notify("example.org.", "192.0.2.1").Rcode)
+	require.Equal(t, dns.RcodeRefused, notify("example.com.", "127.0.0.1").Rcode)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeSOA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: "127.0.0.1"})
+	s.ServeDNS(rec, m)
+	require.Equal(t, dns.RcodeNotImplemented, rec.Msg.Rcode)
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sign
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+
+	cdb "github.com/repustate/go-cdb"
+)
+
+// SignCDB signs the zones of a CDB database, writing the signed database to
+// output, which may be the input path. The signatures of a previous run are
+// replaced. It returns the number of records added.
+func (s *Signer) SignCDB(input, output string) (n int, err error) {
+	c, err := cdb.Open(input)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	v2Keys := false
+	features, err := c.Data([]byte(dnsdata.FeaturesKey), cdb.NewContext())
+	if err == nil {
+		v2Keys = dnsdata.DecodeFeatures(features)&dnsdata.V2KeysFeature > 0
+	} else if !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+
+	add, del, err := s.Sign(c.ForEachRecord, v2Keys)
+	if err != nil {
+		return 0, err
+	}
+	deleted := make(map[string]int, len(del))
+	for _, m := range del {
+		deleted[string(m.Key)+"\x00"+string(m.Value)]++
+	}
+
+	// The signed database is written next to the output, and renamed once
+	// complete.
+	tmp, err := os.CreateTemp(filepath.Dir(output), filepath.Base(output)+".sign")
+	if err != nil {
+		return 0, err
+	}
+	tmp.Close()
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+	w, err := cdb.NewWriter(tmp.Name())
+	if err != nil {
+		return 0, err
+	}
+	err = c.ForEachRecord(func(key, value []byte) error {
+		k := string(key) + "\x00" + string(value)
+		if deleted[k] > 0 {
+			deleted[k]--
+			return nil
+		}
+		return w.Put(key, value)
+	})
+	if err != nil {
+		w.Close()
+		return 0, err
+	}
+	for _, m := range add {
+		if err = w.Put(m.Key, m.Value); err != nil {
+			w.Close()
+			return 0, err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return 0, err
+	}
+	if err = os.Rename(tmp.Name(), output); err != nil {
+		return 0, fmt.Errorf("could not write %s: %w", output, err)
+	}
+	return len(add), nil
+}
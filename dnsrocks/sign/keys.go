@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sign
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Key is a DNSSEC key of a zone, along with its private key.
+type Key struct {
+	DNSKEY *dns.DNSKEY
+	Timing Timing
+	// File is the base name of the files the key was read from, if any
+	File   string
+	signer crypto.Signer
+}
+
+// NewKey returns a Key from its DNSKEY record and private key.
+func NewKey(dnskey *dns.DNSKEY, privateKey crypto.PrivateKey) (*Key, error) {
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key of %s", dnskey.Header().Name)
+	}
+	return &Key{DNSKEY: dnskey, signer: signer}, nil
+}
+
+// IsKSK returns whether the key is a key signing key, e.g has the SEP bit set.
+func (k *Key) IsKSK() bool {
+	return k.DNSKEY.Flags&dns.SEP != 0
+}
+
+// Sign returns the signature of the RRset rrs by the key, valid from
+// inception to expiration. The original TTL of the signature is the one of
+// the first record.
+func (k *Key) Sign(rrs []dns.RR, signerName string, inception, expiration time.Time) (*dns.RRSIG, error) {
+	hdr := rrs[0].Header()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: hdr.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: hdr.Ttl},
+		Algorithm:  k.DNSKEY.Algorithm,
+		KeyTag:     k.DNSKEY.KeyTag(),
+		SignerName: signerName,
+		Inception:  uint32(inception.Unix()),
+		Expiration: uint32(expiration.Unix()),
+	}
+	if err := sig.Sign(k.signer, rrs); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// ParseKeyFile reads a DNSSEC key as generated by dnssec-keygen. The public
+// and private keys are read from the ".key" and ".private" files of the same
+// base name, which can be given with either extension, or without any. The
+// timing metadata are read from the ".private" file.
+func ParseKeyFile(name string) (*Key, error) {
+	base := strings.TrimSuffix(strings.TrimSuffix(name, ".key"), ".private")
+
+	f, err := os.Open(filepath.Clean(base + ".key"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rr, err := dns.ReadRR(f, base+".key")
+	if err != nil {
+		return nil, err
+	}
+	dnskey, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, errors.New("no public key found in " + base + ".key")
+	}
+
+	private, err := os.ReadFile(filepath.Clean(base + ".private"))
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := dnskey.ReadPrivateKey(bytes.NewReader(private), base+".private")
+	if err != nil {
+		return nil, err
+	}
+	timing, err := ParseTiming(bytes.NewReader(private))
+	if err != nil {
+		return nil, fmt.Errorf("invalid timing metadata in %s.private: %w", base, err)
+	}
+	k, err := NewKey(dnskey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	k.Timing = timing
+	k.File = base
+	return k, nil
+}
+
+// ParseKeyFiles reads DNSSEC keys, see ParseKeyFile.
+func ParseKeyFiles(names []string) ([]*Key, error) {
+	keys := make([]*Key, 0, len(names))
+	for _, name := range names {
+		k, err := ParseKeyFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("could not load DNSSEC key %s: %w", name, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// ExpandKeyFiles returns the key files of paths, replacing the directories
+// with the ".key" files of dnssec-keygen found in them.
+func ExpandKeyFiles(paths []string) ([]string, error) {
+	var names []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			names = append(names, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "K*.key"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		names = append(names, matches...)
+	}
+	return names, nil
+}
@@ -0,0 +1,272 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sign
+
+import (
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+
+	"github.com/miekg/dns"
+)
+
+// maxNSEC3Iterations is the highest number of additional hash iterations
+// accepted, as validators treat zones using more as insecure (RFC 9276).
+const maxNSEC3Iterations = 150
+
+// NSEC3Params are the parameters of the NSEC3 chains of the signed zones.
+type NSEC3Params struct {
+	// Salt is the hex encoded salt, empty for none
+	Salt       string
+	Iterations uint16
+	// OptOut leaves the delegations without DS records out of the chains
+	OptOut bool
+}
+
+// SetNSEC3 makes the signer generate an NSEC3 chain for every signed zone,
+// replacing the chain of a previous run, so that negative and wildcard answers
+// can be proven.
+func (s *Signer) SetNSEC3(p NSEC3Params) error {
+	if _, err := hex.DecodeString(p.Salt); err != nil {
+		return fmt.Errorf("invalid NSEC3 salt %q: %w", p.Salt, err)
+	}
+	if len(p.Salt) > 2*255 {
+		return fmt.Errorf("NSEC3 salt %q is too long", p.Salt)
+	}
+	if p.Iterations > maxNSEC3Iterations {
+		return fmt.Errorf("%d NSEC3 iterations is more than the maximum of %d", p.Iterations, maxNSEC3Iterations)
+	}
+	p.Salt = strings.ToUpper(p.Salt)
+	s.nsec3 = &p
+	return nil
+}
+
+// nsec3Owner holds the types of an owner name of the NSEC3 chain, indexed
+// by encoded location. The types of the default location are answered in
+// every location.
+type nsec3Owner struct {
+	types  map[string]map[uint16]bool
+	signed bool
+}
+
+// nsec3ParamRow adds the NSEC3PARAM record of the chain to the zone apex,
+// and returns it.
+func (s *Signer) nsec3ParamRow(apex *nameRows) (row, error) {
+	param := &dns.NSEC3PARAM{
+		Hdr:        dns.RR_Header{Rrtype: dns.TypeNSEC3PARAM, Class: dns.ClassINET},
+		Hash:       dns.SHA1,
+		Iterations: s.nsec3.Iterations,
+		SaltLength: uint8(len(s.nsec3.Salt) / 2),
+		Salt:       s.nsec3.Salt,
+	}
+	rdata, err := packRdata(param)
+	if err != nil {
+		return row{}, err
+	}
+	// Resolvers do not need to cache the parameters of the chain.
+	value := makeRow(dns.TypeNSEC3PARAM, 0, zeroLoc, false, rdata)
+	rec, err := db.ExtractRRFromRow(value, false)
+	if err != nil {
+		return row{}, err
+	}
+	set := rowSet{loc: string(zeroLoc), qtype: dns.TypeNSEC3PARAM}
+	r := row{rec: rec, value: value}
+	apex.rows[set] = append(apex.rows[set], r)
+	return r, nil
+}
+
+// nsec3Chain returns the rows of the NSEC3 chain of a zone, along with their
+// signatures. Every owner name of the zone is part of the chain, including
+// the empty non-terminals and the delegation points, but the ones without DS
+// records when opting out. The type bitmaps of the records stored for a
+// location hold the types answered in that location.
+func (s *Signer) nsec3Chain(z *zone, names map[string]*nameRows, delegations map[string]bool, v2Keys bool) ([]dnsdata.MapRecord, error) {
+	apex := names[string(z.packed)]
+	ttl, err := negativeTTL(z, apex)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string]*nsec3Owner)
+	owner := func(name string) *nsec3Owner {
+		o, ok := owners[name]
+		if !ok {
+			o = &nsec3Owner{types: map[string]map[uint16]bool{string(zeroLoc): {}}}
+			owners[name] = o
+		}
+		return o
+	}
+	for _, n := range names {
+		if s.zone(n.name) != z {
+			continue
+		}
+		cut, occluded := delegation(n.name, z, delegations)
+		if occluded {
+			continue
+		}
+		name, _, err := dns.UnpackDomainName(n.name, 0)
+		if err != nil {
+			return nil, err
+		}
+		// The empty non-terminals above the name are part of the chain,
+		// even when opting out of its delegation.
+		for off := 0; off < len(n.name)-len(z.packed); off += int(n.name[off]) + 1 {
+			parent, _, err := dns.UnpackDomainName(n.name[off+int(n.name[off])+1:], 0)
+			if err != nil {
+				return nil, err
+			}
+			owner(parent)
+		}
+
+		secure := false
+		if cut {
+			for set := range n.rows {
+				secure = secure || (set.qtype == dns.TypeDS && !set.wildcard)
+			}
+			if !secure && s.nsec3.OptOut {
+				continue
+			}
+		}
+		for set := range n.rows {
+			if cut && (set.wildcard || (set.qtype != dns.TypeNS && set.qtype != dns.TypeDS)) {
+				continue
+			}
+			// The name of a wildcard is at least an empty non-terminal.
+			o := owner(name)
+			if set.wildcard {
+				o = owner("*." + name)
+			}
+			// Only the DS RRsets of delegation points are signed.
+			o.signed = o.signed || !cut || secure
+			if o.types[set.loc] == nil {
+				o.types[set.loc] = make(map[uint16]bool)
+			}
+			o.types[set.loc][set.qtype] = true
+		}
+	}
+
+	hashes := make([]string, 0, len(owners))
+	hashed := make(map[string]string, len(owners))
+	for name := range owners {
+		h := strings.ToLower(dns.HashName(name, dns.SHA1, s.nsec3.Iterations, s.nsec3.Salt))
+		if other, ok := hashed[h]; ok {
+			return nil, fmt.Errorf("NSEC3 hash collision between %s and %s, change the salt", name, other)
+		}
+		hashed[h] = name
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+
+	var flags uint8
+	if s.nsec3.OptOut {
+		flags = 1
+	}
+	var records []dnsdata.MapRecord
+	for i, h := range hashes {
+		o := owners[hashed[h]]
+		name := h + "." + z.name
+		packed := make([]byte, len(name)+1)
+		off, err := dns.PackDomainName(name, packed, 0, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		locs := make([]string, 0, len(o.types))
+		for loc := range o.types {
+			locs = append(locs, loc)
+		}
+		sort.Strings(locs)
+		for _, loc := range locs {
+			types := make([]uint16, 0, len(o.types[loc])+len(o.types[string(zeroLoc)])+1)
+			for t := range o.types[loc] {
+				types = append(types, t)
+			}
+			for t := range o.types[string(zeroLoc)] {
+				types = append(types, t)
+			}
+			if o.signed {
+				types = append(types, dns.TypeRRSIG)
+			}
+			slices.Sort(types)
+			nsec3 := &dns.NSEC3{
+				Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: ttl},
+				Hash:       dns.SHA1,
+				Flags:      flags,
+				Iterations: s.nsec3.Iterations,
+				SaltLength: uint8(len(s.nsec3.Salt) / 2),
+				Salt:       s.nsec3.Salt,
+				HashLength: 20,
+				NextDomain: strings.ToUpper(hashes[(i+1)%len(hashes)]),
+				TypeBitMap: slices.Compact(types),
+			}
+			rdata, err := packRdata(nsec3)
+			if err != nil {
+				return nil, err
+			}
+			value := makeRow(dns.TypeNSEC3, ttl, []byte(loc), false, rdata)
+			rec, err := db.ExtractRRFromRow(value, false)
+			if err != nil {
+				return nil, err
+			}
+			key := makeKey(packed[:off], []byte(loc), v2Keys)
+			records = append(records, dnsdata.MapRecord{Key: key, Value: value})
+
+			// Unlike the other RRsets, the located NSEC3 records replace the
+			// default location one rather than being answered along with it.
+			sigs, err := s.signRRset(z, name, dns.TypeNSEC3, []row{{rec: rec, value: value}})
+			if err != nil {
+				return nil, fmt.Errorf("could not sign NSEC3 of %s: %w", hashed[h], err)
+			}
+			for _, sig := range sigs {
+				rdata, err := packRdata(sig)
+				if err != nil {
+					return nil, err
+				}
+				records = append(records, dnsdata.MapRecord{Key: key, Value: makeRow(dns.TypeRRSIG, sig.Hdr.Ttl, []byte(loc), false, rdata)})
+			}
+		}
+	}
+	return records, nil
+}
+
+// negativeTTL returns the TTL of negative answers of a zone, which is the one
+// of its NSEC3 records (RFC 9077).
+func negativeTTL(z *zone, apex *nameRows) (uint32, error) {
+	for _, r := range apex.rows[rowSet{loc: string(zeroLoc), qtype: dns.TypeSOA}] {
+		hdr := dns.RR_Header{Name: z.name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: r.rec.TTL, Rdlength: uint16(len(r.value[r.rec.Offset:]))}
+		rr, _, err := dns.UnpackRRWithHeader(hdr, r.value, r.rec.Offset)
+		if err != nil {
+			return 0, fmt.Errorf("invalid SOA record of %s: %w", z.name, err)
+		}
+		return min(rr.Header().Ttl, rr.(*dns.SOA).Minttl), nil
+	}
+	return 0, fmt.Errorf("zone %s has no default location SOA record", z.name)
+}
+
+// makeKey returns the key of the records of a packed name stored under the
+// encoded location loc.
+func makeKey(name []byte, loc []byte, v2Keys bool) []byte {
+	if v2Keys {
+		key := append([]byte(dnsdata.ResourceRecordsKeyMarker), reverseName(name)...)
+		return append(key, loc...)
+	}
+	return append(slices.Clone(loc), name...)
+}
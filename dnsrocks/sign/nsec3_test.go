@@ -0,0 +1,235 @@
+//go:build cgo
+
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sign
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/db"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+const nsec3TestData = `Zexample.com,a.ns.example.com,dns.example.com,123,7200,1800,604800,120,600,,
+&example.com,,a.ns.example.com,172800,,
+=a.ns.example.com,192.0.2.53,172800,,
++www.example.com,192.0.2.1,300,,
+'www.example.com,hello,300,,\000\002
++a.b.example.com,192.0.2.2,300,,
++*.w.example.com,192.0.2.3,300,,
+&sub.example.com,,ns.sub.example.com,172800,,
++ns.sub.example.com,192.0.2.54,172800,,
+`
+
+// prove answers a DO query the way the handler does, and returns the answer
+// along with its NSEC3 records.
+func prove(t *testing.T, r db.Reader, qname string, qtype uint16, locID db.ID) (*dns.Msg, []*dns.NSEC3, bool) {
+	q := make([]byte, 255)
+	off, err := dns.PackDomainName(qname, q, 0, nil, false)
+	require.NoError(t, err)
+	zone := make([]byte, 255)
+	zoneOff, err := dns.PackDomainName("example.com.", zone, 0, nil, false)
+	require.NoError(t, err)
+
+	a := new(dns.Msg)
+	_, a.Rcode = r.FindAnswer(q[:off], zone[:zoneOff], qname, qtype, locID, a, 10, true, 0)
+	if len(a.Answer) == 0 {
+		db.FindSOA(r, zone[:zoneOff], "example.com.", locID, a)
+	}
+	proven, err := db.NSEC3Proof(r, zone[:zoneOff], qname, qtype, locID, a)
+	require.NoError(t, err)
+
+	var nsec3s []*dns.NSEC3
+	for _, rr := range a.Ns {
+		if nsec3, ok := rr.(*dns.NSEC3); ok {
+			nsec3s = append(nsec3s, nsec3)
+		}
+	}
+	return a, nsec3s, proven
+}
+
+func TestSignNSEC3(t *testing.T) {
+	zsk, ksk := testKeys(t)
+	now := time.Now()
+	s, err := NewSigner([]*Key{zsk, ksk}, now.Add(-time.Hour), now.Add(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, s.SetNSEC3(NSEC3Params{Salt: "abcd", Iterations: 1, OptOut: true}))
+
+	path, driver := compileData(t, nsec3TestData, "rdb v2")
+	signDB(t, s, path, driver)
+	// Signing again replaces the chain.
+	signDB(t, s, path, driver)
+
+	d, err := db.Open(path, driver)
+	require.NoError(t, err)
+	defer d.Destroy()
+	r, err := db.NewReader(d)
+	require.NoError(t, err)
+	defer r.Close()
+
+	// match and cover list the names whose NSEC3 records are expected to
+	// match or cover them.
+	testCases := []struct {
+		qname string
+		qtype uint16
+		locID db.ID
+		rcode int
+		match []string
+		cover []string
+		// types of the matching NSEC3 record of NODATA answers
+		types []uint16
+	}{
+		{
+			qname: "nope.example.com.", qtype: dns.TypeA, locID: db.ZeroID, rcode: dns.RcodeNameError,
+			match: []string{"example.com."}, cover: []string{"nope.example.com.", "*.example.com."},
+		},
+		{
+			qname: "nope.www.example.com.", qtype: dns.TypeA, locID: db.ZeroID, rcode: dns.RcodeNameError,
+			match: []string{"www.example.com."}, cover: []string{"nope.www.example.com.", "*.www.example.com."},
+		},
+		{
+			qname: "www.example.com.", qtype: dns.TypeMX, locID: db.ZeroID, rcode: dns.RcodeSuccess,
+			match: []string{"www.example.com."}, types: []uint16{dns.TypeA, dns.TypeRRSIG},
+		},
+		// The type bitmaps of a location hold its types.
+		{
+			qname: "www.example.com.", qtype: dns.TypeMX, locID: db.ID{0, 2}, rcode: dns.RcodeSuccess,
+			match: []string{"www.example.com."}, types: []uint16{dns.TypeA, dns.TypeTXT, dns.TypeRRSIG},
+		},
+		{
+			qname: "example.com.", qtype: dns.TypeMX, locID: db.ZeroID, rcode: dns.RcodeSuccess,
+			match: []string{"example.com."},
+			types: []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeNSEC3PARAM},
+		},
+		// wildcard answer
+		{
+			qname: "x.w.example.com.", qtype: dns.TypeA, locID: db.ZeroID, rcode: dns.RcodeSuccess,
+			cover: []string{"x.w.example.com."},
+		},
+		// wildcard NODATA answer
+		{
+			qname: "x.w.example.com.", qtype: dns.TypeMX, locID: db.ZeroID, rcode: dns.RcodeSuccess,
+			match: []string{"w.example.com.", "*.w.example.com."}, cover: []string{"x.w.example.com."},
+		},
+		// The unsigned delegation is left out of the chain.
+		{
+			qname: "sub.example.com.", qtype: dns.TypeDS, locID: db.ZeroID, rcode: dns.RcodeSuccess,
+			match: []string{"example.com."}, cover: []string{"sub.example.com."},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s/%s/%v", tc.qname, dns.TypeToString[tc.qtype], tc.locID), func(t *testing.T) {
+			a, nsec3s, proven := prove(t, r, tc.qname, tc.qtype, tc.locID)
+			require.Equal(t, tc.rcode, a.Rcode)
+			require.True(t, proven)
+			require.Len(t, nsec3s, len(tc.match)+len(tc.cover))
+
+			for _, name := range tc.match {
+				found := false
+				for _, nsec3 := range nsec3s {
+					if nsec3.Match(name) {
+						found = true
+						if tc.types != nil {
+							require.Equal(t, tc.types, nsec3.TypeBitMap)
+						}
+					}
+				}
+				require.True(t, found, "no NSEC3 matching %s", name)
+			}
+			for _, name := range tc.cover {
+				found := false
+				for _, nsec3 := range nsec3s {
+					if nsec3.Cover(name) {
+						found = true
+						require.Equal(t, tc.qtype == dns.TypeDS, nsec3.Flags&1 == 1 && name == "sub.example.com.")
+					}
+				}
+				require.True(t, found, "no NSEC3 covering %s", name)
+			}
+
+			// Every NSEC3 record, and the SOA record of negative answers,
+			// is signed.
+			for _, nsec3 := range nsec3s {
+				var sig *dns.RRSIG
+				for _, rr := range a.Ns {
+					if s, ok := rr.(*dns.RRSIG); ok && s.TypeCovered == dns.TypeNSEC3 && s.Hdr.Name == nsec3.Hdr.Name {
+						sig = s
+					}
+				}
+				require.NotNil(t, sig)
+				require.NoError(t, sig.Verify(zsk.DNSKEY, []dns.RR{nsec3}))
+				require.Equal(t, uint32(120), nsec3.Hdr.Ttl)
+			}
+			if len(a.Answer) == 0 {
+				require.True(t, hasSignature(a.Ns, dns.TypeSOA))
+			}
+		})
+	}
+
+	// The empty non-terminals are in the chain, so their NXDOMAIN answers
+	// cannot be proven.
+	a, _, proven := prove(t, r, "b.example.com.", dns.TypeA, db.ZeroID)
+	require.Equal(t, dns.RcodeNameError, a.Rcode)
+	require.False(t, proven)
+}
+
+func TestSignNSEC3Unsorted(t *testing.T) {
+	zsk, _ := testKeys(t)
+	now := time.Now()
+	s, err := NewSigner([]*Key{zsk}, now.Add(-time.Hour), now.Add(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, s.SetNSEC3(NSEC3Params{}))
+
+	path, driver := compileData(t, nsec3TestData, "cdb")
+	signDB(t, s, path, driver)
+
+	d, err := db.Open(path, driver)
+	require.NoError(t, err)
+	defer d.Destroy()
+	r, err := db.NewReader(d)
+	require.NoError(t, err)
+	defer r.Close()
+
+	zone := []byte("\x07example\x03com\x00")
+	a := new(dns.Msg)
+	a.Rcode = dns.RcodeNameError
+	_, err = db.NSEC3Proof(r, zone, "nope.example.com.", dns.TypeA, db.ZeroID, a)
+	require.ErrorIs(t, err, db.ErrNSEC3Unsupported)
+}
+
+func TestSetNSEC3Invalid(t *testing.T) {
+	zsk, _ := testKeys(t)
+	now := time.Now()
+	s, err := NewSigner([]*Key{zsk}, now.Add(-time.Hour), now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Error(t, s.SetNSEC3(NSEC3Params{Salt: "xyz"}))
+	require.Error(t, s.SetNSEC3(NSEC3Params{Iterations: 1000}))
+}
+
+func hasSignature(rrs []dns.RR, qtype uint16) bool {
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok && sig.TypeCovered == qtype {
+			return true
+		}
+	}
+	return false
+}
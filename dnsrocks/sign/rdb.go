@@ -0,0 +1,49 @@
+//go:build cgo
+
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sign
+
+import (
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
+)
+
+// SignRDB signs the zones of a RocksDB database in place, replacing the
+// signatures of a previous run. It returns the number of records added.
+func (s *Signer) SignRDB(path string) (int, error) {
+	r, err := rdb.NewUpdater(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	add, del, err := s.Sign(r.ForEachRow, r.IsV2KeySyntaxUsed())
+	if err != nil {
+		return 0, err
+	}
+	batch := r.CreateBatch()
+	for _, m := range del {
+		batch.Del(m.Key, m.Value)
+	}
+	for _, m := range add {
+		batch.Add(m.Key, m.Value)
+	}
+	if err = r.ExecuteBatch(batch); err != nil {
+		return 0, err
+	}
+	return len(add), nil
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sign
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Apex holds the key records published at the apex of a zone at a given
+// time, according to the rollover state of its keys: pre-published and
+// retired keys are in the DNSKEY RRset, while the CDS and CDNSKEY RRsets
+// reflect the key signing keys the parent should have DS records for.
+type Apex struct {
+	DNSKEY  []dns.RR
+	CDS     []dns.RR
+	CDNSKEY []dns.RR
+}
+
+// ZoneKeys returns the keys of a zone.
+func ZoneKeys(zone string, keys []*Key) []*Key {
+	var zk []*Key
+	for _, k := range keys {
+		if strings.EqualFold(dns.Fqdn(k.DNSKEY.Hdr.Name), dns.Fqdn(zone)) {
+			zk = append(zk, k)
+		}
+	}
+	return zk
+}
+
+// ActiveKeys returns the keys signing at now.
+func ActiveKeys(keys []*Key, now time.Time) []*Key {
+	var active []*Key
+	for _, k := range keys {
+		if k.Active(now) {
+			active = append(active, k)
+		}
+	}
+	return active
+}
+
+// ApexRecords returns the key records published at now at the apex of zone,
+// with the given TTL, from the keys of the zone.
+func ApexRecords(zone string, keys []*Key, now time.Time, ttl uint32) Apex {
+	var a Apex
+	zone = dns.CanonicalName(zone)
+	hdr := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: zone, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+	}
+	for _, k := range keys {
+		if !k.Published(now) {
+			continue
+		}
+		dnskey := *k.DNSKEY
+		dnskey.Hdr = hdr(dns.TypeDNSKEY)
+		a.DNSKEY = append(a.DNSKEY, &dnskey)
+		if !k.IsKSK() || !k.Timing.Sync(now) {
+			continue
+		}
+		cdnskey := dnskey.ToCDNSKEY()
+		cdnskey.Hdr = hdr(dns.TypeCDNSKEY)
+		a.CDNSKEY = append(a.CDNSKEY, cdnskey)
+		cds := dnskey.ToDS(dns.SHA256).ToCDS()
+		cds.Hdr = hdr(dns.TypeCDS)
+		a.CDS = append(a.CDS, cds)
+	}
+	return a
+}
@@ -0,0 +1,486 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package sign implements the offline DNSSEC signing of compiled databases.
+// The RRsets of the signed zones are signed for every location they are
+// answered in, and the signatures are stored as RRSIG records along with
+// them, to be answered to queries with the DO bit set.
+package sign
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+
+	"github.com/miekg/dns"
+)
+
+// zeroLoc is the encoded ID of the default location.
+var zeroLoc = []byte{0, 0}
+
+// Signer signs the zones of its keys.
+type Signer struct {
+	// zones are indexed by their packed, lower case name
+	zones      map[string]*zone
+	inception  time.Time
+	expiration time.Time
+	// nsec3 holds the parameters of the NSEC3 chains, nil for none
+	nsec3 *NSEC3Params
+}
+
+type zone struct {
+	name   string
+	packed []byte
+	keys   []*Key
+}
+
+// NewSigner returns a Signer for the zones of the keys, whose signatures are
+// valid from inception to expiration.
+func NewSigner(keys []*Key, inception, expiration time.Time) (*Signer, error) {
+	if !expiration.After(inception) {
+		return nil, fmt.Errorf("signature expiration %v is not after inception %v", expiration, inception)
+	}
+	s := &Signer{
+		zones:      make(map[string]*zone),
+		inception:  inception,
+		expiration: expiration,
+	}
+	for _, k := range keys {
+		name := dns.CanonicalName(k.DNSKEY.Header().Name)
+		packed := make([]byte, len(name)+1)
+		off, err := dns.PackDomainName(name, packed, 0, nil, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key name %s: %w", name, err)
+		}
+		z, ok := s.zones[string(packed[:off])]
+		if !ok {
+			z = &zone{name: name, packed: packed[:off]}
+			s.zones[string(z.packed)] = z
+		}
+		z.keys = append(z.keys, k)
+	}
+	return s, nil
+}
+
+// zone returns the signed zone of a packed name, nil if none.
+func (s *Signer) zone(name []byte) *zone {
+	for off := 0; off < len(name); off += int(name[off]) + 1 {
+		if z, ok := s.zones[string(name[off:])]; ok {
+			return z
+		}
+	}
+	return nil
+}
+
+// row is a resource record row of the DB.
+type row struct {
+	rec   db.ResourceRecord
+	value []byte
+}
+
+// rowSet identifies the rows of a name sharing a type, location and wildcard
+// flag.
+type rowSet struct {
+	wildcard bool
+	loc      string
+	qtype    uint16
+}
+
+// nameRows holds the rows stored under a name. The rows of the wildcard
+// below the name are stored under the name too.
+type nameRows struct {
+	name []byte
+	// keys are indexed by encoded location
+	keys map[string][]byte
+	rows map[rowSet][]row
+}
+
+// Sign signs the resource records of the DB, whose rows are iterated with
+// forEachRow. It returns the rows to add to the DB, e.g the signatures, the
+// DNSKEY records of the keys and the NSEC3 chains, and the rows to delete from
+// it, e.g the previous signatures and chains.
+//
+// The RRsets of a location are signed along with the default location records
+// of their type, as they are answered together. Delegations are not signed,
+// neither are the names below them, but for the DS RRsets.
+func (s *Signer) Sign(forEachRow func(f func(key, value []byte) error) error, v2Keys bool) (add, del []dnsdata.MapRecord, err error) {
+	names := make(map[string]*nameRows)
+	err = forEachRow(func(key, value []byte) error {
+		name, loc, ok := parseKey(key, v2Keys)
+		if !ok || s.zone(name) == nil {
+			return nil
+		}
+		rec, wildcard, ok := parseRow(value, loc)
+		if !ok {
+			return nil
+		}
+		if rec.Qtype == dns.TypeRRSIG || rec.Qtype == dns.TypeNSEC3 || rec.Qtype == dns.TypeNSEC3PARAM {
+			del = append(del, dnsdata.MapRecord{Key: bytes.Clone(key), Value: bytes.Clone(value)})
+			return nil
+		}
+		n, ok := names[string(name)]
+		if !ok {
+			n = &nameRows{name: bytes.Clone(name), keys: make(map[string][]byte), rows: make(map[rowSet][]row)}
+			names[string(name)] = n
+		}
+		n.keys[string(loc)] = bytes.Clone(key)
+		set := rowSet{wildcard: wildcard, loc: string(loc), qtype: rec.Qtype}
+		n.rows[set] = append(n.rows[set], row{rec: rec, value: bytes.Clone(value)})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, z := range s.zones {
+		apex, ok := names[string(z.packed)]
+		if !ok || apex.keys[string(zeroLoc)] == nil {
+			return nil, nil, fmt.Errorf("zone %s has no default location records in the DB", z.name)
+		}
+		dnskeys, err := z.dnskeyRows(apex)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, r := range dnskeys {
+			add = append(add, dnsdata.MapRecord{Key: apex.keys[string(zeroLoc)], Value: r.value})
+		}
+		if s.nsec3 != nil {
+			r, err := s.nsec3ParamRow(apex)
+			if err != nil {
+				return nil, nil, err
+			}
+			add = append(add, dnsdata.MapRecord{Key: apex.keys[string(zeroLoc)], Value: r.value})
+		}
+	}
+
+	// Delegations are the names with NS records but the zone apexes.
+	delegations := make(map[string]bool)
+	for name, n := range names {
+		if s.zones[name] != nil {
+			continue
+		}
+		for set := range n.rows {
+			if set.qtype == dns.TypeNS && !set.wildcard {
+				delegations[name] = true
+			}
+		}
+	}
+
+	if s.nsec3 != nil {
+		for _, z := range s.zones {
+			chain, err := s.nsec3Chain(z, names, delegations, v2Keys)
+			if err != nil {
+				return nil, nil, err
+			}
+			add = append(add, chain...)
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		n := names[name]
+		z := s.zone(n.name)
+		cut, occluded := delegation(n.name, z, delegations)
+		if occluded {
+			continue
+		}
+		sigs, err := s.signName(z, n, cut)
+		if err != nil {
+			return nil, nil, err
+		}
+		add = append(add, sigs...)
+	}
+	return add, del, nil
+}
+
+// delegation returns whether a name is a delegation point, and whether it is
+// occluded by a delegation above it.
+func delegation(name []byte, z *zone, delegations map[string]bool) (cut bool, occluded bool) {
+	cut = delegations[string(name)]
+	for off := int(name[0]) + 1; off < len(name)-len(z.packed); off += int(name[off]) + 1 {
+		if delegations[string(name[off:])] {
+			return cut, true
+		}
+	}
+	return cut, false
+}
+
+// dnskeyRows adds the DNSKEY records of the keys of the zone missing from its
+// apex, and returns them.
+func (z *zone) dnskeyRows(apex *nameRows) ([]row, error) {
+	set := rowSet{loc: string(zeroLoc), qtype: dns.TypeDNSKEY}
+	existing := make(map[string]bool)
+	for _, r := range apex.rows[set] {
+		existing[string(r.value[r.rec.Offset:])] = true
+	}
+	var rows []row
+	for _, k := range z.keys {
+		rdata, err := packRdata(k.DNSKEY)
+		if err != nil {
+			return nil, err
+		}
+		if existing[string(rdata)] {
+			continue
+		}
+		existing[string(rdata)] = true
+		value := makeRow(dns.TypeDNSKEY, k.DNSKEY.Hdr.Ttl, zeroLoc, false, rdata)
+		rec, err := db.ExtractRRFromRow(value, false)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row{rec: rec, value: value})
+	}
+	apex.rows[set] = append(apex.rows[set], rows...)
+	return rows, nil
+}
+
+// signName signs the RRsets of a name, and of the wildcard below it. Only the
+// DS RRsets of delegation points are signed.
+func (s *Signer) signName(z *zone, n *nameRows, cut bool) ([]dnsdata.MapRecord, error) {
+	sets := make([]rowSet, 0, len(n.rows))
+	for set := range n.rows {
+		if cut && (set.wildcard || set.qtype != dns.TypeDS) {
+			continue
+		}
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		a, b := sets[i], sets[j]
+		if a.wildcard != b.wildcard {
+			return !a.wildcard
+		}
+		if a.loc != b.loc {
+			return a.loc < b.loc
+		}
+		return a.qtype < b.qtype
+	})
+
+	owner, _, err := dns.UnpackDomainName(n.name, 0)
+	if err != nil {
+		return nil, err
+	}
+	var records []dnsdata.MapRecord
+	for _, set := range sets {
+		rows := n.rows[set]
+		if set.loc != string(zeroLoc) {
+			rows = append(slices.Clone(rows), n.rows[rowSet{wildcard: set.wildcard, loc: string(zeroLoc), qtype: set.qtype}]...)
+		}
+		name := owner
+		if set.wildcard {
+			name = "*." + owner
+		}
+		sigs, err := s.signRRset(z, name, set.qtype, rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not sign %s %s: %w", name, dns.TypeToString[set.qtype], err)
+		}
+		for _, sig := range sigs {
+			rdata, err := packRdata(sig)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, dnsdata.MapRecord{
+				Key:   n.keys[set.loc],
+				Value: makeRow(dns.TypeRRSIG, sig.Hdr.Ttl, []byte(set.loc), set.wildcard, rdata),
+			})
+		}
+	}
+	return records, nil
+}
+
+// signRRset returns the signatures of the RRset made of rows. DNSKEY RRsets
+// are signed by the key signing keys, and the other ones by the zone signing
+// keys, if the keys of the zone are split.
+func (s *Signer) signRRset(z *zone, name string, qtype uint16, rows []row) ([]*dns.RRSIG, error) {
+	rrs := make([]dns.RR, 0, len(rows))
+	ttl := rows[0].rec.TTL
+	for _, r := range rows {
+		hdr := dns.RR_Header{Name: name, Rrtype: qtype, Class: dns.ClassINET, Ttl: r.rec.TTL, Rdlength: uint16(len(r.value[r.rec.Offset:]))}
+		rr, _, err := dns.UnpackRRWithHeader(hdr, r.value, r.rec.Offset)
+		if err != nil {
+			return nil, err
+		}
+		rrs = append(rrs, rr)
+		ttl = min(ttl, r.rec.TTL)
+	}
+	// The records are answered with their own TTL, but validators use the
+	// original TTL of the signature.
+	for _, rr := range rrs {
+		rr.Header().Ttl = ttl
+	}
+
+	var ksk, zsk []*Key
+	for _, k := range z.keys {
+		if k.IsKSK() {
+			ksk = append(ksk, k)
+		} else {
+			zsk = append(zsk, k)
+		}
+	}
+	keys := z.keys
+	if qtype == dns.TypeDNSKEY && len(ksk) > 0 {
+		keys = ksk
+	} else if qtype != dns.TypeDNSKEY && len(zsk) > 0 {
+		keys = zsk
+	}
+
+	sigs := make([]*dns.RRSIG, 0, len(keys))
+	for _, k := range keys {
+		sig, err := k.Sign(rrs, z.name, s.inception, s.expiration)
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+// parseKey returns the packed owner name and the encoded location of a
+// resource record key. ok is false for the keys of other records.
+func parseKey(key []byte, v2Keys bool) (name []byte, loc []byte, ok bool) {
+	if v2Keys {
+		marker := len(dnsdata.ResourceRecordsKeyMarker)
+		if !bytes.HasPrefix(key, []byte(dnsdata.ResourceRecordsKeyMarker)) {
+			return nil, nil, false
+		}
+		end, ok := nameEnd(key, marker)
+		if !ok {
+			return nil, nil, false
+		}
+		loc = key[end:]
+		if !validLoc(loc) {
+			return nil, nil, false
+		}
+		return reverseName(key[marker:end]), loc, true
+	}
+
+	locLen := 2
+	if len(key) > 1 && key[0] == 0xff {
+		locLen += int(key[1])
+	}
+	if len(key) <= locLen {
+		return nil, nil, false
+	}
+	end, ok := nameEnd(key, locLen)
+	if !ok || end != len(key) {
+		return nil, nil, false
+	}
+	return key[locLen:], key[:locLen], true
+}
+
+// nameEnd returns the end of the packed name starting at off in b.
+func nameEnd(b []byte, off int) (int, bool) {
+	for off < len(b) && b[off] != 0 {
+		if b[off] > 63 {
+			return 0, false
+		}
+		off += int(b[off]) + 1
+	}
+	if off >= len(b) {
+		return 0, false
+	}
+	return off + 1, true
+}
+
+func validLoc(loc []byte) bool {
+	if len(loc) < 2 {
+		return false
+	}
+	if loc[0] == 0xff {
+		return len(loc) == 2+int(loc[1])
+	}
+	return len(loc) == 2
+}
+
+// reverseName reverses the labels of a packed name.
+func reverseName(name []byte) []byte {
+	var labels [][]byte
+	for off := 0; name[off] != 0; off += int(name[off]) + 1 {
+		labels = append(labels, name[off:off+int(name[off])+1])
+	}
+	reversed := make([]byte, 0, len(name))
+	for i := len(labels) - 1; i >= 0; i-- {
+		reversed = append(reversed, labels[i]...)
+	}
+	return append(reversed, 0)
+}
+
+// parseRow parses a resource record row stored under the encoded location
+// loc, and returns whether it belongs to the wildcard below its name.
+func parseRow(value []byte, loc []byte) (rec db.ResourceRecord, wildcard bool, ok bool) {
+	if len(value) < 3 {
+		return rec, false, false
+	}
+	ch := value[2] &^ (dnsdata.WeightedRecordFlag | dnsdata.HealthTaggedRecordFlag)
+	switch ch {
+	case '=', '*':
+		if !bytes.Equal(loc, zeroLoc) {
+			return rec, false, false
+		}
+	case '=' + 1, '*' + 1:
+		if !bytes.HasPrefix(value[3:], loc) {
+			return rec, false, false
+		}
+	default:
+		return rec, false, false
+	}
+	wildcard = ch == '*' || ch == '*'+1
+	rec, err := db.ExtractRRFromRow(value, wildcard)
+	if err != nil {
+		return rec, false, false
+	}
+	return rec, wildcard, true
+}
+
+// makeRow returns the row of a record stored under the encoded location loc.
+func makeRow(qtype uint16, ttl uint32, loc []byte, wildcard bool, rdata []byte) []byte {
+	ch := byte('=')
+	if wildcard {
+		ch = '*'
+	}
+	located := !bytes.Equal(loc, zeroLoc)
+	if located {
+		ch++
+	}
+	value := binary.BigEndian.AppendUint16(nil, qtype)
+	value = append(value, ch)
+	if located {
+		value = append(value, loc...)
+	}
+	value = binary.BigEndian.AppendUint32(value, ttl)
+	// ttd is not used
+	value = append(value, 0, 0, 0, 0, 0, 0, 0, 0)
+	return append(value, rdata...)
+}
+
+// packRdata returns the wire format of the data of a record.
+func packRdata(rr dns.RR) ([]byte, error) {
+	b := make([]byte, dns.Len(rr))
+	off, err := dns.PackRR(rr, b, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return b[off-int(rr.Header().Rdlength) : off], nil
+}
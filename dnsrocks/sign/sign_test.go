@@ -0,0 +1,257 @@
+//go:build cgo
+
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sign
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata/cdb"
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
+	"github.com/facebook/dns/dnsrocks/testutils"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+const testData = `Zexample.com,a.ns.example.com,dns.example.com,123,7200,1800,604800,120,120,,
+&example.com,,a.ns.example.com,172800,,
+=a.ns.example.com,192.0.2.53,172800,,
++www.example.com,192.0.2.1,300,,
++www.example.com,192.0.2.2,600,,
++www.example.com,192.0.2.3,300,,\000\002
+'www.example.com,hello,300,,
+C*.example.com,www.example.com,1800,,
+&sub.example.com,,ns.sub.example.com,172800,,
++ns.sub.example.com,192.0.2.54,172800,,
++www.example.org,192.0.2.1,300,,
+`
+
+// testKeys returns the ZSK of the fixtures, along with a new KSK.
+func testKeys(t *testing.T) (zsk, ksk *Key) {
+	zsk, err := ParseKeyFile(testutils.FixturePath("../testdata/data/", "Kexample.com.+013+28484.key"))
+	require.NoError(t, err)
+
+	dnskey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	privateKey, err := dnskey.Generate(256)
+	require.NoError(t, err)
+	ksk, err = NewKey(dnskey, privateKey)
+	require.NoError(t, err)
+	return zsk, ksk
+}
+
+// compile compiles the test data to a DB of the given flavour.
+func compile(t *testing.T, flavour string) (path string, driver string) {
+	return compileData(t, testData, flavour)
+}
+
+// compileData compiles data to a DB of the given flavour.
+func compileData(t *testing.T, data string, flavour string) (path string, driver string) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "data")
+	require.NoError(t, os.WriteFile(input, []byte(data), 0o644))
+
+	switch flavour {
+	case "cdb":
+		path = filepath.Join(dir, "data.cdb")
+		_, err := cdb.CreateCDB(input, path, nil)
+		require.NoError(t, err)
+		return path, "cdb"
+	default:
+		path = filepath.Join(dir, "rdb")
+		require.NoError(t, os.Mkdir(path, 0o755))
+		o := rdb.CompilationOptions{UseV2KeySyntax: flavour == "rdb v2"}
+		_, err := rdb.CompileToSpecificRDBVersion(input, path, o)
+		require.NoError(t, err)
+		return path, "rocksdb"
+	}
+}
+
+func signDB(t *testing.T, s *Signer, path, driver string) int {
+	var (
+		n   int
+		err error
+	)
+	if driver == "cdb" {
+		n, err = s.SignCDB(path, path)
+	} else {
+		n, err = s.SignRDB(path)
+	}
+	require.NoError(t, err)
+	return n
+}
+
+// findAnswer returns the answer to a query from a location, split into the
+// records and their signatures.
+func findAnswer(t *testing.T, r db.Reader, qname string, qtype uint16, locID db.ID, maxAnswer int, dnssec bool) ([]dns.RR, []*dns.RRSIG) {
+	q := make([]byte, 255)
+	off, err := dns.PackDomainName(qname, q, 0, nil, false)
+	require.NoError(t, err)
+	zone := make([]byte, 255)
+	zoneOff, err := dns.PackDomainName("example.com.", zone, 0, nil, false)
+	require.NoError(t, err)
+
+	a := new(dns.Msg)
+	_, rcode := r.FindAnswer(q[:off], zone[:zoneOff], qname, qtype, locID, a, maxAnswer, dnssec, 0)
+	require.Equal(t, dns.RcodeSuccess, rcode)
+	var (
+		rrs  []dns.RR
+		sigs []*dns.RRSIG
+	)
+	for _, rr := range a.Answer {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs = append(sigs, sig)
+		} else {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs, sigs
+}
+
+func TestSign(t *testing.T) {
+	zsk, ksk := testKeys(t)
+	now := time.Now()
+	s, err := NewSigner([]*Key{zsk, ksk}, now.Add(-time.Hour), now.Add(time.Hour))
+	require.NoError(t, err)
+
+	for _, flavour := range []string{"rdb v1", "rdb v2", "cdb"} {
+		t.Run(flavour, func(t *testing.T) {
+			path, driver := compile(t, flavour)
+			n := signDB(t, s, path, driver)
+			// Signing again replaces the signatures, the DNSKEY records are
+			// only added once.
+			require.Equal(t, n-2, signDB(t, s, path, driver))
+
+			d, err := db.Open(path, driver)
+			require.NoError(t, err)
+			defer d.Destroy()
+			r, err := db.NewReader(d)
+			require.NoError(t, err)
+			defer r.Close()
+
+			verify := func(key *Key, rrs []dns.RR, sigs []*dns.RRSIG) {
+				require.Len(t, sigs, 1, "%v", rrs)
+				require.Equal(t, key.DNSKEY.KeyTag(), sigs[0].KeyTag)
+				require.NoError(t, sigs[0].Verify(key.DNSKEY, rrs))
+				require.True(t, sigs[0].ValidityPeriod(now))
+			}
+
+			testCases := []struct {
+				qname string
+				qtype uint16
+				locID db.ID
+				key   *Key
+				rrs   int
+			}{
+				{qname: "www.example.com.", qtype: dns.TypeA, locID: db.ZeroID, key: zsk, rrs: 2},
+				// Location records are signed along with the default ones.
+				{qname: "www.example.com.", qtype: dns.TypeA, locID: db.ID{0, 2}, key: zsk, rrs: 3},
+				{qname: "www.example.com.", qtype: dns.TypeA, locID: db.ID{0, 3}, key: zsk, rrs: 2},
+				{qname: "www.example.com.", qtype: dns.TypeTXT, locID: db.ID{0, 2}, key: zsk, rrs: 1},
+				{qname: "foo.example.com.", qtype: dns.TypeCNAME, locID: db.ZeroID, key: zsk, rrs: 1},
+				{qname: "example.com.", qtype: dns.TypeSOA, locID: db.ZeroID, key: zsk, rrs: 1},
+				{qname: "example.com.", qtype: dns.TypeDNSKEY, locID: db.ZeroID, key: ksk, rrs: 2},
+			}
+			for _, tc := range testCases {
+				t.Run(fmt.Sprintf("%s/%s/%v", tc.qname, dns.TypeToString[tc.qtype], tc.locID), func(t *testing.T) {
+					rrs, sigs := findAnswer(t, r, tc.qname, tc.qtype, tc.locID, 10, true)
+					require.Len(t, rrs, tc.rrs)
+					verify(tc.key, rrs, sigs)
+
+					rrs, sigs = findAnswer(t, r, tc.qname, tc.qtype, tc.locID, 10, false)
+					require.Len(t, rrs, tc.rrs)
+					require.Empty(t, sigs)
+				})
+			}
+
+			// The signature of a partial A RRset would not validate.
+			rrs, sigs := findAnswer(t, r, "www.example.com.", dns.TypeA, db.ZeroID, 1, true)
+			require.Len(t, rrs, 1)
+			require.Empty(t, sigs)
+
+			// Signatures are not answered to ANY queries without DO.
+			_, sigs = findAnswer(t, r, "www.example.com.", dns.TypeANY, db.ZeroID, 10, false)
+			require.Empty(t, sigs)
+			_, sigs = findAnswer(t, r, "www.example.com.", dns.TypeANY, db.ZeroID, 10, true)
+			require.Len(t, sigs, 2)
+
+			// Delegations, and the names below them, are not signed.
+			_, sigs = findAnswer(t, r, "sub.example.com.", dns.TypeNS, db.ZeroID, 10, true)
+			require.Empty(t, sigs)
+			_, sigs = findAnswer(t, r, "ns.sub.example.com.", dns.TypeA, db.ZeroID, 10, true)
+			require.Empty(t, sigs)
+			// Neither are zones without keys.
+			_, sigs = findAnswer(t, r, "www.example.org.", dns.TypeA, db.ZeroID, 10, true)
+			require.Empty(t, sigs)
+		})
+	}
+}
+
+func TestNewSignerInvalidValidity(t *testing.T) {
+	zsk, _ := testKeys(t)
+	now := time.Now()
+	_, err := NewSigner([]*Key{zsk}, now, now.Add(-time.Hour))
+	require.Error(t, err)
+}
+
+func TestSignZoneNotFound(t *testing.T) {
+	zsk, _ := testKeys(t)
+	zsk.DNSKEY.Hdr.Name = "example.net."
+	now := time.Now()
+	s, err := NewSigner([]*Key{zsk}, now.Add(-time.Hour), now.Add(time.Hour))
+	require.NoError(t, err)
+	path, _ := compile(t, "rdb v2")
+	_, err = s.SignRDB(path)
+	require.Error(t, err)
+}
+
+func TestParseKey(t *testing.T) {
+	testCases := []struct {
+		key    string
+		v2Keys bool
+		name   string
+		loc    string
+		ok     bool
+	}{
+		{key: "\x00\x00\x03www\x07example\x03com\x00", name: "\x03www\x07example\x03com\x00", loc: "\x00\x00", ok: true},
+		{key: "\xff\x05other\x03www\x00", name: "\x03www\x00", loc: "\xff\x05other", ok: true},
+		{key: "\x00\x00\x03www", ok: false},
+		{key: "\x00o\x03com\x07example\x00\x00\x02", v2Keys: true, name: "\x07example\x03com\x00", loc: "\x00\x02", ok: true},
+		{key: "\x00o\x03com\x00\xff\x05other", v2Keys: true, name: "\x03com\x00", loc: "\xff\x05other", ok: true},
+		{key: "\x00o_features", v2Keys: true, ok: false},
+		{key: "\x00o\x03com\x00\x00", v2Keys: true, ok: false},
+	}
+	for _, tc := range testCases {
+		name, loc, ok := parseKey([]byte(tc.key), tc.v2Keys)
+		require.Equal(t, tc.ok, ok, "%q", tc.key)
+		if ok {
+			require.Equal(t, tc.name, string(name))
+			require.Equal(t, tc.loc, string(loc))
+		}
+	}
+}
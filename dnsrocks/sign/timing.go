@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sign
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// timingLayout is the layout of the timing metadata of dnssec-keygen.
+const timingLayout = "20060102150405"
+
+// KeyState is the state of a key at a given time, according to its timing
+// metadata.
+type KeyState int
+
+// Keys go through these states in order. Keys without timing metadata are
+// always active.
+const (
+	// KeyCreated keys are not published yet.
+	KeyCreated KeyState = iota
+	// KeyPublished keys are published, but do not sign yet, e.g pre-published
+	// zone signing keys.
+	KeyPublished
+	// KeyActive keys are published and sign.
+	KeyActive
+	// KeyRetired keys no longer sign, but are still published for the
+	// signatures they made to expire from caches.
+	KeyRetired
+	// KeyDeleted keys are no longer published.
+	KeyDeleted
+)
+
+var keyStateNames = map[KeyState]string{
+	KeyCreated:   "created",
+	KeyPublished: "published",
+	KeyActive:    "active",
+	KeyRetired:   "retired",
+	KeyDeleted:   "deleted",
+}
+
+func (s KeyState) String() string {
+	if name, ok := keyStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("KeyState(%d)", int(s))
+}
+
+// Timing holds the timing metadata of a key, as set by dnssec-keygen and
+// dnssec-settime. Unset times are zero.
+type Timing struct {
+	Created  time.Time
+	Publish  time.Time
+	Activate time.Time
+	Inactive time.Time
+	Delete   time.Time
+	// SyncPublish and SyncDelete bound the publication of the CDS and
+	// CDNSKEY records of the key.
+	SyncPublish time.Time
+	SyncDelete  time.Time
+}
+
+// ParseTiming reads the timing metadata of a key from its ".private" file.
+func ParseTiming(r io.Reader) (Timing, error) {
+	var t Timing
+	fields := map[string]*time.Time{
+		"Created":     &t.Created,
+		"Publish":     &t.Publish,
+		"Activate":    &t.Activate,
+		"Inactive":    &t.Inactive,
+		"Delete":      &t.Delete,
+		"SyncPublish": &t.SyncPublish,
+		"SyncDelete":  &t.SyncDelete,
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		field, ok := fields[strings.TrimSpace(name)]
+		if !ok {
+			continue
+		}
+		v, err := time.Parse(timingLayout, strings.TrimSpace(value))
+		if err != nil {
+			return t, fmt.Errorf("invalid %s time %q: %w", name, value, err)
+		}
+		*field = v
+	}
+	return t, scanner.Err()
+}
+
+// reached returns whether an event happened at now. Unset events never do.
+func reached(event, now time.Time) bool {
+	return !event.IsZero() && !now.Before(event)
+}
+
+// State returns the state of the key at now.
+func (t Timing) State(now time.Time) KeyState {
+	switch {
+	case reached(t.Delete, now):
+		return KeyDeleted
+	case !t.Publish.IsZero() && !reached(t.Publish, now) && !reached(t.Activate, now):
+		return KeyCreated
+	case reached(t.Inactive, now):
+		return KeyRetired
+	case t.Activate.IsZero() || reached(t.Activate, now):
+		return KeyActive
+	default:
+		return KeyPublished
+	}
+}
+
+// Sync returns whether the CDS and CDNSKEY records of a key signing key are
+// published at now. Without sync metadata, they are published while the key
+// is active, so that both keys of a double-signature rollover are in them.
+func (t Timing) Sync(now time.Time) bool {
+	if t.SyncPublish.IsZero() {
+		return t.State(now) == KeyActive
+	}
+	return reached(t.SyncPublish, now) && !reached(t.SyncDelete, now) && t.State(now) != KeyDeleted
+}
+
+// NextEvent returns the time of the next event of the key after now, zero if
+// none.
+func (t Timing) NextEvent(now time.Time) time.Time {
+	var next time.Time
+	for _, event := range []time.Time{t.Publish, t.Activate, t.Inactive, t.Delete, t.SyncPublish, t.SyncDelete} {
+		if event.After(now) && (next.IsZero() || event.Before(next)) {
+			next = event
+		}
+	}
+	return next
+}
+
+// Published returns whether the DNSKEY record of the key is published at now.
+func (k *Key) Published(now time.Time) bool {
+	s := k.Timing.State(now)
+	return s != KeyCreated && s != KeyDeleted
+}
+
+// Active returns whether the key signs at now.
+func (k *Key) Active(now time.Time) bool {
+	return k.Timing.State(now) == KeyActive
+}
@@ -0,0 +1,129 @@
+//go:build cgo
+
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sign
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTiming(t *testing.T) {
+	private := `Private-key-format: v1.3
+Algorithm: 13 (ECDSAP256SHA256)
+PrivateKey: YRaNT+5yyrEDHDscoyABg+dNHsPzWEX12cLh9kg+Kmc=
+Created: 20191016001059
+Publish: 20191016001059
+Activate: 20191017001059
+SyncPublish: 20191018001059
+`
+	timing, err := ParseTiming(strings.NewReader(private))
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2019, 10, 16, 0, 10, 59, 0, time.UTC), timing.Publish)
+	require.Equal(t, time.Date(2019, 10, 17, 0, 10, 59, 0, time.UTC), timing.Activate)
+	require.Equal(t, time.Date(2019, 10, 18, 0, 10, 59, 0, time.UTC), timing.SyncPublish)
+	require.True(t, timing.Inactive.IsZero())
+
+	_, err = ParseTiming(strings.NewReader("Publish: tomorrow\n"))
+	require.Error(t, err)
+
+	// Key files carry their timing metadata.
+	zsk, _ := testKeys(t)
+	require.Equal(t, time.Date(2019, 10, 16, 0, 10, 59, 0, time.UTC), zsk.Timing.Activate)
+}
+
+func TestTimingState(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := func(n int) time.Time { return base.Add(time.Duration(n) * 24 * time.Hour) }
+	timing := Timing{Publish: day(1), Activate: day(2), Inactive: day(3), Delete: day(4)}
+
+	testCases := []struct {
+		now   time.Time
+		state KeyState
+		next  time.Time
+	}{
+		{now: day(0), state: KeyCreated, next: day(1)},
+		{now: day(1), state: KeyPublished, next: day(2)},
+		{now: day(2), state: KeyActive, next: day(3)},
+		{now: day(3), state: KeyRetired, next: day(4)},
+		{now: day(4), state: KeyDeleted},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.state, timing.State(tc.now), tc.now)
+		require.Equal(t, tc.next, timing.NextEvent(tc.now), tc.now)
+	}
+
+	// Keys without timing metadata are always active.
+	require.Equal(t, KeyActive, Timing{}.State(base))
+	// Activated keys are published.
+	require.Equal(t, KeyActive, Timing{Publish: day(3), Activate: day(1)}.State(day(2)))
+	require.Equal(t, "retired", KeyRetired.String())
+}
+
+func TestTimingSync(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := func(n int) time.Time { return base.Add(time.Duration(n) * 24 * time.Hour) }
+
+	active := Timing{Activate: day(1), Inactive: day(3)}
+	require.False(t, active.Sync(day(0)))
+	require.True(t, active.Sync(day(1)))
+	require.False(t, active.Sync(day(3)))
+
+	synced := Timing{Activate: day(1), SyncPublish: day(2), SyncDelete: day(5)}
+	require.False(t, synced.Sync(day(1)))
+	require.True(t, synced.Sync(day(2)))
+	require.False(t, synced.Sync(day(5)))
+}
+
+func TestApexRecords(t *testing.T) {
+	zsk, ksk := testKeys(t)
+	now := time.Now()
+	// The new ZSK is pre-published.
+	newZSK := *zsk
+	newZSK.DNSKEY = dns.Copy(zsk.DNSKEY).(*dns.DNSKEY)
+	newZSK.DNSKEY.Flags |= dns.REVOKE
+	newZSK.Timing = Timing{Publish: now.Add(-time.Hour), Activate: now.Add(time.Hour)}
+	// The old KSK is retired, but still published.
+	oldKSK := *ksk
+	oldKSK.DNSKEY = dns.Copy(ksk.DNSKEY).(*dns.DNSKEY)
+	oldKSK.DNSKEY.Protocol = 4
+	oldKSK.Timing = Timing{Inactive: now.Add(-time.Hour), Delete: now.Add(time.Hour)}
+
+	keys := []*Key{zsk, &newZSK, ksk, &oldKSK}
+	require.Len(t, ZoneKeys("Example.com", keys), 4)
+	require.Empty(t, ZoneKeys("example.org.", keys))
+	require.Equal(t, []*Key{zsk, ksk}, ActiveKeys(keys, now))
+
+	apex := ApexRecords("example.com.", keys, now, 300)
+	require.Len(t, apex.DNSKEY, 4)
+	require.Len(t, apex.CDS, 1)
+	require.Len(t, apex.CDNSKEY, 1)
+	require.Equal(t, ksk.DNSKEY.KeyTag(), apex.CDS[0].(*dns.CDS).KeyTag)
+	for _, rr := range append(append(apex.DNSKEY, apex.CDS...), apex.CDNSKEY...) {
+		require.Equal(t, "example.com.", rr.Header().Name)
+		require.Equal(t, uint32(300), rr.Header().Ttl)
+	}
+
+	// Once deleted, the old KSK is no longer published.
+	apex = ApexRecords("example.com.", keys, now.Add(2*time.Hour), 300)
+	require.Len(t, apex.DNSKEY, 3)
+}
@@ -0,0 +1,47 @@
+//go:build cgo
+
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testaid
+
+import (
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
+)
+
+// rdbAvailable is whether the RocksDB test databases are created
+const rdbAvailable = true
+
+// compileRDBs compiles the input file to RocksDBs with v1 and v2 keys. It
+// returns the DB and path that failed to compile, if any.
+func compileRDBs(inputFileName, rdbDir, rdbDirV2 string) (string, string, error) {
+	o := rdb.CompilationOptions{}
+	if _, err := rdb.CompileToSpecificRDBVersion(inputFileName, rdbDir, o); err != nil {
+		return "RDB", rdbDir, err
+	}
+	o.UseV2KeySyntax = true
+	if _, err := rdb.CompileToSpecificRDBVersion(inputFileName, rdbDirV2, o); err != nil {
+		return "RDBv2", rdbDirV2, err
+	}
+	return "", "", nil
+}
+
+// compileRDB compiles the input file to a RocksDB at path.
+func compileRDB(inputFileName, path string, useV2Keys bool) error {
+	o := rdb.CompilationOptions{UseV2KeySyntax: useV2Keys}
+	_, err := rdb.CompileToSpecificRDBVersion(inputFileName, path, o)
+	return err
+}
@@ -0,0 +1,35 @@
+//go:build !cgo
+
+/*
+ * Copyright (c) Meta Platforms, Inc. and affiliates.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testaid
+
+import "errors"
+
+// rdbAvailable is whether the RocksDB test databases are created, RocksDB is
+// not available without cgo
+const rdbAvailable = false
+
+// compileRDBs does nothing without cgo.
+func compileRDBs(string, string, string) (string, string, error) {
+	return "", "", nil
+}
+
+// compileRDB fails without cgo.
+func compileRDB(string, string, bool) error {
+	return errors.New("RocksDB is not available without cgo")
+}
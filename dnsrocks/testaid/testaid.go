@@ -33,7 +33,7 @@ import (
 	"time"
 
 	"github.com/facebook/dns/dnsrocks/dnsdata/cdb"
-	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
+	"github.com/facebook/dns/dnsrocks/dnsdata/pdb"
 	"github.com/facebook/dns/dnsrocks/testutils"
 
 	"github.com/stretchr/testify/require"
@@ -62,6 +62,10 @@ var (
 	TestRDB = TestDB{Driver: "rocksdb", Path: "THIS_WILL_BE_OVERRIDDEN_RDB", Flavour: "keys v1"}
 	// TestRDBV2 points to a temporary RDB with v2 keys, it is compiled on each run
 	TestRDBV2 = TestDB{Driver: "rocksdb", Path: "THIS_WILL_BE_OVERRIDDEN_RDB", Flavour: "keys v2"}
+	// TestPDB points to a temporary Pebble DB, it is compiled on each run
+	TestPDB = TestDB{Driver: "pebble", Path: "THIS_WILL_BE_OVERRIDDEN_PDB", Flavour: "keys v1"}
+	// TestPDBV2 points to a temporary Pebble DB with v2 keys, it is compiled on each run
+	TestPDBV2 = TestDB{Driver: "pebble", Path: "THIS_WILL_BE_OVERRIDDEN_PDB", Flavour: "keys v2"}
 )
 
 // TestDBs consists of all test databases
@@ -83,6 +87,21 @@ func Run(m *testing.M, relativePath string) int {
 	}
 	defer os.RemoveAll(rdbDirV2)
 
+	// create tempdir for PDB and PDB v2, the checkpoints the readers take
+	// next to them are removed with it
+	pdbRoot, err := os.MkdirTemp("", "pebble-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(pdbRoot)
+	pdbDir := path.Join(pdbRoot, "v1")
+	pdbDirV2 := path.Join(pdbRoot, "v2")
+	for _, dir := range []string{pdbDir, pdbDirV2} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	// create tempdir for CDB
 	cdbDir, err := os.MkdirTemp("", "cdb-test")
 	if err != nil {
@@ -99,17 +118,21 @@ func Run(m *testing.M, relativePath string) int {
 	errDB, errPath, err := func() (string, string, error) {
 		log.SetOutput(io.Discard)
 		defer log.SetOutput(os.Stderr)
-		// compile RDB into tempdir
-		o := rdb.CompilationOptions{}
-		_, err = rdb.CompileToSpecificRDBVersion(fullInputFileName, rdbDir, o)
+		// compile RDB and RDB v2 into tempdirs
+		if errDB, errPath, err := compileRDBs(fullInputFileName, rdbDir, rdbDirV2); err != nil {
+			return errDB, errPath, err
+		}
+		// compile PDB into tempdir
+		po := pdb.CompilationOptions{}
+		_, err = pdb.CompileToPDB(fullInputFileName, pdbDir, po)
 		if err != nil {
-			return "RDB", rdbDir, err
+			return "PDB", pdbDir, err
 		}
-		// compile RDB v2 into tempdir
-		o.UseV2KeySyntax = true
-		_, err = rdb.CompileToSpecificRDBVersion(fullInputFileName, rdbDirV2, o)
+		// compile PDB v2 into tempdir
+		po.UseV2KeySyntax = true
+		_, err = pdb.CompileToPDB(fullInputFileName, pdbDirV2, po)
 		if err != nil {
-			return "RDBv2", rdbDirV2, err
+			return "PDBv2", pdbDirV2, err
 		}
 		// compile CDB into tempdir
 		creatorOptions := cdb.NewDefaultCreatorOptions()
@@ -122,14 +145,40 @@ func Run(m *testing.M, relativePath string) int {
 	TestCDBBad.Path = testutils.FixturePath(relativePath, inputFileName) // path to CDB should be relative to test executable
 	TestRDB.Path = rdbDir                                                // override path to RDB
 	TestRDBV2.Path = rdbDirV2
-	TestDBs = []TestDB{
-		TestCDB,
-		TestRDB,
-		TestRDBV2,
+	TestPDB.Path = pdbDir
+	TestPDBV2.Path = pdbDirV2
+	TestDBs = []TestDB{TestCDB}
+	if rdbAvailable {
+		TestDBs = append(TestDBs, TestRDB, TestRDBV2)
 	}
+	TestDBs = append(TestDBs, TestPDB, TestPDBV2)
 	return m.Run()
 }
 
+// CompileDB compiles data to a DB with the driver and keys format of config, in
+// a temporary directory of the test, and returns its path.
+func CompileDB(t *testing.T, config TestDB, data string) string {
+	dir := t.TempDir()
+	input := path.Join(dir, "data")
+	require.NoError(t, os.WriteFile(input, []byte(data), 0o644))
+	if config.Driver == "cdb" {
+		dbPath := path.Join(dir, "data.cdb")
+		_, err := cdb.CreateCDB(input, dbPath, nil)
+		require.NoError(t, err)
+		return dbPath
+	}
+	dbPath := path.Join(dir, config.Driver)
+	require.NoError(t, os.Mkdir(dbPath, 0o755))
+	useV2Keys := config.Flavour == TestRDBV2.Flavour
+	if config.Driver == "pebble" {
+		_, err := pdb.CompileToPDB(input, dbPath, pdb.CompilationOptions{UseV2KeySyntax: useV2Keys})
+		require.NoError(t, err)
+		return dbPath
+	}
+	require.NoError(t, compileRDB(input, dbPath, useV2Keys))
+	return dbPath
+}
+
 func pemBlockForKey(priv interface{}) *pem.Block {
 	switch k := priv.(type) {
 	case *rsa.PrivateKey:
@@ -42,6 +42,9 @@ type Limiter struct {
 	// track it separately.  This tracking isn't synchronized with the
 	// semaphore, but that's fine because it's only for monitoring.
 	count atomic.Int64
+	// borrowed counts the queries let through over the limit.  The
+	// release of as many queries must not be returned to the semaphore.
+	borrowed atomic.Int64
 }
 
 // Acquire must be called before accepting each incoming query packet.
@@ -53,8 +56,33 @@ func (l *Limiter) acquire(ctx context.Context) error {
 	return err
 }
 
+// tryAcquire acquires a slot without blocking, and reports whether it
+// succeeded.
+func (l *Limiter) tryAcquire() bool {
+	if !l.sem.TryAcquire(1) {
+		return false
+	}
+	l.count.Add(1)
+	return true
+}
+
+// borrow lets a query through over the limit.
+func (l *Limiter) borrow() {
+	l.borrowed.Add(1)
+	l.count.Add(1)
+}
+
 func (l *Limiter) release() {
-	l.sem.Release(1)
+	for {
+		b := l.borrowed.Load()
+		if b == 0 {
+			l.sem.Release(1)
+			break
+		}
+		if l.borrowed.CompareAndSwap(b, b-1) {
+			break
+		}
+	}
 	l.count.Add(-1)
 }
 
@@ -85,6 +113,9 @@ func NewLimiter(maxWorkers int) (*Limiter, error) {
 type Handler struct {
 	lim  *Limiter
 	Next plugin.Handler
+	// If Bypass is set, it is called with the queries that would block, and
+	// those it returns true for are let through over the limit.
+	Bypass func(buf []byte, addr net.Addr) bool
 }
 
 // NewHandler initializes a new concurrency-limiting Handler.
@@ -123,11 +154,29 @@ func (h *Handler) MsgAcceptFunc(dh dns.Header) dns.MsgAcceptAction {
 	return action
 }
 
+// Acquire blocks until a query may be processed, or ctx is done.  It must be
+// called before ServeDNS for queries that are not read through a [dns.Server]
+// this handler is attached to (e.g. DNS over HTTPS).
+func (h *Handler) Acquire(ctx context.Context) error {
+	return h.lim.acquire(ctx)
+}
+
 // Attach connects this handler to a Server's message processing path.
-// This method must be called before the server starts.
+// This method must be called before the server starts. The Server's own
+// MsgAcceptFunc, if any, keeps deciding which messages are accepted.
 func (h *Handler) Attach(s *dns.Server) {
 	s.DecorateReader = h.DecorateReader
 	s.MsgInvalidFunc = h.MsgInvalid
+	if accept := s.MsgAcceptFunc; accept != nil {
+		s.MsgAcceptFunc = func(dh dns.Header) dns.MsgAcceptAction {
+			action := accept(dh)
+			if action != dns.MsgAccept {
+				h.lim.release()
+			}
+			return action
+		}
+		return
+	}
 	s.MsgAcceptFunc = h.MsgAcceptFunc
 }
 
@@ -145,7 +194,16 @@ func newReader(inner dns.PacketConnReader, handler *Handler) *Reader {
 	}
 }
 
-func (r *Reader) acquire(deadline time.Time) error {
+func (r *Reader) acquire(buf []byte, addr net.Addr, deadline time.Time) error {
+	if r.handler.Bypass != nil {
+		if r.handler.lim.tryAcquire() {
+			return nil
+		}
+		if r.handler.Bypass(buf, addr) {
+			r.handler.lim.borrow()
+			return nil
+		}
+	}
 	ctx := context.Background()
 	if !deadline.IsZero() {
 		var cancel context.CancelFunc
@@ -170,7 +228,7 @@ func (r *Reader) ReadUDP(conn *net.UDPConn, timeout time.Duration) ([]byte, *dns
 		return nil, nil, err
 	}
 
-	if err = r.acquire(deadline); err != nil {
+	if err = r.acquire(queryBuf, s.RemoteAddr(), deadline); err != nil {
 		return nil, nil, err
 	}
 
@@ -185,7 +243,7 @@ func (r *Reader) ReadPacketConn(conn net.PacketConn, timeout time.Duration) ([]b
 		return nil, nil, err
 	}
 
-	if err = r.acquire(deadline); err != nil {
+	if err = r.acquire(queryBuf, addr, deadline); err != nil {
 		return nil, nil, err
 	}
 
@@ -200,7 +258,7 @@ func (r *Reader) ReadTCP(conn net.Conn, timeout time.Duration) ([]byte, error) {
 		return nil, err
 	}
 
-	if err = r.acquire(deadline); err != nil {
+	if err = r.acquire(queryBuf, conn.RemoteAddr(), deadline); err != nil {
 		return nil, err
 	}
 
@@ -154,3 +154,46 @@ func TestCount(t *testing.T) {
 	require.Error(t, err)
 	require.EqualValues(t, 1, l.Count())
 }
+
+// Verify that queries let through by Bypass go over the limit, and that
+// releasing them does not free slots that were never acquired.
+func TestBypass(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	l, err := NewLimiter(1)
+	require.NoError(t, err)
+	h := NewHandler(l)
+	innerReader := NewMockPacketConnReader(ctrl)
+	reader := h.DecorateReader(innerReader).(dns.PacketConnReader)
+	var conn net.PacketConn
+
+	bypassed := []byte{1}
+	other := []byte{2}
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+	h.Bypass = func(buf []byte, _ net.Addr) bool {
+		return buf[0] == bypassed[0]
+	}
+
+	innerReader.EXPECT().ReadPacketConn(conn, time.Duration(0)).Times(2).Return(bypassed, addr, nil)
+	// The first query takes the only slot, the second one is let through.
+	for range 2 {
+		_, _, err = reader.ReadPacketConn(conn, 0)
+		require.NoError(t, err)
+	}
+	require.EqualValues(t, 2, l.Count())
+
+	// Other queries are blocked until both queries are released.
+	innerReader.EXPECT().ReadPacketConn(conn, 10*time.Millisecond).Return(other, addr, nil)
+	_, _, err = reader.ReadPacketConn(conn, 10*time.Millisecond)
+	require.Error(t, err)
+	l.release()
+	innerReader.EXPECT().ReadPacketConn(conn, 10*time.Millisecond).Return(other, addr, nil)
+	_, _, err = reader.ReadPacketConn(conn, 10*time.Millisecond)
+	require.Error(t, err)
+	l.release()
+	innerReader.EXPECT().ReadPacketConn(conn, 10*time.Millisecond).Return(other, addr, nil)
+	_, _, err = reader.ReadPacketConn(conn, 10*time.Millisecond)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, l.Count())
+}